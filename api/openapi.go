@@ -13,3 +13,9 @@ var openapiSpec []byte
 func HandleOpenApi(ctx *gin.Context) {
 	ctx.Data(http.StatusOK, "application/yaml", openapiSpec)
 }
+
+// Spec returns the OpenAPI specification of the service, e.g. to validate
+// the requests against it
+func Spec() []byte {
+	return openapiSpec
+}
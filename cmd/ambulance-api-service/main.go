@@ -2,10 +2,15 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
+	"net/netip"
 	"os"
 	"strings"
 	"time"
+	// ambulance opening hours refer to IANA time zones, the scratch image has no zoneinfo
+	_ "time/tzdata"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -13,7 +18,9 @@ import (
 	"github.com/rs/zerolog/log"
 	"github.com/wac-fiit/cv2-ambulance-webapi/api"
 	"github.com/wac-fiit/cv2-ambulance-webapi/internal/ambulance_wl"
+	"github.com/wac-fiit/cv2-ambulance-webapi/internal/auth"
 	"github.com/wac-fiit/cv2-ambulance-webapi/internal/db_service"
+	"github.com/wac-fiit/cv2-ambulance-webapi/internal/outbox"
 
 	"go.opentelemetry.io/contrib/exporters/autoexport"
 	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
@@ -85,27 +92,199 @@ func main() {
 	corsMiddleware := cors.New(cors.Config{
 		AllowOrigins:     []string{"*"},
 		AllowMethods:     []string{"GET", "PUT", "POST", "DELETE", "PATCH"},
-		AllowHeaders:     []string{"Origin", "Authorization", "Content-Type"},
-		ExposeHeaders:    []string{""},
+		AllowHeaders:     []string{"Origin", "Authorization", "Content-Type", "Last-Event-ID", "X-API-Key", "If-Match", "If-None-Match", "Idempotency-Key"},
+		ExposeHeaders:    []string{"WWW-Authenticate", "ETag", "Idempotent-Replayed"},
 		AllowCredentials: false,
 		MaxAge:           12 * time.Hour,
 	})
 	engine.Use(corsMiddleware)
 
 	// setup context update  middleware
-	dbService := db_service.NewMongoService[ambulance_wl.Ambulance](db_service.MongoServiceConfig{})
+	var dbService db_service.DbService[ambulance_wl.Ambulance]
+	var waitingListDbService db_service.DbChildService[ambulance_wl.WaitingListEntry]
+	var webhooksDbService db_service.DbService[ambulance_wl.Webhook]
+	var deadLettersDbService db_service.DbService[ambulance_wl.WebhookDeadLetter]
+	var deliveriesDbService db_service.DbService[ambulance_wl.WebhookDelivery]
+	var outboxDbService db_service.DbService[outbox.Message]
+	var auditDbService db_service.DbService[ambulance_wl.AuditRecord]
+	var apiKeysDbService db_service.DbService[ambulance_wl.StoredApiKey]
+	var idempotencyKeysDbService db_service.DbService[ambulance_wl.StoredIdempotencyKey]
+	storage := os.Getenv("AMBULANCE_API_STORAGE")
+	if strings.EqualFold(storage, "memory") {
+		log.Warn().Msg("Using in-memory storage, data will be lost on restart")
+		dbService = db_service.NewMemoryService[ambulance_wl.Ambulance]()
+		waitingListDbService = db_service.NewMemoryChildService[ambulance_wl.WaitingListEntry]()
+		webhooksDbService = db_service.NewMemoryService[ambulance_wl.Webhook]()
+		deadLettersDbService = db_service.NewMemoryService[ambulance_wl.WebhookDeadLetter]()
+		deliveriesDbService = db_service.NewMemoryService[ambulance_wl.WebhookDelivery]()
+		outboxDbService = db_service.NewMemoryService[outbox.Message]()
+		auditDbService = db_service.NewMemoryService[ambulance_wl.AuditRecord]()
+		apiKeysDbService = db_service.NewMemoryService[ambulance_wl.StoredApiKey]()
+		idempotencyKeysDbService = db_service.NewMemoryService[ambulance_wl.StoredIdempotencyKey]()
+	} else {
+		dbService = db_service.NewMongoService[ambulance_wl.Ambulance](db_service.MongoServiceConfig{})
+		waitingListDbService = db_service.NewMongoChildService[ambulance_wl.WaitingListEntry](db_service.MongoServiceConfig{})
+		webhooksDbService = db_service.NewMongoCollectionService[ambulance_wl.Webhook](
+			db_service.MongoServiceConfig{}, "AMBULANCE_API_MONGODB_WEBHOOKS_COLLECTION", "webhook")
+		deadLettersDbService = db_service.NewMongoCollectionService[ambulance_wl.WebhookDeadLetter](
+			db_service.MongoServiceConfig{}, "AMBULANCE_API_MONGODB_WEBHOOK_DEAD_LETTERS_COLLECTION", "webhook_dead_letter")
+		deliveriesDbService = db_service.NewMongoCollectionService[ambulance_wl.WebhookDelivery](
+			db_service.MongoServiceConfig{}, "AMBULANCE_API_MONGODB_WEBHOOK_DELIVERIES_COLLECTION", "webhook_delivery")
+		// outbox is written in the transactions of the ambulance updates
+		outboxDbService = db_service.NewMongoCollectionService[outbox.Message](
+			db_service.MongoServiceConfig{}, "AMBULANCE_API_MONGODB_OUTBOX_COLLECTION", "outbox")
+		// audit trail is written in the same transactions as the outbox
+		auditDbService = db_service.NewMongoCollectionService[ambulance_wl.AuditRecord](
+			db_service.MongoServiceConfig{}, "AMBULANCE_API_MONGODB_AUDIT_COLLECTION", "audit")
+		apiKeysDbService = db_service.NewMongoCollectionService[ambulance_wl.StoredApiKey](
+			db_service.MongoServiceConfig{}, "AMBULANCE_API_MONGODB_API_KEYS_COLLECTION", "api_key")
+		idempotencyKeysDbService = db_service.NewMongoCollectionService[ambulance_wl.StoredIdempotencyKey](
+			db_service.MongoServiceConfig{}, "AMBULANCE_API_MONGODB_IDEMPOTENCY_KEYS_COLLECTION", "idempotency_key")
+	}
 	defer dbService.Disconnect(context.Background())
+	defer waitingListDbService.Disconnect(context.Background())
+	defer webhooksDbService.Disconnect(context.Background())
+	defer deadLettersDbService.Disconnect(context.Background())
+	defer deliveriesDbService.Disconnect(context.Background())
+	defer outboxDbService.Disconnect(context.Background())
+	defer auditDbService.Disconnect(context.Background())
+	defer apiKeysDbService.Disconnect(context.Background())
+	defer idempotencyKeysDbService.Disconnect(context.Background())
+	waitingListEvents := ambulance_wl.NewWaitingListEventBroker()
+	// changes made by any replica are published to the clients connected to this one
+	if feed, ok := waitingListDbService.(db_service.ChangeFeed[ambulance_wl.WaitingListEntry]); ok {
+		go ambulance_wl.RelayWaitingListChanges(ctx, feed, waitingListDbService, waitingListEvents)
+	} else {
+		log.Warn().Msg("Waiting list storage does not provide change feed, events are not published")
+	}
+	webhookDispatcher := ambulance_wl.NewWebhookDispatcher(webhooksDbService, deadLettersDbService, deliveriesDbService)
+	go webhookDispatcher.Run(ctx)
+	outboxSink := newOutboxSink(webhookDispatcher)
+	defer outboxSink.Close()
+	go outbox.NewRelay(outboxDbService, outboxSink, 0).Run(ctx)
+	idempotencyKeys, err := ambulance_wl.NewIdempotencyKeys(idempotencyKeysDbService, ambulance_wl.IdempotencyKeysConfig{})
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to initialize idempotency keys")
+	}
+	go idempotencyKeys.Run(ctx)
+	// modifications without If-Match header are refused when required
+	requireIfMatch := strings.EqualFold(os.Getenv("AMBULANCE_API_REQUIRE_IF_MATCH"), "true")
+	proxies, err := trustedProxies()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to parse trusted proxies")
+	}
 	engine.Use(func(ctx *gin.Context) {
 		ctx.Set("db_service", dbService)
+		ctx.Set("db_service_waiting_list", waitingListDbService)
+		ctx.Set("waiting_list_events", waitingListEvents)
+		ctx.Set("db_service_webhooks", webhooksDbService)
+		ctx.Set("db_service_webhook_dead_letters", deadLettersDbService)
+		ctx.Set("webhook_dispatcher", webhookDispatcher)
+		ctx.Set("db_service_outbox", outboxDbService)
+		ctx.Set("db_service_audit", auditDbService)
+		ctx.Set("db_service_api_keys", apiKeysDbService)
+		ctx.Set("require_if_match", requireIfMatch)
+		ctx.Set("trusted_proxies", proxies)
+		ctx.Set("idempotency_keys", idempotencyKeys)
 		ctx.Next()
 	})
+
+	policy, err := auth.LoadPolicyFromEnv()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to load authorization policy")
+	}
+	// API keys of the integrations are accepted alongside the bearer tokens,
+	// they are verified and restricted to their scopes even without the tokens
+	engine.Use(ambulance_wl.NewApiKeyMiddleware())
+	authenticator, err := auth.NewAuthenticator(auth.Config{})
+	switch {
+	case errors.Is(err, auth.ErrNotConfigured):
+		log.Warn().Msg("Authentication is not configured and anonymous access is allowed, API is accessible without bearer token")
+		policy.AllowAnonymous = true
+	case err != nil:
+		log.Fatal().Err(err).Msg("Failed to initialize authentication")
+	default:
+		engine.Use(authenticator.Middleware("/openapi"))
+	}
+	// requests are validated once authenticated, unauthenticated requests are rejected regardless of their content
+	validator, err := ambulance_wl.NewOpenApiValidator(api.Spec(), ambulance_wl.OpenApiValidatorConfig{})
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to initialize OpenAPI validation")
+	}
+	engine.Use(validator.Middleware())
 	// request routings
 	handleFunctions := &ambulance_wl.ApiHandleFunctions{
 		AmbulanceConditionsAPI:  ambulance_wl.NewAmbulanceConditionsApi(),
 		AmbulanceWaitingListAPI: ambulance_wl.NewAmbulanceWaitingListApi(),
 		AmbulancesAPI:           ambulance_wl.NewAmbulancesApi(),
+		ApiKeysAPI:              ambulance_wl.NewApiKeysApi(),
+		AuditAPI:                ambulance_wl.NewAuditApi(),
+		WebhooksAPI:             ambulance_wl.NewWebhooksApi(),
 	}
-	ambulance_wl.NewRouterWithGinEngine(engine, *handleFunctions)
+	ambulance_wl.NewAuthorizedRouterWithGinEngine(engine, *handleFunctions, policy)
+	engine.GET("/api/nurse-console", ambulance_wl.NewNurseConsoleHandler(engine, nurseConsoleOrigins()))
 	engine.GET("/openapi", api.HandleOpenApi)
 	engine.Run(":" + port)
 }
+
+// nurseConsoleOrigins lists the origins of the pages, other than those of the
+// service itself, allowed to connect to the nurse console, separated by commas
+// in the AMBULANCE_API_NURSE_CONSOLE_ORIGINS variable
+func nurseConsoleOrigins() []string {
+	origins := []string{}
+	for _, origin := range strings.Split(os.Getenv("AMBULANCE_API_NURSE_CONSOLE_ORIGINS"), ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+	return origins
+}
+
+// trustedProxies lists the addresses of the authenticating proxies whose
+// X-Forwarded-User and X-Forwarded-Email headers identify the user, separated
+// by commas in the AMBULANCE_API_TRUSTED_PROXIES variable as addresses or CIDR ranges
+func trustedProxies() ([]netip.Prefix, error) {
+	proxies := []netip.Prefix{}
+	for _, proxy := range strings.Split(os.Getenv("AMBULANCE_API_TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy == "" {
+			continue
+		}
+		if address, err := netip.ParseAddr(proxy); err == nil {
+			proxies = append(proxies, netip.PrefixFrom(address, address.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q, expected address or CIDR range", proxy)
+		}
+		proxies = append(proxies, prefix.Masked())
+	}
+	return proxies, nil
+}
+
+// newOutboxSink creates the sinks of the outbox events listed in the
+// AMBULANCE_API_OUTBOX_SINKS variable - webhook, log, or nats
+func newOutboxSink(webhookDispatcher *ambulance_wl.WebhookDispatcher) outbox.MultiSink {
+	sinks := outbox.MultiSink{}
+	names := os.Getenv("AMBULANCE_API_OUTBOX_SINKS")
+	if names == "" {
+		names = "webhook"
+	}
+	for _, name := range strings.Split(names, ",") {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "webhook":
+			sinks = append(sinks, webhookDispatcher)
+		case "log":
+			sinks = append(sinks, outbox.NewLogSink())
+		case "nats":
+			url := os.Getenv("AMBULANCE_API_OUTBOX_NATS_URL")
+			if url == "" {
+				url = "nats://localhost:4222"
+			}
+			sinks = append(sinks, outbox.NewNatsSink(url, os.Getenv("AMBULANCE_API_OUTBOX_NATS_SUBJECT_PREFIX")))
+		default:
+			log.Warn().Str("AMBULANCE_API_OUTBOX_SINKS", names).Msgf("Unknown outbox sink %q ignored", name)
+		}
+	}
+	return sinks
+}
@@ -13,7 +13,8 @@ const retrySeconds = parseInt(process.env.RETRY_CONNECTION_SECONDS || "5") || 5;
 let connection;
 while(true) {
     try {
-        connection = Mongo(`mongodb://${mongoUser}:${mongoPassword}@${mongoHost}:${mongoPort}`);
+        // replica set may not be initiated yet, connect to the member directly
+        connection = Mongo(`mongodb://${mongoUser}:${mongoPassword}@${mongoHost}:${mongoPort}/?directConnection=true`);
         break;
     } catch (exception) {
         print(`Cannot connect to mongoDB: ${exception}`);
@@ -22,6 +23,18 @@ while(true) {
     }
 }
 
+// change streams of the webapi require replica set, initiate it on the first start
+const admin = connection.getDB("admin")
+if (admin.runCommand({ replSetGetStatus: 1 }).codeName === "NotYetInitialized") {
+    print(`Initiating replica set with member ${mongoHost}:${mongoPort}`)
+    admin.runCommand({ replSetInitiate: { _id: "rs0", members: [{ _id: 0, host: `${mongoHost}:${mongoPort}` }] } })
+}
+// the collections can be written once the member becomes primary
+while (!admin.runCommand({ hello: 1 }).isWritablePrimary) {
+    print(`Waiting for replica set primary`)
+    sleep(1000)
+}
+
 // if database and collection exists, exit with success - already initialized
 const databases = connection.getDBNames()
 if (databases.includes(database)) {
@@ -17,6 +17,22 @@ import (
 type AmbulanceWaitingListAPI interface {
 
 
+    // BookAppointment Post /api/waiting-list/:ambulanceId/appointments
+    // Books an appointment slot in the ambulance 
+     BookAppointment(c *gin.Context)
+
+    // CallNextWaitingListEntry Post /api/waiting-list/:ambulanceId/next
+    // Calls the next patient from the waiting list 
+     CallNextWaitingListEntry(c *gin.Context)
+
+    // CallWaitingListEntry Post /api/waiting-list/:ambulanceId/entries/:entryId/call
+    // Calls the patient into the ambulance 
+     CallWaitingListEntry(c *gin.Context)
+
+    // CancelAppointment Delete /api/waiting-list/:ambulanceId/appointments/:entryId
+    // Cancels the booked appointment 
+     CancelAppointment(c *gin.Context)
+
     // CreateWaitingListEntry Post /api/waiting-list/:ambulanceId/entries
     // Saves new entry into waiting list 
      CreateWaitingListEntry(c *gin.Context)
@@ -25,6 +41,10 @@ type AmbulanceWaitingListAPI interface {
     // Deletes specific entry 
      DeleteWaitingListEntry(c *gin.Context)
 
+    // FinishWaitingListEntry Post /api/waiting-list/:ambulanceId/entries/:entryId/finish
+    // Finishes the ambulance visit of the patient 
+     FinishWaitingListEntry(c *gin.Context)
+
     // GetWaitingListEntries Get /api/waiting-list/:ambulanceId/entries
     // Provides the ambulance waiting list 
      GetWaitingListEntries(c *gin.Context)
@@ -33,6 +53,18 @@ type AmbulanceWaitingListAPI interface {
     // Provides details about waiting list entry 
      GetWaitingListEntry(c *gin.Context)
 
+    // GetWaitingListEvents Get /api/waiting-list/:ambulanceId/events
+    // Streams changes of the waiting list 
+     GetWaitingListEvents(c *gin.Context)
+
+    // MarkWaitingListEntryNoShow Post /api/waiting-list/:ambulanceId/entries/:entryId/no-show
+    // Records that the called patient did not show up 
+     MarkWaitingListEntryNoShow(c *gin.Context)
+
+    // StartWaitingListEntry Post /api/waiting-list/:ambulanceId/entries/:entryId/start
+    // Starts the ambulance visit of the patient 
+     StartWaitingListEntry(c *gin.Context)
+
     // UpdateWaitingListEntry Put /api/waiting-list/:ambulanceId/entries/:entryId
     // Updates specific entry 
      UpdateWaitingListEntry(c *gin.Context)
@@ -25,4 +25,20 @@ type AmbulancesAPI interface {
     // Deletes specific ambulance 
      DeleteAmbulance(c *gin.Context)
 
+    // GetAmbulance Get /api/ambulance/:ambulanceId
+    // Provides details about ambulance 
+     GetAmbulance(c *gin.Context)
+
+    // GetAmbulances Get /api/ambulance
+    // Provides the list of ambulances 
+     GetAmbulances(c *gin.Context)
+
+    // PatchAmbulance Patch /api/ambulance/:ambulanceId
+    // Partially updates specific ambulance 
+     PatchAmbulance(c *gin.Context)
+
+    // UpdateAmbulance Put /api/ambulance/:ambulanceId
+    // Updates specific ambulance 
+     UpdateAmbulance(c *gin.Context)
+
 }
\ No newline at end of file
@@ -0,0 +1,41 @@
+/*
+ * Waiting List Api
+ *
+ * Ambulance Waiting List management for Web-In-Cloud system
+ *
+ * API version: 1.0.0
+ * Contact: tomas.bocinec@siemens-healthineers.com
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+
+package ambulance_wl
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+type ApiKeysAPI interface {
+
+
+    // CreateApiKey Post /api/api-keys
+    // Issues new API key 
+     CreateApiKey(c *gin.Context)
+
+    // GetApiKey Get /api/api-keys/:keyId
+    // Provides details about the API key 
+     GetApiKey(c *gin.Context)
+
+    // GetApiKeys Get /api/api-keys
+    // Provides the list of API keys 
+     GetApiKeys(c *gin.Context)
+
+    // RevokeApiKey Post /api/api-keys/:keyId/revoke
+    // Revokes the API key 
+     RevokeApiKey(c *gin.Context)
+
+    // RotateApiKey Post /api/api-keys/:keyId/rotate
+    // Replaces the secret of the API key 
+     RotateApiKey(c *gin.Context)
+
+}
@@ -0,0 +1,24 @@
+/*
+ * Waiting List Api
+ *
+ * Ambulance Waiting List management for Web-In-Cloud system
+ *
+ * API version: 1.0.0
+ * Contact: tomas.bocinec@siemens-healthineers.com
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package ambulance_wl
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+type AuditAPI interface {
+
+
+    // GetAuditRecords Get /api/audit
+    // Provides the audit trail 
+     GetAuditRecords(c *gin.Context)
+
+}
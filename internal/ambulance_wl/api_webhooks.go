@@ -0,0 +1,52 @@
+/*
+ * Waiting List Api
+ *
+ * Ambulance Waiting List management for Web-In-Cloud system
+ *
+ * API version: 1.0.0
+ * Contact: tomas.bocinec@siemens-healthineers.com
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package ambulance_wl
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+type WebhooksAPI interface {
+
+
+    // CreateWebhook Post /api/webhooks
+    // Subscribes webhook to the waiting list events 
+     CreateWebhook(c *gin.Context)
+
+    // DeleteWebhook Delete /api/webhooks/:webhookId
+    // Deletes the webhook subscription 
+     DeleteWebhook(c *gin.Context)
+
+    // DeleteWebhookDeadLetter Delete /api/webhooks/:webhookId/dead-letters/:deliveryId
+    // Discards the failed delivery 
+     DeleteWebhookDeadLetter(c *gin.Context)
+
+    // GetWebhook Get /api/webhooks/:webhookId
+    // Provides details about the webhook subscription 
+     GetWebhook(c *gin.Context)
+
+    // GetWebhookDeadLetters Get /api/webhooks/:webhookId/dead-letters
+    // Provides events which could not be delivered to the webhook 
+     GetWebhookDeadLetters(c *gin.Context)
+
+    // GetWebhooks Get /api/webhooks
+    // Provides the list of webhook subscriptions 
+     GetWebhooks(c *gin.Context)
+
+    // ReplayWebhookDeadLetter Post /api/webhooks/:webhookId/dead-letters/:deliveryId/replay
+    // Delivers the failed event again 
+     ReplayWebhookDeadLetter(c *gin.Context)
+
+    // UpdateWebhook Put /api/webhooks/:webhookId
+    // Updates the webhook subscription 
+     UpdateWebhook(c *gin.Context)
+
+}
@@ -1,47 +1,361 @@
 package ambulance_wl
 
 import (
+	"cmp"
+	"errors"
+	"fmt"
 	"time"
 
 	"slices"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// priority assigned to entries without explicit triage level
+	defaultEntryPriority = 4
+	// most urgent triage level, entries are never promoted above it
+	highestEntryPriority = 1
+	lowestEntryPriority  = 5
+	// starvation protection used when ambulance does not configure its own
+	defaultMaxWaitingMinutes = 120
+	// visit duration used when ambulance does not configure its own
+	defaultDurationMinutes = 15
+	// minimal number of finished visits needed to trust the learned duration
+	minLearnedDurationSamples = 3
+	// only the most recent visits are considered when learning the duration
+	maxLearnedDurationSamples = 20
 )
 
 func (a *Ambulance) reconcileWaitingList() {
-	slices.SortFunc(a.WaitingList, func(left, right WaitingListEntry) int {
-		if left.WaitingSince.Before(right.WaitingSince) {
-			return -1
-		} else if left.WaitingSince.After(right.WaitingSince) {
-			return 1
-		} else {
-			return 0
+	if len(a.WaitingList) == 0 {
+		return
+	}
+
+	now := time.Now()
+	maxWaiting := time.Duration(a.MaxWaitingMinutes) * time.Minute
+	if maxWaiting <= 0 {
+		maxWaiting = defaultMaxWaitingMinutes * time.Minute
+	}
+
+	// patients in the ambulance first, then the called ones, then the waiting ones
+	// ordered by urgency and arrival, entries which left the list are kept at the end
+	slices.SortStableFunc(a.WaitingList, func(left, right WaitingListEntry) int {
+		if order := cmp.Compare(left.stateOrder(), right.stateOrder()); order != 0 {
+			return order
+		}
+		switch left.currentState() {
+		case IN_PROGRESS:
+			return timeOf(left.StartedAt).Compare(timeOf(right.StartedAt))
+		case CALLED:
+			return timeOf(left.CalledAt).Compare(timeOf(right.CalledAt))
+		case WAITING:
+			if order := cmp.Compare(left.effectivePriority(now, maxWaiting), right.effectivePriority(now, maxWaiting)); order != 0 {
+				return order
+			}
+		default:
+			return timeOf(left.FinishedAt).Compare(timeOf(right.FinishedAt))
 		}
+		return left.WaitingSince.Compare(right.WaitingSince)
 	})
 
-	// we assume the first entry EstimatedStart is the correct one (computed before previous entry was deleted)
-	// but cannot be before current time
-	// for sake of simplicity we ignore concepts of opening hours here
+	// invalid settings are rejected when the ambulance is stored, estimate
+	// continuously if the stored settings cannot be used anyway
+	schedule, err := a.openingSchedule()
+	if err != nil {
+		schedule = nil
+	}
+
+	// visits in progress use their real start time, appointments reserve their
+	// slots, and walk-in patients are estimated to start on the server which
+	// becomes available first, within the opening hours, and not before the
+	// current time
+	serverIds := a.serverIds()
+	availableAt := make(map[string]time.Time, len(serverIds))
+	for _, serverId := range serverIds {
+		availableAt[serverId] = now
+	}
+	candidateServers := func(entry *WaitingListEntry) []string {
+		if _, bound := availableAt[entry.ServerId]; bound {
+			return []string{entry.ServerId}
+		}
+		return serverIds
+	}
 
-	if a.WaitingList[0].EstimatedStart.Before(a.WaitingList[0].WaitingSince) {
-		a.WaitingList[0].EstimatedStart = a.WaitingList[0].WaitingSince
+	for i := range a.WaitingList {
+		entry := &a.WaitingList[i]
+		if entry.currentState() != IN_PROGRESS {
+			continue
+		}
+		entry.EstimatedStart = timeOf(entry.StartedAt)
+		// visit without explicit server occupies the one available first
+		serverId := slices.MinFunc(candidateServers(entry), func(left, right string) int {
+			return availableAt[left].Compare(availableAt[right])
+		})
+		if end := timeOf(entry.StartedAt).Add(entry.duration()); end.After(availableAt[serverId]) {
+			availableAt[serverId] = end
+		}
 	}
 
-	if a.WaitingList[0].EstimatedStart.Before(time.Now()) {
-		a.WaitingList[0].EstimatedStart = time.Now()
+	reserved := map[string][]timeRange{}
+	for i := range a.WaitingList {
+		entry := &a.WaitingList[i]
+		if !entry.isActive() || entry.currentState() == IN_PROGRESS || !entry.isAppointment() {
+			continue
+		}
+		// overlapping bookings are rejected, take the first server if they exist anyway
+		candidates := candidateServers(entry)
+		slot := entry.appointmentSlot()
+		serverIndx := max(slices.IndexFunc(candidates, func(serverId string) bool {
+			return !slot.overlapsAny(reserved[serverId])
+		}), 0)
+		serverId := candidates[serverIndx]
+
+		// appointment is delayed only by the visit in progress
+		entry.EstimatedStart = slot.from
+		if availableAt[serverId].After(entry.EstimatedStart) {
+			entry.EstimatedStart = availableAt[serverId]
+		}
+		reserved[serverId] = append(reserved[serverId], timeRange{
+			from: entry.EstimatedStart,
+			to:   entry.EstimatedStart.Add(entry.duration()),
+		})
 	}
 
-	nextEntryStart :=
-		a.WaitingList[0].EstimatedStart.
-			Add(time.Duration(a.WaitingList[0].EstimatedDurationMinutes) * time.Minute)
-	for _, entry := range a.WaitingList[1:] {
-		if entry.EstimatedStart.Before(nextEntryStart) {
-			entry.EstimatedStart = nextEntryStart
+	for i := range a.WaitingList {
+		entry := &a.WaitingList[i]
+		if !entry.isActive() || entry.currentState() == IN_PROGRESS || entry.isAppointment() {
+			continue
 		}
-		if entry.EstimatedStart.Before(entry.WaitingSince) {
-			entry.EstimatedStart = entry.WaitingSince
+		var serverId string
+		for _, candidate := range candidateServers(entry) {
+			start := availableAt[candidate]
+			if start.Before(entry.WaitingSince) {
+				start = entry.WaitingSince
+			}
+			start = schedule.nextFreeStart(reserved[candidate], start, entry.duration())
+			if serverId == "" || start.Before(entry.EstimatedStart) {
+				serverId = candidate
+				entry.EstimatedStart = start
+			}
 		}
+		availableAt[serverId] = entry.EstimatedStart.Add(entry.duration())
+	}
 
-		nextEntryStart =
-			entry.EstimatedStart.
-				Add(time.Duration(entry.EstimatedDurationMinutes) * time.Minute)
+	// appointments are placed among the waiting walk-in patients by their slots
+	firstWaiting := slices.IndexFunc(a.WaitingList, func(entry WaitingListEntry) bool {
+		return entry.currentState() == WAITING
+	})
+	if firstWaiting >= 0 {
+		waitingCount := 0
+		for _, entry := range a.WaitingList[firstWaiting:] {
+			if entry.currentState() != WAITING {
+				break
+			}
+			waitingCount++
+		}
+		slices.SortStableFunc(a.WaitingList[firstWaiting:firstWaiting+waitingCount], func(left, right WaitingListEntry) int {
+			return left.EstimatedStart.Compare(right.EstimatedStart)
+		})
+	}
+}
+
+// admitWalkIn prepares the walk-in patient to join the waiting list. The
+// lifecycle of the entry is reset, the missing properties are defaulted and
+// the entry is validated against the ambulance, invalid properties are
+// reported relative to the pointer of the entry in the request. The duration
+// of the visit is learned from the finished visits. The entry is not added to
+// the list.
+func (a *Ambulance) admitWalkIn(entry *WaitingListEntry, pointer string, visits []WaitingListEntry) error {
+	invalid := fieldErrors{}
+	if entry.PatientId == "" {
+		invalid = append(invalid, requiredField("/patientId"))
+	}
+	var priorityErrors fieldErrors
+	if errors.As(entry.validatePriority(), &priorityErrors) {
+		invalid = append(invalid, priorityErrors...)
+	}
+	if entry.ServerId != "" && !a.hasServer(entry.ServerId) {
+		invalid = append(invalid, invalidField("/serverId", "server not found in the ambulance"))
+	}
+	if len(invalid) > 0 {
+		for i := range invalid {
+			invalid[i].Field = pointer + invalid[i].Field
+		}
+		return invalid
+	}
+
+	if entry.Priority == 0 {
+		entry.Priority = defaultEntryPriority
+	}
+
+	// appointments are booked by BookAppointment
+	entry.Type = WALK_IN
+	entry.AppointmentAt = time.Time{}
+
+	// lifecycle of the entry is driven only by the transition operations
+	entry.State = WAITING
+	entry.CalledAt = nil
+	entry.StartedAt = nil
+	entry.FinishedAt = nil
+	if entry.WaitingSince.IsZero() {
+		entry.WaitingSince = time.Now()
+	}
+
+	if entry.EstimatedDurationMinutes <= 0 {
+		entry.EstimatedDurationMinutes = a.estimateDuration(entry, visits)
+	}
+
+	if entry.Id == "" || entry.Id == "@new" {
+		entry.Id = uuid.NewString()
+	}
+
+	// patient may return after the previous visit has finished, or have booked appointments
+	if slices.ContainsFunc(a.WaitingList, func(waiting WaitingListEntry) bool {
+		return entry.Id == waiting.Id ||
+			(entry.PatientId == waiting.PatientId && waiting.isActive() && !waiting.isAppointment())
+	}) {
+		return errEntryExists
+	}
+	return nil
+}
+
+// appointmentConflicts reports whether the slot of the appointment overlaps
+// with other booked appointments so that there is no server left to serve it
+func (a *Ambulance) appointmentConflicts(appointment *WaitingListEntry) bool {
+	slot := appointment.appointmentSlot()
+	overlapping := 0
+	for i := range a.WaitingList {
+		booked := &a.WaitingList[i]
+		if booked.Id == appointment.Id || !booked.isAppointment() || !booked.isActive() ||
+			!slot.overlaps(booked.appointmentSlot()) {
+			continue
+		}
+		if appointment.ServerId != "" && booked.ServerId == appointment.ServerId {
+			return true
+		}
+		overlapping++
+	}
+	return overlapping >= len(a.serverIds())
+}
+
+// implicitServerId identifies the single server of ambulance without configured servers
+const implicitServerId = ""
+
+// serverIds returns ids of the servers serving the patients in parallel
+func (a *Ambulance) serverIds() []string {
+	if len(a.Servers) == 0 {
+		return []string{implicitServerId}
+	}
+	ids := make([]string, 0, len(a.Servers))
+	for _, server := range a.Servers {
+		ids = append(ids, server.Id)
+	}
+	return ids
+}
+
+// hasServer reports whether the ambulance has a server with the id
+func (a *Ambulance) hasServer(serverId string) bool {
+	return slices.ContainsFunc(a.Servers, func(server Server) bool {
+		return server.Id == serverId
+	})
+}
+
+// estimateDuration returns expected duration of the visit in minutes for the
+// entry which does not provide its own estimate. The learned duration of the
+// recently finished visits with the same condition takes precedence over the
+// typical duration of the predefined condition, the ambulance default is used
+// if neither is known. Finished visits are not part of the waiting list, they
+// are provided by the caller.
+func (a *Ambulance) estimateDuration(entry *WaitingListEntry, visits []WaitingListEntry) int32 {
+	code := entry.Condition.Code
+	if code == "" {
+		return a.defaultDuration()
+	}
+
+	if learned, ok := learnedDuration(code, visits); ok {
+		return learned
+	}
+
+	conditionIndx := slices.IndexFunc(a.PredefinedConditions, func(condition Condition) bool {
+		return condition.Code == code
+	})
+	if conditionIndx >= 0 && a.PredefinedConditions[conditionIndx].TypicalDurationMinutes > 0 {
+		return a.PredefinedConditions[conditionIndx].TypicalDurationMinutes
+	}
+	return a.defaultDuration()
+}
+
+func (a *Ambulance) defaultDuration() int32 {
+	if a.DefaultDurationMinutes > 0 {
+		return a.DefaultDurationMinutes
+	}
+	return defaultDurationMinutes
+}
+
+// learnedDuration averages real durations of the most recent finished visits with the condition
+func learnedDuration(code string, finished []WaitingListEntry) (int32, bool) {
+	visits := []WaitingListEntry{}
+	for _, entry := range finished {
+		if entry.currentState() == FINISHED && entry.Condition.Code == code &&
+			entry.StartedAt != nil && entry.FinishedAt != nil && entry.FinishedAt.After(*entry.StartedAt) {
+			visits = append(visits, entry)
+		}
+	}
+	if len(visits) < minLearnedDurationSamples {
+		return 0, false
+	}
+
+	slices.SortFunc(visits, func(left, right WaitingListEntry) int {
+		return right.FinishedAt.Compare(*left.FinishedAt)
+	})
+	visits = visits[:min(len(visits), maxLearnedDurationSamples)]
+
+	var total time.Duration
+	for _, visit := range visits {
+		total += visit.FinishedAt.Sub(*visit.StartedAt)
+	}
+	average := total / time.Duration(len(visits))
+	return max(int32(average.Round(time.Minute)/time.Minute), 1), true
+}
+
+// GetVersion implements db_service.Versioned
+func (a *Ambulance) GetVersion() int64 {
+	return a.Version
+}
+
+// SetVersion implements db_service.Versioned
+func (a *Ambulance) SetVersion(version int64) {
+	a.Version = version
+}
+
+// validate checks that the ambulance has all properties required by the Ambulance schema
+// and that its opening hours and servers can be used for scheduling
+func (a *Ambulance) validate() error {
+	invalid := fieldErrors{}
+	if a.Id == "" {
+		invalid = append(invalid, requiredField("/id"))
+	}
+	if a.Name == "" {
+		invalid = append(invalid, requiredField("/name"))
+	}
+	if a.RoomNumber == "" {
+		invalid = append(invalid, requiredField("/roomNumber"))
+	}
+	if _, err := a.openingSchedule(); err != nil {
+		invalid = append(invalid, invalidField("/openingHours", "invalid opening hours: "+err.Error()))
+	}
+	serverIds := map[string]bool{}
+	for i, server := range a.Servers {
+		if server.Id == "" {
+			invalid = append(invalid, requiredField(fmt.Sprintf("/servers/%d/id", i)))
+		} else if serverIds[server.Id] {
+			invalid = append(invalid, invalidField(fmt.Sprintf("/servers/%d/id", i), fmt.Sprintf("duplicate server id %q", server.Id)))
+		}
+		serverIds[server.Id] = true
+	}
+	if len(invalid) > 0 {
+		return invalid
 	}
+	return nil
 }
@@ -0,0 +1,266 @@
+package ambulance_wl
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type AmbulanceModelSuite struct {
+	suite.Suite
+}
+
+func TestAmbulanceModelSuite(t *testing.T) {
+	suite.Run(t, new(AmbulanceModelSuite))
+}
+
+func (suite *AmbulanceModelSuite) entryIds(ambulance *Ambulance) []string {
+	ids := []string{}
+	for _, entry := range ambulance.WaitingList {
+		ids = append(ids, entry.Id)
+	}
+	return ids
+}
+
+func (suite *AmbulanceModelSuite) Test_ReconcileWaitingList_OrdersByPriorityThenArrival() {
+	// ARRANGE
+	now := time.Now()
+	ambulance := &Ambulance{
+		WaitingList: []WaitingListEntry{
+			{Id: "routine", WaitingSince: now.Add(-30 * time.Minute), EstimatedDurationMinutes: 15},
+			{Id: "non-urgent", WaitingSince: now.Add(-40 * time.Minute), EstimatedDurationMinutes: 15, Priority: 5},
+			{Id: "acute", WaitingSince: now.Add(-5 * time.Minute), EstimatedDurationMinutes: 20, Priority: 1},
+			{Id: "routine-later", WaitingSince: now.Add(-10 * time.Minute), EstimatedDurationMinutes: 15, Priority: 4},
+		},
+	}
+
+	// ACT
+	ambulance.reconcileWaitingList()
+
+	// ASSERT
+	suite.Equal([]string{"acute", "routine", "routine-later", "non-urgent"}, suite.entryIds(ambulance))
+	suite.False(ambulance.WaitingList[0].EstimatedStart.Before(now))
+	for i := 1; i < len(ambulance.WaitingList); i++ {
+		previous := ambulance.WaitingList[i-1]
+		suite.Equal(
+			previous.EstimatedStart.Add(time.Duration(previous.EstimatedDurationMinutes)*time.Minute),
+			ambulance.WaitingList[i].EstimatedStart)
+	}
+}
+
+func (suite *AmbulanceModelSuite) Test_ReconcileWaitingList_PromotesLongWaitingEntries() {
+	// ARRANGE
+	now := time.Now()
+	ambulance := &Ambulance{
+		MaxWaitingMinutes: 30,
+		WaitingList: []WaitingListEntry{
+			{Id: "urgent", WaitingSince: now.Add(-5 * time.Minute), Priority: 3},
+			{Id: "starving", WaitingSince: now.Add(-65 * time.Minute), Priority: 5},
+			{Id: "standard", WaitingSince: now.Add(-45 * time.Minute), Priority: 4},
+		},
+	}
+
+	// ACT
+	ambulance.reconcileWaitingList()
+
+	// ASSERT
+	// starving is promoted to 3 and waits longer than urgent, standard is promoted to 3 as well
+	suite.Equal([]string{"starving", "standard", "urgent"}, suite.entryIds(ambulance))
+	suite.Equal(int32(5), ambulance.WaitingList[0].Priority)
+}
+
+func (suite *AmbulanceModelSuite) Test_ReconcileWaitingList_EmptyList() {
+	// ARRANGE
+	ambulance := &Ambulance{}
+
+	// ACT & ASSERT
+	suite.NotPanics(ambulance.reconcileWaitingList)
+}
+
+func (suite *AmbulanceModelSuite) Test_ReconcileWaitingList_UsesStartOfVisitInProgress() {
+	// ARRANGE
+	now := time.Now()
+	startedAt := now.Add(-10 * time.Minute)
+	ambulance := &Ambulance{
+		WaitingList: []WaitingListEntry{
+			{Id: "waiting", State: WAITING, WaitingSince: now.Add(-60 * time.Minute), EstimatedDurationMinutes: 15},
+			{Id: "finished", State: FINISHED, WaitingSince: now.Add(-90 * time.Minute), EstimatedDurationMinutes: 15},
+			{Id: "in-progress", State: IN_PROGRESS, WaitingSince: now.Add(-30 * time.Minute), StartedAt: &startedAt, EstimatedDurationMinutes: 30},
+		},
+	}
+
+	// ACT
+	ambulance.reconcileWaitingList()
+
+	// ASSERT
+	suite.Equal([]string{"in-progress", "waiting", "finished"}, suite.entryIds(ambulance))
+	suite.Equal(startedAt, ambulance.WaitingList[0].EstimatedStart)
+	suite.Equal(startedAt.Add(30*time.Minute), ambulance.WaitingList[1].EstimatedStart)
+	suite.True(ambulance.WaitingList[2].EstimatedStart.IsZero())
+}
+
+func (suite *AmbulanceModelSuite) Test_EstimateDuration_FallbackChain() {
+	// ARRANGE
+	finishedAt := time.Now().Add(-2 * time.Hour)
+	finished := func(code string, minutes int) WaitingListEntry {
+		startedAt := finishedAt.Add(-time.Duration(minutes) * time.Minute)
+		return WaitingListEntry{
+			State:      FINISHED,
+			Condition:  Condition{Code: code},
+			StartedAt:  &startedAt,
+			FinishedAt: &finishedAt,
+		}
+	}
+	ambulance := &Ambulance{
+		DefaultDurationMinutes: 12,
+		PredefinedConditions: []Condition{
+			{Code: "nausea", TypicalDurationMinutes: 45},
+			{Code: "followup", TypicalDurationMinutes: 15},
+		},
+	}
+	visits := []WaitingListEntry{
+		finished("nausea", 30), finished("nausea", 32), finished("nausea", 37),
+		finished("followup", 5), finished("followup", 5),
+	}
+
+	// ACT & ASSERT
+	suite.Equal(int32(33), ambulance.estimateDuration(&WaitingListEntry{Condition: Condition{Code: "nausea"}}, visits))
+	// not enough finished visits to learn from
+	suite.Equal(int32(15), ambulance.estimateDuration(&WaitingListEntry{Condition: Condition{Code: "followup"}}, visits))
+	suite.Equal(int32(12), ambulance.estimateDuration(&WaitingListEntry{Condition: Condition{Code: "unknown"}}, visits))
+	suite.Equal(int32(defaultDurationMinutes), (&Ambulance{}).estimateDuration(&WaitingListEntry{}, nil))
+}
+
+func (suite *AmbulanceModelSuite) Test_ReconcileWaitingList_SkipsClosedIntervals() {
+	// ARRANGE
+	location, err := time.LoadLocation("Europe/Bratislava")
+	suite.Require().NoError(err)
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2038, 12, day, hour, minute, 0, 0, location)
+	}
+	ambulance := &Ambulance{
+		TimeZone: "Europe/Bratislava",
+		OpeningHours: []OpeningHours{
+			{DayOfWeek: THURSDAY, From: "08:00", To: "12:00", Breaks: []TimeInterval{{From: "10:00", To: "10:30"}}},
+			{DayOfWeek: FRIDAY, From: "08:00", To: "12:00"},
+			{DayOfWeek: MONDAY, From: "08:00", To: "12:00"},
+		},
+		Closures: []Closure{{From: "2038-12-24", To: "2038-12-26", Reason: "Christmas"}},
+		WaitingList: []WaitingListEntry{
+			{Id: "a", WaitingSince: at(23, 9, 40), EstimatedDurationMinutes: 15},
+			{Id: "b", WaitingSince: at(23, 9, 41), EstimatedDurationMinutes: 15},
+			{Id: "c", WaitingSince: at(23, 9, 42), EstimatedDurationMinutes: 120},
+			{Id: "d", WaitingSince: at(23, 9, 43), EstimatedDurationMinutes: 15},
+		},
+	}
+	_, err = ambulance.openingSchedule()
+	suite.Require().NoError(err)
+
+	// ACT
+	ambulance.reconcileWaitingList()
+
+	// ASSERT
+	starts := []time.Time{}
+	for _, entry := range ambulance.WaitingList {
+		starts = append(starts, entry.EstimatedStart.In(location))
+	}
+	suite.Equal([]time.Time{
+		at(23, 9, 40),
+		// postponed after the lunch break
+		at(23, 10, 30),
+		// does not fit before closing, friday is a holiday
+		at(27, 8, 0),
+		at(27, 10, 0),
+	}, starts)
+}
+
+func (suite *AmbulanceModelSuite) Test_Validate_InvalidOpeningHours() {
+	// ARRANGE
+	ambulance := &Ambulance{
+		Id:           "test-ambulance",
+		Name:         "Test Ambulance",
+		RoomNumber:   "101",
+		OpeningHours: []OpeningHours{{DayOfWeek: MONDAY, From: "12:00", To: "08:00"}},
+	}
+
+	// ACT
+	err := ambulance.validate()
+
+	// ASSERT
+	suite.ErrorContains(err, "invalid opening hours")
+}
+
+func (suite *AmbulanceModelSuite) Test_ReconcileWaitingList_ParallelServers() {
+	// ARRANGE
+	base := time.Now().Add(time.Hour).Truncate(time.Minute)
+	ambulance := &Ambulance{
+		Servers: []Server{{Id: "doctor"}, {Id: "nurse"}},
+		WaitingList: []WaitingListEntry{
+			{Id: "first", WaitingSince: base, EstimatedDurationMinutes: 30},
+			{Id: "second", WaitingSince: base.Add(time.Minute), EstimatedDurationMinutes: 10},
+			{Id: "third", WaitingSince: base.Add(2 * time.Minute), EstimatedDurationMinutes: 10},
+			{Id: "doctor-only", WaitingSince: base.Add(3 * time.Minute), EstimatedDurationMinutes: 10, ServerId: "doctor"},
+		},
+	}
+
+	// ACT
+	ambulance.reconcileWaitingList()
+
+	// ASSERT
+	suite.Equal([]string{"first", "second", "third", "doctor-only"}, suite.entryIds(ambulance))
+	suite.Equal(base, ambulance.WaitingList[0].EstimatedStart)
+	suite.Equal(base.Add(time.Minute), ambulance.WaitingList[1].EstimatedStart)
+	// nurse is available first after the second patient
+	suite.Equal(base.Add(11*time.Minute), ambulance.WaitingList[2].EstimatedStart)
+	// waits for the doctor even though the nurse is available sooner
+	suite.Equal(base.Add(30*time.Minute), ambulance.WaitingList[3].EstimatedStart)
+}
+
+func (suite *AmbulanceModelSuite) Test_ReconcileWaitingList_WalkInsFillGapsAroundAppointments() {
+	// ARRANGE
+	base := time.Now().Add(time.Hour).Truncate(time.Minute)
+	ambulance := &Ambulance{
+		WaitingList: []WaitingListEntry{
+			{Id: "walk-in-1", WaitingSince: base, EstimatedDurationMinutes: 20},
+			{Id: "walk-in-2", WaitingSince: base.Add(time.Minute), EstimatedDurationMinutes: 15},
+			{Id: "walk-in-3", WaitingSince: base.Add(2 * time.Minute), EstimatedDurationMinutes: 10},
+			{Id: "appointment", Type: APPOINTMENT, WaitingSince: base.Add(-24 * time.Hour),
+				AppointmentAt: base.Add(30 * time.Minute), EstimatedDurationMinutes: 15},
+		},
+	}
+
+	// ACT
+	ambulance.reconcileWaitingList()
+
+	// ASSERT
+	// walk-in-2 does not fit into the gap before the appointment and follows it
+	suite.Equal([]string{"walk-in-1", "appointment", "walk-in-2", "walk-in-3"}, suite.entryIds(ambulance))
+	suite.Equal(base, ambulance.WaitingList[0].EstimatedStart)
+	suite.Equal(base.Add(30*time.Minute), ambulance.WaitingList[1].EstimatedStart)
+	suite.Equal(base.Add(45*time.Minute), ambulance.WaitingList[2].EstimatedStart)
+	suite.Equal(base.Add(60*time.Minute), ambulance.WaitingList[3].EstimatedStart)
+}
+
+func (suite *AmbulanceModelSuite) Test_AppointmentConflicts() {
+	// ARRANGE
+	at := time.Date(2038, 12, 24, 13, 0, 0, 0, time.UTC)
+	booked := WaitingListEntry{Id: "booked", Type: APPOINTMENT, AppointmentAt: at, EstimatedDurationMinutes: 30}
+	overlapping := &WaitingListEntry{Id: "new", Type: APPOINTMENT, AppointmentAt: at.Add(15 * time.Minute), EstimatedDurationMinutes: 30}
+	following := &WaitingListEntry{Id: "new", Type: APPOINTMENT, AppointmentAt: at.Add(30 * time.Minute), EstimatedDurationMinutes: 30}
+
+	single := &Ambulance{WaitingList: []WaitingListEntry{booked}}
+	parallel := &Ambulance{Servers: []Server{{Id: "doctor"}, {Id: "nurse"}}, WaitingList: []WaitingListEntry{booked}}
+	boundBooked := booked
+	boundBooked.ServerId = "doctor"
+	bound := &Ambulance{Servers: []Server{{Id: "doctor"}, {Id: "nurse"}}, WaitingList: []WaitingListEntry{boundBooked}}
+
+	// ACT & ASSERT
+	suite.True(single.appointmentConflicts(overlapping))
+	suite.False(single.appointmentConflicts(following))
+	suite.False(parallel.appointmentConflicts(overlapping))
+	suite.False(bound.appointmentConflicts(overlapping))
+	suite.True(bound.appointmentConflicts(&WaitingListEntry{
+		Id: "new", Type: APPOINTMENT, AppointmentAt: at, EstimatedDurationMinutes: 30, ServerId: "doctor",
+	}))
+}
@@ -0,0 +1,182 @@
+package ambulance_wl
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/wac-fiit/cv2-ambulance-webapi/internal/auth"
+)
+
+var (
+	errUnknownApiKey = errors.New("unknown API key")
+	errRevokedApiKey = errors.New("API key is revoked")
+	errExpiredApiKey = errors.New("API key expired")
+)
+
+// StoredApiKey is the API key as kept in the database, the key itself is
+// never stored, only its hash
+type StoredApiKey struct {
+	ApiKey `bson:",inline"`
+
+	// hex encoded SHA-256 hash of the key
+	KeyHash string
+
+	// revision of the stored key, the usage tracking must not overwrite
+	// concurrent rotation or revocation
+	Version int64
+}
+
+// GetVersion implements db_service.Versioned
+func (k *StoredApiKey) GetVersion() int64 {
+	return k.Version
+}
+
+// SetVersion implements db_service.Versioned
+func (k *StoredApiKey) SetVersion(version int64) {
+	k.Version = version
+}
+
+// apiKeyScopes lists the permissions which may be granted to the API keys
+func apiKeyScopes() []string {
+	scopes := []string{permissionAmbulancesAll}
+	for _, permission := range routePermissions {
+		if !slices.Contains(scopes, permission) {
+			scopes = append(scopes, permission)
+		}
+	}
+	return scopes
+}
+
+// validate checks the API key requested by the admin
+func (k *ApiKey) validate(now time.Time) error {
+	invalid := fieldErrors{}
+	if k.Name == "" {
+		invalid = append(invalid, requiredField("/name"))
+	}
+	if len(k.Scopes) == 0 {
+		invalid = append(invalid, requiredField("/scopes"))
+	}
+	scopes := apiKeyScopes()
+	for i, scope := range k.Scopes {
+		if !slices.Contains(scopes, scope) {
+			invalid = append(invalid, invalidField(fmt.Sprintf("/scopes/%d", i), fmt.Sprintf("unknown scope %q", scope)))
+		}
+	}
+	if !k.ExpiresAt.IsZero() && !k.ExpiresAt.After(now) {
+		invalid = append(invalid, invalidField("/expiresAt", "expiry is not in the future"))
+	}
+	if len(invalid) > 0 {
+		return invalid
+	}
+	return nil
+}
+
+// restrictTo checks that the key grants nothing the principal issuing it is
+// not granted itself, principal is nil if the requests are not authenticated.
+// Principal restricted to the assigned ambulances may issue keys only for those
+// ambulances, the key of the other principals is granted all ambulances
+// explicitly if it is not restricted.
+func (k *ApiKey) restrictTo(principal *auth.Principal, policy *auth.Policy, assigned []string, restricted bool) (problemKind, string, bool) {
+	for _, scope := range k.Scopes {
+		if principal == nil {
+			break
+		}
+		if !policy.Allows(principal, scope) {
+			return problemForbidden, fmt.Sprintf("scope %s is not granted to %s", scope, principal.Username), false
+		}
+	}
+	if !restricted {
+		if len(k.AmbulanceIds) == 0 && !slices.Contains(k.Scopes, permissionAmbulancesAll) {
+			k.Scopes = append(k.Scopes, permissionAmbulancesAll)
+		}
+		return problemKind{}, "", true
+	}
+	if len(k.AmbulanceIds) == 0 {
+		return problemAmbulanceNotAssigned, "key must be restricted to the ambulances assigned to " + principal.Username, false
+	}
+	for _, ambulanceId := range k.AmbulanceIds {
+		if !slices.Contains(assigned, ambulanceId) {
+			return problemAmbulanceNotAssigned, "ambulance " + ambulanceId + " is not assigned to " + principal.Username, false
+		}
+	}
+	return problemKind{}, "", true
+}
+
+// issue generates new key, only its hash is kept in the stored key. The key
+// is prefixed with the id of the stored key to find it without scanning. The
+// time of the first issue is kept when the key is rotated.
+func (k *StoredApiKey) issue(now time.Time) (key string, err error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	key = k.Id + "." + base64.RawURLEncoding.EncodeToString(secret)
+	k.KeyHash = hashApiKey(key)
+	if k.CreatedAt.IsZero() {
+		k.CreatedAt = now
+	}
+	return key, nil
+}
+
+func hashApiKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
+
+// apiKeyId returns the id of the stored key the key was issued for
+func apiKeyId(key string) string {
+	id, _, _ := strings.Cut(key, ".")
+	return id
+}
+
+// verify checks that the key matches the stored one and can be used
+func (k *StoredApiKey) verify(key string, now time.Time) error {
+	if subtle.ConstantTimeCompare([]byte(hashApiKey(key)), []byte(k.KeyHash)) != 1 {
+		return errUnknownApiKey
+	}
+	if !k.RevokedAt.IsZero() {
+		return errRevokedApiKey
+	}
+	if !k.ExpiresAt.IsZero() && !now.Before(k.ExpiresAt) {
+		return errExpiredApiKey
+	}
+	return nil
+}
+
+// principal returns the principal authenticated by the key, the key grants
+// only its scopes, the key restricted to some ambulances never all of them
+func (k *StoredApiKey) principal() *auth.Principal {
+	principal := &auth.Principal{
+		Subject:     k.Id,
+		Username:    k.Name,
+		Permissions: slices.Clone(k.Scopes),
+		Claims:      jwt.MapClaims{"sub": k.Id},
+	}
+	if len(k.AmbulanceIds) > 0 {
+		principal.Permissions = slices.DeleteFunc(principal.Permissions, func(scope string) bool {
+			return scope == permissionAmbulancesAll
+		})
+		ambulances := []interface{}{}
+		for _, ambulanceId := range k.AmbulanceIds {
+			ambulances = append(ambulances, ambulanceId)
+		}
+		principal.Claims[assignedAmbulancesClaim] = ambulances
+	}
+	return principal
+}
+
+// withoutSecrets returns the API key as provided by the API
+func (k *StoredApiKey) withoutSecrets() *ApiKey {
+	result := k.ApiKey
+	result.Key = ""
+	return &result
+}
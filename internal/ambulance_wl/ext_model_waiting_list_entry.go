@@ -0,0 +1,133 @@
+package ambulance_wl
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+var errInvalidStateTransition = errors.New("invalid state transition")
+var errEntryExists = errors.New("waiting list entry already exists")
+
+// entryStateTransitions lists states reachable from the given state
+var entryStateTransitions = map[EntryState][]EntryState{
+	WAITING:     {CALLED, IN_PROGRESS},
+	CALLED:      {IN_PROGRESS, NO_SHOW},
+	IN_PROGRESS: {FINISHED},
+}
+
+// GetVersion implements db_service.Versioned
+func (e *WaitingListEntry) GetVersion() int64 {
+	return e.Version
+}
+
+// SetVersion implements db_service.Versioned
+func (e *WaitingListEntry) SetVersion(version int64) {
+	e.Version = version
+}
+
+// effectivePriority returns the triage priority of the entry raised by one
+// level for every elapsed maxWaiting, so that less urgent patients are not
+// postponed indefinitely by the more urgent ones
+func (e *WaitingListEntry) effectivePriority(now time.Time, maxWaiting time.Duration) int32 {
+	priority := e.Priority
+	if priority == 0 {
+		priority = defaultEntryPriority
+	}
+	if waited := now.Sub(e.WaitingSince); waited > 0 {
+		priority -= int32(waited / maxWaiting)
+	}
+	return max(priority, highestEntryPriority)
+}
+
+// validatePriority checks that the priority is on the triage scale, zero means not provided
+func (e *WaitingListEntry) validatePriority() error {
+	if e.Priority != 0 && (e.Priority < highestEntryPriority || e.Priority > lowestEntryPriority) {
+		return fieldErrors{invalidField("/priority",
+			fmt.Sprintf("priority must be between %d and %d", highestEntryPriority, lowestEntryPriority))}
+	}
+	return nil
+}
+
+// currentState returns state of the entry, entries stored before introducing
+// the lifecycle have no state and are considered waiting
+func (e *WaitingListEntry) currentState() EntryState {
+	if e.State == "" {
+		return WAITING
+	}
+	return e.State
+}
+
+// isActive reports whether the patient is still expected in the ambulance
+func (e *WaitingListEntry) isActive() bool {
+	return !e.currentState().isTerminal()
+}
+
+// isTerminal reports whether the entry in the state left the waiting list
+func (s EntryState) isTerminal() bool {
+	return s == FINISHED || s == NO_SHOW
+}
+
+// transitionTo moves the entry to the target state and records the time of the transition
+func (e *WaitingListEntry) transitionTo(state EntryState, at time.Time) error {
+	if !slices.Contains(entryStateTransitions[e.currentState()], state) {
+		return fmt.Errorf("%w: entry in state %s cannot become %s", errInvalidStateTransition, e.currentState(), state)
+	}
+	switch state {
+	case CALLED:
+		e.CalledAt = &at
+	case IN_PROGRESS:
+		e.StartedAt = &at
+	case FINISHED, NO_SHOW:
+		e.FinishedAt = &at
+	}
+	e.State = state
+	return nil
+}
+
+// clearUnsetTransitions drops the zero timestamps of the transitions which did
+// not happen, older versions of the service stored them instead of omitting them
+func (e *WaitingListEntry) clearUnsetTransitions() {
+	for _, at := range []**time.Time{&e.CalledAt, &e.StartedAt, &e.FinishedAt} {
+		if *at != nil && (*at).IsZero() {
+			*at = nil
+		}
+	}
+}
+
+// timeOf returns the time of the transition, zero if it did not happen yet
+func timeOf(at *time.Time) time.Time {
+	if at == nil {
+		return time.Time{}
+	}
+	return *at
+}
+
+// entryStateOrder ranks the states in the order in which the entries are served
+var entryStateOrder = map[EntryState]int{
+	IN_PROGRESS: 0,
+	CALLED:      1,
+	WAITING:     2,
+	FINISHED:    3,
+	NO_SHOW:     3,
+}
+
+func (e *WaitingListEntry) stateOrder() int {
+	return entryStateOrder[e.currentState()]
+}
+
+// isAppointment reports whether the entry reserves a booked slot
+func (e *WaitingListEntry) isAppointment() bool {
+	return e.Type == APPOINTMENT
+}
+
+// duration returns the estimated duration of the visit
+func (e *WaitingListEntry) duration() time.Duration {
+	return time.Duration(e.EstimatedDurationMinutes) * time.Minute
+}
+
+// appointmentSlot returns the booked slot of the appointment
+func (e *WaitingListEntry) appointmentSlot() timeRange {
+	return timeRange{from: e.AppointmentAt, to: e.AppointmentAt.Add(e.duration())}
+}
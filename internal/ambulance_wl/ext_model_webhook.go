@@ -0,0 +1,94 @@
+package ambulance_wl
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+)
+
+var webhookEventTypes = []WebhookEventType{ENTRY_ENQUEUED, ENTRY_CALLED, ENTRY_REMOVED}
+
+var errMissingWebhookSecret = fieldErrors{requiredField("/secret")}
+
+var errWebhookTargetNotAllowed = errors.New("webhook target is not allowed")
+
+// suffixes of the host names resolved inside of the cluster or the host
+var internalHostSuffixes = []string{".localhost", ".local", ".internal", ".svc"}
+
+// validate checks the webhook subscription, the secret is checked by the callers
+// because updates may keep the stored one
+func (w *Webhook) validate() error {
+	invalid := fieldErrors{}
+	if w.Id == "" {
+		invalid = append(invalid, requiredField("/id"))
+	}
+	if w.Url == "" {
+		invalid = append(invalid, requiredField("/url"))
+	} else if target, err := url.Parse(w.Url); err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		invalid = append(invalid, invalidField("/url", fmt.Sprintf("invalid url %q, expected absolute http or https URL", w.Url)))
+	} else if err := checkWebhookHost(target.Hostname()); err != nil {
+		invalid = append(invalid, invalidField("/url", fmt.Sprintf("invalid url %q, %v", w.Url, err)))
+	}
+	for i, eventType := range w.EventTypes {
+		if !slices.Contains(webhookEventTypes, eventType) {
+			invalid = append(invalid, invalidField(fmt.Sprintf("/eventTypes/%d", i), fmt.Sprintf("unknown event type %q", eventType)))
+		}
+	}
+	if len(invalid) > 0 {
+		return invalid
+	}
+	return nil
+}
+
+// matches tells if the event shall be delivered to the webhook
+func (w *Webhook) matches(event WebhookEvent) bool {
+	return (len(w.EventTypes) == 0 || slices.Contains(w.EventTypes, event.Type)) &&
+		(len(w.AmbulanceIds) == 0 || slices.Contains(w.AmbulanceIds, event.AmbulanceId))
+}
+
+// checkWebhookHost refuses the hosts of the cluster and of the service itself,
+// so that the webhooks cannot be used to reach the internal services. The
+// addresses the host resolves to are checked once again when connecting.
+func checkWebhookHost(host string) error {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if webhookHostAllowed(host) {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return checkWebhookAddress(ip)
+	}
+	// single label names are resolved by the search domains of the cluster
+	if host == "localhost" || !strings.Contains(host, ".") {
+		return fmt.Errorf("%w, internal host %q", errWebhookTargetNotAllowed, host)
+	}
+	for _, suffix := range internalHostSuffixes {
+		if strings.HasSuffix(host, suffix) {
+			return fmt.Errorf("%w, internal host %q", errWebhookTargetNotAllowed, host)
+		}
+	}
+	return nil
+}
+
+// checkWebhookAddress refuses the loopback, link-local and private addresses
+func checkWebhookAddress(ip net.IP) error {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() ||
+		ip.IsPrivate() || ip.IsUnspecified() || ip.IsMulticast() {
+		return fmt.Errorf("%w, internal address %s", errWebhookTargetNotAllowed, ip)
+	}
+	return nil
+}
+
+// webhookHostAllowed tells if the host is listed in the comma separated
+// AMBULANCE_API_WEBHOOK_ALLOWED_HOSTS variable, such hosts may be internal
+func webhookHostAllowed(host string) bool {
+	for _, allowed := range strings.Split(os.Getenv("AMBULANCE_API_WEBHOOK_ALLOWED_HOSTS"), ",") {
+		if allowed = strings.TrimSpace(allowed); allowed != "" && strings.EqualFold(strings.TrimSuffix(allowed, "."), host) {
+			return true
+		}
+	}
+	return false
+}
@@ -1,6 +1,9 @@
 package ambulance_wl
 
 import (
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"slices"
 	"time"
@@ -9,6 +12,7 @@ import (
 	"github.com/google/uuid"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
+	"github.com/wac-fiit/cv2-ambulance-webapi/internal/db_service"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
@@ -66,6 +70,14 @@ func (o implAmbulanceWaitingListAPI) CreateWaitingListEntry(c *gin.Context) {
 	// update request context to build span hierarchy accross calls and services
 	c.Request = c.Request.WithContext(ctx)
 
+	// retried requests are answered by the response of the first one, instead
+	// of the conflict with the entry it created
+	idempotent, ok := startIdempotentRequest(c)
+	if !ok {
+		return
+	}
+	defer idempotent.finish()
+
 	updateAmbulanceFunc(c, func(c *gin.Context, ambulance *Ambulance) (*Ambulance, interface{}, int) {
 		ctx, span := o.tracer.Start(c.Request.Context(), "CreateWaitingListEntry-updateAmbulanceFunc")
 		defer span.End()
@@ -82,41 +94,26 @@ func (o implAmbulanceWaitingListAPI) CreateWaitingListEntry(c *gin.Context) {
 		if err := c.ShouldBindJSON(&entry); err != nil {
 			logger.Error().Err(err).Msg("Failed to bind JSON")
 			span.SetStatus(codes.Error, "Failed to bind JSON")
-			return nil, gin.H{
-				"status":  http.StatusBadRequest,
-				"message": "Invalid request body",
-				"error":   err.Error(),
-			}, http.StatusBadRequest
+			return rejectUpdate(c, problemInvalidBody, "", err)
 		}
 
-		if entry.PatientId == "" {
-			logger.Error().Msg("Patient ID is required")
-			span.SetStatus(codes.Error, "Patient ID is required")
-			logger.Trace().Msgf("Entry: %+v", entry)
-			return nil, gin.H{
-				"status":  http.StatusBadRequest,
-				"message": "Patient ID is required",
-			}, http.StatusBadRequest
+		visits, err := finishedVisits(c, ambulance.Id, &entry)
+		if err != nil {
+			logger.Error().Err(err).Msg("Failed to load finished visits")
+			span.SetStatus(codes.Error, "Failed to load finished visits")
+			return rejectUpdate(c, problemDatabaseError, "Failed to load finished visits", err)
 		}
 
-		if entry.Id == "" || entry.Id == "@new" {
-			logger.Debug().
-				Str("entry-id", entry.Id).
-				Msg("Generating new ID for entry")
-			entry.Id = uuid.NewString()
-		}
-
-		conflictIndx := slices.IndexFunc(ambulance.WaitingList, func(waiting WaitingListEntry) bool {
+		switch err := ambulance.admitWalkIn(&entry, "", visits); {
+		case errors.Is(err, errEntryExists):
 			logger.Error().Msg("Entry already exists")
 			span.SetStatus(codes.Error, "Entry already exists")
-			return entry.Id == waiting.Id || entry.PatientId == waiting.PatientId
-		})
-
-		if conflictIndx >= 0 {
-			return nil, gin.H{
-				"status":  http.StatusConflict,
-				"message": "Entry already exists",
-			}, http.StatusConflict
+			return rejectUpdate(c, problemEntryExists, "", nil)
+		case err != nil:
+			logger.Error().Err(err).Msg("Invalid entry")
+			span.SetStatus(codes.Error, "Invalid entry")
+			logger.Trace().Msgf("Entry: %+v", entry)
+			return rejectUpdate(c, problemValidationFailed, "", err)
 		}
 
 		ambulance.WaitingList = append(ambulance.WaitingList, entry)
@@ -128,10 +125,7 @@ func (o implAmbulanceWaitingListAPI) CreateWaitingListEntry(c *gin.Context) {
 		if entryIndx < 0 {
 			logger.Error().Msg("Failed to find entry in waiting list after saving")
 			span.SetStatus(codes.Error, "Failed to find entry in waiting list after saving")
-			return nil, gin.H{
-				"status":  http.StatusInternalServerError,
-				"message": "Failed to save entry",
-			}, http.StatusInternalServerError
+			return rejectUpdate(c, problemInternalError, "Failed to save entry", nil)
 		}
 		logger.Info().
 			Str("entry-id", ambulance.WaitingList[entryIndx].Id).
@@ -146,7 +140,8 @@ func (o implAmbulanceWaitingListAPI) CreateWaitingListEntry(c *gin.Context) {
 			),
 		)
 
-		return ambulance, ambulance.WaitingList[entryIndx], http.StatusOK
+		// return reference - version of the entry is set when it is stored
+		return ambulance, &ambulance.WaitingList[entryIndx], http.StatusOK
 	})
 }
 
@@ -155,10 +150,7 @@ func (o implAmbulanceWaitingListAPI) DeleteWaitingListEntry(c *gin.Context) {
 		entryId := c.Param("entryId")
 
 		if entryId == "" {
-			return nil, gin.H{
-				"status":  http.StatusBadRequest,
-				"message": "Entry ID is required",
-			}, http.StatusBadRequest
+			return rejectUpdate(c, problemValidationFailed, "Entry ID is required", nil)
 		}
 
 		entryIndx := slices.IndexFunc(ambulance.WaitingList, func(waiting WaitingListEntry) bool {
@@ -166,10 +158,11 @@ func (o implAmbulanceWaitingListAPI) DeleteWaitingListEntry(c *gin.Context) {
 		})
 
 		if entryIndx < 0 {
-			return nil, gin.H{
-				"status":  http.StatusNotFound,
-				"message": "Entry not found",
-			}, http.StatusNotFound
+			return rejectUpdate(c, problemEntryNotFound, "", nil)
+		}
+
+		if kind, ok := checkIfMatch(c, ambulance.WaitingList[entryIndx]); !ok {
+			return rejectUpdate(c, kind, "", nil)
 		}
 
 		ambulance.WaitingList = append(ambulance.WaitingList[:entryIndx], ambulance.WaitingList[entryIndx+1:]...)
@@ -187,9 +180,40 @@ func (o implAmbulanceWaitingListAPI) DeleteWaitingListEntry(c *gin.Context) {
 
 func (o implAmbulanceWaitingListAPI) GetWaitingListEntries(c *gin.Context) {
 	updateAmbulanceFunc(c, func(c *gin.Context, ambulance *Ambulance) (*Ambulance, interface{}, int) {
-		result := ambulance.WaitingList
-		if result == nil {
-			result = []WaitingListEntry{}
+		states := []EntryState{WAITING, CALLED, IN_PROGRESS}
+		if requested := c.QueryArray("state"); len(requested) > 0 {
+			states = []EntryState{}
+			for _, state := range requested {
+				if _, known := entryStateOrder[EntryState(state)]; !known {
+					return rejectUpdate(c, problemInvalidQuery, fmt.Sprintf("unknown entry state %q", state), nil)
+				}
+				states = append(states, EntryState(state))
+			}
+		}
+
+		result := []WaitingListEntry{}
+		for _, entry := range ambulance.WaitingList {
+			if slices.Contains(states, entry.currentState()) {
+				result = append(result, entry)
+			}
+		}
+
+		// entries which left the waiting list are not loaded with it
+		history := []string{}
+		for _, state := range states {
+			if state.isTerminal() {
+				history = append(history, string(state))
+			}
+		}
+		if len(history) > 0 {
+			finished, err := findEntries(c, ambulance.Id, db_service.Query{
+				Filters: []db_service.Filter{{Field: "state", Operator: db_service.FilterIn, Value: history}},
+				SortBy:  "finishedat",
+			})
+			if err != nil {
+				return rejectUpdate(c, problemDatabaseError, "Failed to load history of the waiting list", err)
+			}
+			result = append(result, finished...)
 		}
 		// return nil ambulance - no need to update it in db
 		return nil, result, http.StatusOK
@@ -201,10 +225,7 @@ func (o implAmbulanceWaitingListAPI) GetWaitingListEntry(c *gin.Context) {
 		entryId := c.Param("entryId")
 
 		if entryId == "" {
-			return nil, gin.H{
-				"status":  http.StatusBadRequest,
-				"message": "Entry ID is required",
-			}, http.StatusBadRequest
+			return rejectUpdate(c, problemValidationFailed, "Entry ID is required", nil)
 		}
 
 		entryIndx := slices.IndexFunc(ambulance.WaitingList, func(waiting WaitingListEntry) bool {
@@ -212,10 +233,7 @@ func (o implAmbulanceWaitingListAPI) GetWaitingListEntry(c *gin.Context) {
 		})
 
 		if entryIndx < 0 {
-			return nil, gin.H{
-				"status":  http.StatusNotFound,
-				"message": "Entry not found",
-			}, http.StatusNotFound
+			return rejectUpdate(c, problemEntryNotFound, "", nil)
 		}
 
 		// return nil ambulance - no need to update it in db
@@ -228,20 +246,17 @@ func (o implAmbulanceWaitingListAPI) UpdateWaitingListEntry(c *gin.Context) {
 		var entry WaitingListEntry
 
 		if err := c.ShouldBindJSON(&entry); err != nil {
-			return nil, gin.H{
-				"status":  http.StatusBadRequest,
-				"message": "Invalid request body",
-				"error":   err.Error(),
-			}, http.StatusBadRequest
+			return rejectUpdate(c, problemInvalidBody, "", err)
 		}
 
 		entryId := c.Param("entryId")
 
 		if entryId == "" {
-			return nil, gin.H{
-				"status":  http.StatusBadRequest,
-				"message": "Entry ID is required",
-			}, http.StatusBadRequest
+			return rejectUpdate(c, problemValidationFailed, "Entry ID is required", nil)
+		}
+
+		if err := entry.validatePriority(); err != nil {
+			return rejectUpdate(c, problemValidationFailed, "", err)
 		}
 
 		entryIndx := slices.IndexFunc(ambulance.WaitingList, func(waiting WaitingListEntry) bool {
@@ -249,10 +264,11 @@ func (o implAmbulanceWaitingListAPI) UpdateWaitingListEntry(c *gin.Context) {
 		})
 
 		if entryIndx < 0 {
-			return nil, gin.H{
-				"status":  http.StatusNotFound,
-				"message": "Entry not found",
-			}, http.StatusNotFound
+			return rejectUpdate(c, problemEntryNotFound, "", nil)
+		}
+
+		if kind, ok := checkIfMatch(c, ambulance.WaitingList[entryIndx]); !ok {
+			return rejectUpdate(c, kind, "", nil)
 		}
 
 		if entry.PatientId != "" {
@@ -271,14 +287,389 @@ func (o implAmbulanceWaitingListAPI) UpdateWaitingListEntry(c *gin.Context) {
 			ambulance.WaitingList[entryIndx].EstimatedDurationMinutes = entry.EstimatedDurationMinutes
 		}
 
+		if entry.Priority > 0 {
+			ambulance.WaitingList[entryIndx].Priority = entry.Priority
+		}
+
+		if entry.ServerId != "" {
+			if !ambulance.hasServer(entry.ServerId) {
+				return rejectUpdate(c, problemValidationFailed, "", fieldErrors{invalidField("/serverId", "server not found in the ambulance")})
+			}
+			ambulance.WaitingList[entryIndx].ServerId = entry.ServerId
+		}
+
+		updatedId := ambulance.WaitingList[entryIndx].Id
+		ambulance.reconcileWaitingList()
+		// priority change may move the entry within the list
+		entryIndx = slices.IndexFunc(ambulance.WaitingList, func(waiting WaitingListEntry) bool {
+			return updatedId == waiting.Id
+		})
+		o.entriesUpdatedCounter.Add(
+			c.Request.Context(), 1,
+			metric.WithAttributes(
+				attribute.String("ambulance_id", ambulance.Id),
+				attribute.String("ambulance_name", ambulance.Name),
+			),
+		)
+		// return reference - version of the entry is set when it is stored
+		return ambulance, &ambulance.WaitingList[entryIndx], http.StatusOK
+	})
+}
+
+func (o implAmbulanceWaitingListAPI) BookAppointment(c *gin.Context) {
+	ctx, span := o.tracer.Start(c.Request.Context(), "BookAppointment")
+	defer span.End()
+	// update request context to build span hierarchy accross calls and services
+	c.Request = c.Request.WithContext(ctx)
+
+	updateAmbulanceFunc(c, func(c *gin.Context, ambulance *Ambulance) (*Ambulance, interface{}, int) {
+		logger := o.logger.With().
+			Str("method", "BookAppointment").
+			Str("ambulanceId", ambulance.Id).
+			Logger()
+		var entry WaitingListEntry
+
+		if err := c.ShouldBindJSON(&entry); err != nil {
+			logger.Error().Err(err).Msg("Failed to bind JSON")
+			span.SetStatus(codes.Error, "Failed to bind JSON")
+			return rejectUpdate(c, problemInvalidBody, "", err)
+		}
+
+		now := time.Now()
+		invalid := fieldErrors{}
+		if entry.PatientId == "" {
+			invalid = append(invalid, requiredField("/patientId"))
+		}
+		if entry.AppointmentAt.IsZero() {
+			invalid = append(invalid, requiredField("/appointmentAt"))
+		} else if entry.AppointmentAt.Before(now) {
+			invalid = append(invalid, invalidField("/appointmentAt", "appointment time is in the past"))
+		}
+		var priorityErrors fieldErrors
+		if errors.As(entry.validatePriority(), &priorityErrors) {
+			invalid = append(invalid, priorityErrors...)
+		}
+		if entry.ServerId != "" && !ambulance.hasServer(entry.ServerId) {
+			invalid = append(invalid, invalidField("/serverId", "server not found in the ambulance"))
+		}
+		if len(invalid) > 0 {
+			logger.Error().Err(invalid).Msg("Invalid appointment")
+			span.SetStatus(codes.Error, "Invalid appointment")
+			return rejectUpdate(c, problemValidationFailed, "", invalid)
+		}
+
+		entry.Type = APPOINTMENT
+		entry.State = WAITING
+		entry.CalledAt = nil
+		entry.StartedAt = nil
+		entry.FinishedAt = nil
+		if entry.WaitingSince.IsZero() {
+			entry.WaitingSince = now
+		}
+		if entry.Priority == 0 {
+			entry.Priority = defaultEntryPriority
+		}
+		if entry.EstimatedDurationMinutes <= 0 {
+			visits, err := finishedVisits(c, ambulance.Id, &entry)
+			if err != nil {
+				logger.Error().Err(err).Msg("Failed to load finished visits")
+				span.SetStatus(codes.Error, "Failed to load finished visits")
+				return rejectUpdate(c, problemDatabaseError, "Failed to load finished visits", err)
+			}
+			entry.EstimatedDurationMinutes = ambulance.estimateDuration(&entry, visits)
+		}
+		if entry.Id == "" || entry.Id == "@new" {
+			entry.Id = uuid.NewString()
+		}
+
+		// invalid settings are rejected when the ambulance is stored, the
+		// appointment cannot be checked against them anyway
+		schedule, err := ambulance.openingSchedule()
+		if err != nil {
+			logger.Error().Err(err).Msg("Invalid opening hours of the ambulance")
+			span.SetStatus(codes.Error, "Invalid opening hours of the ambulance")
+			return rejectUpdate(c, problemInternalError, "Opening hours of the ambulance are invalid", err)
+		}
+		if !schedule.nextStart(entry.AppointmentAt, entry.duration()).Equal(entry.AppointmentAt) {
+			logger.Error().Time("appointmentAt", entry.AppointmentAt).Msg("Appointment outside of the opening hours")
+			span.SetStatus(codes.Error, "Appointment outside of the opening hours")
+			return rejectUpdate(c, problemValidationFailed, "", fieldErrors{invalidField("/appointmentAt", "appointment is outside of the opening hours")})
+		}
+
+		if slices.ContainsFunc(ambulance.WaitingList, func(waiting WaitingListEntry) bool {
+			return entry.Id == waiting.Id
+		}) {
+			logger.Error().Msg("Entry already exists")
+			span.SetStatus(codes.Error, "Entry already exists")
+			return rejectUpdate(c, problemEntryExists, "", nil)
+		}
+
+		if ambulance.appointmentConflicts(&entry) {
+			logger.Error().Time("appointmentAt", entry.AppointmentAt).Msg("Appointment slot is not available")
+			span.SetStatus(codes.Error, "Appointment slot is not available")
+			return rejectUpdate(c, problemAppointmentUnavailable, "Appointment slot is not available", nil)
+		}
+
+		ambulance.WaitingList = append(ambulance.WaitingList, entry)
+		ambulance.reconcileWaitingList()
+		entryIndx := slices.IndexFunc(ambulance.WaitingList, func(waiting WaitingListEntry) bool {
+			return entry.Id == waiting.Id
+		})
+
+		logger.Info().Str("entryId", entry.Id).Msg("Appointment booked")
+		span.SetStatus(codes.Ok, "Appointment booked")
+		o.entriesCreatedCounter.Add(
+			c.Request.Context(), 1,
+			metric.WithAttributes(
+				attribute.String("ambulance_id", ambulance.Id),
+				attribute.String("ambulance_name", ambulance.Name),
+				attribute.String("type", string(APPOINTMENT)),
+			),
+		)
+		// return reference - version of the entry is set when it is stored
+		return ambulance, &ambulance.WaitingList[entryIndx], http.StatusOK
+	})
+}
+
+func (o implAmbulanceWaitingListAPI) CancelAppointment(c *gin.Context) {
+	updateAmbulanceFunc(c, func(c *gin.Context, ambulance *Ambulance) (*Ambulance, interface{}, int) {
+		entryId := c.Param("entryId")
+
+		entryIndx := slices.IndexFunc(ambulance.WaitingList, func(waiting WaitingListEntry) bool {
+			return entryId == waiting.Id && waiting.isAppointment()
+		})
+
+		if entryIndx < 0 {
+			return rejectUpdate(c, problemEntryNotFound, "Appointment not found", nil)
+		}
+
+		if ambulance.WaitingList[entryIndx].currentState() != WAITING {
+			return rejectUpdate(c, problemInvalidStateTransition, "Patient was already called", nil)
+		}
+
+		ambulance.WaitingList = append(ambulance.WaitingList[:entryIndx], ambulance.WaitingList[entryIndx+1:]...)
+		ambulance.reconcileWaitingList()
+		o.entriesDeletedCounter.Add(
+			c.Request.Context(), 1,
+			metric.WithAttributes(
+				attribute.String("ambulance_id", ambulance.Id),
+				attribute.String("ambulance_name", ambulance.Name),
+				attribute.String("type", string(APPOINTMENT)),
+			),
+		)
+		return ambulance, nil, http.StatusNoContent
+	})
+}
+
+func (o implAmbulanceWaitingListAPI) CallWaitingListEntry(c *gin.Context) {
+	o.transitionWaitingListEntry(c, "CallWaitingListEntry", CALLED)
+}
+
+func (o implAmbulanceWaitingListAPI) StartWaitingListEntry(c *gin.Context) {
+	o.transitionWaitingListEntry(c, "StartWaitingListEntry", IN_PROGRESS)
+}
+
+func (o implAmbulanceWaitingListAPI) FinishWaitingListEntry(c *gin.Context) {
+	o.transitionWaitingListEntry(c, "FinishWaitingListEntry", FINISHED)
+}
+
+func (o implAmbulanceWaitingListAPI) MarkWaitingListEntryNoShow(c *gin.Context) {
+	o.transitionWaitingListEntry(c, "MarkWaitingListEntryNoShow", NO_SHOW)
+}
+
+func (o implAmbulanceWaitingListAPI) CallNextWaitingListEntry(c *gin.Context) {
+	ctx, span := o.tracer.Start(c.Request.Context(), "CallNextWaitingListEntry")
+	defer span.End()
+	// update request context to build span hierarchy accross calls and services
+	c.Request = c.Request.WithContext(ctx)
+
+	// the called entry is stored with its version, concurrent request calling the
+	// same entry fails on version conflict and is retried with the next entry
+	updateAmbulanceFunc(c, func(c *gin.Context, ambulance *Ambulance) (*Ambulance, interface{}, int) {
+		serverId := c.Query("serverId")
+		logger := o.logger.With().
+			Str("method", "CallNextWaitingListEntry").
+			Str("ambulanceId", ambulance.Id).
+			Str("serverId", serverId).
+			Logger()
+
+		if serverId != "" && !ambulance.hasServer(serverId) {
+			logger.Error().Msg("Unknown server")
+			span.SetStatus(codes.Error, "Unknown server")
+			return rejectUpdate(c, problemValidationFailed, "", fieldErrors{invalidField("/serverId", "server not found in the ambulance")})
+		}
+
+		// order may change over time because of the starvation protection
+		ambulance.reconcileWaitingList()
+		now := time.Now()
+		entryIndx := slices.IndexFunc(ambulance.WaitingList, func(waiting WaitingListEntry) bool {
+			// patients bound to other servers are called by them, and patients
+			// with appointment are not expected before their slot
+			return waiting.currentState() == WAITING &&
+				(serverId == "" || waiting.ServerId == "" || waiting.ServerId == serverId) &&
+				(!waiting.isAppointment() || !waiting.AppointmentAt.After(now))
+		})
+
+		if entryIndx < 0 {
+			logger.Debug().Msg("No waiting patient")
+			span.SetStatus(codes.Ok, "No waiting patient")
+			return nil, nil, http.StatusNoContent
+		}
+
+		entryId := ambulance.WaitingList[entryIndx].Id
+		if serverId != "" {
+			ambulance.WaitingList[entryIndx].ServerId = serverId
+		}
+		if err := ambulance.WaitingList[entryIndx].transitionTo(CALLED, now); err != nil {
+			logger.Error().Err(err).Msg("Failed to call the next patient")
+			span.SetStatus(codes.Error, "Failed to call the next patient")
+			return rejectUpdate(c, problemInternalError, "Failed to call the next patient", err)
+		}
+
+		ambulance.reconcileWaitingList()
+		entryIndx = slices.IndexFunc(ambulance.WaitingList, func(waiting WaitingListEntry) bool {
+			return entryId == waiting.Id
+		})
+
+		logger.Info().Str("entryId", entryId).Msg("Next patient called")
+		span.SetStatus(codes.Ok, "Next patient called")
+		o.entriesUpdatedCounter.Add(
+			c.Request.Context(), 1,
+			metric.WithAttributes(
+				attribute.String("ambulance_id", ambulance.Id),
+				attribute.String("ambulance_name", ambulance.Name),
+				attribute.String("state", string(CALLED)),
+			),
+		)
+		// return reference - version of the entry is set when it is stored
+		return ambulance, &ambulance.WaitingList[entryIndx], http.StatusOK
+	})
+}
+
+// transitionWaitingListEntry moves the entry to the target state of its lifecycle
+func (o implAmbulanceWaitingListAPI) transitionWaitingListEntry(c *gin.Context, method string, state EntryState) {
+	ctx, span := o.tracer.Start(c.Request.Context(), method)
+	defer span.End()
+	// update request context to build span hierarchy accross calls and services
+	c.Request = c.Request.WithContext(ctx)
+
+	updateAmbulanceFunc(c, func(c *gin.Context, ambulance *Ambulance) (*Ambulance, interface{}, int) {
+		entryId := c.Param("entryId")
+		logger := o.logger.With().
+			Str("method", method).
+			Str("ambulanceId", ambulance.Id).
+			Str("entryId", entryId).
+			Logger()
+
+		if entryId == "" {
+			return rejectUpdate(c, problemValidationFailed, "Entry ID is required", nil)
+		}
+
+		entryIndx := slices.IndexFunc(ambulance.WaitingList, func(waiting WaitingListEntry) bool {
+			return entryId == waiting.Id
+		})
+
+		if entryIndx < 0 {
+			return rejectUpdate(c, problemEntryNotFound, "", nil)
+		}
+
+		if err := ambulance.WaitingList[entryIndx].transitionTo(state, time.Now()); err != nil {
+			logger.Warn().Err(err).Msg("Invalid state transition")
+			span.SetStatus(codes.Error, "Invalid state transition")
+			return rejectUpdate(c, problemInvalidStateTransition, "", err)
+		}
+
 		ambulance.reconcileWaitingList()
+		// state change moves the entry within the list
+		entryIndx = slices.IndexFunc(ambulance.WaitingList, func(waiting WaitingListEntry) bool {
+			return entryId == waiting.Id
+		})
+
+		logger.Info().Str("state", string(state)).Msg("Entry state changed")
+		span.SetStatus(codes.Ok, "Entry state changed")
 		o.entriesUpdatedCounter.Add(
 			c.Request.Context(), 1,
 			metric.WithAttributes(
 				attribute.String("ambulance_id", ambulance.Id),
 				attribute.String("ambulance_name", ambulance.Name),
+				attribute.String("state", string(state)),
 			),
 		)
-		return ambulance, ambulance.WaitingList[entryIndx], http.StatusOK
+		// return reference - version of the entry is set when it is stored
+		return ambulance, &ambulance.WaitingList[entryIndx], http.StatusOK
 	})
 }
+
+func (o implAmbulanceWaitingListAPI) GetWaitingListEvents(c *gin.Context) {
+	ambulanceId := c.Param("ambulanceId")
+	logger := o.logger.With().
+		Str("method", "GetWaitingListEvents").
+		Str("ambulanceId", ambulanceId).
+		Logger()
+
+	broker, ok := c.Value("waiting_list_events").(*WaitingListEventBroker)
+	if !ok {
+		logger.Error().Msg("waiting_list_events not found")
+		writeProblem(c, problemInternalError, "waiting_list_events not found", nil)
+		return
+	}
+
+	db, ok := c.Value("db_service").(db_service.DbService[Ambulance])
+	if !ok {
+		logger.Error().Msg("db_service not found")
+		writeProblem(c, problemInternalError, "db_service not found", nil)
+		return
+	}
+
+	switch _, err := db.FindDocument(c.Request.Context(), ambulanceId); err {
+	case nil:
+	case db_service.ErrNotFound:
+		writeProblem(c, problemAmbulanceNotFound, "", nil)
+		return
+	default:
+		logger.Error().Err(err).Msg("Failed to load ambulance from database")
+		writeProblem(c, problemDatabaseError, "Failed to load ambulance from database", err)
+		return
+	}
+
+	replay, events, cancel := broker.Subscribe(ambulanceId, c.GetHeader("Last-Event-ID"))
+	defer cancel()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	// disable buffering in nginx based ingress controllers
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	for _, event := range replay {
+		if err := writeServerSentEvent(c.Writer, event); err != nil {
+			return
+		}
+	}
+	c.Writer.Flush()
+	logger.Debug().Int("replayed", len(replay)).Msg("Waiting list events subscribed")
+
+	heartbeat := time.NewTicker(waitingListEventsHeartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		var err error
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case event, open := <-events:
+			if !open {
+				// subscriber was too slow, client reconnects and resumes from the last event
+				logger.Warn().Msg("Waiting list events subscriber disconnected")
+				return
+			}
+			err = writeServerSentEvent(c.Writer, event)
+		case <-heartbeat.C:
+			_, err = io.WriteString(c.Writer, ": heartbeat\n\n")
+		}
+		if err != nil {
+			return
+		}
+		c.Writer.Flush()
+	}
+}
@@ -2,7 +2,9 @@ package ambulance_wl
 
 import (
 	"context"
+	"net/http"
 	"net/http/httptest"
+	"slices"
 	"strings"
 	"testing"
 	"time"
@@ -18,7 +20,8 @@ import (
 
 type AmbulanceWlSuite struct {
 	suite.Suite
-	dbServiceMock *DbServiceMock[Ambulance]
+	dbServiceMock        *DbServiceMock[Ambulance]
+	entriesDbServiceMock *DbChildServiceMock[WaitingListEntry]
 }
 
 type DbServiceMock[DocType interface{}] struct {
@@ -32,7 +35,17 @@ func (this *DbServiceMock[DocType]) CreateDocument(ctx context.Context, id strin
 
 func (this *DbServiceMock[DocType]) FindDocument(ctx context.Context, id string) (*DocType, error) {
 	args := this.Called(ctx, id)
-	return args.Get(0).(*DocType), args.Error(1)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	// every load returns new instance, same as the real database
+	document := *args.Get(0).(*DocType)
+	return &document, args.Error(1)
+}
+
+func (this *DbServiceMock[DocType]) FindDocuments(ctx context.Context, query db_service.Query) (*db_service.Page[DocType], error) {
+	args := this.Called(ctx, query)
+	return args.Get(0).(*db_service.Page[DocType]), args.Error(1)
 }
 
 func (this *DbServiceMock[DocType]) UpdateDocument(ctx context.Context, id string, document *DocType) error {
@@ -50,24 +63,76 @@ func (this *DbServiceMock[DocType]) Disconnect(ctx context.Context) error {
 	return args.Error(0)
 }
 
+type DbChildServiceMock[DocType interface{}] struct {
+	mock.Mock
+}
+
+func (this *DbChildServiceMock[DocType]) CreateChildDocument(ctx context.Context, parentId string, id string, document *DocType) error {
+	args := this.Called(ctx, parentId, id, document)
+	return args.Error(0)
+}
+
+func (this *DbChildServiceMock[DocType]) FindChildDocument(ctx context.Context, parentId string, id string) (*DocType, error) {
+	args := this.Called(ctx, parentId, id)
+	return args.Get(0).(*DocType), args.Error(1)
+}
+
+func (this *DbChildServiceMock[DocType]) FindChildDocuments(ctx context.Context, parentId string, query db_service.Query) ([]*DocType, error) {
+	args := this.Called(ctx, parentId, query)
+	return args.Get(0).([]*DocType), args.Error(1)
+}
+
+func (this *DbChildServiceMock[DocType]) UpdateChildDocument(ctx context.Context, parentId string, id string, document *DocType) error {
+	args := this.Called(ctx, parentId, id, document)
+	return args.Error(0)
+}
+
+func (this *DbChildServiceMock[DocType]) DeleteChildDocument(ctx context.Context, parentId string, id string) error {
+	args := this.Called(ctx, parentId, id)
+	return args.Error(0)
+}
+
+func (this *DbChildServiceMock[DocType]) DeleteChildDocuments(ctx context.Context, parentId string) error {
+	args := this.Called(ctx, parentId)
+	return args.Error(0)
+}
+
+func (this *DbChildServiceMock[DocType]) Disconnect(ctx context.Context) error {
+	args := this.Called(ctx)
+	return args.Error(0)
+}
+
 func (suite *AmbulanceWlSuite) SetupTest() {
 	suite.dbServiceMock = &DbServiceMock[Ambulance]{}
 
 	// Compile time Assert that the mock is of type db_service.DbService[Ambulance]
 	var _ db_service.DbService[Ambulance] = suite.dbServiceMock
 
+	suite.entriesDbServiceMock = &DbChildServiceMock[WaitingListEntry]{}
+	var _ db_service.DbChildService[WaitingListEntry] = suite.entriesDbServiceMock
+
 	suite.dbServiceMock.
 		On("FindDocument", mock.Anything, mock.Anything).
 		Return(
 			&Ambulance{
 				Id: "test-ambulance",
-				WaitingList: []WaitingListEntry{
-					{
-						Id:                       "test-entry",
-						PatientId:                "test-patient",
-						WaitingSince:             time.Now(),
-						EstimatedDurationMinutes: 101,
-					},
+			},
+			nil,
+		)
+	suite.dbServiceMock.
+		On("UpdateDocument", mock.Anything, mock.Anything, mock.Anything).
+		Return(nil).
+		Maybe()
+
+	suite.entriesDbServiceMock.
+		On("FindChildDocuments", mock.Anything, "test-ambulance", mock.Anything).
+		Return(
+			[]*WaitingListEntry{
+				{
+					Id:                       "test-entry",
+					PatientId:                "test-patient",
+					WaitingSince:             time.Now(),
+					EstimatedDurationMinutes: 101,
 				},
 			},
 			nil,
@@ -80,9 +145,103 @@ func TestAmbulanceWlSuite(t *testing.T) {
 
 func (suite *AmbulanceWlSuite) Test_UpdateWl_DbServiceUpdateCalled() {
 	// ARRANGE
+	suite.entriesDbServiceMock.
+		On("UpdateChildDocument", mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Return(nil)
+
+	json := `{
+        "id": "test-entry",
+        "patientId": "test-patient",
+        "estimatedDurationMinutes": 42
+    }`
+
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(recorder)
+	ctx.Set("db_service", suite.dbServiceMock)
+	ctx.Set("db_service_waiting_list", suite.entriesDbServiceMock)
+	ctx.Params = []gin.Param{
+		{Key: "ambulanceId", Value: "test-ambulance"},
+		{Key: "entryId", Value: "test-entry"},
+	}
+	ctx.Request = httptest.NewRequest("POST", "/ambulance/test-ambulance/waitinglist/test-entry", strings.NewReader(json))
+
+	sut := implAmbulanceWaitingListAPI{
+		tracer:                noop.NewTracerProvider().Tracer("ambulance-wl"),
+		logger:                zerolog.Nop(),
+		entriesCreatedCounter: metricNoop.Int64Counter{},
+		entriesUpdatedCounter: metricNoop.Int64Counter{},
+		entriesDeletedCounter: metricNoop.Int64Counter{},
+	}
+
+	// ACT
+	sut.UpdateWaitingListEntry(ctx)
+
+	// ASSERT
+	suite.entriesDbServiceMock.AssertCalled(suite.T(), "UpdateChildDocument", mock.Anything, "test-ambulance", "test-entry", mock.Anything)
+	// revision of the ambulance is bumped by the change of its waiting list
+	suite.dbServiceMock.AssertCalled(suite.T(), "UpdateDocument", mock.Anything, "test-ambulance", mock.Anything)
+}
+
+func (suite *AmbulanceWlSuite) Test_UpdateWl_RetriedOnVersionConflict() {
+	// ARRANGE
+	suite.entriesDbServiceMock.
+		On("UpdateChildDocument", mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Return(db_service.ErrVersionConflict).
+		Once()
+	suite.entriesDbServiceMock.
+		On("UpdateChildDocument", mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Return(nil)
+
+	json := `{
+        "id": "test-entry",
+        "patientId": "test-patient",
+        "estimatedDurationMinutes": 42
+    }`
+
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(recorder)
+	ctx.Set("db_service", suite.dbServiceMock)
+	ctx.Set("db_service_waiting_list", suite.entriesDbServiceMock)
+	ctx.Params = []gin.Param{
+		{Key: "ambulanceId", Value: "test-ambulance"},
+		{Key: "entryId", Value: "test-entry"},
+	}
+	ctx.Request = httptest.NewRequest("POST", "/ambulance/test-ambulance/waitinglist/test-entry", strings.NewReader(json))
+
+	sut := implAmbulanceWaitingListAPI{
+		tracer:                noop.NewTracerProvider().Tracer("ambulance-wl"),
+		logger:                zerolog.Nop(),
+		entriesCreatedCounter: metricNoop.Int64Counter{},
+		entriesUpdatedCounter: metricNoop.Int64Counter{},
+		entriesDeletedCounter: metricNoop.Int64Counter{},
+	}
+
+	// ACT
+	sut.UpdateWaitingListEntry(ctx)
+
+	// ASSERT
+	suite.dbServiceMock.AssertNumberOfCalls(suite.T(), "FindDocument", 2)
+	suite.entriesDbServiceMock.AssertNumberOfCalls(suite.T(), "UpdateChildDocument", 2)
+	suite.Equal(http.StatusOK, recorder.Code)
+}
+
+func (suite *AmbulanceWlSuite) Test_UpdateWl_EntriesNotWrittenOnAmbulanceVersionConflict() {
+	// ARRANGE
+	suite.dbServiceMock.ExpectedCalls = slices.DeleteFunc(suite.dbServiceMock.ExpectedCalls, func(call *mock.Call) bool {
+		return call.Method == "UpdateDocument"
+	})
+	suite.dbServiceMock.
+		On("UpdateDocument", mock.Anything, mock.Anything, mock.Anything).
+		Return(db_service.ErrVersionConflict).
+		Once()
 	suite.dbServiceMock.
 		On("UpdateDocument", mock.Anything, mock.Anything, mock.Anything).
 		Return(nil)
+	suite.entriesDbServiceMock.
+		On("UpdateChildDocument", mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Return(nil)
 
 	json := `{
         "id": "test-entry",
@@ -94,6 +253,7 @@ func (suite *AmbulanceWlSuite) Test_UpdateWl_DbServiceUpdateCalled() {
 	recorder := httptest.NewRecorder()
 	ctx, _ := gin.CreateTestContext(recorder)
 	ctx.Set("db_service", suite.dbServiceMock)
+	ctx.Set("db_service_waiting_list", suite.entriesDbServiceMock)
 	ctx.Params = []gin.Param{
 		{Key: "ambulanceId", Value: "test-ambulance"},
 		{Key: "entryId", Value: "test-entry"},
@@ -112,5 +272,151 @@ func (suite *AmbulanceWlSuite) Test_UpdateWl_DbServiceUpdateCalled() {
 	sut.UpdateWaitingListEntry(ctx)
 
 	// ASSERT
-	suite.dbServiceMock.AssertCalled(suite.T(), "UpdateDocument", mock.Anything, "test-ambulance", mock.Anything)
+	suite.dbServiceMock.AssertNumberOfCalls(suite.T(), "UpdateDocument", 2)
+	suite.entriesDbServiceMock.AssertNumberOfCalls(suite.T(), "UpdateChildDocument", 1)
+	suite.Equal(http.StatusOK, recorder.Code)
+}
+
+func (suite *AmbulanceWlSuite) Test_UpdateWl_ConflictAfterRetriesExhausted() {
+	// ARRANGE
+	suite.entriesDbServiceMock.
+		On("UpdateChildDocument", mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Return(db_service.ErrVersionConflict)
+
+	json := `{
+        "id": "test-entry",
+        "patientId": "test-patient",
+        "estimatedDurationMinutes": 42
+    }`
+
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(recorder)
+	ctx.Set("db_service", suite.dbServiceMock)
+	ctx.Set("db_service_waiting_list", suite.entriesDbServiceMock)
+	ctx.Params = []gin.Param{
+		{Key: "ambulanceId", Value: "test-ambulance"},
+		{Key: "entryId", Value: "test-entry"},
+	}
+	ctx.Request = httptest.NewRequest("POST", "/ambulance/test-ambulance/waitinglist/test-entry", strings.NewReader(json))
+
+	sut := implAmbulanceWaitingListAPI{
+		tracer:                noop.NewTracerProvider().Tracer("ambulance-wl"),
+		logger:                zerolog.Nop(),
+		entriesCreatedCounter: metricNoop.Int64Counter{},
+		entriesUpdatedCounter: metricNoop.Int64Counter{},
+		entriesDeletedCounter: metricNoop.Int64Counter{},
+	}
+
+	// ACT
+	sut.UpdateWaitingListEntry(ctx)
+
+	// ASSERT
+	suite.entriesDbServiceMock.AssertNumberOfCalls(suite.T(), "UpdateChildDocument", maxAmbulanceUpdateAttempts)
+	suite.Equal(http.StatusConflict, recorder.Code)
+}
+
+func (suite *AmbulanceWlSuite) Test_UpdateWl_RetriesStoppedWhenRequestCancelled() {
+	// ARRANGE
+	suite.entriesDbServiceMock.
+		On("UpdateChildDocument", mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Return(db_service.ErrVersionConflict)
+
+	json := `{
+        "id": "test-entry",
+        "patientId": "test-patient",
+        "estimatedDurationMinutes": 42
+    }`
+
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(recorder)
+	ctx.Set("db_service", suite.dbServiceMock)
+	ctx.Set("db_service_waiting_list", suite.entriesDbServiceMock)
+	ctx.Params = []gin.Param{
+		{Key: "ambulanceId", Value: "test-ambulance"},
+		{Key: "entryId", Value: "test-entry"},
+	}
+	requestCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+	ctx.Request = httptest.NewRequest("POST", "/ambulance/test-ambulance/waitinglist/test-entry", strings.NewReader(json)).
+		WithContext(requestCtx)
+
+	sut := implAmbulanceWaitingListAPI{
+		tracer:                noop.NewTracerProvider().Tracer("ambulance-wl"),
+		logger:                zerolog.Nop(),
+		entriesCreatedCounter: metricNoop.Int64Counter{},
+		entriesUpdatedCounter: metricNoop.Int64Counter{},
+		entriesDeletedCounter: metricNoop.Int64Counter{},
+	}
+
+	// ACT
+	sut.UpdateWaitingListEntry(ctx)
+
+	// ASSERT
+	suite.entriesDbServiceMock.AssertNumberOfCalls(suite.T(), "UpdateChildDocument", 1)
+	suite.Equal(http.StatusConflict, recorder.Code)
+}
+
+func (suite *AmbulanceWlSuite) Test_UpdateWl_EmbeddedWaitingListMigrated() {
+	// ARRANGE
+	dbServiceMock := &DbServiceMock[Ambulance]{}
+	dbServiceMock.
+		On("FindDocument", mock.Anything, mock.Anything).
+		Return(
+			&Ambulance{
+				Id: "test-ambulance",
+				WaitingList: []WaitingListEntry{
+					{
+						Id:                       "embedded-entry",
+						PatientId:                "embedded-patient",
+						WaitingSince:             time.Now(),
+						EstimatedDurationMinutes: 15,
+					},
+				},
+			},
+			nil,
+		)
+	dbServiceMock.
+		On("UpdateDocument", mock.Anything, mock.Anything, mock.Anything).
+		Return(nil)
+	suite.entriesDbServiceMock.
+		On("CreateChildDocument", mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Return(nil)
+	suite.entriesDbServiceMock.
+		On("UpdateChildDocument", mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Return(nil)
+
+	json := `{
+        "estimatedDurationMinutes": 42
+    }`
+
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(recorder)
+	ctx.Set("db_service", dbServiceMock)
+	ctx.Set("db_service_waiting_list", suite.entriesDbServiceMock)
+	ctx.Params = []gin.Param{
+		{Key: "ambulanceId", Value: "test-ambulance"},
+		{Key: "entryId", Value: "test-entry"},
+	}
+	ctx.Request = httptest.NewRequest("PUT", "/api/waiting-list/test-ambulance/entries/test-entry", strings.NewReader(json))
+
+	sut := implAmbulanceWaitingListAPI{
+		tracer:                noop.NewTracerProvider().Tracer("ambulance-wl"),
+		logger:                zerolog.Nop(),
+		entriesCreatedCounter: metricNoop.Int64Counter{},
+		entriesUpdatedCounter: metricNoop.Int64Counter{},
+		entriesDeletedCounter: metricNoop.Int64Counter{},
+	}
+
+	// ACT
+	sut.UpdateWaitingListEntry(ctx)
+
+	// ASSERT
+	suite.Equal(http.StatusOK, recorder.Code)
+	suite.entriesDbServiceMock.AssertCalled(suite.T(), "CreateChildDocument", mock.Anything, "test-ambulance", "embedded-entry", mock.Anything)
+	dbServiceMock.AssertCalled(suite.T(), "UpdateDocument", mock.Anything, "test-ambulance", mock.MatchedBy(func(ambulance *Ambulance) bool {
+		return len(ambulance.WaitingList) == 0
+	}))
 }
@@ -1,7 +1,14 @@
 package ambulance_wl
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -11,45 +18,55 @@ import (
 type implAmbulancesAPI struct {
 }
 
+const defaultAmbulancesPageSize = 20
+const maxAmbulancesPageSize = 100
+
+// maps sortable properties of the API to the fields of the stored ambulance document
+var ambulanceSortFields = map[string]string{
+	"id":         "id",
+	"name":       "name",
+	"roomNumber": "roomnumber",
+}
+
 func NewAmbulancesApi() AmbulancesAPI {
 	return &implAmbulancesAPI{}
 }
 
 func (o implAmbulancesAPI) CreateAmbulance(c *gin.Context) {
+	idempotent, ok := startIdempotentRequest(c)
+	if !ok {
+		return
+	}
+	defer idempotent.finish()
+
 	value, exists := c.Get("db_service")
 	if !exists {
-		c.JSON(
-			http.StatusInternalServerError,
-			gin.H{
-				"status":  "Internal Server Error",
-				"message": "db not found",
-				"error":   "db not found",
-			})
+		writeProblem(c, problemInternalError, "db not found", nil)
 		return
 	}
 
 	db, ok := value.(db_service.DbService[Ambulance])
 	if !ok {
-		c.JSON(
-			http.StatusInternalServerError,
-			gin.H{
-				"status":  "Internal Server Error",
-				"message": "db context is not of required type",
-				"error":   "cannot cast db context to db_service.DbService",
-			})
+		writeProblem(c, problemInternalError, "cannot cast db context to db_service.DbService", nil)
+		return
+	}
+
+	value, exists = c.Get("db_service_waiting_list")
+	if !exists {
+		writeProblem(c, problemInternalError, "db_service_waiting_list not found", nil)
+		return
+	}
+
+	entriesDb, ok := value.(db_service.DbChildService[WaitingListEntry])
+	if !ok {
+		writeProblem(c, problemInternalError, "cannot cast db_service_waiting_list context to db_service.DbChildService", nil)
 		return
 	}
 
 	ambulance := Ambulance{}
 	err := c.BindJSON(&ambulance)
 	if err != nil {
-		c.JSON(
-			http.StatusBadRequest,
-			gin.H{
-				"status":  "Bad Request",
-				"message": "Invalid request body",
-				"error":   err.Error(),
-			})
+		writeProblem(c, problemInvalidBody, "", err)
 		return
 	}
 
@@ -57,82 +74,283 @@ func (o implAmbulancesAPI) CreateAmbulance(c *gin.Context) {
 		ambulance.Id = uuid.New().String()
 	}
 
-	err = db.CreateDocument(c, ambulance.Id, &ambulance)
+	if err := ambulance.validate(); err != nil {
+		writeProblem(c, problemValidationFailed, "", err)
+		return
+	}
+
+	if assigned, ok := assignedAmbulances(c); ok && !slices.Contains(assigned, ambulance.Id) {
+		writeProblem(c, problemAmbulanceNotAssigned, "ambulance "+ambulance.Id+" is not assigned to the user", nil)
+		return
+	}
+
+	// patients of the initial waiting list join it as the walk-in patients
+	// added by CreateWaitingListEntry, their lifecycle starts from waiting and
+	// there are no finished visits to learn the durations from
+	requested := ambulance.WaitingList
+	ambulance.WaitingList = nil
+	for i := range requested {
+		entry := requested[i]
+		switch err := ambulance.admitWalkIn(&entry, fmt.Sprintf("/waitingList/%d", i), nil); {
+		case errors.Is(err, errEntryExists):
+			writeProblem(c, problemEntryExists, fmt.Sprintf("Entry /waitingList/%d is listed more than once", i), nil)
+			return
+		case err != nil:
+			writeProblem(c, problemValidationFailed, "", err)
+			return
+		}
+		ambulance.WaitingList = append(ambulance.WaitingList, entry)
+	}
+	ambulance.reconcileWaitingList()
+
+	// waiting list entries are stored separately from the ambulance
+	waitingList := ambulance.WaitingList
+	ambulance.WaitingList = nil
+	err = runInTransaction(c, db, func(ctx context.Context) error {
+		changes := newChangeLog(c)
+		if err := db.CreateDocument(ctx, ambulance.Id, &ambulance); err != nil {
+			return err
+		}
+		if err := changes.ambulanceChanged(ambulance.Id, nil, &ambulance); err != nil {
+			return err
+		}
+		for i := range waitingList {
+			if err := entriesDb.CreateChildDocument(ctx, ambulance.Id, waitingList[i].Id, &waitingList[i]); err != nil {
+				return err
+			}
+			if err := changes.entryChanged(ambulance.Id, nil, &waitingList[i]); err != nil {
+				return err
+			}
+		}
+		return changes.store(ctx)
+	})
 
 	switch err {
 	case nil:
+		ambulance.WaitingList = waitingList
 		c.JSON(
 			http.StatusCreated,
 			ambulance,
 		)
 	case db_service.ErrConflict:
-		c.JSON(
-			http.StatusConflict,
-			gin.H{
-				"status":  "Conflict",
-				"message": "Ambulance already exists",
-				"error":   err.Error(),
-			},
-		)
+		writeProblem(c, problemAmbulanceExists, "", nil)
 	default:
-		c.JSON(
-			http.StatusBadGateway,
-			gin.H{
-				"status":  "Bad Gateway",
-				"message": "Failed to create ambulance in database",
-				"error":   err.Error(),
-			},
-		)
+		writeProblem(c, problemDatabaseError, "Failed to create ambulance in database", err)
 	}
 }
 
 func (o implAmbulancesAPI) DeleteAmbulance(c *gin.Context) {
 	value, exists := c.Get("db_service")
 	if !exists {
-		c.JSON(
-			http.StatusInternalServerError,
-			gin.H{
-				"status":  "Internal Server Error",
-				"message": "db_service not found",
-				"error":   "db_service not found",
-			})
+		writeProblem(c, problemInternalError, "db_service not found", nil)
 		return
 	}
 
 	db, ok := value.(db_service.DbService[Ambulance])
 	if !ok {
-		c.JSON(
-			http.StatusInternalServerError,
-			gin.H{
-				"status":  "Internal Server Error",
-				"message": "db_service context is not of type db_service.DbService",
-				"error":   "cannot cast db_service context to db_service.DbService",
-			})
+		writeProblem(c, problemInternalError, "cannot cast db_service context to db_service.DbService", nil)
+		return
+	}
+
+	value, exists = c.Get("db_service_waiting_list")
+	if !exists {
+		writeProblem(c, problemInternalError, "db_service_waiting_list not found", nil)
+		return
+	}
+
+	entriesDb, ok := value.(db_service.DbChildService[WaitingListEntry])
+	if !ok {
+		writeProblem(c, problemInternalError, "cannot cast db_service_waiting_list context to db_service.DbChildService", nil)
 		return
 	}
 
 	ambulanceId := c.Param("ambulanceId")
-	err := db.DeleteDocument(c, ambulanceId)
+	var refused problemKind
+	err := runInTransaction(c, db, func(ctx context.Context) error {
+		ambulance, err := db.FindDocument(ctx, ambulanceId)
+		if err != nil {
+			return err
+		}
+		loaded, err := loadWaitingList(ctx, entriesDb, ambulance, "")
+		if err != nil {
+			return err
+		}
+		if kind, ok := checkIfMatch(c, ambulance); !ok {
+			refused = kind
+			return errPreconditionRefused
+		}
+		if err := db.DeleteDocument(ctx, ambulanceId); err != nil {
+			return err
+		}
+		if err := entriesDb.DeleteChildDocuments(ctx, ambulanceId); err != nil {
+			return err
+		}
+
+		// patients on the waiting list are removed together with the ambulance
+		changes := newChangeLog(c)
+		for i := range ambulance.WaitingList {
+			entry, err := json.Marshal(&ambulance.WaitingList[i])
+			if err == nil {
+				err = changes.entryChanged(ambulanceId, entry, nil)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		if err := changes.ambulanceChanged(ambulanceId, loaded.metadata, nil); err != nil {
+			return err
+		}
+		return changes.store(ctx)
+	})
 
 	switch err {
 	case nil:
 		c.AbortWithStatus(http.StatusNoContent)
 	case db_service.ErrNotFound:
-		c.JSON(
-			http.StatusNotFound,
-			gin.H{
-				"status":  "Not Found",
-				"message": "Ambulance not found",
-				"error":   err.Error(),
-			},
-		)
+		writeProblem(c, problemAmbulanceNotFound, "", nil)
+	case errPreconditionRefused:
+		writeProblem(c, refused, "", nil)
 	default:
-		c.JSON(
-			http.StatusBadGateway,
-			gin.H{
-				"status":  "Bad Gateway",
-				"message": "Failed to delete ambulance from database",
-				"error":   err.Error(),
-			})
+		writeProblem(c, problemDatabaseError, "Failed to delete ambulance from database", err)
+	}
+}
+
+func (o implAmbulancesAPI) GetAmbulances(c *gin.Context) {
+	value, exists := c.Get("db_service")
+	if !exists {
+		writeProblem(c, problemInternalError, "db_service not found", nil)
+		return
+	}
+
+	db, ok := value.(db_service.DbService[Ambulance])
+	if !ok {
+		writeProblem(c, problemInternalError, "cannot cast db_service context to db_service.DbService", nil)
+		return
+	}
+
+	limit := defaultAmbulancesPageSize
+	if limitParam := c.Query("limit"); limitParam != "" {
+		var err error
+		limit, err = strconv.Atoi(limitParam)
+		if err != nil || limit < 1 || limit > maxAmbulancesPageSize {
+			writeProblem(c, problemInvalidQuery, "Invalid limit "+limitParam+", expected number between 1 and "+strconv.Itoa(maxAmbulancesPageSize), nil)
+			return
+		}
+	}
+
+	sortParam := c.DefaultQuery("sort", "name")
+	sortField, ok := ambulanceSortFields[strings.TrimPrefix(sortParam, "-")]
+	if !ok {
+		writeProblem(c, problemInvalidQuery, "Invalid sort property "+sortParam, nil)
+		return
+	}
+	if strings.HasPrefix(sortParam, "-") {
+		sortField = "-" + sortField
+	}
+
+	query := db_service.Query{
+		SearchText:   c.Query("search"),
+		SearchFields: []string{"name", "roomnumber"},
+		SortBy:       sortField,
+		Limit:        limit,
+		Cursor:       c.Query("cursor"),
+		Projection:   []string{"id", "name", "roomnumber"},
+	}
+	// staff sees only the ambulances they are assigned to
+	if assigned, ok := assignedAmbulances(c); ok {
+		query.Filters = append(query.Filters, db_service.Filter{Field: "id", Operator: db_service.FilterIn, Value: assigned})
 	}
+	page, err := db.FindDocuments(c, query)
+
+	switch {
+	case err == nil:
+		// continue
+	case errors.Is(err, db_service.ErrInvalidQuery):
+		writeProblem(c, problemInvalidQuery, "", err)
+		return
+	default:
+		writeProblem(c, problemDatabaseError, "Failed to load ambulances from database", err)
+		return
+	}
+
+	result := AmbulanceList{
+		Items: make([]AmbulanceSummary, 0, len(page.Items)),
+		Links: PagingLinks{Self: c.Request.URL.RequestURI()},
+	}
+	for _, ambulance := range page.Items {
+		result.Items = append(result.Items, AmbulanceSummary{
+			Id:         ambulance.Id,
+			Name:       ambulance.Name,
+			RoomNumber: ambulance.RoomNumber,
+		})
+	}
+	if page.NextCursor != "" {
+		next := c.Request.URL.Query()
+		next.Set("cursor", page.NextCursor)
+		result.Links.Next = c.Request.URL.Path + "?" + next.Encode()
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+func (o implAmbulancesAPI) GetAmbulance(c *gin.Context) {
+	updateAmbulanceFunc(c, func(c *gin.Context, ambulance *Ambulance) (*Ambulance, interface{}, int) {
+		// return nil ambulance - no need to update it in db
+		return nil, ambulance, http.StatusOK
+	})
+}
+
+func (o implAmbulancesAPI) UpdateAmbulance(c *gin.Context) {
+	updateAmbulanceFunc(c, func(c *gin.Context, ambulance *Ambulance) (*Ambulance, interface{}, int) {
+		var updated Ambulance
+
+		if err := c.ShouldBindJSON(&updated); err != nil {
+			return rejectUpdate(c, problemInvalidBody, "", err)
+		}
+
+		return replaceAmbulanceDetails(c, ambulance, &updated)
+	})
+}
+
+func (o implAmbulancesAPI) PatchAmbulance(c *gin.Context) {
+	updateAmbulanceFunc(c, func(c *gin.Context, ambulance *Ambulance) (*Ambulance, interface{}, int) {
+		contentType := c.ContentType()
+		if contentType != "application/merge-patch+json" && contentType != "application/json" {
+			return rejectUpdate(c, problemUnsupportedMediaType, "Expected application/merge-patch+json request body", nil)
+		}
+
+		patch, err := c.GetRawData()
+		if err != nil {
+			return rejectUpdate(c, problemInvalidBody, "Failed to read request body", err)
+		}
+
+		updated, err := mergePatchDocument(ambulance, patch)
+		if err != nil {
+			return rejectUpdate(c, problemInvalidBody, "Invalid merge patch", err)
+		}
+
+		return replaceAmbulanceDetails(c, ambulance, updated)
+	})
+}
+
+// replaceAmbulanceDetails validates the updated ambulance and takes over the
+// waiting list and revision of the stored ambulance, the stored ambulance must
+// match the If-Match precondition of the request
+func replaceAmbulanceDetails(c *gin.Context, ambulance *Ambulance, updated *Ambulance) (*Ambulance, interface{}, int) {
+	if kind, ok := checkIfMatch(c, ambulance); !ok {
+		return rejectUpdate(c, kind, "", nil)
+	}
+
+	if err := updated.validate(); err != nil {
+		return rejectUpdate(c, problemValidationFailed, "", err)
+	}
+
+	if updated.Id != ambulance.Id {
+		return rejectUpdate(c, problemValidationFailed, "", fieldErrors{invalidField("/id", "id does not match the ambulanceId")})
+	}
+
+	// the waiting list is managed by the waiting list API only
+	updated.WaitingList = ambulance.WaitingList
+	updated.Version = ambulance.Version
+	return updated, updated, http.StatusOK
 }
@@ -0,0 +1,166 @@
+package ambulance_wl
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+	"github.com/wac-fiit/cv2-ambulance-webapi/internal/db_service"
+)
+
+type AmbulancesSuite struct {
+	suite.Suite
+	dbServiceMock        *DbServiceMock[Ambulance]
+	entriesDbServiceMock *DbChildServiceMock[WaitingListEntry]
+}
+
+func (suite *AmbulancesSuite) SetupTest() {
+	suite.dbServiceMock = &DbServiceMock[Ambulance]{}
+	suite.entriesDbServiceMock = &DbChildServiceMock[WaitingListEntry]{}
+	suite.entriesDbServiceMock.
+		On("FindChildDocuments", mock.Anything, "test-ambulance", mock.Anything).
+		Return([]*WaitingListEntry{{Id: "test-entry", PatientId: "test-patient"}}, nil)
+}
+
+func TestAmbulancesSuite(t *testing.T) {
+	suite.Run(t, new(AmbulancesSuite))
+}
+
+func (suite *AmbulancesSuite) Test_GetAmbulances_ReturnsSummariesWithNextLink() {
+	// ARRANGE
+	suite.dbServiceMock.
+		On("FindDocuments", mock.Anything, mock.Anything).
+		Return(
+			&db_service.Page[Ambulance]{
+				Items: []*Ambulance{
+					{
+						Id:          "test-ambulance",
+						Name:        "Test Ambulance",
+						RoomNumber:  "101",
+						WaitingList: []WaitingListEntry{{Id: "test-entry"}},
+					},
+				},
+				NextCursor: "next-page",
+			},
+			nil,
+		)
+
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(recorder)
+	ctx.Set("db_service", suite.dbServiceMock)
+	ctx.Set("db_service_waiting_list", suite.entriesDbServiceMock)
+	ctx.Request = httptest.NewRequest("GET", "/api/ambulance?search=test&sort=-roomNumber&limit=1", nil)
+
+	sut := implAmbulancesAPI{}
+
+	// ACT
+	sut.GetAmbulances(ctx)
+
+	// ASSERT
+	suite.Equal(http.StatusOK, recorder.Code)
+	suite.dbServiceMock.AssertCalled(suite.T(), "FindDocuments", mock.Anything, mock.MatchedBy(func(query db_service.Query) bool {
+		return query.SearchText == "test" && query.SortBy == "-roomnumber" && query.Limit == 1
+	}))
+
+	var result AmbulanceList
+	suite.NoError(json.Unmarshal(recorder.Body.Bytes(), &result))
+	suite.Equal([]AmbulanceSummary{{Id: "test-ambulance", Name: "Test Ambulance", RoomNumber: "101"}}, result.Items)
+	suite.Equal("/api/ambulance?search=test&sort=-roomNumber&limit=1", result.Links.Self)
+	suite.Equal("/api/ambulance?cursor=next-page&limit=1&search=test&sort=-roomNumber", result.Links.Next)
+}
+
+func (suite *AmbulancesSuite) Test_GetAmbulances_InvalidLimit() {
+	// ARRANGE
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(recorder)
+	ctx.Set("db_service", suite.dbServiceMock)
+	ctx.Set("db_service_waiting_list", suite.entriesDbServiceMock)
+	ctx.Request = httptest.NewRequest("GET", "/api/ambulance?limit=1000", nil)
+
+	sut := implAmbulancesAPI{}
+
+	// ACT
+	sut.GetAmbulances(ctx)
+
+	// ASSERT
+	suite.Equal(http.StatusBadRequest, recorder.Code)
+	suite.dbServiceMock.AssertNotCalled(suite.T(), "FindDocuments", mock.Anything, mock.Anything)
+}
+
+func (suite *AmbulancesSuite) Test_PatchAmbulance_PreservesWaitingList() {
+	// ARRANGE
+	suite.dbServiceMock.
+		On("FindDocument", mock.Anything, "test-ambulance").
+		Return(
+			&Ambulance{
+				Id:         "test-ambulance",
+				Name:       "Test Ambulance",
+				RoomNumber: "101",
+				Version:    3,
+			},
+			nil,
+		)
+	suite.dbServiceMock.
+		On("UpdateDocument", mock.Anything, mock.Anything, mock.Anything).
+		Return(nil)
+
+	patch := `{ "roomNumber": "202", "waitingList": null }`
+
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(recorder)
+	ctx.Set("db_service", suite.dbServiceMock)
+	ctx.Set("db_service_waiting_list", suite.entriesDbServiceMock)
+	ctx.Params = []gin.Param{{Key: "ambulanceId", Value: "test-ambulance"}}
+	ctx.Request = httptest.NewRequest("PATCH", "/api/ambulance/test-ambulance", strings.NewReader(patch))
+	ctx.Request.Header.Set("Content-Type", "application/merge-patch+json")
+
+	sut := implAmbulancesAPI{}
+
+	// ACT
+	sut.PatchAmbulance(ctx)
+
+	// ASSERT
+	suite.Equal(http.StatusOK, recorder.Code)
+	suite.dbServiceMock.AssertCalled(suite.T(), "UpdateDocument", mock.Anything, "test-ambulance", mock.MatchedBy(func(ambulance *Ambulance) bool {
+		return ambulance.RoomNumber == "202" &&
+			ambulance.Name == "Test Ambulance" &&
+			ambulance.Version == 3
+	}))
+	suite.entriesDbServiceMock.AssertNotCalled(suite.T(), "DeleteChildDocument", mock.Anything, mock.Anything, mock.Anything)
+
+	var result Ambulance
+	suite.NoError(json.Unmarshal(recorder.Body.Bytes(), &result))
+	suite.Equal([]WaitingListEntry{{Id: "test-entry", PatientId: "test-patient"}}, result.WaitingList)
+}
+
+func (suite *AmbulancesSuite) Test_UpdateAmbulance_MissingRequiredProperties() {
+	// ARRANGE
+	suite.dbServiceMock.
+		On("FindDocument", mock.Anything, "test-ambulance").
+		Return(&Ambulance{Id: "test-ambulance", Name: "Test Ambulance", RoomNumber: "101"}, nil)
+
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(recorder)
+	ctx.Set("db_service", suite.dbServiceMock)
+	ctx.Set("db_service_waiting_list", suite.entriesDbServiceMock)
+	ctx.Params = []gin.Param{{Key: "ambulanceId", Value: "test-ambulance"}}
+	ctx.Request = httptest.NewRequest("PUT", "/api/ambulance/test-ambulance", strings.NewReader(`{ "id": "test-ambulance", "name": "Renamed" }`))
+
+	sut := implAmbulancesAPI{}
+
+	// ACT
+	sut.UpdateAmbulance(ctx)
+
+	// ASSERT
+	suite.Equal(http.StatusBadRequest, recorder.Code)
+	suite.dbServiceMock.AssertNotCalled(suite.T(), "UpdateDocument", mock.Anything, mock.Anything, mock.Anything)
+}
@@ -0,0 +1,188 @@
+package ambulance_wl
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/wac-fiit/cv2-ambulance-webapi/internal/auth"
+	"github.com/wac-fiit/cv2-ambulance-webapi/internal/db_service"
+)
+
+type implApiKeysAPI struct {
+}
+
+func NewApiKeysApi() ApiKeysAPI {
+	return &implApiKeysAPI{}
+}
+
+// apiKeysService provides the database of the API keys from the context, the
+// error response is written if it is missing
+func apiKeysService(c *gin.Context) (db_service.DbService[StoredApiKey], bool) {
+	apiKeysDb, ok := c.Value("db_service_api_keys").(db_service.DbService[StoredApiKey])
+	if !ok {
+		writeProblem(c, problemInternalError, "db_service_api_keys not found", nil)
+		return nil, false
+	}
+	return apiKeysDb, true
+}
+
+// findApiKey loads the API key of the request path, the error response is
+// written if it cannot be loaded
+func findApiKey(c *gin.Context, apiKeysDb db_service.DbService[StoredApiKey]) (*StoredApiKey, bool) {
+	apiKey, err := apiKeysDb.FindDocument(c, c.Param("keyId"))
+	switch err {
+	case nil:
+		return apiKey, true
+	case db_service.ErrNotFound:
+		writeProblem(c, problemApiKeyNotFound, "", nil)
+	default:
+		writeProblem(c, problemDatabaseError, "Failed to load API key from database", err)
+	}
+	return nil, false
+}
+
+// updateApiKey stores the revoked or rotated API key and writes the response,
+// the key is returned only if it was rotated
+func updateApiKey(c *gin.Context, apiKeysDb db_service.DbService[StoredApiKey], apiKey *StoredApiKey, key string) {
+	switch err := apiKeysDb.UpdateDocument(c, apiKey.Id, apiKey); err {
+	case nil:
+		result := apiKey.withoutSecrets()
+		result.Key = key
+		c.JSON(http.StatusOK, result)
+	case db_service.ErrNotFound:
+		writeProblem(c, problemApiKeyNotFound, "", nil)
+	case db_service.ErrVersionConflict:
+		writeProblem(c, problemConcurrentModification, "API key was modified concurrently, retry the request", nil)
+	default:
+		writeProblem(c, problemDatabaseError, "Failed to update API key in database", err)
+	}
+}
+
+func (o implApiKeysAPI) CreateApiKey(c *gin.Context) {
+	apiKeysDb, ok := apiKeysService(c)
+	if !ok {
+		return
+	}
+
+	apiKey := StoredApiKey{}
+	if err := c.BindJSON(&apiKey.ApiKey); err != nil {
+		writeProblem(c, problemInvalidBody, "", err)
+		return
+	}
+
+	now := time.Now()
+	if err := apiKey.validate(now); err != nil {
+		writeProblem(c, problemValidationFailed, "", err)
+		return
+	}
+
+	// keys must not grant more than the user issuing them is granted, anybody
+	// may issue any key only if the requests are not authenticated at all
+	principal, _ := auth.PrincipalFromContext(c)
+	policy, _ := c.Value("authorization_policy").(*auth.Policy)
+	if principal != nil && policy == nil {
+		writeProblem(c, problemInternalError, "authorization_policy not found", nil)
+		return
+	}
+	assigned, restricted := assignedAmbulances(c)
+	if kind, detail, ok := apiKey.restrictTo(principal, policy, assigned, restricted); !ok {
+		writeProblem(c, kind, detail, nil)
+		return
+	}
+
+	// the server issues the keys, properties provided by the client are ignored
+	apiKey.Id = uuid.NewString()
+	apiKey.Key = ""
+	apiKey.CreatedAt = time.Time{}
+	apiKey.LastUsedAt = time.Time{}
+	apiKey.RevokedAt = time.Time{}
+	key, err := apiKey.issue(now)
+	if err != nil {
+		writeProblem(c, problemInternalError, "Failed to generate API key", err)
+		return
+	}
+
+	if err := apiKeysDb.CreateDocument(c, apiKey.Id, &apiKey); err != nil {
+		writeProblem(c, problemDatabaseError, "Failed to create API key in database", err)
+		return
+	}
+
+	result := apiKey.withoutSecrets()
+	result.Key = key
+	c.JSON(http.StatusCreated, result)
+}
+
+func (o implApiKeysAPI) GetApiKey(c *gin.Context) {
+	apiKeysDb, ok := apiKeysService(c)
+	if !ok {
+		return
+	}
+
+	if apiKey, ok := findApiKey(c, apiKeysDb); ok {
+		c.JSON(http.StatusOK, apiKey.withoutSecrets())
+	}
+}
+
+func (o implApiKeysAPI) GetApiKeys(c *gin.Context) {
+	apiKeysDb, ok := apiKeysService(c)
+	if !ok {
+		return
+	}
+
+	page, err := apiKeysDb.FindDocuments(c, db_service.Query{})
+	if err != nil {
+		writeProblem(c, problemDatabaseError, "Failed to load API keys from database", err)
+		return
+	}
+
+	result := []*ApiKey{}
+	for _, apiKey := range page.Items {
+		result = append(result, apiKey.withoutSecrets())
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+func (o implApiKeysAPI) RevokeApiKey(c *gin.Context) {
+	apiKeysDb, ok := apiKeysService(c)
+	if !ok {
+		return
+	}
+
+	apiKey, ok := findApiKey(c, apiKeysDb)
+	if !ok {
+		return
+	}
+	if !apiKey.RevokedAt.IsZero() {
+		// already revoked, the original time is kept
+		c.JSON(http.StatusOK, apiKey.withoutSecrets())
+		return
+	}
+
+	apiKey.RevokedAt = time.Now()
+	updateApiKey(c, apiKeysDb, apiKey, "")
+}
+
+func (o implApiKeysAPI) RotateApiKey(c *gin.Context) {
+	apiKeysDb, ok := apiKeysService(c)
+	if !ok {
+		return
+	}
+
+	apiKey, ok := findApiKey(c, apiKeysDb)
+	if !ok {
+		return
+	}
+	if !apiKey.RevokedAt.IsZero() {
+		writeProblem(c, problemApiKeyRevoked, "Revoked API key cannot be rotated", nil)
+		return
+	}
+
+	key, err := apiKey.issue(time.Now())
+	if err != nil {
+		writeProblem(c, problemInternalError, "Failed to generate API key", err)
+		return
+	}
+	updateApiKey(c, apiKeysDb, apiKey, key)
+}
@@ -0,0 +1,116 @@
+package ambulance_wl
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wac-fiit/cv2-ambulance-webapi/internal/db_service"
+)
+
+type implAuditAPI struct {
+}
+
+const defaultAuditPageSize = 20
+const maxAuditPageSize = 100
+
+// maps filtering query parameters of the API to the fields of the stored audit record
+var auditFilterFields = map[string]string{
+	"ambulanceId": "ambulanceid",
+	"patientId":   "patientid",
+	"actor":       "actor",
+}
+
+func NewAuditApi() AuditAPI {
+	return &implAuditAPI{}
+}
+
+func (o implAuditAPI) GetAuditRecords(c *gin.Context) {
+	value, exists := c.Get("db_service_audit")
+	if !exists {
+		writeProblem(c, problemInternalError, "db_service_audit not found", nil)
+		return
+	}
+
+	db, ok := value.(db_service.DbService[AuditRecord])
+	if !ok {
+		writeProblem(c, problemInternalError, "cannot cast db_service_audit context to db_service.DbService", nil)
+		return
+	}
+
+	limit := defaultAuditPageSize
+	if limitParam := c.Query("limit"); limitParam != "" {
+		var err error
+		limit, err = strconv.Atoi(limitParam)
+		if err != nil || limit < 1 || limit > maxAuditPageSize {
+			writeProblem(c, problemInvalidQuery, "Invalid limit "+limitParam+", expected number between 1 and "+strconv.Itoa(maxAuditPageSize), nil)
+			return
+		}
+	}
+
+	filters := []db_service.Filter{}
+	for param, field := range auditFilterFields {
+		if value := c.Query(param); value != "" {
+			filters = append(filters, db_service.Filter{Field: field, Value: value})
+		}
+	}
+	// staff sees only the changes of the ambulances they are assigned to
+	if assigned, ok := assignedAmbulances(c); ok {
+		if ambulanceId := c.Query("ambulanceId"); ambulanceId != "" && !slices.Contains(assigned, ambulanceId) {
+			writeProblem(c, problemAmbulanceNotAssigned, "ambulance "+ambulanceId+" is not assigned to the user", nil)
+			return
+		}
+		filters = append(filters, db_service.Filter{Field: "ambulanceid", Operator: db_service.FilterIn, Value: assigned})
+	}
+	for param, operator := range map[string]db_service.FilterOperator{
+		"from": db_service.FilterGreaterOrEqual,
+		"to":   db_service.FilterLessOrEqual,
+	} {
+		value := c.Query(param)
+		if value == "" {
+			continue
+		}
+		occurredAt, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			writeProblem(c, problemInvalidQuery, "Invalid "+param+" time, expected RFC 3339 date-time", err)
+			return
+		}
+		filters = append(filters, db_service.Filter{Field: "occurredat", Operator: operator, Value: occurredAt})
+	}
+
+	page, err := db.FindDocuments(c, db_service.Query{
+		Filters: filters,
+		SortBy:  "occurredat",
+		Limit:   limit,
+		Cursor:  c.Query("cursor"),
+	})
+
+	switch {
+	case err == nil:
+		// continue
+	case errors.Is(err, db_service.ErrInvalidQuery):
+		writeProblem(c, problemInvalidQuery, "", err)
+		return
+	default:
+		writeProblem(c, problemDatabaseError, "Failed to load audit records from database", err)
+		return
+	}
+
+	result := AuditRecordList{
+		Items: make([]AuditRecord, 0, len(page.Items)),
+		Links: PagingLinks{Self: c.Request.URL.RequestURI()},
+	}
+	for _, record := range page.Items {
+		result.Items = append(result.Items, *record)
+	}
+	if page.NextCursor != "" {
+		next := c.Request.URL.Query()
+		next.Set("cursor", page.NextCursor)
+		result.Links.Next = c.Request.URL.Path + "?" + next.Encode()
+	}
+
+	c.JSON(http.StatusOK, result)
+}
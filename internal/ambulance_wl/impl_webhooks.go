@@ -0,0 +1,309 @@
+package ambulance_wl
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/wac-fiit/cv2-ambulance-webapi/internal/db_service"
+)
+
+type implWebhooksAPI struct {
+}
+
+func NewWebhooksApi() WebhooksAPI {
+	return &implWebhooksAPI{}
+}
+
+// webhookServices provides services of the webhook operations from the context,
+// the error response is written if any of them is missing
+func webhookServices(c *gin.Context) (
+	db_service.DbService[Webhook],
+	db_service.DbService[WebhookDeadLetter],
+	*WebhookDispatcher,
+	bool,
+) {
+	webhooksDb, ok := c.Value("db_service_webhooks").(db_service.DbService[Webhook])
+	if !ok {
+		writeProblem(c, problemInternalError, "db_service_webhooks not found", nil)
+		return nil, nil, nil, false
+	}
+
+	deadLettersDb, ok := c.Value("db_service_webhook_dead_letters").(db_service.DbService[WebhookDeadLetter])
+	if !ok {
+		writeProblem(c, problemInternalError, "db_service_webhook_dead_letters not found", nil)
+		return nil, nil, nil, false
+	}
+
+	dispatcher, ok := c.Value("webhook_dispatcher").(*WebhookDispatcher)
+	if !ok {
+		writeProblem(c, problemInternalError, "webhook_dispatcher not found", nil)
+		return nil, nil, nil, false
+	}
+	return webhooksDb, deadLettersDb, dispatcher, true
+}
+
+// findWebhook loads the webhook of the request path, the error response is
+// written if it cannot be loaded
+func findWebhook(c *gin.Context, webhooksDb db_service.DbService[Webhook]) (*Webhook, bool) {
+	webhook, err := webhooksDb.FindDocument(c, c.Param("webhookId"))
+	switch err {
+	case nil:
+		return webhook, true
+	case db_service.ErrNotFound:
+		writeProblem(c, problemWebhookNotFound, "", nil)
+	default:
+		writeProblem(c, problemDatabaseError, "Failed to load webhook from database", err)
+	}
+	return nil, false
+}
+
+// findDeadLetter loads the dead letter of the request path, the error response
+// is written if it cannot be loaded or belongs to other webhook
+func findDeadLetter(c *gin.Context, deadLettersDb db_service.DbService[WebhookDeadLetter]) (*WebhookDeadLetter, bool) {
+	deadLetter, err := deadLettersDb.FindDocument(c, c.Param("deliveryId"))
+	if err == nil && deadLetter.WebhookId != c.Param("webhookId") {
+		err = db_service.ErrNotFound
+	}
+	switch err {
+	case nil:
+		return deadLetter, true
+	case db_service.ErrNotFound:
+		writeProblem(c, problemDeadLetterNotFound, "", nil)
+	default:
+		writeProblem(c, problemDatabaseError, "Failed to load dead letter from database", err)
+	}
+	return nil, false
+}
+
+// withoutSecret returns the webhook as provided by the API
+func withoutSecret(webhook *Webhook) *Webhook {
+	result := *webhook
+	result.Secret = ""
+	return &result
+}
+
+func (o implWebhooksAPI) CreateWebhook(c *gin.Context) {
+	webhooksDb, _, _, ok := webhookServices(c)
+	if !ok {
+		return
+	}
+
+	webhook := Webhook{}
+	if err := c.BindJSON(&webhook); err != nil {
+		writeProblem(c, problemInvalidBody, "", err)
+		return
+	}
+
+	if webhook.Id == "" {
+		webhook.Id = uuid.NewString()
+	}
+
+	err := webhook.validate()
+	if err == nil && webhook.Secret == "" {
+		err = errMissingWebhookSecret
+	}
+	if err != nil {
+		writeProblem(c, problemValidationFailed, "", err)
+		return
+	}
+
+	switch err := webhooksDb.CreateDocument(c, webhook.Id, &webhook); err {
+	case nil:
+		c.JSON(http.StatusCreated, withoutSecret(&webhook))
+	case db_service.ErrConflict:
+		writeProblem(c, problemWebhookExists, "", nil)
+	default:
+		writeProblem(c, problemDatabaseError, "Failed to create webhook in database", err)
+	}
+}
+
+func (o implWebhooksAPI) DeleteWebhook(c *gin.Context) {
+	webhooksDb, deadLettersDb, _, ok := webhookServices(c)
+	if !ok {
+		return
+	}
+
+	webhookId := c.Param("webhookId")
+	switch err := webhooksDb.DeleteDocument(c, webhookId); err {
+	case nil:
+	case db_service.ErrNotFound:
+		writeProblem(c, problemWebhookNotFound, "", nil)
+		return
+	default:
+		writeProblem(c, problemDatabaseError, "Failed to delete webhook from database", err)
+		return
+	}
+
+	deadLetters, err := deadLettersDb.FindDocuments(c, db_service.Query{
+		Filters: []db_service.Filter{{Field: "webhookid", Value: webhookId}},
+	})
+	if err == nil {
+		for _, deadLetter := range deadLetters.Items {
+			if err = deadLettersDb.DeleteDocument(c, deadLetter.Id); err == db_service.ErrNotFound {
+				err = nil // replayed or discarded meanwhile
+			} else if err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		writeProblem(c, problemDatabaseError, "Failed to delete dead letters of the webhook from database", err)
+		return
+	}
+	c.AbortWithStatus(http.StatusNoContent)
+}
+
+func (o implWebhooksAPI) DeleteWebhookDeadLetter(c *gin.Context) {
+	_, deadLettersDb, _, ok := webhookServices(c)
+	if !ok {
+		return
+	}
+
+	deadLetter, ok := findDeadLetter(c, deadLettersDb)
+	if !ok {
+		return
+	}
+
+	switch err := deadLettersDb.DeleteDocument(c, deadLetter.Id); err {
+	case nil:
+		c.AbortWithStatus(http.StatusNoContent)
+	case db_service.ErrNotFound:
+		writeProblem(c, problemDeadLetterNotFound, "", nil)
+	default:
+		writeProblem(c, problemDatabaseError, "Failed to delete dead letter from database", err)
+	}
+}
+
+func (o implWebhooksAPI) GetWebhook(c *gin.Context) {
+	webhooksDb, _, _, ok := webhookServices(c)
+	if !ok {
+		return
+	}
+
+	if webhook, ok := findWebhook(c, webhooksDb); ok {
+		c.JSON(http.StatusOK, withoutSecret(webhook))
+	}
+}
+
+func (o implWebhooksAPI) GetWebhookDeadLetters(c *gin.Context) {
+	webhooksDb, deadLettersDb, _, ok := webhookServices(c)
+	if !ok {
+		return
+	}
+
+	webhook, ok := findWebhook(c, webhooksDb)
+	if !ok {
+		return
+	}
+
+	page, err := deadLettersDb.FindDocuments(c, db_service.Query{
+		Filters: []db_service.Filter{{Field: "webhookid", Value: webhook.Id}},
+		SortBy:  "failedat",
+	})
+	if err != nil {
+		writeProblem(c, problemDatabaseError, "Failed to load dead letters from database", err)
+		return
+	}
+
+	result := []*WebhookDeadLetter{}
+	result = append(result, page.Items...)
+	c.JSON(http.StatusOK, result)
+}
+
+func (o implWebhooksAPI) GetWebhooks(c *gin.Context) {
+	webhooksDb, _, _, ok := webhookServices(c)
+	if !ok {
+		return
+	}
+
+	page, err := webhooksDb.FindDocuments(c, db_service.Query{})
+	if err != nil {
+		writeProblem(c, problemDatabaseError, "Failed to load webhooks from database", err)
+		return
+	}
+
+	result := []*Webhook{}
+	for _, webhook := range page.Items {
+		result = append(result, withoutSecret(webhook))
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+func (o implWebhooksAPI) ReplayWebhookDeadLetter(c *gin.Context) {
+	webhooksDb, deadLettersDb, dispatcher, ok := webhookServices(c)
+	if !ok {
+		return
+	}
+
+	webhook, ok := findWebhook(c, webhooksDb)
+	if !ok {
+		return
+	}
+	deadLetter, ok := findDeadLetter(c, deadLettersDb)
+	if !ok {
+		return
+	}
+
+	status, err := dispatcher.replay(c, webhook, deadLetter)
+	if err == nil {
+		// delivered, the dead letter is not needed any more
+		if err := deadLettersDb.DeleteDocument(c, deadLetter.Id); err != nil && err != db_service.ErrNotFound {
+			writeProblem(c, problemDatabaseError, "Event delivered but failed to delete dead letter from database", err)
+			return
+		}
+		c.AbortWithStatus(http.StatusNoContent)
+		return
+	}
+
+	deadLetter.Attempts++
+	deadLetter.LastStatus = int32(status)
+	deadLetter.LastError = err.Error()
+	deadLetter.FailedAt = time.Now()
+	if err := deadLettersDb.UpdateDocument(c, deadLetter.Id, deadLetter); err != nil && err != db_service.ErrNotFound {
+		writeProblem(c, problemDatabaseError, "Failed to update dead letter in database", err)
+		return
+	}
+	c.JSON(http.StatusBadGateway, deadLetter)
+}
+
+func (o implWebhooksAPI) UpdateWebhook(c *gin.Context) {
+	webhooksDb, _, _, ok := webhookServices(c)
+	if !ok {
+		return
+	}
+
+	webhook := Webhook{}
+	if err := c.BindJSON(&webhook); err != nil {
+		writeProblem(c, problemInvalidBody, "", err)
+		return
+	}
+
+	if err := webhook.validate(); err != nil {
+		writeProblem(c, problemValidationFailed, "", err)
+		return
+	}
+
+	if webhook.Id != c.Param("webhookId") {
+		writeProblem(c, problemValidationFailed, "", fieldErrors{invalidField("/id", "id does not match the webhookId")})
+		return
+	}
+
+	stored, ok := findWebhook(c, webhooksDb)
+	if !ok {
+		return
+	}
+	if webhook.Secret == "" {
+		webhook.Secret = stored.Secret
+	}
+
+	switch err := webhooksDb.UpdateDocument(c, webhook.Id, &webhook); err {
+	case nil:
+		c.JSON(http.StatusOK, withoutSecret(&webhook))
+	case db_service.ErrNotFound:
+		writeProblem(c, problemWebhookNotFound, "", nil)
+	default:
+		writeProblem(c, problemDatabaseError, "Failed to update webhook in database", err)
+	}
+}
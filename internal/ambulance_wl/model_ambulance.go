@@ -12,7 +12,7 @@ package ambulance_wl
 
 type Ambulance struct {
 
-	// Unique identifier of the ambulance
+	// Unique identifier of the ambulance, generated when the ambulance is created without it
 	Id string `json:"id"`
 
 	// Human readable display name of the ambulance
@@ -20,7 +20,29 @@ type Ambulance struct {
 
 	RoomNumber string `json:"roomNumber"`
 
+	// Patients expected in the ambulance, finished visits and no-shows are not included
 	WaitingList []WaitingListEntry `json:"waitingList,omitempty"`
 
 	PredefinedConditions []Condition `json:"predefinedConditions,omitempty"`
+
+	// Doctors or rooms serving the patients in parallel. The ambulance is considered to have a single server if not provided.
+	Servers []Server `json:"servers,omitempty"`
+
+	// Estimated duration of the visit used when it cannot be derived from the patient's condition. Defaults to 15 minutes if not provided.
+	DefaultDurationMinutes int32 `json:"defaultDurationMinutes,omitempty"`
+
+	// Protection of low priority patients against starvation. Priority of the waiting patient is raised by one level for every elapsed `maxWaitingMinutes`. Defaults to 120 minutes if not provided.
+	MaxWaitingMinutes int32 `json:"maxWaitingMinutes,omitempty"`
+
+	// IANA time zone in which the opening hours and closures are specified. Defaults to UTC if not provided.
+	TimeZone string `json:"timeZone,omitempty"`
+
+	// Weekly opening hours of the ambulance. The ambulance is considered to be open all the time if not provided.
+	OpeningHours []OpeningHours `json:"openingHours,omitempty"`
+
+	// Days on which the ambulance is closed, e.g. holidays
+	Closures []Closure `json:"closures,omitempty"`
+
+	// Revision of the stored ambulance, incremented on every update. Used for optimistic concurrency control, ignored on input.
+	Version int64 `json:"version,omitempty"`
 }
@@ -0,0 +1,47 @@
+/*
+ * Waiting List Api
+ *
+ * Ambulance Waiting List management for Web-In-Cloud system
+ *
+ * API version: 1.0.0
+ * Contact: tomas.bocinec@siemens-healthineers.com
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+
+package ambulance_wl
+
+import (
+	"time"
+)
+
+// ApiKey - Key authenticating the requests of the external system in the `X-API-Key` header. The key grants its scopes, the permissions of the operations, instead of the roles of the users.
+type ApiKey struct {
+
+	// Unique identifier of the key, generated when the key is issued
+	Id string `json:"id,omitempty"`
+
+	// Human readable name of the system using the key, recorded as the actor of the changes
+	Name string `json:"name"`
+
+	// The key itself, returned only when the key is issued or rotated
+	Key string `json:"key,omitempty"`
+
+	// Permissions granted by the key, e.g. `waiting-list:write`
+	Scopes []string `json:"scopes"`
+
+	// Ambulances accessible with the key. The key without ambulances is granted the `ambulances:all` scope, only the users not restricted to their assigned ambulances may issue such key.
+	AmbulanceIds []string `json:"ambulanceIds,omitempty"`
+
+	// Time when the key expires, the key does not expire if missing
+	ExpiresAt time.Time `json:"expiresAt,omitempty"`
+
+	// Time when the key was issued, it is kept when the key is rotated
+	CreatedAt time.Time `json:"createdAt,omitempty"`
+
+	// Approximate time of the last request authenticated by the key
+	LastUsedAt time.Time `json:"lastUsedAt,omitempty"`
+
+	// Time when the key was revoked, missing for the valid keys
+	RevokedAt time.Time `json:"revokedAt,omitempty"`
+}
@@ -0,0 +1,28 @@
+/*
+ * Waiting List Api
+ *
+ * Ambulance Waiting List management for Web-In-Cloud system
+ *
+ * API version: 1.0.0
+ * Contact: tomas.bocinec@siemens-healthineers.com
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package ambulance_wl
+
+import (
+	"encoding/json"
+)
+
+// AuditChange - Changed value of the resource
+type AuditChange struct {
+
+	// JSON Pointer of the changed value within the resource
+	Path string `json:"path"`
+
+	// Value before the change, missing if the value was added
+	Before json.RawMessage `json:"before,omitempty"`
+
+	// Value after the change, missing if the value was removed
+	After json.RawMessage `json:"after,omitempty"`
+}
@@ -0,0 +1,21 @@
+/*
+ * Waiting List Api
+ *
+ * Ambulance Waiting List management for Web-In-Cloud system
+ *
+ * API version: 1.0.0
+ * Contact: tomas.bocinec@siemens-healthineers.com
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package ambulance_wl
+
+// AuditOperation : Kind of the change of the audited resource
+type AuditOperation string
+
+// List of AuditOperation
+const (
+	CREATE AuditOperation = "create"
+	UPDATE AuditOperation = "update"
+	DELETE AuditOperation = "delete"
+)
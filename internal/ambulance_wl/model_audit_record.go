@@ -0,0 +1,45 @@
+/*
+ * Waiting List Api
+ *
+ * Ambulance Waiting List management for Web-In-Cloud system
+ *
+ * API version: 1.0.0
+ * Contact: tomas.bocinec@siemens-healthineers.com
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package ambulance_wl
+
+import (
+	"time"
+)
+
+// AuditRecord - Change of the ambulance or of its waiting list entry. The record of the ambulance change has no `entryId`.
+type AuditRecord struct {
+
+	// Unique identifier of the record
+	Id string `json:"id"`
+
+	// Time of the change
+	OccurredAt time.Time `json:"occurredAt"`
+
+	// Identity of the user who made the change, `anonymous` if unknown
+	Actor string `json:"actor"`
+
+	Operation AuditOperation `json:"operation"`
+
+	// API operation which made the change
+	Request string `json:"request,omitempty"`
+
+	// Id of the changed ambulance
+	AmbulanceId string `json:"ambulanceId"`
+
+	// Id of the changed waiting list entry
+	EntryId string `json:"entryId,omitempty"`
+
+	// Id of the patient of the changed waiting list entry
+	PatientId string `json:"patientId,omitempty"`
+
+	// Values changed by the operation
+	Changes []AuditChange `json:"changes"`
+}
@@ -0,0 +1,19 @@
+/*
+ * Waiting List Api
+ *
+ * Ambulance Waiting List management for Web-In-Cloud system
+ *
+ * API version: 1.0.0
+ * Contact: tomas.bocinec@siemens-healthineers.com
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package ambulance_wl
+
+// AuditRecordList - One page of the audit trail
+type AuditRecordList struct {
+
+	Items []AuditRecord `json:"items"`
+
+	Links PagingLinks `json:"links"`
+}
@@ -0,0 +1,24 @@
+/*
+ * Waiting List Api
+ *
+ * Ambulance Waiting List management for Web-In-Cloud system
+ *
+ * API version: 1.0.0
+ * Contact: tomas.bocinec@siemens-healthineers.com
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package ambulance_wl
+
+// Closure - Period of whole days when the ambulance is closed
+type Closure struct {
+
+	// First day of the closure
+	From string `json:"from"`
+
+	// Last day of the closure, inclusive
+	To string `json:"to"`
+
+	// Reason of the closure displayed to the patients
+	Reason string `json:"reason,omitempty"`
+}
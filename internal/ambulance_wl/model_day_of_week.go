@@ -0,0 +1,24 @@
+/*
+ * Waiting List Api
+ *
+ * Ambulance Waiting List management for Web-In-Cloud system
+ *
+ * API version: 1.0.0
+ * Contact: tomas.bocinec@siemens-healthineers.com
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package ambulance_wl
+
+type DayOfWeek string
+
+// List of DayOfWeek
+const (
+	MONDAY DayOfWeek = "monday"
+	TUESDAY DayOfWeek = "tuesday"
+	WEDNESDAY DayOfWeek = "wednesday"
+	THURSDAY DayOfWeek = "thursday"
+	FRIDAY DayOfWeek = "friday"
+	SATURDAY DayOfWeek = "saturday"
+	SUNDAY DayOfWeek = "sunday"
+)
@@ -0,0 +1,23 @@
+/*
+ * Waiting List Api
+ *
+ * Ambulance Waiting List management for Web-In-Cloud system
+ *
+ * API version: 1.0.0
+ * Contact: tomas.bocinec@siemens-healthineers.com
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package ambulance_wl
+
+// EntryState : Lifecycle state of the waiting list entry. New entries are `waiting`, the patient is `called` into the ambulance, the visit is `in-progress` and ends as `finished`, or as `no-show` if the called patient did not come. The state is changed only by the transition operations and is ignored on input.
+type EntryState string
+
+// List of EntryState
+const (
+	WAITING EntryState = "waiting"
+	CALLED EntryState = "called"
+	IN_PROGRESS EntryState = "in-progress"
+	FINISHED EntryState = "finished"
+	NO_SHOW EntryState = "no-show"
+)
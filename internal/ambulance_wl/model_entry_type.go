@@ -0,0 +1,20 @@
+/*
+ * Waiting List Api
+ *
+ * Ambulance Waiting List management for Web-In-Cloud system
+ *
+ * API version: 1.0.0
+ * Contact: tomas.bocinec@siemens-healthineers.com
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package ambulance_wl
+
+// EntryType : Walk-in patients are served in the order of their priority and arrival, appointments reserve their slot in advance. Entries are walk-ins unless booked as appointments.
+type EntryType string
+
+// List of EntryType
+const (
+	WALK_IN EntryType = "walk-in"
+	APPOINTMENT EntryType = "appointment"
+)
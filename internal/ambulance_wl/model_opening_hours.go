@@ -0,0 +1,26 @@
+/*
+ * Waiting List Api
+ *
+ * Ambulance Waiting List management for Web-In-Cloud system
+ *
+ * API version: 1.0.0
+ * Contact: tomas.bocinec@siemens-healthineers.com
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package ambulance_wl
+
+// OpeningHours - Opening hours of the ambulance on the particular day of the week
+type OpeningHours struct {
+
+	DayOfWeek DayOfWeek `json:"dayOfWeek"`
+
+	// Opening time in the `HH:MM` format
+	From string `json:"from"`
+
+	// Closing time in the `HH:MM` format
+	To string `json:"to"`
+
+	// Breaks within the opening hours, e.g. lunch break
+	Breaks []TimeInterval `json:"breaks,omitempty"`
+}
@@ -0,0 +1,21 @@
+/*
+ * Waiting List Api
+ *
+ * Ambulance Waiting List management for Web-In-Cloud system
+ *
+ * API version: 1.0.0
+ * Contact: tomas.bocinec@siemens-healthineers.com
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package ambulance_wl
+
+// PagingLinks - Links for navigation between pages of the list
+type PagingLinks struct {
+
+	// Link to the current page
+	Self string `json:"self"`
+
+	// Link to the next page, missing on the last page
+	Next string `json:"next,omitempty"`
+}
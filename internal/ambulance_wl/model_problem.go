@@ -0,0 +1,39 @@
+/*
+ * Waiting List Api
+ *
+ * Ambulance Waiting List management for Web-In-Cloud system
+ *
+ * API version: 1.0.0
+ * Contact: tomas.bocinec@siemens-healthineers.com
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+
+package ambulance_wl
+
+// Problem - Problem details of the failed request as defined by RFC 7807, sent with the `application/problem+json` content type.
+type Problem struct {
+
+	// URI identifying the problem type, `urn:ambulance-wl:problem:<code>`
+	Type string `json:"type"`
+
+	// Short summary of the problem type, same for all occurrences of the type
+	Title string `json:"title"`
+
+	// HTTP status code of the response
+	Status int32 `json:"status"`
+
+	// Explanation specific to this occurrence of the problem
+	Detail string `json:"detail,omitempty"`
+
+	// Path of the request which failed
+	Instance string `json:"instance,omitempty"`
+
+	Code ProblemCode `json:"code"`
+
+	// Id of the OpenTelemetry trace of the request, to correlate the problem with the logs and traces of the service
+	TraceId string `json:"traceId,omitempty"`
+
+	// Invalid properties of the request body
+	Errors []ProblemFieldError `json:"errors,omitempty"`
+}
@@ -0,0 +1,48 @@
+/*
+ * Waiting List Api
+ *
+ * Ambulance Waiting List management for Web-In-Cloud system
+ *
+ * API version: 1.0.0
+ * Contact: tomas.bocinec@siemens-healthineers.com
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+
+package ambulance_wl
+
+// ProblemCode : Stable machine-readable code of the problem type
+type ProblemCode string
+
+// List of ProblemCode
+const (
+	INTERNAL_ERROR ProblemCode = "internal-error"
+	INVALID_BODY ProblemCode = "invalid-body"
+	UNSUPPORTED_MEDIA_TYPE ProblemCode = "unsupported-media-type"
+	VALIDATION_FAILED ProblemCode = "validation-failed"
+	INVALID_QUERY ProblemCode = "invalid-query"
+	AMBULANCE_NOT_FOUND ProblemCode = "ambulance-not-found"
+	ENTRY_NOT_FOUND ProblemCode = "entry-not-found"
+	WEBHOOK_NOT_FOUND ProblemCode = "webhook-not-found"
+	DEAD_LETTER_NOT_FOUND ProblemCode = "dead-letter-not-found"
+	API_KEY_NOT_FOUND ProblemCode = "api-key-not-found"
+	AMBULANCE_EXISTS ProblemCode = "ambulance-exists"
+	ENTRY_EXISTS ProblemCode = "entry-exists"
+	WEBHOOK_EXISTS ProblemCode = "webhook-exists"
+	CONCURRENT_MODIFICATION ProblemCode = "concurrent-modification"
+	INVALID_STATE_TRANSITION ProblemCode = "invalid-state-transition"
+	APPOINTMENT_UNAVAILABLE ProblemCode = "appointment-unavailable"
+	API_KEY_REVOKED ProblemCode = "api-key-revoked"
+	PRECONDITION_FAILED ProblemCode = "precondition-failed"
+	PRECONDITION_REQUIRED ProblemCode = "precondition-required"
+	IDEMPOTENCY_KEY_REUSED ProblemCode = "idempotency-key-reused"
+	IDEMPOTENCY_KEY_IN_USE ProblemCode = "idempotency-key-in-use"
+	IDEMPOTENCY_KEY_ANONYMOUS ProblemCode = "idempotency-key-anonymous"
+	DATABASE_ERROR ProblemCode = "database-error"
+	UNAUTHENTICATED ProblemCode = "unauthenticated"
+	INVALID_CREDENTIALS ProblemCode = "invalid-credentials"
+	MALFORMED_CREDENTIALS ProblemCode = "malformed-credentials"
+	FORBIDDEN ProblemCode = "forbidden"
+	AMBULANCE_NOT_ASSIGNED ProblemCode = "ambulance-not-assigned"
+	CREDENTIALS_UNAVAILABLE ProblemCode = "credentials-unavailable"
+)
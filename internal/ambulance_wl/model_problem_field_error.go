@@ -0,0 +1,25 @@
+/*
+ * Waiting List Api
+ *
+ * Ambulance Waiting List management for Web-In-Cloud system
+ *
+ * API version: 1.0.0
+ * Contact: tomas.bocinec@siemens-healthineers.com
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+
+package ambulance_wl
+
+// ProblemFieldError - Invalid property of the request body
+type ProblemFieldError struct {
+
+	// JSON Pointer of the invalid property in the request body
+	Field string `json:"field"`
+
+	// `required` if the property is missing, `invalid` if its value is not acceptable
+	Code string `json:"code"`
+
+	// Description of the violated constraint
+	Message string `json:"message"`
+}
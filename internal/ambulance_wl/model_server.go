@@ -0,0 +1,21 @@
+/*
+ * Waiting List Api
+ *
+ * Ambulance Waiting List management for Web-In-Cloud system
+ *
+ * API version: 1.0.0
+ * Contact: tomas.bocinec@siemens-healthineers.com
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package ambulance_wl
+
+// Server - Doctor or room of the ambulance serving one patient at a time
+type Server struct {
+
+	// Unique identifier of the server within the ambulance
+	Id string `json:"id"`
+
+	// Human readable display name of the server
+	Name string `json:"name,omitempty"`
+}
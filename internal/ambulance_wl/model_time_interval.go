@@ -0,0 +1,21 @@
+/*
+ * Waiting List Api
+ *
+ * Ambulance Waiting List management for Web-In-Cloud system
+ *
+ * API version: 1.0.0
+ * Contact: tomas.bocinec@siemens-healthineers.com
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package ambulance_wl
+
+// TimeInterval - Interval of time within a day, in the time zone of the ambulance
+type TimeInterval struct {
+
+	// Start of the interval in the `HH:MM` format
+	From string `json:"from"`
+
+	// End of the interval in the `HH:MM` format, exclusive. Use `24:00` for the end of the day.
+	To string `json:"to"`
+}
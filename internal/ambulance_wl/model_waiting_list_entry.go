@@ -14,9 +14,10 @@ import (
 	"time"
 )
 
+// WaitingListEntry - Entry of the waiting list. The server generates `id` and `waitingSince` of the new entries if they are not provided, the stored entries always have them.
 type WaitingListEntry struct {
 
-	// Unique id of the entry in this waiting list
+	// Unique id of the entry in this waiting list, generated if not provided or `@new`
 	Id string `json:"id"`
 
 	// Name of patient in waiting list
@@ -25,14 +26,39 @@ type WaitingListEntry struct {
 	// Unique identifier of the patient known to Web-In-Cloud system
 	PatientId string `json:"patientId"`
 
-	// Timestamp since when the patient entered the waiting list
+	// Timestamp since when the patient entered the waiting list, the time the entry was stored if not provided
 	WaitingSince time.Time `json:"waitingSince"`
 
 	// Estimated time of entering ambulance. Ignored on post.
 	EstimatedStart time.Time `json:"estimatedStart,omitempty"`
 
-	// Estimated duration of ambulance visit. If not provided then it will be computed based on condition and ambulance settings
-	EstimatedDurationMinutes int32 `json:"estimatedDurationMinutes"`
+	// Estimated duration of ambulance visit. If not provided then it will be computed based on condition and ambulance settings - the average duration of recently finished visits with the same condition code is used if there are enough of them, otherwise the typical duration of the matching predefined condition, otherwise the ambulance default.
+	EstimatedDurationMinutes int32 `json:"estimatedDurationMinutes,omitempty"`
 
 	Condition Condition `json:"condition,omitempty"`
+
+	// Triage priority of the patient following the Manchester triage scale - 1 immediate, 2 very urgent, 3 urgent, 4 standard, 5 non urgent. Patients with higher priority are served first, patients with the same priority in order of their arrival. Standard priority is assumed if not provided.
+	Priority int32 `json:"priority,omitempty"`
+
+	Type EntryType `json:"type,omitempty"`
+
+	// Start of the booked slot of the `appointment` entry. Ignored for walk-in patients.
+	AppointmentAt time.Time `json:"appointmentAt,omitempty"`
+
+	// Id of the ambulance server (doctor or room) the patient must be served by. The patient is served by the first available server if not provided.
+	ServerId string `json:"serverId,omitempty"`
+
+	State EntryState `json:"state,omitempty"`
+
+	// Timestamp when the patient was called into the ambulance, omitted until the patient is called. Ignored on input.
+	CalledAt *time.Time `json:"calledAt,omitempty"`
+
+	// Timestamp when the ambulance visit of the patient started, omitted until the visit starts. Ignored on input.
+	StartedAt *time.Time `json:"startedAt,omitempty"`
+
+	// Timestamp when the ambulance visit finished or when the called patient was marked as not showing up, omitted until then. Ignored on input.
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+
+	// Revision of the stored entry, incremented on every update. Ignored on input.
+	Version int64 `json:"version,omitempty"`
 }
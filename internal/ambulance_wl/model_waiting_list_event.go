@@ -0,0 +1,31 @@
+/*
+ * Waiting List Api
+ *
+ * Ambulance Waiting List management for Web-In-Cloud system
+ *
+ * API version: 1.0.0
+ * Contact: tomas.bocinec@siemens-healthineers.com
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package ambulance_wl
+
+// WaitingListEvent - Change of the ambulance waiting list
+type WaitingListEvent struct {
+
+	// Id of the event, used to resume the stream
+	Id string `json:"id"`
+
+	Type WaitingListEventType `json:"type"`
+
+	// Id of the ambulance whose waiting list has changed
+	AmbulanceId string `json:"ambulanceId"`
+
+	// Id of the created, updated, or deleted entry
+	EntryId string `json:"entryId,omitempty"`
+
+	Entry WaitingListEntry `json:"entry,omitempty"`
+
+	// Ids of the active entries in the new order of the waiting list
+	Order []string `json:"order,omitempty"`
+}
@@ -0,0 +1,23 @@
+/*
+ * Waiting List Api
+ *
+ * Ambulance Waiting List management for Web-In-Cloud system
+ *
+ * API version: 1.0.0
+ * Contact: tomas.bocinec@siemens-healthineers.com
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package ambulance_wl
+
+// WaitingListEventType : Kind of the waiting list change. `reset` means that the events following the `Last-Event-ID` are no longer available and the waiting list shall be reloaded.
+type WaitingListEventType string
+
+// List of WaitingListEventType
+const (
+	CREATED WaitingListEventType = "created"
+	UPDATED WaitingListEventType = "updated"
+	DELETED WaitingListEventType = "deleted"
+	REORDERED WaitingListEventType = "reordered"
+	RESET WaitingListEventType = "reset"
+)
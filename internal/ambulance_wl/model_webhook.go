@@ -0,0 +1,30 @@
+/*
+ * Waiting List Api
+ *
+ * Ambulance Waiting List management for Web-In-Cloud system
+ *
+ * API version: 1.0.0
+ * Contact: tomas.bocinec@siemens-healthineers.com
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package ambulance_wl
+
+// Webhook - Subscription of the external system to the waiting list events. Events are posted to the URL with headers `X-Webhook-Event` (event type), `X-Webhook-Delivery` (id of the delivery, same for its retries), `X-Webhook-Timestamp` (Unix time of the attempt), and `X-Webhook-Signature` in the form `sha256=<hex>`, which is HMAC-SHA256 of `<timestamp>.<body>` keyed by the secret of the webhook.
+type Webhook struct {
+
+	// Unique identifier of the webhook
+	Id string `json:"id"`
+
+	// Absolute http or https URL the events are posted to
+	Url string `json:"url"`
+
+	// Key of the request signatures, never returned by the API
+	Secret string `json:"secret,omitempty"`
+
+	// Types of the events delivered to the webhook, all events if empty
+	EventTypes []WebhookEventType `json:"eventTypes,omitempty"`
+
+	// Ambulances whose events are delivered to the webhook, all ambulances if empty
+	AmbulanceIds []string `json:"ambulanceIds,omitempty"`
+}
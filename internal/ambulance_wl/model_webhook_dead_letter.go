@@ -0,0 +1,39 @@
+/*
+ * Waiting List Api
+ *
+ * Ambulance Waiting List management for Web-In-Cloud system
+ *
+ * API version: 1.0.0
+ * Contact: tomas.bocinec@siemens-healthineers.com
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package ambulance_wl
+
+import (
+	"time"
+)
+
+// WebhookDeadLetter - Delivery of the event which failed after all retries
+type WebhookDeadLetter struct {
+
+	// Id of the delivery, sent in the `X-Webhook-Delivery` header
+	Id string `json:"id"`
+
+	// Id of the webhook the event was delivered to
+	WebhookId string `json:"webhookId"`
+
+	Event WebhookEvent `json:"event"`
+
+	// Number of the failed delivery attempts
+	Attempts int32 `json:"attempts"`
+
+	// HTTP status of the last attempt, missing if no response was received
+	LastStatus int32 `json:"lastStatus,omitempty"`
+
+	// Reason of the last failure
+	LastError string `json:"lastError,omitempty"`
+
+	// Time of the last failed attempt
+	FailedAt time.Time `json:"failedAt"`
+}
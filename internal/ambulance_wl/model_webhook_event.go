@@ -0,0 +1,32 @@
+/*
+ * Waiting List Api
+ *
+ * Ambulance Waiting List management for Web-In-Cloud system
+ *
+ * API version: 1.0.0
+ * Contact: tomas.bocinec@siemens-healthineers.com
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package ambulance_wl
+
+import (
+	"time"
+)
+
+// WebhookEvent - Waiting list event posted to the webhooks
+type WebhookEvent struct {
+
+	// Unique identifier of the event
+	Id string `json:"id"`
+
+	Type WebhookEventType `json:"type"`
+
+	// Time when the waiting list was changed
+	OccurredAt time.Time `json:"occurredAt"`
+
+	// Id of the ambulance whose waiting list has changed
+	AmbulanceId string `json:"ambulanceId"`
+
+	Entry WaitingListEntry `json:"entry"`
+}
@@ -0,0 +1,21 @@
+/*
+ * Waiting List Api
+ *
+ * Ambulance Waiting List management for Web-In-Cloud system
+ *
+ * API version: 1.0.0
+ * Contact: tomas.bocinec@siemens-healthineers.com
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package ambulance_wl
+
+// WebhookEventType : Kind of the webhook event. Patient is `entry.enqueued` when added to the waiting list or booked for an appointment, `entry.called` into the ambulance, and `entry.removed` when deleted from the waiting list or the appointment is cancelled.
+type WebhookEventType string
+
+// List of WebhookEventType
+const (
+	ENTRY_ENQUEUED WebhookEventType = "entry.enqueued"
+	ENTRY_CALLED WebhookEventType = "entry.called"
+	ENTRY_REMOVED WebhookEventType = "entry.removed"
+)
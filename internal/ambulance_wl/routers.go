@@ -69,6 +69,12 @@ type ApiHandleFunctions struct {
 	AmbulanceWaitingListAPI AmbulanceWaitingListAPI
 	// Routes for the AmbulancesAPI part of the API
 	AmbulancesAPI AmbulancesAPI
+	// Routes for the ApiKeysAPI part of the API
+	ApiKeysAPI ApiKeysAPI
+	// Routes for the AuditAPI part of the API
+	AuditAPI AuditAPI
+	// Routes for the WebhooksAPI part of the API
+	WebhooksAPI WebhooksAPI
 }
 
 func getRoutes(handleFunctions ApiHandleFunctions) []Route {
@@ -79,6 +85,30 @@ func getRoutes(handleFunctions ApiHandleFunctions) []Route {
 			"/api/waiting-list/:ambulanceId/condition",
 			handleFunctions.AmbulanceConditionsAPI.GetConditions,
 		},
+		{
+			"BookAppointment",
+			http.MethodPost,
+			"/api/waiting-list/:ambulanceId/appointments",
+			handleFunctions.AmbulanceWaitingListAPI.BookAppointment,
+		},
+		{
+			"CallNextWaitingListEntry",
+			http.MethodPost,
+			"/api/waiting-list/:ambulanceId/next",
+			handleFunctions.AmbulanceWaitingListAPI.CallNextWaitingListEntry,
+		},
+		{
+			"CallWaitingListEntry",
+			http.MethodPost,
+			"/api/waiting-list/:ambulanceId/entries/:entryId/call",
+			handleFunctions.AmbulanceWaitingListAPI.CallWaitingListEntry,
+		},
+		{
+			"CancelAppointment",
+			http.MethodDelete,
+			"/api/waiting-list/:ambulanceId/appointments/:entryId",
+			handleFunctions.AmbulanceWaitingListAPI.CancelAppointment,
+		},
 		{
 			"CreateWaitingListEntry",
 			http.MethodPost,
@@ -91,6 +121,12 @@ func getRoutes(handleFunctions ApiHandleFunctions) []Route {
 			"/api/waiting-list/:ambulanceId/entries/:entryId",
 			handleFunctions.AmbulanceWaitingListAPI.DeleteWaitingListEntry,
 		},
+		{
+			"FinishWaitingListEntry",
+			http.MethodPost,
+			"/api/waiting-list/:ambulanceId/entries/:entryId/finish",
+			handleFunctions.AmbulanceWaitingListAPI.FinishWaitingListEntry,
+		},
 		{
 			"GetWaitingListEntries",
 			http.MethodGet,
@@ -103,6 +139,24 @@ func getRoutes(handleFunctions ApiHandleFunctions) []Route {
 			"/api/waiting-list/:ambulanceId/entries/:entryId",
 			handleFunctions.AmbulanceWaitingListAPI.GetWaitingListEntry,
 		},
+		{
+			"GetWaitingListEvents",
+			http.MethodGet,
+			"/api/waiting-list/:ambulanceId/events",
+			handleFunctions.AmbulanceWaitingListAPI.GetWaitingListEvents,
+		},
+		{
+			"MarkWaitingListEntryNoShow",
+			http.MethodPost,
+			"/api/waiting-list/:ambulanceId/entries/:entryId/no-show",
+			handleFunctions.AmbulanceWaitingListAPI.MarkWaitingListEntryNoShow,
+		},
+		{
+			"StartWaitingListEntry",
+			http.MethodPost,
+			"/api/waiting-list/:ambulanceId/entries/:entryId/start",
+			handleFunctions.AmbulanceWaitingListAPI.StartWaitingListEntry,
+		},
 		{
 			"UpdateWaitingListEntry",
 			http.MethodPut,
@@ -121,5 +175,113 @@ func getRoutes(handleFunctions ApiHandleFunctions) []Route {
 			"/api/ambulance/:ambulanceId",
 			handleFunctions.AmbulancesAPI.DeleteAmbulance,
 		},
+		{
+			"GetAmbulance",
+			http.MethodGet,
+			"/api/ambulance/:ambulanceId",
+			handleFunctions.AmbulancesAPI.GetAmbulance,
+		},
+		{
+			"GetAmbulances",
+			http.MethodGet,
+			"/api/ambulance",
+			handleFunctions.AmbulancesAPI.GetAmbulances,
+		},
+		{
+			"PatchAmbulance",
+			http.MethodPatch,
+			"/api/ambulance/:ambulanceId",
+			handleFunctions.AmbulancesAPI.PatchAmbulance,
+		},
+		{
+			"UpdateAmbulance",
+			http.MethodPut,
+			"/api/ambulance/:ambulanceId",
+			handleFunctions.AmbulancesAPI.UpdateAmbulance,
+		},
+		{
+			"CreateApiKey",
+			http.MethodPost,
+			"/api/api-keys",
+			handleFunctions.ApiKeysAPI.CreateApiKey,
+		},
+		{
+			"GetApiKey",
+			http.MethodGet,
+			"/api/api-keys/:keyId",
+			handleFunctions.ApiKeysAPI.GetApiKey,
+		},
+		{
+			"GetApiKeys",
+			http.MethodGet,
+			"/api/api-keys",
+			handleFunctions.ApiKeysAPI.GetApiKeys,
+		},
+		{
+			"RevokeApiKey",
+			http.MethodPost,
+			"/api/api-keys/:keyId/revoke",
+			handleFunctions.ApiKeysAPI.RevokeApiKey,
+		},
+		{
+			"RotateApiKey",
+			http.MethodPost,
+			"/api/api-keys/:keyId/rotate",
+			handleFunctions.ApiKeysAPI.RotateApiKey,
+		},
+		{
+			"GetAuditRecords",
+			http.MethodGet,
+			"/api/audit",
+			handleFunctions.AuditAPI.GetAuditRecords,
+		},
+		{
+			"CreateWebhook",
+			http.MethodPost,
+			"/api/webhooks",
+			handleFunctions.WebhooksAPI.CreateWebhook,
+		},
+		{
+			"DeleteWebhook",
+			http.MethodDelete,
+			"/api/webhooks/:webhookId",
+			handleFunctions.WebhooksAPI.DeleteWebhook,
+		},
+		{
+			"DeleteWebhookDeadLetter",
+			http.MethodDelete,
+			"/api/webhooks/:webhookId/dead-letters/:deliveryId",
+			handleFunctions.WebhooksAPI.DeleteWebhookDeadLetter,
+		},
+		{
+			"GetWebhook",
+			http.MethodGet,
+			"/api/webhooks/:webhookId",
+			handleFunctions.WebhooksAPI.GetWebhook,
+		},
+		{
+			"GetWebhookDeadLetters",
+			http.MethodGet,
+			"/api/webhooks/:webhookId/dead-letters",
+			handleFunctions.WebhooksAPI.GetWebhookDeadLetters,
+		},
+		{
+			"GetWebhooks",
+			http.MethodGet,
+			"/api/webhooks",
+			handleFunctions.WebhooksAPI.GetWebhooks,
+		},
+		{
+			"ReplayWebhookDeadLetter",
+			http.MethodPost,
+			"/api/webhooks/:webhookId/dead-letters/:deliveryId/replay",
+			handleFunctions.WebhooksAPI.ReplayWebhookDeadLetter,
+		},
+		{
+			"UpdateWebhook",
+			http.MethodPut,
+			"/api/webhooks/:webhookId",
+			handleFunctions.WebhooksAPI.UpdateWebhook,
+		},
 	}
 }
@@ -0,0 +1,901 @@
+package ambulance_wl
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/suite"
+	"github.com/wac-fiit/cv2-ambulance-webapi/api"
+	"github.com/wac-fiit/cv2-ambulance-webapi/internal/db_service"
+	"github.com/wac-fiit/cv2-ambulance-webapi/internal/outbox"
+)
+
+// RoutersSuite exercises the API end to end with the in-memory storage
+type RoutersSuite struct {
+	suite.Suite
+	router      *gin.Engine
+	stopRelay   context.CancelFunc
+	ambulanceDb db_service.DbService[Ambulance]
+	outboxDb    db_service.DbService[outbox.Message]
+	// modifications without If-Match header are refused
+	requireIfMatch bool
+	// peers allowed to forward the identity of the user
+	trustedProxies []netip.Prefix
+}
+
+func TestRoutersSuite(t *testing.T) {
+	suite.Run(t, new(RoutersSuite))
+}
+
+func (suite *RoutersSuite) SetupTest() {
+	gin.SetMode(gin.TestMode)
+	suite.requireIfMatch = false
+	// requests of the tests come from the address of httptest.NewRequest
+	suite.trustedProxies = []netip.Prefix{netip.MustParsePrefix("192.0.2.1/32")}
+	suite.T().Setenv("AMBULANCE_API_WEBHOOK_ALLOWED_HOSTS", "127.0.0.1")
+	dbService := db_service.NewMemoryService[Ambulance]()
+	suite.ambulanceDb = dbService
+	waitingListDbService := db_service.NewMemoryChildService[WaitingListEntry]()
+	events := NewWaitingListEventBroker()
+	var relayCtx context.Context
+	relayCtx, suite.stopRelay = context.WithCancel(context.Background())
+	go RelayWaitingListChanges(relayCtx, waitingListDbService.(db_service.ChangeFeed[WaitingListEntry]), waitingListDbService, events)
+	webhooksDbService := db_service.NewMemoryService[Webhook]()
+	deadLettersDbService := db_service.NewMemoryService[WebhookDeadLetter]()
+	dispatcher := NewWebhookDispatcher(webhooksDbService, deadLettersDbService, db_service.NewMemoryService[WebhookDelivery]())
+	dispatcher.pollInterval = 10 * time.Millisecond
+	go dispatcher.Run(relayCtx)
+	outboxDbService := db_service.NewMemoryService[outbox.Message]()
+	suite.outboxDb = outboxDbService
+	go outbox.NewRelay(outboxDbService, dispatcher, 10*time.Millisecond).Run(relayCtx)
+	auditDbService := db_service.NewMemoryService[AuditRecord]()
+	idempotencyKeys, err := NewIdempotencyKeys(db_service.NewMemoryService[StoredIdempotencyKey](), IdempotencyKeysConfig{})
+	suite.Require().NoError(err)
+	validator, err := NewOpenApiValidator(api.Spec(), OpenApiValidatorConfig{Responses: ResponseValidationFail})
+	suite.Require().NoError(err)
+	engine := gin.New()
+	engine.Use(func(ctx *gin.Context) {
+		ctx.Set("db_service", dbService)
+		ctx.Set("db_service_waiting_list", waitingListDbService)
+		ctx.Set("waiting_list_events", events)
+		ctx.Set("db_service_webhooks", webhooksDbService)
+		ctx.Set("db_service_webhook_dead_letters", deadLettersDbService)
+		ctx.Set("webhook_dispatcher", dispatcher)
+		ctx.Set("db_service_outbox", outboxDbService)
+		ctx.Set("db_service_audit", auditDbService)
+		ctx.Set("require_if_match", suite.requireIfMatch)
+		ctx.Set("trusted_proxies", suite.trustedProxies)
+		ctx.Set("idempotency_keys", idempotencyKeys)
+		ctx.Next()
+	})
+	engine.Use(validator.Middleware())
+	suite.router = NewRouterWithGinEngine(engine, ApiHandleFunctions{
+		AmbulanceConditionsAPI:  NewAmbulanceConditionsApi(),
+		AmbulanceWaitingListAPI: NewAmbulanceWaitingListApi(),
+		AmbulancesAPI:           NewAmbulancesApi(),
+		ApiKeysAPI:              NewApiKeysApi(),
+		AuditAPI:                NewAuditApi(),
+		WebhooksAPI:             NewWebhooksApi(),
+	})
+}
+
+func (suite *RoutersSuite) TearDownTest() {
+	suite.stopRelay()
+}
+
+func (suite *RoutersSuite) request(method string, path string, body string) *httptest.ResponseRecorder {
+	return suite.requestAs("", method, path, body)
+}
+
+// requestWithHeader sends the request with the header, e.g. the entity tag or the idempotency key
+func (suite *RoutersSuite) requestWithHeader(header string, value string, method string, path string, body string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(method, path, strings.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(header, value)
+	suite.router.ServeHTTP(recorder, request)
+	return recorder
+}
+
+// requestWithKey sends the request with the idempotency key on behalf of the
+// user authenticated by the proxy, keys of the anonymous clients are refused
+func (suite *RoutersSuite) requestWithKey(key string, method string, path string, body string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(method, path, strings.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Idempotency-Key", key)
+	request.Header.Set("X-Forwarded-User", "nurse")
+	suite.router.ServeHTTP(recorder, request)
+	return recorder
+}
+
+// requestAs sends the request on behalf of the user authenticated by the proxy
+func (suite *RoutersSuite) requestAs(actor string, method string, path string, body string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(method, path, strings.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	if actor != "" {
+		request.Header.Set("X-Forwarded-User", actor)
+	}
+	suite.router.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func (suite *RoutersSuite) Test_AmbulanceAndWaitingListLifecycle() {
+	// ARRANGE
+	created := suite.request(http.MethodPost, "/api/ambulance",
+		`{ "id": "test-ambulance", "name": "Test Ambulance", "roomNumber": "101" }`)
+	suite.Require().Equal(http.StatusCreated, created.Code)
+
+	// ACT
+	entry := suite.request(http.MethodPost, "/api/waiting-list/test-ambulance/entries",
+		`{ "patientId": "test-patient", "waitingSince": "2038-12-24T10:05:00Z", "estimatedDurationMinutes": 15 }`)
+	duplicate := suite.request(http.MethodPost, "/api/waiting-list/test-ambulance/entries",
+		`{ "patientId": "test-patient", "waitingSince": "2038-12-24T10:05:00Z", "estimatedDurationMinutes": 15 }`)
+	entries := suite.request(http.MethodGet, "/api/waiting-list/test-ambulance/entries", "")
+	list := suite.request(http.MethodGet, "/api/ambulance?search=test", "")
+	deleted := suite.request(http.MethodDelete, "/api/ambulance/test-ambulance", "")
+	missing := suite.request(http.MethodGet, "/api/ambulance/test-ambulance", "")
+
+	// ASSERT
+	suite.Equal(http.StatusOK, entry.Code)
+	suite.Equal(http.StatusConflict, duplicate.Code)
+
+	suite.Equal(http.StatusOK, entries.Code)
+	var waitingList []WaitingListEntry
+	suite.NoError(json.Unmarshal(entries.Body.Bytes(), &waitingList))
+	suite.Require().Len(waitingList, 1)
+	suite.Equal("test-patient", waitingList[0].PatientId)
+	suite.NotEmpty(waitingList[0].Id)
+
+	suite.Equal(http.StatusOK, list.Code)
+	var ambulances AmbulanceList
+	suite.NoError(json.Unmarshal(list.Body.Bytes(), &ambulances))
+	suite.Equal([]AmbulanceSummary{{Id: "test-ambulance", Name: "Test Ambulance", RoomNumber: "101"}}, ambulances.Items)
+
+	suite.Equal(http.StatusNoContent, deleted.Code)
+	suite.Equal(http.StatusNotFound, missing.Code)
+}
+
+func (suite *RoutersSuite) Test_CreateAmbulance_AdmitsInitialWaitingList() {
+	// ACT
+	created := suite.request(http.MethodPost, "/api/ambulance",
+		`{ "id": "test-ambulance", "name": "Test Ambulance", "roomNumber": "101", "waitingList": [
+			{ "patientId": "test-patient", "state": "finished", "finishedAt": "2038-12-24T10:05:00Z", "type": "appointment" }
+		] }`)
+	invalid := suite.request(http.MethodPost, "/api/ambulance",
+		`{ "id": "other-ambulance", "name": "Other Ambulance", "roomNumber": "102", "waitingList": [
+			{ "patientId": "test-patient" },
+			{ "patientId": "other-patient", "serverId": "missing-server" }
+		] }`)
+	duplicate := suite.request(http.MethodPost, "/api/ambulance",
+		`{ "id": "other-ambulance", "name": "Other Ambulance", "roomNumber": "102", "waitingList": [
+			{ "patientId": "test-patient" },
+			{ "patientId": "test-patient" }
+		] }`)
+	entries := suite.request(http.MethodGet, "/api/waiting-list/test-ambulance/entries", "")
+
+	// ASSERT
+	suite.Equal(http.StatusCreated, created.Code)
+	suite.Equal(http.StatusOK, entries.Code)
+	var waitingList []WaitingListEntry
+	suite.NoError(json.Unmarshal(entries.Body.Bytes(), &waitingList))
+	suite.Require().Len(waitingList, 1)
+	suite.Equal(WAITING, waitingList[0].State)
+	suite.Equal(WALK_IN, waitingList[0].Type)
+	suite.Nil(waitingList[0].FinishedAt)
+	suite.NotEmpty(waitingList[0].Id)
+	suite.Equal(int32(defaultEntryPriority), waitingList[0].Priority)
+	suite.Equal(int32(defaultDurationMinutes), waitingList[0].EstimatedDurationMinutes)
+
+	suite.Equal(http.StatusBadRequest, invalid.Code)
+	var problem Problem
+	suite.Require().NoError(json.Unmarshal(invalid.Body.Bytes(), &problem))
+	suite.Equal([]ProblemFieldError{
+		{Field: "/waitingList/1/serverId", Code: "invalid", Message: "server not found in the ambulance"},
+	}, problem.Errors)
+	suite.Equal(http.StatusConflict, duplicate.Code)
+	suite.Equal(http.StatusNotFound, suite.request(http.MethodGet, "/api/ambulance/other-ambulance", "").Code)
+}
+
+func (suite *RoutersSuite) Test_Errors_ReportedAsProblemDetails() {
+	// ACT
+	invalid := suite.request(http.MethodPost, "/api/ambulance", `{ "name": "Test Ambulance" }`)
+	missing := suite.request(http.MethodGet, "/api/waiting-list/missing-ambulance/entries", "")
+
+	// ASSERT
+	suite.Equal(http.StatusBadRequest, invalid.Code)
+	suite.Equal(problemContentType, invalid.Header().Get("Content-Type"))
+	var problem Problem
+	suite.Require().NoError(json.Unmarshal(invalid.Body.Bytes(), &problem))
+	suite.Equal(VALIDATION_FAILED, problem.Code)
+	suite.Equal("urn:ambulance-wl:problem:validation-failed", problem.Type)
+	suite.Equal(int32(http.StatusBadRequest), problem.Status)
+	suite.Equal("/api/ambulance", problem.Instance)
+	suite.Equal([]ProblemFieldError{
+		{Field: "/roomNumber", Code: "required", Message: "missing required property"},
+	}, problem.Errors)
+
+	suite.Equal(http.StatusNotFound, missing.Code)
+	suite.Equal(problemContentType, missing.Header().Get("Content-Type"))
+	problem = Problem{}
+	suite.Require().NoError(json.Unmarshal(missing.Body.Bytes(), &problem))
+	suite.Equal(AMBULANCE_NOT_FOUND, problem.Code)
+	suite.Equal("Ambulance not found", problem.Title)
+	suite.Equal("/api/waiting-list/missing-ambulance/entries", problem.Instance)
+}
+
+func (suite *RoutersSuite) Test_ConditionalRequests_HonorEntityTags() {
+	// ARRANGE
+	suite.Require().Equal(http.StatusCreated, suite.request(http.MethodPost, "/api/ambulance",
+		`{ "id": "test-ambulance", "name": "Test Ambulance", "roomNumber": "101" }`).Code)
+	suite.Require().Equal(http.StatusOK, suite.request(http.MethodPost, "/api/waiting-list/test-ambulance/entries",
+		`{ "id": "test-entry", "patientId": "test-patient", "waitingSince": "2038-12-24T10:05:00Z", "priority": 3 }`).Code)
+	retrieved := suite.request(http.MethodGet, "/api/waiting-list/test-ambulance/entries/test-entry", "")
+	suite.Require().Equal(http.StatusOK, retrieved.Code)
+	etag := retrieved.Header().Get("ETag")
+	suite.Require().NotEmpty(etag)
+
+	// ACT
+	notModified := suite.requestWithHeader("If-None-Match", etag, http.MethodGet, "/api/waiting-list/test-ambulance/entries/test-entry", "")
+	updated := suite.requestWithHeader("If-Match", etag, http.MethodPut, "/api/waiting-list/test-ambulance/entries/test-entry",
+		`{ "id": "test-entry", "patientId": "test-patient", "waitingSince": "2038-12-24T10:05:00Z", "priority": 1 }`)
+	stale := suite.requestWithHeader("If-Match", etag, http.MethodPut, "/api/waiting-list/test-ambulance/entries/test-entry",
+		`{ "id": "test-entry", "patientId": "test-patient", "waitingSince": "2038-12-24T10:05:00Z", "priority": 5 }`)
+	modified := suite.requestWithHeader("If-None-Match", etag, http.MethodGet, "/api/waiting-list/test-ambulance/entries/test-entry", "")
+	staleDelete := suite.requestWithHeader("If-Match", etag, http.MethodDelete, "/api/waiting-list/test-ambulance/entries/test-entry", "")
+	deleted := suite.requestWithHeader("If-Match", updated.Header().Get("ETag"), http.MethodDelete, "/api/waiting-list/test-ambulance/entries/test-entry", "")
+
+	// ASSERT
+	suite.Equal(http.StatusNotModified, notModified.Code)
+	suite.Empty(notModified.Body.String())
+	suite.Equal(etag, notModified.Header().Get("ETag"))
+
+	suite.Equal(http.StatusOK, updated.Code)
+	suite.NotEqual(etag, updated.Header().Get("ETag"))
+	suite.Equal(updated.Header().Get("ETag"), modified.Header().Get("ETag"))
+	suite.Equal(http.StatusOK, modified.Code)
+
+	suite.Equal(http.StatusPreconditionFailed, stale.Code)
+	var problem Problem
+	suite.NoError(json.Unmarshal(stale.Body.Bytes(), &problem))
+	suite.Equal(PRECONDITION_FAILED, problem.Code)
+	suite.Equal(http.StatusPreconditionFailed, staleDelete.Code)
+	suite.Equal(http.StatusNoContent, deleted.Code)
+}
+
+func (suite *RoutersSuite) Test_ConditionalRequests_IfMatchRequired() {
+	// ARRANGE
+	suite.requireIfMatch = true
+	suite.Require().Equal(http.StatusCreated, suite.request(http.MethodPost, "/api/ambulance",
+		`{ "id": "test-ambulance", "name": "Test Ambulance", "roomNumber": "101" }`).Code)
+	retrieved := suite.request(http.MethodGet, "/api/ambulance/test-ambulance", "")
+	suite.Require().Equal(http.StatusOK, retrieved.Code)
+
+	// ACT
+	unconditional := suite.request(http.MethodDelete, "/api/ambulance/test-ambulance", "")
+	stale := suite.requestWithHeader("If-Match", `"stale"`, http.MethodDelete, "/api/ambulance/test-ambulance", "")
+	deleted := suite.requestWithHeader("If-Match", retrieved.Header().Get("ETag"), http.MethodDelete, "/api/ambulance/test-ambulance", "")
+
+	// ASSERT
+	suite.Equal(http.StatusPreconditionRequired, unconditional.Code)
+	var problem Problem
+	suite.NoError(json.Unmarshal(unconditional.Body.Bytes(), &problem))
+	suite.Equal(PRECONDITION_REQUIRED, problem.Code)
+	suite.Equal(http.StatusPreconditionFailed, stale.Code)
+	suite.Equal(http.StatusNoContent, deleted.Code)
+}
+
+func (suite *RoutersSuite) Test_IdempotencyKey_ReplaysResponse() {
+	// ARRANGE
+	created := suite.requestWithKey("ambulance-key", http.MethodPost, "/api/ambulance",
+		`{ "id": "test-ambulance", "name": "Test Ambulance", "roomNumber": "101" }`)
+	suite.Require().Equal(http.StatusCreated, created.Code)
+	entry := `{ "patientId": "test-patient", "waitingSince": "2038-12-24T10:05:00Z", "priority": 3 }`
+
+	// ACT
+	first := suite.requestWithKey("entry-key", http.MethodPost, "/api/waiting-list/test-ambulance/entries", entry)
+	retried := suite.requestWithKey("entry-key", http.MethodPost, "/api/waiting-list/test-ambulance/entries", entry)
+	reused := suite.requestWithKey("entry-key", http.MethodPost, "/api/waiting-list/test-ambulance/entries",
+		`{ "patientId": "other-patient", "waitingSince": "2038-12-24T10:05:00Z", "priority": 3 }`)
+	duplicate := suite.requestWithKey("other-key", http.MethodPost, "/api/waiting-list/test-ambulance/entries", entry)
+	retriedAmbulance := suite.requestWithKey("ambulance-key", http.MethodPost, "/api/ambulance",
+		`{ "id": "test-ambulance", "name": "Test Ambulance", "roomNumber": "101" }`)
+	anonymous := suite.requestWithHeader("Idempotency-Key", "anonymous-key", http.MethodPost, "/api/waiting-list/test-ambulance/entries", entry)
+	entries := suite.request(http.MethodGet, "/api/waiting-list/test-ambulance/entries", "")
+
+	// ASSERT
+	suite.Equal(http.StatusOK, first.Code)
+	suite.Empty(first.Header().Get("Idempotent-Replayed"))
+	suite.Equal(http.StatusOK, retried.Code)
+	suite.Equal("true", retried.Header().Get("Idempotent-Replayed"))
+	suite.Equal(first.Header().Get("Content-Type"), retried.Header().Get("Content-Type"))
+	suite.JSONEq(first.Body.String(), retried.Body.String())
+
+	suite.Equal(http.StatusUnprocessableEntity, reused.Code)
+	var problem Problem
+	suite.NoError(json.Unmarshal(reused.Body.Bytes(), &problem))
+	suite.Equal(IDEMPOTENCY_KEY_REUSED, problem.Code)
+	suite.Equal(http.StatusConflict, duplicate.Code)
+
+	suite.Equal(http.StatusCreated, retriedAmbulance.Code)
+	suite.Equal("true", retriedAmbulance.Header().Get("Idempotent-Replayed"))
+	suite.JSONEq(created.Body.String(), retriedAmbulance.Body.String())
+
+	suite.Equal(http.StatusBadRequest, anonymous.Code)
+	suite.Contains(anonymous.Body.String(), string(IDEMPOTENCY_KEY_ANONYMOUS))
+
+	var waitingList []WaitingListEntry
+	suite.NoError(json.Unmarshal(entries.Body.Bytes(), &waitingList))
+	suite.Len(waitingList, 1)
+}
+
+func (suite *RoutersSuite) Test_WaitingListEntryLifecycle() {
+	// ARRANGE
+	suite.Require().Equal(http.StatusCreated, suite.request(http.MethodPost, "/api/ambulance",
+		`{ "id": "test-ambulance", "name": "Test Ambulance", "roomNumber": "101" }`).Code)
+	suite.Require().Equal(http.StatusOK, suite.request(http.MethodPost, "/api/waiting-list/test-ambulance/entries",
+		`{ "id": "test-entry", "patientId": "test-patient", "waitingSince": "2038-12-24T10:05:00Z", "estimatedDurationMinutes": 15 }`).Code)
+
+	// ACT
+	invalid := suite.request(http.MethodPost, "/api/waiting-list/test-ambulance/entries/test-entry/finish", "")
+	called := suite.request(http.MethodPost, "/api/waiting-list/test-ambulance/entries/test-entry/call", "")
+	started := suite.request(http.MethodPost, "/api/waiting-list/test-ambulance/entries/test-entry/start", "")
+	finished := suite.request(http.MethodPost, "/api/waiting-list/test-ambulance/entries/test-entry/finish", "")
+	active := suite.request(http.MethodGet, "/api/waiting-list/test-ambulance/entries", "")
+	history := suite.request(http.MethodGet, "/api/waiting-list/test-ambulance/entries?state=finished", "")
+	returning := suite.request(http.MethodPost, "/api/waiting-list/test-ambulance/entries",
+		`{ "patientId": "test-patient", "waitingSince": "2038-12-24T11:05:00Z", "estimatedDurationMinutes": 15 }`)
+
+	// ASSERT
+	suite.Equal(http.StatusConflict, invalid.Code)
+	suite.Equal(http.StatusOK, called.Code)
+	suite.Equal(http.StatusOK, started.Code)
+	suite.Equal(http.StatusOK, finished.Code)
+
+	var entry WaitingListEntry
+	suite.NoError(json.Unmarshal(finished.Body.Bytes(), &entry))
+	suite.Equal(FINISHED, entry.State)
+	suite.NotNil(entry.CalledAt)
+	suite.Require().NotNil(entry.StartedAt)
+	suite.Require().NotNil(entry.FinishedAt)
+	suite.False(entry.StartedAt.After(*entry.FinishedAt))
+	suite.Equal(*entry.StartedAt, entry.EstimatedStart)
+
+	var waitingList []WaitingListEntry
+	suite.NoError(json.Unmarshal(active.Body.Bytes(), &waitingList))
+	suite.Empty(waitingList)
+	suite.NoError(json.Unmarshal(history.Body.Bytes(), &waitingList))
+	suite.Len(waitingList, 1)
+
+	suite.Equal(http.StatusOK, returning.Code)
+}
+
+func (suite *RoutersSuite) Test_CallNextWaitingListEntry() {
+	// ARRANGE
+	suite.Require().Equal(http.StatusCreated, suite.request(http.MethodPost, "/api/ambulance",
+		`{ "id": "test-ambulance", "name": "Test Ambulance", "roomNumber": "101" }`).Code)
+	suite.Require().Equal(http.StatusOK, suite.request(http.MethodPost, "/api/waiting-list/test-ambulance/entries",
+		`{ "id": "routine", "patientId": "routine-patient", "waitingSince": "2038-12-24T10:05:00Z", "estimatedDurationMinutes": 15 }`).Code)
+	suite.Require().Equal(http.StatusOK, suite.request(http.MethodPost, "/api/waiting-list/test-ambulance/entries",
+		`{ "id": "acute", "patientId": "acute-patient", "waitingSince": "2038-12-24T10:15:00Z", "estimatedDurationMinutes": 15, "priority": 2 }`).Code)
+
+	// ACT
+	first := suite.request(http.MethodPost, "/api/waiting-list/test-ambulance/next", "")
+	second := suite.request(http.MethodPost, "/api/waiting-list/test-ambulance/next", "")
+	empty := suite.request(http.MethodPost, "/api/waiting-list/test-ambulance/next", "")
+
+	// ASSERT
+	var entry WaitingListEntry
+	suite.Require().Equal(http.StatusOK, first.Code)
+	suite.NoError(json.Unmarshal(first.Body.Bytes(), &entry))
+	suite.Equal("acute", entry.Id)
+	suite.Equal(CALLED, entry.State)
+	suite.NotNil(entry.CalledAt)
+	suite.Nil(entry.StartedAt)
+
+	suite.Require().Equal(http.StatusOK, second.Code)
+	suite.NoError(json.Unmarshal(second.Body.Bytes(), &entry))
+	suite.Equal("routine", entry.Id)
+
+	suite.Equal(http.StatusNoContent, empty.Code)
+	suite.Empty(empty.Body.String())
+}
+
+func (suite *RoutersSuite) Test_CreateWaitingListEntry_EstimatesDurationFromCondition() {
+	// ARRANGE
+	suite.Require().Equal(http.StatusCreated, suite.request(http.MethodPost, "/api/ambulance",
+		`{ "id": "test-ambulance", "name": "Test Ambulance", "roomNumber": "101",
+		   "predefinedConditions": [ { "value": "Nevoľnosť", "code": "nausea", "typicalDurationMinutes": 45 } ] }`).Code)
+
+	// ACT
+	created := suite.request(http.MethodPost, "/api/waiting-list/test-ambulance/entries",
+		`{ "patientId": "test-patient", "waitingSince": "2038-12-24T10:05:00Z", "condition": { "value": "Nevoľnosť", "code": "nausea" } }`)
+
+	// ASSERT
+	suite.Require().Equal(http.StatusOK, created.Code)
+	var entry WaitingListEntry
+	suite.NoError(json.Unmarshal(created.Body.Bytes(), &entry))
+	suite.Equal(int32(45), entry.EstimatedDurationMinutes)
+}
+
+func (suite *RoutersSuite) Test_FinishedEntries_KeptOutOfWaitingList() {
+	// ARRANGE
+	suite.Require().Equal(http.StatusCreated, suite.request(http.MethodPost, "/api/ambulance",
+		`{ "id": "test-ambulance", "name": "Test Ambulance", "roomNumber": "101",
+		   "predefinedConditions": [ { "value": "Nevoľnosť", "code": "nausea", "typicalDurationMinutes": 45 } ] }`).Code)
+	for _, id := range []string{"first", "second", "third"} {
+		suite.Require().Equal(http.StatusOK, suite.request(http.MethodPost, "/api/waiting-list/test-ambulance/entries",
+			`{ "id": "`+id+`", "patientId": "`+id+`-patient", "condition": { "value": "Nevoľnosť", "code": "nausea" } }`).Code)
+		for _, transition := range []string{"call", "start", "finish"} {
+			// stored timestamps have millisecond precision, the visit must last
+			time.Sleep(2 * time.Millisecond)
+			suite.Require().Equal(http.StatusOK, suite.request(http.MethodPost,
+				"/api/waiting-list/test-ambulance/entries/"+id+"/"+transition, "").Code)
+		}
+	}
+
+	// ACT
+	created := suite.request(http.MethodPost, "/api/waiting-list/test-ambulance/entries",
+		`{ "id": "waiting", "patientId": "waiting-patient", "condition": { "value": "Nevoľnosť", "code": "nausea" } }`)
+	ambulance := suite.request(http.MethodGet, "/api/ambulance/test-ambulance", "")
+	finished := suite.request(http.MethodGet, "/api/waiting-list/test-ambulance/entries/first", "")
+	refinished := suite.request(http.MethodPost, "/api/waiting-list/test-ambulance/entries/first/finish", "")
+	history := suite.request(http.MethodGet, "/api/waiting-list/test-ambulance/entries?state=finished&state=waiting", "")
+
+	// ASSERT
+	suite.Require().Equal(http.StatusOK, created.Code)
+	var entry WaitingListEntry
+	suite.NoError(json.Unmarshal(created.Body.Bytes(), &entry))
+	// learned from the finished visits, which took less than a minute
+	suite.Equal(int32(1), entry.EstimatedDurationMinutes)
+
+	suite.Equal(http.StatusOK, ambulance.Code)
+	var loaded Ambulance
+	suite.NoError(json.Unmarshal(ambulance.Body.Bytes(), &loaded))
+	suite.Equal([]string{"waiting"}, entryIds(loaded.WaitingList))
+
+	suite.Equal(http.StatusOK, finished.Code)
+	suite.NoError(json.Unmarshal(finished.Body.Bytes(), &entry))
+	suite.Equal(FINISHED, entry.State)
+	suite.Equal(http.StatusConflict, refinished.Code)
+
+	suite.Equal(http.StatusOK, history.Code)
+	var waitingList []WaitingListEntry
+	suite.NoError(json.Unmarshal(history.Body.Bytes(), &waitingList))
+	suite.Equal([]string{"waiting", "first", "second", "third"}, entryIds(waitingList))
+}
+
+// entryIds returns ids of the entries in their order
+func entryIds(entries []WaitingListEntry) []string {
+	ids := []string{}
+	for _, entry := range entries {
+		ids = append(ids, entry.Id)
+	}
+	return ids
+}
+
+func (suite *RoutersSuite) Test_CallNextWaitingListEntry_RespectsServer() {
+	// ARRANGE
+	suite.Require().Equal(http.StatusCreated, suite.request(http.MethodPost, "/api/ambulance",
+		`{ "id": "test-ambulance", "name": "Test Ambulance", "roomNumber": "101",
+		   "servers": [ { "id": "doctor" }, { "id": "nurse" } ] }`).Code)
+	suite.Require().Equal(http.StatusOK, suite.request(http.MethodPost, "/api/waiting-list/test-ambulance/entries",
+		`{ "id": "doctor-only", "patientId": "first-patient", "waitingSince": "2038-12-24T10:05:00Z", "serverId": "doctor" }`).Code)
+	suite.Require().Equal(http.StatusOK, suite.request(http.MethodPost, "/api/waiting-list/test-ambulance/entries",
+		`{ "id": "anyone", "patientId": "second-patient", "waitingSince": "2038-12-24T10:15:00Z" }`).Code)
+
+	// ACT
+	unknownServer := suite.request(http.MethodPost, "/api/waiting-list/test-ambulance/entries",
+		`{ "patientId": "third-patient", "waitingSince": "2038-12-24T10:25:00Z", "serverId": "dentist" }`)
+	nurse := suite.request(http.MethodPost, "/api/waiting-list/test-ambulance/next?serverId=nurse", "")
+	nurseAgain := suite.request(http.MethodPost, "/api/waiting-list/test-ambulance/next?serverId=nurse", "")
+
+	// ASSERT
+	suite.Equal(http.StatusBadRequest, unknownServer.Code)
+
+	var entry WaitingListEntry
+	suite.Require().Equal(http.StatusOK, nurse.Code)
+	suite.NoError(json.Unmarshal(nurse.Body.Bytes(), &entry))
+	suite.Equal("anyone", entry.Id)
+	suite.Equal("nurse", entry.ServerId)
+
+	suite.Equal(http.StatusNoContent, nurseAgain.Code)
+}
+
+func (suite *RoutersSuite) Test_BookAndCancelAppointment() {
+	// ARRANGE
+	suite.Require().Equal(http.StatusCreated, suite.request(http.MethodPost, "/api/ambulance",
+		`{ "id": "test-ambulance", "name": "Test Ambulance", "roomNumber": "101" }`).Code)
+
+	// ACT
+	booked := suite.request(http.MethodPost, "/api/waiting-list/test-ambulance/appointments",
+		`{ "id": "booked", "patientId": "first-patient", "appointmentAt": "2038-12-24T13:00:00Z", "estimatedDurationMinutes": 30 }`)
+	overlapping := suite.request(http.MethodPost, "/api/waiting-list/test-ambulance/appointments",
+		`{ "patientId": "second-patient", "appointmentAt": "2038-12-24T13:15:00Z", "estimatedDurationMinutes": 30 }`)
+	past := suite.request(http.MethodPost, "/api/waiting-list/test-ambulance/appointments",
+		`{ "patientId": "second-patient", "appointmentAt": "2000-12-24T13:15:00Z" }`)
+	walkIn := suite.request(http.MethodPost, "/api/waiting-list/test-ambulance/entries",
+		`{ "patientId": "first-patient", "waitingSince": "2038-12-24T10:05:00Z", "estimatedDurationMinutes": 15 }`)
+	notCalled := suite.request(http.MethodPost, "/api/waiting-list/test-ambulance/next", "")
+	cancelled := suite.request(http.MethodDelete, "/api/waiting-list/test-ambulance/appointments/booked", "")
+	cancelledAgain := suite.request(http.MethodDelete, "/api/waiting-list/test-ambulance/appointments/booked", "")
+	rebooked := suite.request(http.MethodPost, "/api/waiting-list/test-ambulance/appointments",
+		`{ "patientId": "second-patient", "appointmentAt": "2038-12-24T13:15:00Z", "estimatedDurationMinutes": 30 }`)
+
+	// ASSERT
+	suite.Require().Equal(http.StatusOK, booked.Code)
+	var entry WaitingListEntry
+	suite.NoError(json.Unmarshal(booked.Body.Bytes(), &entry))
+	suite.Equal(APPOINTMENT, entry.Type)
+	suite.Equal(entry.AppointmentAt, entry.EstimatedStart)
+
+	suite.Equal(http.StatusConflict, overlapping.Code)
+	suite.Equal(http.StatusBadRequest, past.Code)
+	// patient with appointment may still come as walk-in
+	suite.Equal(http.StatusOK, walkIn.Code)
+	suite.NoError(json.Unmarshal(notCalled.Body.Bytes(), &entry))
+	suite.Equal(WALK_IN, entry.Type)
+	suite.Equal(http.StatusNoContent, cancelled.Code)
+	suite.Equal(http.StatusNotFound, cancelledAgain.Code)
+	suite.Equal(http.StatusOK, rebooked.Code)
+}
+
+func (suite *RoutersSuite) Test_BookAppointment_RefusedForInvalidOpeningHours() {
+	// ARRANGE
+	suite.Require().Equal(http.StatusCreated, suite.request(http.MethodPost, "/api/ambulance",
+		`{ "id": "test-ambulance", "name": "Test Ambulance", "roomNumber": "101" }`).Code)
+	// stored by an older version of the service which did not validate the opening hours
+	ambulance, err := suite.ambulanceDb.FindDocument(context.Background(), "test-ambulance")
+	suite.Require().NoError(err)
+	ambulance.TimeZone = "Mars/Olympus_Mons"
+	ambulance.OpeningHours = []OpeningHours{{DayOfWeek: MONDAY, From: "08:00", To: "12:00"}}
+	suite.Require().NoError(suite.ambulanceDb.UpdateDocument(context.Background(), ambulance.Id, ambulance))
+
+	// ACT
+	booked := suite.request(http.MethodPost, "/api/waiting-list/test-ambulance/appointments",
+		`{ "patientId": "test-patient", "appointmentAt": "2038-12-24T13:00:00Z", "estimatedDurationMinutes": 30 }`)
+
+	// ASSERT
+	suite.Equal(http.StatusInternalServerError, booked.Code)
+	entries := suite.request(http.MethodGet, "/api/waiting-list/test-ambulance/entries", "")
+	suite.JSONEq(`[]`, entries.Body.String())
+}
+
+func (suite *RoutersSuite) Test_GetWaitingListEvents_StreamsChanges() {
+	// ARRANGE
+	suite.Require().Equal(http.StatusCreated, suite.request(http.MethodPost, "/api/ambulance",
+		`{ "id": "test-ambulance", "name": "Test Ambulance", "roomNumber": "101" }`).Code)
+	server := httptest.NewServer(suite.router)
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	request, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/api/waiting-list/test-ambulance/events", nil)
+
+	// ACT
+	response, err := http.DefaultClient.Do(request)
+	suite.Require().NoError(err)
+	defer response.Body.Close()
+	created := suite.request(http.MethodPost, "/api/waiting-list/test-ambulance/entries",
+		`{ "id": "test-entry", "patientId": "test-patient", "waitingSince": "2038-12-24T10:05:00Z" }`)
+	missing := suite.request(http.MethodGet, "/api/waiting-list/missing-ambulance/events", "")
+
+	// ASSERT
+	suite.Require().Equal(http.StatusOK, created.Code)
+	suite.Equal(http.StatusNotFound, missing.Code)
+	suite.Require().Equal(http.StatusOK, response.StatusCode)
+	suite.Equal("text/event-stream", response.Header.Get("Content-Type"))
+
+	eventTypes := []string{}
+	var event WaitingListEvent
+	scanner := bufio.NewScanner(response.Body)
+	for len(eventTypes) < 2 && scanner.Scan() {
+		line := scanner.Text()
+		if eventType, found := strings.CutPrefix(line, "event: "); found {
+			eventTypes = append(eventTypes, eventType)
+		}
+		if data, found := strings.CutPrefix(line, "data: "); found && event.Id == "" {
+			suite.NoError(json.Unmarshal([]byte(data), &event))
+		}
+	}
+	suite.Equal([]string{"created", "reordered"}, eventTypes)
+	suite.Equal("test-entry", event.EntryId)
+	suite.Equal("test-patient", event.Entry.PatientId)
+	suite.Equal(int64(1), event.Entry.Version)
+}
+
+func (suite *RoutersSuite) Test_NurseConsole_RejectsForeignOrigins() {
+	// ARRANGE
+	suite.router.GET("/api/nurse-console", NewNurseConsoleHandler(suite.router, []string{"https://wac.example.com"}))
+	server := httptest.NewServer(suite.router)
+	defer server.Close()
+	dial := func(origin string) (*http.Response, error) {
+		conn, response, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/api/nurse-console",
+			http.Header{"Origin": []string{origin}})
+		if err == nil {
+			conn.Close()
+		}
+		return response, err
+	}
+
+	// ACT
+	foreign, foreignErr := dial("https://attacker.example.com")
+	_, allowedErr := dial("https://wac.example.com")
+	_, sameOriginErr := dial(server.URL)
+
+	// ASSERT
+	suite.Error(foreignErr)
+	suite.Require().NotNil(foreign)
+	suite.Equal(http.StatusForbidden, foreign.StatusCode)
+	suite.NoError(allowedErr)
+	suite.NoError(sameOriginErr)
+}
+
+func (suite *RoutersSuite) Test_NurseConsole_SubscribesAndDispatchesCommands() {
+	// ARRANGE
+	suite.router.GET("/api/nurse-console", NewNurseConsoleHandler(suite.router, nil))
+	suite.Require().Equal(http.StatusCreated, suite.request(http.MethodPost, "/api/ambulance",
+		`{ "id": "test-ambulance", "name": "Test Ambulance", "roomNumber": "101" }`).Code)
+	suite.Require().Equal(http.StatusOK, suite.request(http.MethodPost, "/api/waiting-list/test-ambulance/entries",
+		`{ "id": "test-entry", "patientId": "test-patient", "waitingSince": "2038-12-24T10:05:00Z" }`).Code)
+	server := httptest.NewServer(suite.router)
+	defer server.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/api/nurse-console", nil)
+	suite.Require().NoError(err)
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	// events are relayed asynchronously, they are collected while waiting for the results
+	events := []WaitingListEvent{}
+	send := func(request nurseConsoleRequest) nurseConsoleMessage {
+		suite.Require().NoError(conn.WriteJSON(request))
+		for {
+			var message nurseConsoleMessage
+			suite.Require().NoError(conn.ReadJSON(&message))
+			if message.Type == "event" {
+				events = append(events, *message.Event)
+			} else if message.Id == request.Id {
+				return message
+			}
+		}
+	}
+
+	// ACT
+	subscribed := send(nurseConsoleRequest{Id: "1", Type: "subscribe", AmbulanceId: "test-ambulance"})
+	missing := send(nurseConsoleRequest{Id: "2", Type: "subscribe", AmbulanceId: "missing-ambulance"})
+	called := send(nurseConsoleRequest{Id: "3", Type: "command", AmbulanceId: "test-ambulance", Command: "call-next"})
+	invalid := send(nurseConsoleRequest{Id: "4", Type: "command", AmbulanceId: "test-ambulance", Command: "finish", EntryId: "test-entry"})
+	unknown := send(nurseConsoleRequest{Id: "5", Type: "command", AmbulanceId: "test-ambulance", Command: "discharge"})
+	for !slices.ContainsFunc(events, func(event WaitingListEvent) bool { return event.Type == UPDATED }) {
+		var message nurseConsoleMessage
+		suite.Require().NoError(conn.ReadJSON(&message))
+		events = append(events, *message.Event)
+	}
+
+	// ASSERT
+	suite.Equal(nurseConsoleMessage{Id: "1", Type: "result", AmbulanceId: "test-ambulance", Status: http.StatusOK, Body: subscribed.Body}, subscribed)
+	var entries []WaitingListEntry
+	suite.NoError(json.Unmarshal(subscribed.Body, &entries))
+	suite.Require().Len(entries, 1)
+	suite.Equal("test-entry", entries[0].Id)
+	suite.Equal(http.StatusNotFound, missing.Status)
+
+	suite.Equal(http.StatusOK, called.Status)
+	var calledEntry WaitingListEntry
+	suite.NoError(json.Unmarshal(called.Body, &calledEntry))
+	suite.Equal(CALLED, calledEntry.State)
+	updated := events[slices.IndexFunc(events, func(event WaitingListEvent) bool { return event.Type == UPDATED })]
+	suite.Equal("test-ambulance", updated.AmbulanceId)
+	suite.Equal(CALLED, updated.Entry.State)
+
+	suite.Equal(http.StatusConflict, invalid.Status)
+	suite.Equal(http.StatusBadRequest, unknown.Status)
+}
+
+func (suite *RoutersSuite) Test_NurseConsole_CommandsAttributedToForwardedUser() {
+	// ARRANGE
+	suite.trustedProxies = []netip.Prefix{netip.MustParsePrefix("127.0.0.1/32")}
+	suite.router.GET("/api/nurse-console", NewNurseConsoleHandler(suite.router, nil))
+	suite.Require().Equal(http.StatusCreated, suite.request(http.MethodPost, "/api/ambulance",
+		`{ "id": "test-ambulance", "name": "Test Ambulance", "roomNumber": "101" }`).Code)
+	suite.Require().Equal(http.StatusOK, suite.request(http.MethodPost, "/api/waiting-list/test-ambulance/entries",
+		`{ "id": "test-entry", "patientId": "test-patient", "waitingSince": "2038-12-24T10:05:00Z" }`).Code)
+	server := httptest.NewServer(suite.router)
+	defer server.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/api/nurse-console",
+		http.Header{"X-Forwarded-User": {"nurse"}})
+	suite.Require().NoError(err)
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	// ACT
+	suite.Require().NoError(conn.WriteJSON(nurseConsoleRequest{Id: "1", Type: "command", AmbulanceId: "test-ambulance", Command: "call-next"}))
+	var called nurseConsoleMessage
+	for called.Id != "1" {
+		suite.Require().NoError(conn.ReadJSON(&called))
+	}
+
+	// ASSERT
+	suite.Require().Equal(http.StatusOK, called.Status)
+	audit := suite.request(http.MethodGet, "/api/audit?ambulanceId=test-ambulance&patientId=test-patient", "")
+	var records AuditRecordList
+	suite.Require().NoError(json.Unmarshal(audit.Body.Bytes(), &records))
+	actors := []string{}
+	for _, record := range records.Items {
+		actors = append(actors, record.Actor)
+	}
+	suite.Contains(actors, "nurse")
+}
+
+func (suite *RoutersSuite) Test_Webhooks_NotifiedAboutWaitingListChanges() {
+	// ARRANGE
+	deliveries := make(chan *http.Request, 10)
+	bodies := make(chan []byte, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		deliveries <- r
+		bodies <- body
+	}))
+	defer receiver.Close()
+	suite.Require().Equal(http.StatusCreated, suite.request(http.MethodPost, "/api/ambulance",
+		`{ "id": "test-ambulance", "name": "Test Ambulance", "roomNumber": "101" }`).Code)
+
+	// ACT
+	created := suite.request(http.MethodPost, "/api/webhooks",
+		`{ "id": "test-webhook", "url": "`+receiver.URL+`", "secret": "test-secret", "eventTypes": ["entry.called"] }`)
+	withoutSecret := suite.request(http.MethodPost, "/api/webhooks",
+		`{ "id": "other-webhook", "url": "`+receiver.URL+`" }`)
+	invalidUrl := suite.request(http.MethodPost, "/api/webhooks",
+		`{ "id": "other-webhook", "url": "/relative", "secret": "test-secret" }`)
+	internalUrl := suite.request(http.MethodPost, "/api/webhooks",
+		`{ "id": "other-webhook", "url": "http://169.254.169.254/latest/meta-data", "secret": "test-secret" }`)
+	suite.request(http.MethodPost, "/api/waiting-list/test-ambulance/entries",
+		`{ "id": "test-entry", "patientId": "test-patient", "waitingSince": "2038-12-24T10:05:00Z", "estimatedDurationMinutes": 15 }`)
+	suite.request(http.MethodPost, "/api/waiting-list/test-ambulance/entries/test-entry/call", "")
+	stored := suite.request(http.MethodGet, "/api/webhooks/test-webhook", "")
+	deadLetters := suite.request(http.MethodGet, "/api/webhooks/test-webhook/dead-letters", "")
+
+	// ASSERT
+	suite.Equal(http.StatusCreated, created.Code)
+	suite.NotContains(created.Body.String(), "test-secret")
+	suite.Equal(http.StatusBadRequest, withoutSecret.Code)
+	suite.Equal(http.StatusBadRequest, invalidUrl.Code)
+	suite.Equal(http.StatusBadRequest, internalUrl.Code)
+	suite.Equal(http.StatusOK, stored.Code)
+	suite.NotContains(stored.Body.String(), "test-secret")
+	suite.JSONEq(`[]`, deadLetters.Body.String())
+
+	var delivery *http.Request
+	select {
+	case delivery = <-deliveries:
+	case <-time.After(5 * time.Second):
+		suite.FailNow("webhook event not delivered")
+	}
+	body := <-bodies
+	// entry.enqueued is not subscribed, the first delivery is the call
+	suite.Equal("entry.called", delivery.Header.Get("X-Webhook-Event"))
+	suite.Equal(
+		signWebhookPayload("test-secret", delivery.Header.Get("X-Webhook-Timestamp"), body),
+		delivery.Header.Get("X-Webhook-Signature"))
+	var event WebhookEvent
+	suite.Require().NoError(json.Unmarshal(body, &event))
+	suite.Equal(ENTRY_CALLED, event.Type)
+	suite.Equal("test-ambulance", event.AmbulanceId)
+	suite.Equal("test-entry", event.Entry.Id)
+	suite.Equal(CALLED, event.Entry.State)
+}
+
+func (suite *RoutersSuite) Test_WaitingListChanges_RecordedInOutbox() {
+	// ARRANGE
+	suite.Require().Equal(http.StatusCreated, suite.request(http.MethodPost, "/api/ambulance",
+		`{ "id": "test-ambulance", "name": "Test Ambulance", "roomNumber": "101" }`).Code)
+
+	// ACT
+	suite.request(http.MethodPost, "/api/waiting-list/test-ambulance/entries",
+		`{ "id": "test-entry", "patientId": "test-patient", "waitingSince": "2038-12-24T10:05:00Z", "estimatedDurationMinutes": 15 }`)
+	suite.request(http.MethodPut, "/api/waiting-list/test-ambulance/entries/test-entry",
+		`{ "id": "test-entry", "patientId": "test-patient", "waitingSince": "2038-12-24T10:05:00Z", "priority": 1 }`)
+	suite.request(http.MethodPost, "/api/waiting-list/test-ambulance/entries/test-entry/call", "")
+	suite.request(http.MethodDelete, "/api/waiting-list/test-ambulance/entries/test-entry", "")
+
+	// ASSERT
+	page, err := suite.outboxDb.FindDocuments(context.Background(), db_service.Query{SortBy: "createdat"})
+	suite.Require().NoError(err)
+	types := []string{}
+	for _, message := range page.Items {
+		types = append(types, message.Event.Type)
+		suite.Equal("1.0", message.Event.SpecVersion)
+		suite.Equal("/api/waiting-list/test-ambulance", message.Event.Source)
+		suite.Equal("test-entry", message.Event.Subject)
+	}
+	// priority change is not published
+	suite.Equal([]string{"ambulance-wl.entry.enqueued", "ambulance-wl.entry.called", "ambulance-wl.entry.removed"}, types)
+	var data waitingListEventData
+	suite.Require().NoError(json.Unmarshal(page.Items[1].Event.Data, &data))
+	suite.Equal("test-ambulance", data.AmbulanceId)
+	suite.Equal(CALLED, data.Entry.State)
+}
+
+func (suite *RoutersSuite) Test_Changes_ForwardedUserIgnoredFromUntrustedPeer() {
+	// ARRANGE
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodPost, "/api/ambulance",
+		strings.NewReader(`{ "id": "test-ambulance", "name": "Test Ambulance", "roomNumber": "101" }`))
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("X-Forwarded-User", "admin")
+	request.RemoteAddr = "198.51.100.7:43210"
+
+	// ACT
+	suite.router.ServeHTTP(recorder, request)
+
+	// ASSERT
+	suite.Require().Equal(http.StatusCreated, recorder.Code)
+	audit := suite.request(http.MethodGet, "/api/audit?ambulanceId=test-ambulance", "")
+	var records AuditRecordList
+	suite.Require().NoError(json.Unmarshal(audit.Body.Bytes(), &records))
+	suite.Require().Len(records.Items, 1)
+	suite.Equal(anonymousActor, records.Items[0].Actor)
+}
+
+func (suite *RoutersSuite) Test_Changes_RecordedInAuditTrail() {
+	// ARRANGE
+	start := time.Now().UTC().Add(-time.Second).Format(time.RFC3339)
+	suite.Require().Equal(http.StatusCreated, suite.requestAs("admin", http.MethodPost, "/api/ambulance",
+		`{ "id": "test-ambulance", "name": "Test Ambulance", "roomNumber": "101" }`).Code)
+
+	// ACT
+	suite.requestAs("nurse", http.MethodPost, "/api/waiting-list/test-ambulance/entries",
+		`{ "id": "test-entry", "patientId": "test-patient", "waitingSince": "2038-12-24T10:05:00Z", "estimatedDurationMinutes": 15 }`)
+	suite.requestAs("nurse", http.MethodPost, "/api/waiting-list/test-ambulance/entries/test-entry/call", "")
+	suite.requestAs("admin", http.MethodPatch, "/api/ambulance/test-ambulance", `{ "roomNumber": "102" }`)
+	suite.request(http.MethodDelete, "/api/ambulance/test-ambulance", "")
+	byPatient := suite.request(http.MethodGet, "/api/audit?patientId=test-patient", "")
+	byActor := suite.request(http.MethodGet, "/api/audit?ambulanceId=test-ambulance&actor=admin&from="+start+"&limit=1", "")
+	before := suite.request(http.MethodGet, "/api/audit?to="+start, "")
+	invalid := suite.request(http.MethodGet, "/api/audit?from=yesterday", "")
+
+	// ASSERT
+	suite.Equal(http.StatusOK, byPatient.Code)
+	var patientRecords AuditRecordList
+	suite.Require().NoError(json.Unmarshal(byPatient.Body.Bytes(), &patientRecords))
+	operations := []AuditOperation{}
+	actors := []string{}
+	for _, record := range patientRecords.Items {
+		operations = append(operations, record.Operation)
+		actors = append(actors, record.Actor)
+		suite.Equal("test-ambulance", record.AmbulanceId)
+		suite.Equal("test-entry", record.EntryId)
+	}
+	suite.Equal([]AuditOperation{CREATE, UPDATE, DELETE}, operations)
+	suite.Equal([]string{"nurse", "nurse", anonymousActor}, actors)
+	called := patientRecords.Items[1]
+	suite.Equal("POST /api/waiting-list/:ambulanceId/entries/:entryId/call", called.Request)
+	suite.Contains(called.Changes, AuditChange{Path: "/state", Before: json.RawMessage(`"waiting"`), After: json.RawMessage(`"called"`)})
+
+	suite.Equal(http.StatusOK, byActor.Code)
+	var actorRecords AuditRecordList
+	suite.Require().NoError(json.Unmarshal(byActor.Body.Bytes(), &actorRecords))
+	suite.Require().Len(actorRecords.Items, 1)
+	suite.Equal(CREATE, actorRecords.Items[0].Operation)
+	suite.Empty(actorRecords.Items[0].EntryId)
+	suite.Require().NotEmpty(actorRecords.Links.Next)
+	next := suite.request(http.MethodGet, actorRecords.Links.Next, "")
+	suite.Require().NoError(json.Unmarshal(next.Body.Bytes(), &actorRecords))
+	suite.Require().Len(actorRecords.Items, 1)
+	suite.Equal(UPDATE, actorRecords.Items[0].Operation)
+	suite.Equal([]AuditChange{{Path: "/roomNumber", Before: json.RawMessage(`"101"`), After: json.RawMessage(`"102"`)}}, actorRecords.Items[0].Changes)
+
+	suite.Equal(http.StatusOK, before.Code)
+	suite.Contains(before.Body.String(), `"items":[]`)
+	suite.Equal(http.StatusBadRequest, invalid.Code)
+}
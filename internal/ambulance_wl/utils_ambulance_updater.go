@@ -1,15 +1,31 @@
 package ambulance_wl
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"maps"
+	"math/rand"
 	"net/http"
+	"slices"
+	"time"
 
 	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
 
 	"github.com/gin-gonic/gin"
 	"github.com/wac-fiit/cv2-ambulance-webapi/internal/db_service"
 )
 
+// how many times the updater is applied when the ambulance is modified concurrently
+const maxAmbulanceUpdateAttempts = 5
+
+// upper bound of the random delay before the first retry, grows linearly with attempts
+const ambulanceUpdateRetryDelay = 20 * time.Millisecond
+
 type ambulanceUpdater = func(
 	ctx *gin.Context,
 	ambulance *Ambulance,
@@ -25,107 +41,337 @@ func updateAmbulanceFunc(ctx *gin.Context, updater ambulanceUpdater) {
 	value, exists := ctx.Get("db_service")
 	if !exists {
 		span.SetStatus(codes.Error, "db_service not found")
-		ctx.JSON(
-			http.StatusInternalServerError,
-			gin.H{
-				"status":  "Internal Server Error",
-				"message": "db_service not found",
-				"error":   "db_service not found",
-			})
+		writeProblem(ctx, problemInternalError, "db_service not found", nil)
 		return
 	}
 
 	db, ok := value.(db_service.DbService[Ambulance])
 	if !ok {
 		span.SetStatus(codes.Error, "db_service context is not of type db_service.DbService")
-		ctx.JSON(
-			http.StatusInternalServerError,
-			gin.H{
-				"status":  "Internal Server Error",
-				"message": "db_service context is not of type db_service.DbService",
-				"error":   "cannot cast db_service context to db_service.DbService",
-			})
+		writeProblem(ctx, problemInternalError, "cannot cast db_service context to db_service.DbService", nil)
 		return
 	}
 
-	ambulanceId := ctx.Param("ambulanceId")
-
-	ambulance, err := db.FindDocument(ctx, ambulanceId)
-
-	switch err {
-	case nil:
-		// continue
-	case db_service.ErrNotFound:
-		span.SetStatus(codes.Error, "Ambulance not found")
-		ctx.JSON(
-			http.StatusNotFound,
-			gin.H{
-				"status":  "Not Found",
-				"message": "Ambulance not found",
-				"error":   err.Error(),
-			},
-		)
-		return
-	default:
-		span.SetStatus(codes.Error, "Failed to load ambulance from database")
-		ctx.JSON(
-			http.StatusBadGateway,
-			gin.H{
-				"status":  "Bad Gateway",
-				"message": "Failed to load ambulance from database",
-				"error":   err.Error(),
-			})
+	value, exists = ctx.Get("db_service_waiting_list")
+	if !exists {
+		span.SetStatus(codes.Error, "db_service_waiting_list not found")
+		writeProblem(ctx, problemInternalError, "db_service_waiting_list not found", nil)
 		return
 	}
 
+	entriesDb, ok := value.(db_service.DbChildService[WaitingListEntry])
 	if !ok {
-		span.SetStatus(codes.Error, "Failed to cast ambulance from database")
-		ctx.JSON(
-			http.StatusInternalServerError,
-			gin.H{
-				"status":  "Internal Server Error",
-				"message": "Failed to cast ambulance from database",
-				"error":   "Failed to cast ambulance from database",
+		span.SetStatus(codes.Error, "db_service_waiting_list context is not of type db_service.DbChildService")
+		writeProblem(ctx, problemInternalError, "cannot cast db_service_waiting_list context to db_service.DbChildService", nil)
+		return
+	}
+
+	// the request body is consumed by the updater, keep it for the retries
+	var body []byte
+	if ctx.Request.Body != nil {
+		var err error
+		if body, err = io.ReadAll(ctx.Request.Body); err != nil {
+			span.SetStatus(codes.Error, "Failed to read request body")
+			writeProblem(ctx, problemInvalidBody, "Failed to read request body", err)
+			return
+		}
+	}
+
+	ambulanceId := ctx.Param("ambulanceId")
+
+	for attempt := 1; ; attempt++ {
+		span.SetAttributes(attribute.Int("update.attempt", attempt))
+		ctx.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		ambulance, err := db.FindDocument(ctx, ambulanceId)
+		var loaded *loadedAmbulance
+		if err == nil {
+			loaded, err = loadWaitingList(ctx, entriesDb, ambulance, ctx.Param("entryId"))
+		}
+
+		switch err {
+		case nil:
+			// continue
+		case db_service.ErrNotFound:
+			span.SetStatus(codes.Error, "Ambulance not found")
+			writeProblem(ctx, problemAmbulanceNotFound, "", nil)
+			return
+		default:
+			span.SetStatus(codes.Error, "Failed to load ambulance from database")
+			writeProblem(ctx, problemDatabaseError, "Failed to load ambulance from database", err)
+			return
+		}
+
+		updatedAmbulance, responseObject, status := updater(ctx, ambulance)
+
+		if updatedAmbulance != nil {
+			err = runInTransaction(ctx, db, func(txCtx context.Context) error {
+				return loaded.persist(txCtx, db, entriesDb, newChangeLog(ctx), updatedAmbulance)
 			})
+		} else {
+			err = nil // redundant but for clarity
+		}
+
+		if err == db_service.ErrVersionConflict && attempt < maxAmbulanceUpdateAttempts {
+			// somebody else updated the ambulance meanwhile, apply the update again on the fresh document
+			// unless the client gave up waiting for the response
+			delay := time.NewTimer(time.Duration(rand.Int63n(int64(attempt) * int64(ambulanceUpdateRetryDelay))))
+			select {
+			case <-delay.C:
+				continue
+			case <-ctx.Request.Context().Done():
+				delay.Stop()
+			}
+		}
+
+		switch err {
+		case nil:
+			span.SetStatus(codes.Ok, "Ambulance updated")
+			if problem, ok := responseObject.(*Problem); ok {
+				renderProblem(ctx, problem)
+			} else if responseObject != nil && status == http.StatusOK && representsTarget(ctx.Request) {
+				writeRepresentation(ctx, status, responseObject)
+			} else if responseObject != nil {
+				ctx.JSON(status, responseObject)
+			} else {
+				ctx.AbortWithStatus(status)
+			}
+		case db_service.ErrNotFound:
+			span.SetStatus(codes.Error, "Ambulance not found")
+			writeProblem(ctx, problemAmbulanceNotFound, "Ambulance was deleted while processing the request", nil)
+		case db_service.ErrConflict:
+			span.SetStatus(codes.Error, "Entry already exists")
+			writeProblem(ctx, problemEntryExists, "Entry was created meanwhile", nil)
+		case db_service.ErrVersionConflict:
+			span.SetStatus(codes.Error, "Ambulance was modified concurrently")
+			writeProblem(ctx, problemConcurrentModification, "Ambulance was modified concurrently, retry the request", nil)
+		default:
+			span.SetStatus(codes.Error, "Failed to update ambulance in database")
+			writeProblem(ctx, problemDatabaseError, "Failed to update ambulance in database", err)
+		}
 		return
 	}
+}
 
-	updatedAmbulance, responseObject, status := updater(ctx, ambulance)
+// representsTarget tells if the response of the request is the representation
+// of the requested resource, its entity tag is then provided to the client
+func representsTarget(request *http.Request) bool {
+	return slices.Contains([]string{http.MethodGet, http.MethodPut, http.MethodPatch}, request.Method)
+}
 
-	if updatedAmbulance != nil {
-		err = db.UpdateDocument(ctx, ambulanceId, updatedAmbulance)
-	} else {
-		err = nil // redundant but for clarity
+// loadedAmbulance keeps the stored state of the ambulance so that only the
+// changes made by the updater are written back to the database
+type loadedAmbulance struct {
+	ambulanceId string
+	metadata    []byte
+	entries     map[string][]byte
+	// waiting list is still embedded in the ambulance document
+	embeddedWaitingList bool
+	// entries moved from the ambulance document, they are not new to the waiting list
+	migrated map[string]struct{}
+}
+
+// runInTransaction stores the changes atomically if the storage supports transactions
+func runInTransaction(ctx context.Context, db db_service.DbService[Ambulance], fn func(ctx context.Context) error) error {
+	if transactional, ok := db.(db_service.Transactional); ok {
+		return transactional.RunInTransaction(ctx, fn)
 	}
+	return fn(ctx)
+}
 
-	switch err {
-	case nil:
-		span.SetStatus(codes.Ok, "Ambulance updated")
-		if responseObject != nil {
-			ctx.JSON(status, responseObject)
-		} else {
-			ctx.AbortWithStatus(status)
-		}
-	case db_service.ErrNotFound:
-		span.SetStatus(codes.Error, "Ambulance not found")
-
-		ctx.JSON(
-			http.StatusNotFound,
-			gin.H{
-				"status":  "Not Found",
-				"message": "Ambulance was deleted while processing the request",
-				"error":   err.Error(),
-			},
-		)
-	default:
-		span.SetStatus(codes.Error, "Failed to update ambulance in database")
-		ctx.JSON(
-			http.StatusBadGateway,
-			gin.H{
-				"status":  "Bad Gateway",
-				"message": "Failed to update ambulance in database",
-				"error":   err.Error(),
-			})
+// activeEntries selects the entries of the patients still expected in the
+// ambulance, entries stored before introducing the lifecycle have no state.
+// Finished visits and no-shows are kept as the history of the ambulance, they
+// are loaded only when requested, so that the updates do not slow down as the
+// history grows.
+var activeEntries = db_service.Query{
+	Filters: []db_service.Filter{{
+		Field:    "state",
+		Operator: db_service.FilterIn,
+		Value:    []interface{}{nil, "", string(WAITING), string(CALLED), string(IN_PROGRESS)},
+	}},
+}
+
+// loadWaitingList loads active entries of the ambulance waiting list into the
+// ambulance, together with the entry of the id which may have left the list
+// already, e.g. the entry addressed by the request. Entries embedded in the
+// ambulance document by older versions of the service are moved into the
+// entries collection on the next update of the ambulance.
+func loadWaitingList(
+	ctx context.Context,
+	entriesDb db_service.DbChildService[WaitingListEntry],
+	ambulance *Ambulance,
+	entryId string,
+) (*loadedAmbulance, error) {
+	stored, err := entriesDb.FindChildDocuments(ctx, ambulance.Id, activeEntries)
+	if err != nil {
+		return nil, err
+	}
+	if entryId != "" && !slices.ContainsFunc(stored, func(entry *WaitingListEntry) bool { return entry.Id == entryId }) {
+		switch entry, err := entriesDb.FindChildDocument(ctx, ambulance.Id, entryId); err {
+		case nil:
+			stored = append(stored, entry)
+		case db_service.ErrNotFound:
+		default:
+			return nil, err
+		}
 	}
 
+	loaded := &loadedAmbulance{
+		ambulanceId:         ambulance.Id,
+		entries:             map[string][]byte{},
+		embeddedWaitingList: len(ambulance.WaitingList) > 0,
+		migrated:            map[string]struct{}{},
+	}
+
+	embedded := ambulance.WaitingList
+	ambulance.WaitingList = nil
+	if loaded.metadata, err = json.Marshal(ambulance); err != nil {
+		return nil, err
+	}
+
+	for _, entry := range stored {
+		entry.clearUnsetTransitions()
+		if loaded.entries[entry.Id], err = json.Marshal(entry); err != nil {
+			return nil, err
+		}
+		ambulance.WaitingList = append(ambulance.WaitingList, *entry)
+	}
+	for _, entry := range embedded {
+		if _, exists := loaded.entries[entry.Id]; !exists {
+			ambulance.WaitingList = append(ambulance.WaitingList, entry)
+			loaded.migrated[entry.Id] = struct{}{}
+		}
+	}
+	return loaded, nil
+}
+
+// persist stores entries which were created, changed, or removed by the updater
+// and the ambulance document itself. The revision of the ambulance is bumped
+// by any change of the waiting list, so that concurrent updates of the same
+// ambulance, e.g. two entries created for the same patient, conflict even if
+// they write different entries. The changes are recorded in the change log and
+// stored with it.
+func (loaded *loadedAmbulance) persist(
+	ctx context.Context,
+	db db_service.DbService[Ambulance],
+	entriesDb db_service.DbChildService[WaitingListEntry],
+	changes *changeLog,
+	updated *Ambulance,
+) error {
+	removed := maps.Clone(loaded.entries)
+	created := []*WaitingListEntry{}
+	changed := []*WaitingListEntry{}
+	for i := range updated.WaitingList {
+		entry := &updated.WaitingList[i]
+		original, exists := loaded.entries[entry.Id]
+		delete(removed, entry.Id)
+
+		if !exists {
+			created = append(created, entry)
+		} else if current, err := json.Marshal(entry); err != nil {
+			return err
+		} else if !bytes.Equal(current, original) {
+			changed = append(changed, entry)
+		}
+	}
+	waitingListChanged := len(created) > 0 || len(changed) > 0 || len(removed) > 0
+
+	details := *updated
+	details.WaitingList = nil
+	metadata, err := json.Marshal(&details)
+	if err != nil {
+		return err
+	}
+	detailsChanged := !bytes.Equal(metadata, loaded.metadata)
+	// the ambulance is written first, storage without transactions then does
+	// not write the entries of the update which failed on the version conflict
+	if loaded.embeddedWaitingList || waitingListChanged || detailsChanged {
+		if err := db.UpdateDocument(ctx, loaded.ambulanceId, &details); err != nil {
+			return err
+		}
+		updated.Version = details.Version
+	}
+	if detailsChanged {
+		if err := changes.ambulanceChanged(loaded.ambulanceId, loaded.metadata, &details); err != nil {
+			return err
+		}
+	}
+
+	for _, entry := range created {
+		err := entriesDb.CreateChildDocument(ctx, loaded.ambulanceId, entry.Id, entry)
+		if _, migrated := loaded.migrated[entry.Id]; err == nil && !migrated {
+			err = changes.entryChanged(loaded.ambulanceId, nil, entry)
+		}
+		if err != nil {
+			return concurrentEntryChange(err)
+		}
+	}
+	for _, entry := range changed {
+		err := entriesDb.UpdateChildDocument(ctx, loaded.ambulanceId, entry.Id, entry)
+		if err == nil {
+			err = changes.entryChanged(loaded.ambulanceId, loaded.entries[entry.Id], entry)
+		}
+		if err != nil {
+			return concurrentEntryChange(err)
+		}
+	}
+	// removals are recorded in the stable order, so are their events
+	for _, entryId := range slices.Sorted(maps.Keys(removed)) {
+		if err := entriesDb.DeleteChildDocument(ctx, loaded.ambulanceId, entryId); err != nil {
+			return concurrentEntryChange(err)
+		}
+		if err := changes.entryChanged(loaded.ambulanceId, removed[entryId], nil); err != nil {
+			return err
+		}
+	}
+
+	// changes are recorded last, storage without transactions then does not
+	// record the update which failed on the version conflict
+	return changes.store(ctx)
+}
+
+// finishedVisits loads the most recent finished visits with the condition of
+// the entry, the duration of the entry is learned from them. Nothing is loaded
+// if the entry provides its own estimate.
+func finishedVisits(ctx *gin.Context, ambulanceId string, entry *WaitingListEntry) ([]WaitingListEntry, error) {
+	if entry.EstimatedDurationMinutes > 0 || entry.Condition.Code == "" {
+		return nil, nil
+	}
+	return findEntries(ctx, ambulanceId, db_service.Query{
+		Filters: []db_service.Filter{
+			{Field: "state", Value: string(FINISHED)},
+			{Field: "condition.code", Value: entry.Condition.Code},
+		},
+		SortBy: "-finishedat",
+		Limit:  maxLearnedDurationSamples,
+	})
+}
+
+// findEntries loads the entries of the ambulance which are not part of the
+// loaded waiting list, e.g. its history
+func findEntries(ctx *gin.Context, ambulanceId string, query db_service.Query) ([]WaitingListEntry, error) {
+	entriesDb, ok := ctx.Value("db_service_waiting_list").(db_service.DbChildService[WaitingListEntry])
+	if !ok {
+		return nil, errors.New("db_service_waiting_list not found")
+	}
+	stored, err := entriesDb.FindChildDocuments(ctx, ambulanceId, query)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]WaitingListEntry, 0, len(stored))
+	for _, entry := range stored {
+		entry.clearUnsetTransitions()
+		entries = append(entries, *entry)
+	}
+	return entries, nil
+}
+
+// concurrentEntryChange maps missing entry to the concurrency conflict - the
+// entry was removed by other request after the waiting list was loaded
+func concurrentEntryChange(err error) error {
+	if err == db_service.ErrNotFound {
+		return db_service.ErrVersionConflict
+	}
+	return err
 }
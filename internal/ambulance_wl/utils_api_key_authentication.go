@@ -0,0 +1,63 @@
+package ambulance_wl
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"github.com/wac-fiit/cv2-ambulance-webapi/internal/auth"
+	"github.com/wac-fiit/cv2-ambulance-webapi/internal/db_service"
+)
+
+// header of the requests carrying the API key
+const apiKeyHeader = "X-API-Key"
+
+// last use of the key is stored at most once per interval, not on every request
+const apiKeyUsageInterval = time.Minute
+
+// NewApiKeyMiddleware authenticates the requests with the X-API-Key header by
+// the keys of the db_service_api_keys context service. Requests without the
+// header are passed to the other authentication middlewares of the engine.
+func NewApiKeyMiddleware() gin.HandlerFunc {
+	logger := log.With().Str("component", "api-key-authentication").Logger()
+	return func(c *gin.Context) {
+		key := c.GetHeader(apiKeyHeader)
+		if key == "" {
+			c.Next()
+			return
+		}
+
+		apiKeysDb, ok := c.Value("db_service_api_keys").(db_service.DbService[StoredApiKey])
+		if !ok {
+			abortWithProblem(c, problemInternalError, "db_service_api_keys not found", nil)
+			return
+		}
+
+		now := time.Now()
+		apiKey, err := apiKeysDb.FindDocument(c, apiKeyId(key))
+		switch err {
+		case nil:
+			err = apiKey.verify(key, now)
+		case db_service.ErrNotFound:
+			err = errUnknownApiKey
+		default:
+			abortWithProblem(c, problemDatabaseError, "Failed to load API key from database", err)
+			return
+		}
+		if err != nil {
+			abortWithProblem(c, problemInvalidCredentials, "Invalid API key", err)
+			return
+		}
+
+		if now.Sub(apiKey.LastUsedAt) >= apiKeyUsageInterval {
+			apiKey.LastUsedAt = now
+			// concurrent requests or admin changes win, the usage is only informative
+			if err := apiKeysDb.UpdateDocument(c, apiKey.Id, apiKey); err != nil && err != db_service.ErrVersionConflict {
+				logger.Warn().Err(err).Str("apiKeyId", apiKey.Id).Msg("Failed to record usage of the API key")
+			}
+		}
+
+		auth.SetPrincipal(c, apiKey.principal())
+		c.Next()
+	}
+}
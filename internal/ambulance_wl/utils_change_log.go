@@ -0,0 +1,252 @@
+package ambulance_wl
+
+import (
+	"context"
+	"encoding/json"
+	"net/netip"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/wac-fiit/cv2-ambulance-webapi/internal/auth"
+	"github.com/wac-fiit/cv2-ambulance-webapi/internal/db_service"
+	"github.com/wac-fiit/cv2-ambulance-webapi/internal/outbox"
+)
+
+// actor of the requests which do not carry the identity of the user
+const anonymousActor = "anonymous"
+
+// escapes the property names in the JSON Pointer of the audited change
+var jsonPointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+// changeLog collects the outbox events and the audit records of the changes
+// made by one request, they are stored together with the changes
+type changeLog struct {
+	outboxDb db_service.DbService[outbox.Message]
+	auditDb  db_service.DbService[AuditRecord]
+	actor    string
+	request  string
+	messages []*outbox.Message
+	records  []*AuditRecord
+}
+
+// newChangeLog creates the log of the request changes. Events are not recorded
+// if the outbox is not configured, and changes are not audited if the audit
+// trail is not configured.
+func newChangeLog(c *gin.Context) *changeLog {
+	outboxDb, _ := c.Value("db_service_outbox").(db_service.DbService[outbox.Message])
+	auditDb, _ := c.Value("db_service_audit").(db_service.DbService[AuditRecord])
+	return &changeLog{
+		outboxDb: outboxDb,
+		auditDb:  auditDb,
+		actor:    requestActor(c),
+		request:  c.Request.Method + " " + c.FullPath(),
+	}
+}
+
+// requestActor identifies the user who made the request by the bearer token
+// or the API key of the request. If the service does not authenticate the
+// requests itself, the headers set by the authenticating proxy in front of the
+// service are used, but only when the request comes from one of the trusted
+// proxies, others could claim any identity.
+func requestActor(c *gin.Context) string {
+	if principal, ok := auth.PrincipalFromContext(c); ok {
+		return principal.Username
+	}
+	if actor := forwardedActor(c); actor != "" {
+		return actor
+	}
+	return anonymousActor
+}
+
+// forwardedActor returns the user asserted by the trusted proxy, or empty
+// string if the request does not come from the trusted proxy
+func forwardedActor(c *gin.Context) string {
+	if !fromTrustedProxy(c) {
+		return ""
+	}
+	for _, header := range []string{"X-Forwarded-User", "X-Forwarded-Email"} {
+		if actor := c.GetHeader(header); actor != "" {
+			return actor
+		}
+	}
+	return ""
+}
+
+// fromTrustedProxy tells if the peer of the request is within the trusted_proxies
+func fromTrustedProxy(c *gin.Context) bool {
+	proxies, _ := c.Value("trusted_proxies").([]netip.Prefix)
+	peer, err := netip.ParseAddr(c.RemoteIP())
+	if err != nil {
+		return false
+	}
+	peer = peer.Unmap()
+	for _, proxy := range proxies {
+		if proxy.Contains(peer) {
+			return true
+		}
+	}
+	return false
+}
+
+// entryChanged records the change of the waiting list entry, previous is the
+// stored entry or nil for the created entry, and current is nil for the
+// removed entry
+func (l *changeLog) entryChanged(ambulanceId string, previous []byte, current *WaitingListEntry) error {
+	var previousEntry *WaitingListEntry
+	if previous != nil {
+		previousEntry = &WaitingListEntry{}
+		if err := json.Unmarshal(previous, previousEntry); err != nil {
+			return err
+		}
+	}
+	entry := current
+	if entry == nil {
+		entry = previousEntry
+	}
+
+	if eventType, ok := entryChangeEvent(previousEntry, current); ok && l.outboxDb != nil {
+		event, err := newWaitingListCloudEvent(eventType, ambulanceId, *entry)
+		if err != nil {
+			return err
+		}
+		l.messages = append(l.messages, outbox.NewMessage(event))
+	}
+	return l.audit(ambulanceId, entry.Id, entry.PatientId, previous, current)
+}
+
+// ambulanceChanged records the change of the ambulance details, previous is
+// the stored ambulance without its waiting list or nil for the created
+// ambulance, and current is nil for the deleted ambulance. Waiting list is
+// audited by its entries.
+func (l *changeLog) ambulanceChanged(ambulanceId string, previous []byte, current *Ambulance) error {
+	var details *Ambulance
+	if current != nil {
+		copied := *current
+		copied.WaitingList = nil
+		details = &copied
+	}
+	return l.audit(ambulanceId, "", "", previous, details)
+}
+
+func (l *changeLog) audit(ambulanceId string, entryId string, patientId string, previous []byte, current interface{}) error {
+	if l.auditDb == nil {
+		return nil
+	}
+	encoded, err := json.Marshal(current)
+	if err != nil {
+		return err
+	}
+	var before, after interface{}
+	if previous != nil {
+		if err := json.Unmarshal(previous, &before); err != nil {
+			return err
+		}
+	}
+	if err := json.Unmarshal(encoded, &after); err != nil {
+		return err
+	}
+
+	changes := []AuditChange{}
+	if err := diffJsonValues("", before, after, &changes); err != nil || len(changes) == 0 {
+		return err
+	}
+
+	operation := UPDATE
+	switch {
+	case before == nil:
+		operation = CREATE
+	case after == nil:
+		operation = DELETE
+	}
+	// ids are ordered by time, so the records of one request keep their order
+	id, err := uuid.NewV7()
+	if err != nil {
+		return err
+	}
+	l.records = append(l.records, &AuditRecord{
+		Id:          id.String(),
+		OccurredAt:  time.Now().UTC(),
+		Actor:       l.actor,
+		Operation:   operation,
+		Request:     l.request,
+		AmbulanceId: ambulanceId,
+		EntryId:     entryId,
+		PatientId:   patientId,
+		Changes:     changes,
+	})
+	return nil
+}
+
+// store writes the recorded events and audit records, it is called after the
+// changes themselves were stored, so storage without transactions records
+// nothing for the changes which failed
+func (l *changeLog) store(ctx context.Context) error {
+	for _, message := range l.messages {
+		if err := l.outboxDb.CreateDocument(ctx, message.Id, message); err != nil {
+			return err
+		}
+	}
+	for _, record := range l.records {
+		if err := l.auditDb.CreateDocument(ctx, record.Id, record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// diffJsonValues appends the changes between the decoded JSON values. Objects
+// are compared property by property, other values including arrays are
+// compared as a whole.
+func diffJsonValues(path string, before interface{}, after interface{}, changes *[]AuditChange) error {
+	beforeObject, beforeIsObject := before.(map[string]interface{})
+	afterObject, afterIsObject := after.(map[string]interface{})
+	if before == nil && afterIsObject {
+		beforeObject, beforeIsObject = map[string]interface{}{}, true
+	}
+	if after == nil && beforeIsObject {
+		afterObject, afterIsObject = map[string]interface{}{}, true
+	}
+
+	if beforeIsObject && afterIsObject {
+		keys := make([]string, 0, len(beforeObject)+len(afterObject))
+		for key := range beforeObject {
+			keys = append(keys, key)
+		}
+		for key := range afterObject {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+		for _, key := range slices.Compact(keys) {
+			// version is the optimistic locking of the storage, not a change of the resource
+			if path == "" && key == "version" {
+				continue
+			}
+			if err := diffJsonValues(path+"/"+jsonPointerEscaper.Replace(key), beforeObject[key], afterObject[key], changes); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if reflect.DeepEqual(before, after) {
+		return nil
+	}
+	change := AuditChange{Path: path}
+	var err error
+	if before != nil {
+		if change.Before, err = json.Marshal(before); err != nil {
+			return err
+		}
+	}
+	if after != nil {
+		if change.After, err = json.Marshal(after); err != nil {
+			return err
+		}
+	}
+	*changes = append(*changes, change)
+	return nil
+}
@@ -0,0 +1,89 @@
+package ambulance_wl
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// errPreconditionRefused stops the transaction of the request which does not
+// meet its If-Match precondition
+var errPreconditionRefused = errors.New("precondition of the request is not met")
+
+// entityTag returns the strong entity tag of the resource representation,
+// the tag changes whenever the JSON representation of the resource changes
+func entityTag(representation interface{}) (string, error) {
+	data, err := json.Marshal(representation)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(data)
+	return `"` + base64.RawURLEncoding.EncodeToString(hash[:]) + `"`, nil
+}
+
+// matchesEntityTag tells if the list of the entity tags of the If-Match or
+// If-None-Match header contains the tag. Weak tags match only if the weak
+// comparison is requested, as for If-None-Match.
+func matchesEntityTag(header string, etag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if strings.HasPrefix(candidate, "W/") {
+			if !weak {
+				continue
+			}
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+		if candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// checkIfMatch evaluates the If-Match header of the request modifying the
+// resource against its current representation. The modification is refused
+// if the resource changed since the client retrieved it, or if the header
+// is missing and the service requires it.
+func checkIfMatch(c *gin.Context, current interface{}) (problemKind, bool) {
+	header := c.GetHeader("If-Match")
+	if header == "" {
+		if c.GetBool("require_if_match") {
+			return problemPreconditionRequired, false
+		}
+		return problemKind{}, true
+	}
+	etag, err := entityTag(current)
+	if err != nil {
+		return problemInternalError, false
+	}
+	if !matchesEntityTag(header, etag, false) {
+		return problemPreconditionFailed, false
+	}
+	return problemKind{}, true
+}
+
+// writeRepresentation responds with the resource representation and its
+// entity tag. Retrieval of the representation the client already has is
+// answered by 304 Not Modified.
+func writeRepresentation(c *gin.Context, status int, representation interface{}) {
+	etag, err := entityTag(representation)
+	if err != nil {
+		writeProblem(c, problemInternalError, "Failed to serialize response", err)
+		return
+	}
+	c.Header("ETag", etag)
+	if c.Request.Method == http.MethodGet && status == http.StatusOK &&
+		matchesEntityTag(c.GetHeader("If-None-Match"), etag, true) {
+		c.AbortWithStatus(http.StatusNotModified)
+		return
+	}
+	c.JSON(status, representation)
+}
@@ -0,0 +1,370 @@
+package ambulance_wl
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/wac-fiit/cv2-ambulance-webapi/internal/auth"
+	"github.com/wac-fiit/cv2-ambulance-webapi/internal/db_service"
+)
+
+const (
+	// how long the responses are kept for the retried requests by default
+	idempotencyKeyRetention = 24 * time.Hour
+	// how long the key is locked by the request being processed, the lease is
+	// renewed while the request is processed, so the retry takes the key over
+	// only if the request did not finish, e.g. when the service crashed
+	idempotencyKeyLease = time.Minute
+	// period of the removal of the expired keys
+	idempotencyKeysPurgeInterval = 10 * time.Minute
+	// expired keys removed at once
+	idempotencyKeysPurgeBatchSize = 100
+	// attempts to claim the key which is concurrently removed
+	idempotencyKeyClaimAttempts = 3
+)
+
+// response headers replayed together with the stored response
+var idempotentResponseHeaders = []string{"Content-Type", "Location", "ETag"}
+
+// StoredIdempotencyKey is the idempotency key of the processed request as kept
+// in the database, together with the response replayed to the retries
+type StoredIdempotencyKey struct {
+	// hash of the key, the client and the target of the request
+	Id string
+	// hash of the request body, the key must not be reused for other requests
+	Fingerprint string
+	// false while the first request is processed
+	Completed bool
+	// incomplete key is not taken over by the retries before this time
+	LockedUntil time.Time
+	Status      int
+	Headers     map[string]string
+	Body        []byte
+	ExpiresAt   time.Time
+	// revision of the stored key, only one of the retries may take it over
+	Version int64
+}
+
+// GetVersion implements db_service.Versioned
+func (k *StoredIdempotencyKey) GetVersion() int64 {
+	return k.Version
+}
+
+// SetVersion implements db_service.Versioned
+func (k *StoredIdempotencyKey) SetVersion(version int64) {
+	k.Version = version
+}
+
+type IdempotencyKeysConfig struct {
+	// how long the processed requests are remembered
+	Retention time.Duration
+}
+
+// IdempotencyKeys remembers the responses of the requests sent with the
+// Idempotency-Key header, so that the client can safely retry the request
+// whose response it did not receive
+type IdempotencyKeys struct {
+	IdempotencyKeysConfig
+	db     db_service.DbService[StoredIdempotencyKey]
+	logger zerolog.Logger
+	lease  time.Duration
+}
+
+// NewIdempotencyKeys completes the configuration from the environment variables
+func NewIdempotencyKeys(db db_service.DbService[StoredIdempotencyKey], config IdempotencyKeysConfig) (*IdempotencyKeys, error) {
+	if config.Retention == 0 {
+		config.Retention = idempotencyKeyRetention
+		if value := os.Getenv("AMBULANCE_API_IDEMPOTENCY_KEY_RETENTION"); value != "" {
+			retention, err := time.ParseDuration(value)
+			if err != nil || retention <= 0 {
+				return nil, fmt.Errorf("invalid idempotency key retention %q, expected positive duration", value)
+			}
+			config.Retention = retention
+		}
+	}
+	return &IdempotencyKeys{
+		IdempotencyKeysConfig: config,
+		db:                    db,
+		logger:                log.With().Str("component", "idempotency-keys").Logger(),
+		lease:                 idempotencyKeyLease,
+	}, nil
+}
+
+// Run removes the expired keys until the context is cancelled
+func (k *IdempotencyKeys) Run(ctx context.Context) {
+	ticker := time.NewTicker(idempotencyKeysPurgeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			k.purgeExpired(ctx)
+		}
+	}
+}
+
+func (k *IdempotencyKeys) purgeExpired(ctx context.Context) {
+	for {
+		page, err := k.db.FindDocuments(ctx, db_service.Query{
+			Filters: []db_service.Filter{
+				{Field: "expiresat", Operator: db_service.FilterLessOrEqual, Value: time.Now()},
+			},
+			Limit:      idempotencyKeysPurgeBatchSize,
+			Projection: []string{"id"},
+		})
+		if err != nil {
+			if ctx.Err() == nil {
+				k.logger.Error().Err(err).Msg("Failed to find expired idempotency keys")
+			}
+			return
+		}
+		for _, key := range page.Items {
+			if err := k.db.DeleteDocument(ctx, key.Id); err != nil && err != db_service.ErrNotFound {
+				k.logger.Error().Err(err).Str("keyId", key.Id).Msg("Failed to remove expired idempotency key")
+				return
+			}
+		}
+		if len(page.Items) < idempotencyKeysPurgeBatchSize {
+			return
+		}
+	}
+}
+
+// claim stores the key of the request being processed. The key stored by the
+// previous request is returned instead, unless it already expired, or the
+// previous request did not complete and its lease expired.
+func (k *IdempotencyKeys) claim(ctx context.Context, key *StoredIdempotencyKey) (*StoredIdempotencyKey, error) {
+	for attempt := 0; attempt < idempotencyKeyClaimAttempts; attempt++ {
+		now := time.Now()
+		key.LockedUntil = now.Add(k.lease)
+		err := k.db.CreateDocument(ctx, key.Id, key)
+		if err != db_service.ErrConflict {
+			return nil, err
+		}
+		stored, err := k.db.FindDocument(ctx, key.Id)
+		switch {
+		case err == db_service.ErrNotFound:
+			continue
+		case err != nil:
+			return nil, err
+		case stored.ExpiresAt.After(now) && (stored.Completed || stored.LockedUntil.After(now)):
+			return stored, nil
+		}
+		// expired key is not yet purged, or it was released, the concurrent
+		// retry fails to update the same revision
+		key.Version = stored.Version
+		switch err := k.db.UpdateDocument(ctx, key.Id, key); err {
+		case nil:
+			return nil, nil
+		case db_service.ErrVersionConflict, db_service.ErrNotFound:
+			continue
+		default:
+			return nil, err
+		}
+	}
+	return nil, db_service.ErrConflict
+}
+
+// idempotentRequest is the request with the claimed idempotency key, its
+// response is recorded to be replayed to the retries
+type idempotentRequest struct {
+	keys     *IdempotencyKeys
+	key      *StoredIdempotencyKey
+	c        *gin.Context
+	writer   gin.ResponseWriter
+	response *recordingResponse
+	// closed by finish to stop the renewal of the lease, renewed is closed
+	// once the renewal stopped
+	stop    chan struct{}
+	renewed chan struct{}
+}
+
+// startIdempotentRequest claims the Idempotency-Key of the request. The
+// response of the earlier request with the same key is replayed, and false
+// is returned if the request must not be processed. The returned request
+// is nil if the client did not send the key.
+func startIdempotentRequest(c *gin.Context) (*idempotentRequest, bool) {
+	header := c.GetHeader("Idempotency-Key")
+	if header == "" {
+		return nil, true
+	}
+
+	keys, ok := c.Value("idempotency_keys").(*IdempotencyKeys)
+	if !ok {
+		writeProblem(c, problemInternalError, "idempotency_keys not found", nil)
+		return nil, false
+	}
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		writeProblem(c, problemInvalidBody, "", err)
+		return nil, false
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+	// keys of different clients and endpoints must not collide, the keys of
+	// the anonymous clients cannot be told apart and are refused
+	client := idempotencyClient(c)
+	if client == "" {
+		writeProblem(c, problemIdempotencyKeyAnonymous, "", nil)
+		return nil, false
+	}
+	scope := sha256.Sum256([]byte(client + "\n" + c.Request.Method + " " + c.Request.URL.Path + "\n" + header))
+	fingerprint := sha256.Sum256(body)
+	key := &StoredIdempotencyKey{
+		Id:          hex.EncodeToString(scope[:]),
+		Fingerprint: hex.EncodeToString(fingerprint[:]),
+		ExpiresAt:   time.Now().Add(keys.Retention),
+	}
+
+	stored, err := keys.claim(c.Request.Context(), key)
+	switch {
+	case err != nil:
+		writeProblem(c, problemDatabaseError, "Failed to store idempotency key", err)
+		return nil, false
+	case stored == nil:
+		response := &recordingResponse{ResponseWriter: c.Writer}
+		request := &idempotentRequest{
+			keys:     keys,
+			key:      key,
+			c:        c,
+			writer:   c.Writer,
+			response: response,
+			stop:     make(chan struct{}),
+			renewed:  make(chan struct{}),
+		}
+		c.Writer = response
+		// the lease is renewed even if the client disconnected, the request is still processed
+		go request.renewLease(context.WithoutCancel(c.Request.Context()))
+		return request, true
+	case stored.Fingerprint != key.Fingerprint:
+		writeProblem(c, problemIdempotencyKeyReused, "", nil)
+	case !stored.Completed:
+		writeProblem(c, problemIdempotencyKeyInUse, "", nil)
+	default:
+		for name, value := range stored.Headers {
+			c.Header(name, value)
+		}
+		c.Header("Idempotent-Replayed", "true")
+		c.Status(stored.Status)
+		c.Writer.Write(stored.Body)
+	}
+	return nil, false
+}
+
+// idempotencyClient identifies the client the keys are scoped to by its
+// credentials, or by the user asserted by the trusted proxy, other headers can
+// be forged. Empty string is returned for the anonymous client.
+func idempotencyClient(c *gin.Context) string {
+	if principal, ok := auth.PrincipalFromContext(c); ok {
+		return principal.Issuer + " " + principal.Subject
+	}
+	if actor := forwardedActor(c); actor != "" {
+		return "forwarded " + actor
+	}
+	return ""
+}
+
+// renewLease extends the lease of the key until the request finishes, so that
+// the slow request is not taken over by its retry. The renewal stops if the
+// key was taken over anyway, e.g. when the renewal failed for longer than the
+// lease.
+func (r *idempotentRequest) renewLease(ctx context.Context) {
+	defer close(r.renewed)
+	ticker := time.NewTicker(r.keys.lease / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+		}
+		r.key.LockedUntil = time.Now().Add(r.keys.lease)
+		switch err := r.keys.db.UpdateDocument(ctx, r.key.Id, r.key); err {
+		case nil:
+		case db_service.ErrVersionConflict, db_service.ErrNotFound:
+			r.keys.logger.Warn().Str("keyId", r.key.Id).Msg("Idempotency key was taken over while the request was processed")
+			return
+		default:
+			r.keys.logger.Error().Err(err).Str("keyId", r.key.Id).Msg("Failed to renew idempotency key lease")
+		}
+	}
+}
+
+// finish stores the response of the request for its retries. The key is
+// released if the request failed transiently, so that it can be retried.
+func (r *idempotentRequest) finish() {
+	if r == nil {
+		return
+	}
+	close(r.stop)
+	<-r.renewed
+	r.c.Writer = r.writer
+	// the response is kept even if the client disconnected, that is when it retries
+	ctx := context.WithoutCancel(r.c.Request.Context())
+
+	status := r.response.Status()
+	if r.failedTransiently(status) {
+		// the key taken over by the retry after the lease expired is not released
+		r.key.LockedUntil = time.Time{}
+		if err := r.keys.db.UpdateDocument(ctx, r.key.Id, r.key); err != nil &&
+			err != db_service.ErrVersionConflict && err != db_service.ErrNotFound {
+			r.keys.logger.Error().Err(err).Str("keyId", r.key.Id).Msg("Failed to release idempotency key")
+		}
+		return
+	}
+
+	r.key.Completed = true
+	r.key.Status = status
+	r.key.Headers = map[string]string{}
+	for _, name := range idempotentResponseHeaders {
+		if value := r.response.Header().Get(name); value != "" {
+			r.key.Headers[name] = value
+		}
+	}
+	r.key.Body = r.response.body.Bytes()
+	if err := r.keys.db.UpdateDocument(ctx, r.key.Id, r.key); err != nil {
+		r.keys.logger.Error().Err(err).Str("keyId", r.key.Id).Msg("Failed to store response of idempotent request")
+	}
+}
+
+// failedTransiently tells if the request may succeed when it is retried, that
+// is when it failed on the server side or on the concurrent modification
+func (r *idempotentRequest) failedTransiently(status int) bool {
+	if status >= 500 {
+		return true
+	}
+	if status != http.StatusConflict {
+		return false
+	}
+	problem := Problem{}
+	return json.Unmarshal(r.response.body.Bytes(), &problem) == nil && problem.Code == CONCURRENT_MODIFICATION
+}
+
+// recordingResponse copies the response sent to the client
+type recordingResponse struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (r *recordingResponse) Write(data []byte) (int, error) {
+	r.body.Write(data)
+	return r.ResponseWriter.Write(data)
+}
+
+func (r *recordingResponse) WriteString(data string) (int, error) {
+	r.body.WriteString(data)
+	return r.ResponseWriter.WriteString(data)
+}
@@ -0,0 +1,261 @@
+package ambulance_wl
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/suite"
+	"github.com/wac-fiit/cv2-ambulance-webapi/internal/auth"
+	"github.com/wac-fiit/cv2-ambulance-webapi/internal/db_service"
+)
+
+type IdempotencyKeysSuite struct {
+	suite.Suite
+	db      db_service.DbService[StoredIdempotencyKey]
+	keys    *IdempotencyKeys
+	handled int
+	// proxies trusted to assert the user, none by default
+	trustedProxies []netip.Prefix
+}
+
+var testPrincipal = &auth.Principal{Issuer: "test-issuer", Subject: "nurse", Username: "nurse"}
+
+func TestIdempotencyKeysSuite(t *testing.T) {
+	suite.Run(t, new(IdempotencyKeysSuite))
+}
+
+func (suite *IdempotencyKeysSuite) SetupTest() {
+	gin.SetMode(gin.TestMode)
+	suite.db = db_service.NewMemoryService[StoredIdempotencyKey]()
+	keys, err := NewIdempotencyKeys(suite.db, IdempotencyKeysConfig{Retention: time.Hour})
+	suite.Require().NoError(err)
+	suite.keys = keys
+	suite.handled = 0
+	suite.trustedProxies = nil
+}
+
+// request posts the body with the key to the handler which responds with the status
+func (suite *IdempotencyKeysSuite) request(key string, status int) *httptest.ResponseRecorder {
+	return suite.requestAs(testPrincipal, key, func(c *gin.Context) {
+		c.JSON(status, gin.H{"handled": suite.handled})
+	}, nil)
+}
+
+// requestAs posts the body with the key on behalf of the principal, the
+// response is written by the handler
+func (suite *IdempotencyKeysSuite) requestAs(principal *auth.Principal, key string, handler gin.HandlerFunc, header http.Header) *httptest.ResponseRecorder {
+	engine := gin.New()
+	engine.POST("/api/test", func(c *gin.Context) {
+		c.Set("idempotency_keys", suite.keys)
+		c.Set("trusted_proxies", suite.trustedProxies)
+		if principal != nil {
+			auth.SetPrincipal(c, principal)
+		}
+		idempotent, ok := startIdempotentRequest(c)
+		if !ok {
+			return
+		}
+		defer idempotent.finish()
+		suite.handled++
+		handler(c)
+	})
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodPost, "/api/test", strings.NewReader(`{ "name": "test" }`))
+	for name, values := range header {
+		request.Header[name] = values
+	}
+	request.Header.Set("Idempotency-Key", key)
+	engine.ServeHTTP(recorder, request)
+	return recorder
+}
+
+// lockedKey stores the key of the request which did not finish, as after
+// the crash of the service
+func (suite *IdempotencyKeysSuite) lockedKey(key string, lockedUntil time.Time) {
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/test", strings.NewReader(`{ "name": "test" }`))
+	c.Request.Header.Set("Idempotency-Key", key)
+	c.Set("idempotency_keys", suite.keys)
+	auth.SetPrincipal(c, testPrincipal)
+	idempotent, ok := startIdempotentRequest(c)
+	suite.Require().True(ok)
+	stored, err := suite.db.FindDocument(context.Background(), idempotent.key.Id)
+	suite.Require().NoError(err)
+	stored.LockedUntil = lockedUntil
+	suite.Require().NoError(suite.db.UpdateDocument(context.Background(), stored.Id, stored))
+}
+
+// expire moves the expiration of all stored keys to the past
+func (suite *IdempotencyKeysSuite) expire() {
+	page, err := suite.db.FindDocuments(context.Background(), db_service.Query{})
+	suite.Require().NoError(err)
+	for _, key := range page.Items {
+		key.ExpiresAt = time.Now().Add(-time.Minute)
+		suite.Require().NoError(suite.db.UpdateDocument(context.Background(), key.Id, key))
+	}
+}
+
+func (suite *IdempotencyKeysSuite) Test_StartIdempotentRequest_ReleasesKeyOfServerError() {
+	// ARRANGE
+	failed := suite.request("test-key", http.StatusBadGateway)
+
+	// ACT
+	retried := suite.request("test-key", http.StatusOK)
+
+	// ASSERT
+	suite.Equal(http.StatusBadGateway, failed.Code)
+	suite.Equal(http.StatusOK, retried.Code)
+	suite.Empty(retried.Header().Get("Idempotent-Replayed"))
+	suite.Equal(2, suite.handled)
+}
+
+func (suite *IdempotencyKeysSuite) Test_StartIdempotentRequest_ReleasesKeyOfConcurrentModification() {
+	// ARRANGE
+	conflicted := suite.requestAs(testPrincipal, "test-key", func(c *gin.Context) {
+		writeProblem(c, problemConcurrentModification, "", nil)
+	}, nil)
+	invalid := suite.requestAs(testPrincipal, "other-key", func(c *gin.Context) {
+		writeProblem(c, problemInvalidStateTransition, "", nil)
+	}, nil)
+
+	// ACT
+	retried := suite.request("test-key", http.StatusOK)
+	replayed := suite.request("other-key", http.StatusOK)
+
+	// ASSERT
+	suite.Equal(http.StatusConflict, conflicted.Code)
+	suite.Equal(http.StatusOK, retried.Code)
+	suite.Empty(retried.Header().Get("Idempotent-Replayed"))
+	suite.Equal(http.StatusConflict, invalid.Code)
+	suite.Equal(http.StatusConflict, replayed.Code)
+	suite.Equal("true", replayed.Header().Get("Idempotent-Replayed"))
+}
+
+func (suite *IdempotencyKeysSuite) Test_StartIdempotentRequest_TakesOverKeyAfterLeaseExpired() {
+	// ARRANGE
+	suite.lockedKey("locked-key", time.Now().Add(time.Minute))
+	suite.lockedKey("abandoned-key", time.Now().Add(-time.Second))
+
+	// ACT
+	locked := suite.request("locked-key", http.StatusOK)
+	abandoned := suite.request("abandoned-key", http.StatusOK)
+	replayed := suite.request("abandoned-key", http.StatusOK)
+
+	// ASSERT
+	suite.Equal(http.StatusConflict, locked.Code)
+	suite.Contains(locked.Body.String(), string(IDEMPOTENCY_KEY_IN_USE))
+	suite.Equal(http.StatusOK, abandoned.Code)
+	suite.Empty(abandoned.Header().Get("Idempotent-Replayed"))
+	suite.Equal("true", replayed.Header().Get("Idempotent-Replayed"))
+	suite.Equal(1, suite.handled)
+}
+
+func (suite *IdempotencyKeysSuite) Test_StartIdempotentRequest_ScopesKeyByPrincipal() {
+	// ARRANGE
+	respond := func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"handled": suite.handled})
+	}
+	doctor := &auth.Principal{Issuer: "test-issuer", Subject: "doctor", Username: "doctor"}
+	forwarded := http.Header{"X-Forwarded-User": {"nurse"}}
+	suite.Require().Equal(http.StatusOK, suite.requestAs(testPrincipal, "test-key", respond, nil).Code)
+
+	// ACT
+	byNurse := suite.requestAs(testPrincipal, "test-key", respond, nil)
+	byDoctor := suite.requestAs(doctor, "test-key", respond, nil)
+	anonymous := suite.requestAs(nil, "test-key", respond, nil)
+	forged := suite.requestAs(nil, "test-key", respond, forwarded)
+	suite.trustedProxies = []netip.Prefix{netip.MustParsePrefix("192.0.2.1/32")}
+	proxied := suite.requestAs(nil, "test-key", respond, forwarded)
+	proxiedAgain := suite.requestAs(nil, "test-key", respond, forwarded)
+
+	// ASSERT
+	suite.Equal("true", byNurse.Header().Get("Idempotent-Replayed"))
+	suite.JSONEq(`{ "handled": 1 }`, byNurse.Body.String())
+	suite.Empty(byDoctor.Header().Get("Idempotent-Replayed"))
+	suite.JSONEq(`{ "handled": 2 }`, byDoctor.Body.String())
+	suite.Equal(http.StatusBadRequest, anonymous.Code)
+	suite.Contains(anonymous.Body.String(), string(IDEMPOTENCY_KEY_ANONYMOUS))
+	suite.Equal(http.StatusBadRequest, forged.Code)
+	suite.Empty(proxied.Header().Get("Idempotent-Replayed"))
+	suite.JSONEq(`{ "handled": 3 }`, proxied.Body.String())
+	suite.Equal("true", proxiedAgain.Header().Get("Idempotent-Replayed"))
+	suite.Equal(3, suite.handled)
+}
+
+func (suite *IdempotencyKeysSuite) Test_StartIdempotentRequest_RenewsLeaseOfSlowRequest() {
+	// ARRANGE
+	suite.keys.lease = 30 * time.Millisecond
+	release := make(chan struct{})
+	started := make(chan struct{})
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		done <- suite.requestAs(testPrincipal, "slow-key", func(c *gin.Context) {
+			close(started)
+			<-release
+			c.JSON(http.StatusOK, gin.H{"handled": 1})
+		}, nil)
+	}()
+	<-started
+	// the request is processed longer than the lease
+	time.Sleep(5 * suite.keys.lease)
+
+	// ACT
+	retried := suite.request("slow-key", http.StatusOK)
+	close(release)
+	first := <-done
+
+	// ASSERT
+	suite.Equal(http.StatusConflict, retried.Code)
+	suite.Contains(retried.Body.String(), string(IDEMPOTENCY_KEY_IN_USE))
+	suite.Equal(http.StatusOK, first.Code)
+	suite.Equal(1, suite.handled)
+}
+
+func (suite *IdempotencyKeysSuite) Test_StartIdempotentRequest_ProcessesExpiredKeyAgain() {
+	// ARRANGE
+	suite.Require().Equal(http.StatusOK, suite.request("test-key", http.StatusOK).Code)
+	suite.expire()
+
+	// ACT
+	retried := suite.request("test-key", http.StatusOK)
+
+	// ASSERT
+	suite.Equal(http.StatusOK, retried.Code)
+	suite.JSONEq(`{ "handled": 2 }`, retried.Body.String())
+}
+
+func (suite *IdempotencyKeysSuite) Test_PurgeExpired_RemovesOnlyExpiredKeys() {
+	// ARRANGE
+	suite.Require().Equal(http.StatusOK, suite.request("expired-key", http.StatusOK).Code)
+	suite.expire()
+	suite.Require().Equal(http.StatusOK, suite.request("valid-key", http.StatusOK).Code)
+
+	// ACT
+	suite.keys.purgeExpired(context.Background())
+
+	// ASSERT
+	page, err := suite.db.FindDocuments(context.Background(), db_service.Query{})
+	suite.Require().NoError(err)
+	suite.Len(page.Items, 1)
+	replayed := suite.request("valid-key", http.StatusOK)
+	suite.Equal("true", replayed.Header().Get("Idempotent-Replayed"))
+	suite.JSONEq(`{ "handled": 2 }`, replayed.Body.String())
+}
+
+func (suite *IdempotencyKeysSuite) Test_NewIdempotencyKeys_RejectsInvalidRetention() {
+	// ARRANGE
+	suite.T().Setenv("AMBULANCE_API_IDEMPOTENCY_KEY_RETENTION", "one day")
+
+	// ACT
+	_, err := NewIdempotencyKeys(suite.db, IdempotencyKeysConfig{})
+
+	// ASSERT
+	suite.ErrorContains(err, "invalid idempotency key retention")
+}
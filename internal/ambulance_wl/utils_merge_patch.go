@@ -0,0 +1,60 @@
+package ambulance_wl
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// applyMergePatch returns the target document modified by the JSON merge patch
+// according to RFC 7386. The target object may be modified in place.
+func applyMergePatch(target interface{}, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		// non-object patch replaces the target
+		return patch
+	}
+
+	targetObject, ok := target.(map[string]interface{})
+	if !ok {
+		targetObject = map[string]interface{}{}
+	}
+
+	for name, value := range patchObject {
+		if value == nil {
+			delete(targetObject, name)
+		} else {
+			targetObject[name] = applyMergePatch(targetObject[name], value)
+		}
+	}
+	return targetObject
+}
+
+// mergePatchDocument returns copy of the document with the JSON merge patch applied
+func mergePatchDocument[DocType interface{}](document *DocType, patch []byte) (*DocType, error) {
+	var patchValue interface{}
+	if err := json.Unmarshal(patch, &patchValue); err != nil {
+		return nil, err
+	}
+	if _, ok := patchValue.(map[string]interface{}); !ok {
+		return nil, fmt.Errorf("merge patch must be a JSON object")
+	}
+
+	original, err := json.Marshal(document)
+	if err != nil {
+		return nil, err
+	}
+	var target interface{}
+	if err := json.Unmarshal(original, &target); err != nil {
+		return nil, err
+	}
+
+	patched, err := json.Marshal(applyMergePatch(target, patchValue))
+	if err != nil {
+		return nil, err
+	}
+	result := new(DocType)
+	if err := json.Unmarshal(patched, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -0,0 +1,448 @@
+package ambulance_wl
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// time allowed to write a message to the nurse console
+	nurseConsoleWriteTimeout = 10 * time.Second
+	// the console is disconnected if it does not respond to ping within this time
+	nurseConsolePongTimeout  = 60 * time.Second
+	nurseConsolePingInterval = nurseConsolePongTimeout * 9 / 10
+	// commands are small, larger messages are refused
+	nurseConsoleMaxMessageSize = 64 * 1024
+)
+
+// message types sent by the nurse console
+const (
+	nurseConsoleSubscribe   = "subscribe"
+	nurseConsoleUnsubscribe = "unsubscribe"
+	nurseConsoleCommand     = "command"
+)
+
+// message types sent to the nurse console
+const (
+	nurseConsoleResult       = "result"
+	nurseConsoleEvent        = "event"
+	nurseConsoleUnsubscribed = "unsubscribed"
+)
+
+// nurseConsoleRequest is the message sent by the nurse console. The id is
+// echoed in the result so that the console can pair it with the request.
+type nurseConsoleRequest struct {
+	Id          string `json:"id,omitempty"`
+	Type        string `json:"type"`
+	AmbulanceId string `json:"ambulanceId"`
+	// resumes the subscription after the event with this id
+	LastEventId string `json:"lastEventId,omitempty"`
+	Command     string `json:"command,omitempty"`
+	EntryId     string `json:"entryId,omitempty"`
+	ServerId    string `json:"serverId,omitempty"`
+	Priority    int32  `json:"priority,omitempty"`
+	// ETag of the entry the command modifies, passed in If-Match header
+	IfMatch string `json:"ifMatch,omitempty"`
+}
+
+// nurseConsoleMessage is the message sent to the nurse console, status and body
+// of the results are the same as the response of the corresponding REST call
+type nurseConsoleMessage struct {
+	Id          string            `json:"id,omitempty"`
+	Type        string            `json:"type"`
+	AmbulanceId string            `json:"ambulanceId,omitempty"`
+	Status      int               `json:"status,omitempty"`
+	Body        json.RawMessage   `json:"body,omitempty"`
+	Event       *WaitingListEvent `json:"event,omitempty"`
+}
+
+// nurseConsoleCommands maps the console commands to the waiting list API calls
+var nurseConsoleCommands = map[string]func(request nurseConsoleRequest) (method string, path string, body any){
+	"call-next": func(request nurseConsoleRequest) (string, string, any) {
+		path := "/next"
+		if request.ServerId != "" {
+			path += "?serverId=" + url.QueryEscape(request.ServerId)
+		}
+		return http.MethodPost, path, nil
+	},
+	"call":    entryTransitionCommand("call"),
+	"start":   entryTransitionCommand("start"),
+	"finish":  entryTransitionCommand("finish"),
+	"no-show": entryTransitionCommand("no-show"),
+	// moves the entry within the list by changing its priority
+	"reorder": func(request nurseConsoleRequest) (string, string, any) {
+		return http.MethodPut, entryPath(request), WaitingListEntry{Priority: request.Priority}
+	},
+}
+
+func entryTransitionCommand(action string) func(request nurseConsoleRequest) (string, string, any) {
+	return func(request nurseConsoleRequest) (string, string, any) {
+		return http.MethodPost, entryPath(request) + "/" + action, nil
+	}
+}
+
+func entryPath(request nurseConsoleRequest) string {
+	return "/entries/" + url.PathEscape(request.EntryId)
+}
+
+// NewNurseConsoleHandler returns the WebSocket endpoint of the nurse console. The
+// console subscribes to the changes of several ambulances over one connection and
+// issues commands which are dispatched to the router as the waiting list API
+// calls, so they are processed by the same middlewares and handlers. Browsers
+// may connect only from the pages of the service itself or of the allowed
+// origins, the console would be otherwise driven by any site the nurse visits.
+func NewNurseConsoleHandler(router http.Handler, allowedOrigins []string) gin.HandlerFunc {
+	upgrader := websocket.Upgrader{CheckOrigin: nurseConsoleOriginCheck(allowedOrigins)}
+	return func(c *gin.Context) {
+		logger := log.With().Str("method", "NurseConsole").Logger()
+
+		broker, ok := c.Value("waiting_list_events").(*WaitingListEventBroker)
+		if !ok {
+			logger.Error().Msg("waiting_list_events not found")
+			writeProblem(c, problemInternalError, "waiting_list_events not found", nil)
+			return
+		}
+
+		conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+		if err != nil {
+			// the upgrader already responded with the error
+			logger.Debug().Err(err).Msg("Failed to upgrade nurse console connection")
+			return
+		}
+
+		console := &nurseConsole{
+			conn:          conn,
+			router:        router,
+			broker:        broker,
+			request:       c.Request,
+			header:        dispatchedHeader(c.Request),
+			ctx:           c.Request.Context(),
+			logger:        logger,
+			subscriptions: map[string]*nurseConsoleSubscription{},
+		}
+		console.serve()
+	}
+}
+
+type nurseConsole struct {
+	conn   *websocket.Conn
+	router http.Handler
+	broker *WaitingListEventBroker
+	// upgrade request, the problems reported over the connection refer to it
+	request *http.Request
+	// headers of the upgrade request, e.g. credentials, forwarded to the dispatched calls
+	header http.Header
+	ctx    context.Context
+	logger zerolog.Logger
+
+	// websocket connection supports only one concurrent writer
+	writeLock sync.Mutex
+
+	subscriptionsLock sync.Mutex
+	subscriptions     map[string]*nurseConsoleSubscription
+}
+
+type nurseConsoleSubscription struct {
+	cancel func()
+}
+
+// nurseConsoleOriginCheck accepts the clients which are not browsers and do not
+// send the Origin header, the same origin pages, and the allowed origins
+func nurseConsoleOriginCheck(allowedOrigins []string) func(r *http.Request) bool {
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+		if parsed, err := url.Parse(origin); err == nil && strings.EqualFold(parsed.Host, r.Host) {
+			return true
+		}
+		return slices.ContainsFunc(allowedOrigins, func(allowed string) bool {
+			return strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin)
+		})
+	}
+}
+
+func (n *nurseConsole) serve() {
+	defer n.conn.Close()
+	defer n.unsubscribeAll()
+
+	n.conn.SetReadLimit(nurseConsoleMaxMessageSize)
+	n.conn.SetReadDeadline(time.Now().Add(nurseConsolePongTimeout))
+	n.conn.SetPongHandler(func(string) error {
+		return n.conn.SetReadDeadline(time.Now().Add(nurseConsolePongTimeout))
+	})
+
+	done := make(chan struct{})
+	defer close(done)
+	go n.ping(done)
+
+	n.logger.Debug().Msg("Nurse console connected")
+	for {
+		var request nurseConsoleRequest
+		_, data, err := n.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				n.logger.Warn().Err(err).Msg("Nurse console connection failed")
+			}
+			n.logger.Debug().Msg("Nurse console disconnected")
+			return
+		}
+		if err := json.Unmarshal(data, &request); err != nil {
+			n.replyError(request, problemInvalidBody, "Invalid message", err)
+			continue
+		}
+		if request.AmbulanceId == "" {
+			n.replyError(request, problemValidationFailed, "", fieldErrors{requiredField("/ambulanceId")})
+			continue
+		}
+
+		switch request.Type {
+		case nurseConsoleSubscribe:
+			n.subscribe(request)
+		case nurseConsoleUnsubscribe:
+			n.unsubscribe(request.AmbulanceId)
+			n.write(nurseConsoleMessage{
+				Id:          request.Id,
+				Type:        nurseConsoleResult,
+				AmbulanceId: request.AmbulanceId,
+				Status:      http.StatusOK,
+			})
+		case nurseConsoleCommand:
+			n.command(request)
+		default:
+			n.replyError(request, problemValidationFailed, "", fieldErrors{invalidField("/type", fmt.Sprintf("unknown message type %q", request.Type))})
+		}
+	}
+}
+
+func (n *nurseConsole) ping(done <-chan struct{}) {
+	ticker := time.NewTicker(nurseConsolePingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			n.writeLock.Lock()
+			err := n.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(nurseConsoleWriteTimeout))
+			n.writeLock.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// subscribe forwards the events of the ambulance to the console, the result
+// carries the current waiting list so that the console can render it
+func (n *nurseConsole) subscribe(request nurseConsoleRequest) {
+	status, body := n.dispatch(request.AmbulanceId, http.MethodGet, "/entries", nil, "")
+	if status != http.StatusOK {
+		n.write(nurseConsoleMessage{Id: request.Id, Type: nurseConsoleResult, AmbulanceId: request.AmbulanceId, Status: status, Body: body})
+		return
+	}
+
+	// subscription replaces the previous one of the same ambulance
+	n.unsubscribe(request.AmbulanceId)
+	replay, events, cancel := n.broker.Subscribe(request.AmbulanceId, request.LastEventId)
+	subscription := &nurseConsoleSubscription{cancel: cancel}
+	n.subscriptionsLock.Lock()
+	n.subscriptions[request.AmbulanceId] = subscription
+	n.subscriptionsLock.Unlock()
+
+	n.write(nurseConsoleMessage{Id: request.Id, Type: nurseConsoleResult, AmbulanceId: request.AmbulanceId, Status: status, Body: body})
+	for _, event := range replay {
+		n.writeEvent(event)
+	}
+
+	go func() {
+		for event := range events {
+			n.writeEvent(event)
+		}
+
+		// the broker closed the channel of the slow subscriber, the console has to subscribe again
+		n.subscriptionsLock.Lock()
+		dropped := n.subscriptions[request.AmbulanceId] == subscription
+		if dropped {
+			delete(n.subscriptions, request.AmbulanceId)
+		}
+		n.subscriptionsLock.Unlock()
+		if dropped {
+			n.write(nurseConsoleMessage{Type: nurseConsoleUnsubscribed, AmbulanceId: request.AmbulanceId})
+		}
+	}()
+}
+
+func (n *nurseConsole) unsubscribe(ambulanceId string) {
+	n.subscriptionsLock.Lock()
+	subscription, exists := n.subscriptions[ambulanceId]
+	delete(n.subscriptions, ambulanceId)
+	n.subscriptionsLock.Unlock()
+
+	if exists {
+		subscription.cancel()
+	}
+}
+
+func (n *nurseConsole) unsubscribeAll() {
+	n.subscriptionsLock.Lock()
+	subscriptions := n.subscriptions
+	n.subscriptions = map[string]*nurseConsoleSubscription{}
+	n.subscriptionsLock.Unlock()
+
+	for _, subscription := range subscriptions {
+		subscription.cancel()
+	}
+}
+
+func (n *nurseConsole) command(request nurseConsoleRequest) {
+	toCall, ok := nurseConsoleCommands[request.Command]
+	if !ok {
+		n.replyError(request, problemValidationFailed, "", fieldErrors{invalidField("/command", fmt.Sprintf("unknown command %q", request.Command))})
+		return
+	}
+	if request.Command != "call-next" && request.EntryId == "" {
+		n.replyError(request, problemValidationFailed, "", fieldErrors{requiredField("/entryId")})
+		return
+	}
+
+	method, path, body := toCall(request)
+	status, response := n.dispatch(request.AmbulanceId, method, path, body, request.IfMatch)
+	n.logger.Debug().
+		Str("command", request.Command).
+		Str("ambulanceId", request.AmbulanceId).
+		Int("status", status).
+		Msg("Nurse console command dispatched")
+	n.write(nurseConsoleMessage{
+		Id:          request.Id,
+		Type:        nurseConsoleResult,
+		AmbulanceId: request.AmbulanceId,
+		Status:      status,
+		Body:        response,
+	})
+}
+
+// dispatch calls the waiting list API of the ambulance and returns the status and
+// the JSON body of its response, the call is conditional if ifMatch is not empty
+func (n *nurseConsole) dispatch(ambulanceId string, method string, path string, body any, ifMatch string) (int, json.RawMessage) {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return n.problemBody(problemInternalError, "Failed to serialize command", err)
+		}
+	}
+
+	target := "/api/waiting-list/" + url.PathEscape(ambulanceId) + path
+	request, err := http.NewRequestWithContext(n.ctx, method, target, bytes.NewReader(payload))
+	if err != nil {
+		return n.problemBody(problemValidationFailed, "Invalid command", err)
+	}
+	request.Header = n.header.Clone()
+	// the forwarded headers are trusted only from the peer of the connection
+	request.RemoteAddr = n.request.RemoteAddr
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	if ifMatch != "" {
+		request.Header.Set("If-Match", ifMatch)
+	}
+
+	response := &dispatchedResponse{header: http.Header{}}
+	n.router.ServeHTTP(response, request)
+
+	if response.status == 0 {
+		response.status = http.StatusOK
+	}
+	if !json.Valid(response.body.Bytes()) {
+		return response.status, nil
+	}
+	return response.status, response.body.Bytes()
+}
+
+func (n *nurseConsole) writeEvent(event WaitingListEvent) {
+	n.write(nurseConsoleMessage{Type: nurseConsoleEvent, AmbulanceId: event.AmbulanceId, Event: &event})
+}
+
+func (n *nurseConsole) replyError(request nurseConsoleRequest, kind problemKind, detail string, err error) {
+	status, body := n.problemBody(kind, detail, err)
+	n.write(nurseConsoleMessage{
+		Id:          request.Id,
+		Type:        nurseConsoleResult,
+		AmbulanceId: request.AmbulanceId,
+		Status:      status,
+		Body:        body,
+	})
+}
+
+func (n *nurseConsole) write(message nurseConsoleMessage) {
+	n.writeLock.Lock()
+	defer n.writeLock.Unlock()
+
+	n.conn.SetWriteDeadline(time.Now().Add(nurseConsoleWriteTimeout))
+	if err := n.conn.WriteJSON(message); err != nil {
+		// failed connection is closed by the read loop
+		n.logger.Debug().Err(err).Msg("Failed to write to nurse console")
+	}
+}
+
+// problemBody returns the status and the problem details of the failed
+// console message, as the dispatched calls do for their failures
+func (n *nurseConsole) problemBody(kind problemKind, detail string, err error) (int, json.RawMessage) {
+	body, _ := json.Marshal(newProblem(n.request, kind, detail, err))
+	return kind.status, body
+}
+
+// dispatchedHeader returns headers of the upgrade request without those of the websocket handshake
+func dispatchedHeader(request *http.Request) http.Header {
+	dispatched := http.Header{}
+	for name, values := range request.Header {
+		switch {
+		case name == "Connection", name == "Upgrade", strings.HasPrefix(name, "Sec-Websocket-"):
+			continue
+		}
+		dispatched[name] = values
+	}
+	// browsers cannot set headers of the websocket handshake, bearer token of
+	// the query is passed to the dispatched calls in the Authorization header
+	if token := request.URL.Query().Get("access_token"); token != "" && dispatched.Get("Authorization") == "" {
+		dispatched.Set("Authorization", "Bearer "+token)
+	}
+	return dispatched
+}
+
+// dispatchedResponse collects the response of the dispatched API call
+type dispatchedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *dispatchedResponse) Header() http.Header {
+	return r.header
+}
+
+func (r *dispatchedResponse) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+func (r *dispatchedResponse) Write(data []byte) (int, error) {
+	r.WriteHeader(http.StatusOK)
+	return r.body.Write(data)
+}
@@ -0,0 +1,307 @@
+package ambulance_wl
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// ResponseValidation tells how the responses violating the API contract are handled
+type ResponseValidation string
+
+const (
+	// responses are not validated
+	ResponseValidationOff ResponseValidation = "off"
+	// violations are logged, the responses are sent unchanged
+	ResponseValidationLog ResponseValidation = "log"
+	// violating responses are replaced by the internal error, for tests and staging
+	ResponseValidationFail ResponseValidation = "fail"
+)
+
+type OpenApiValidatorConfig struct {
+	// handling of the responses violating the API contract, responses are
+	// not validated by default
+	Responses ResponseValidation
+}
+
+// OpenApiValidator validates the requests, and optionally the responses, of
+// the operations described by the OpenAPI specification of the service
+type OpenApiValidator struct {
+	OpenApiValidatorConfig
+	router  routers.Router
+	options openapi3filter.Options
+	logger  zerolog.Logger
+}
+
+// NewOpenApiValidator completes the configuration from the environment
+// variables and loads the specification
+func NewOpenApiValidator(spec []byte, config OpenApiValidatorConfig) (*OpenApiValidator, error) {
+	if config.Responses == "" {
+		config.Responses = ResponseValidation(strings.ToLower(os.Getenv("AMBULANCE_API_RESPONSE_VALIDATION")))
+	}
+	switch config.Responses {
+	case "":
+		config.Responses = ResponseValidationOff
+	case ResponseValidationOff, ResponseValidationLog, ResponseValidationFail:
+	default:
+		return nil, fmt.Errorf("unknown response validation %q, expected off, log, or fail", config.Responses)
+	}
+
+	loader := openapi3.NewLoader()
+	doc, err := loader.LoadFromData(spec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load OpenAPI specification: %w", err)
+	}
+	// examples are only illustrative, some of them are intentionally partial
+	if err := doc.Validate(loader.Context, openapi3.DisableExamplesValidation()); err != nil {
+		return nil, fmt.Errorf("invalid OpenAPI specification: %w", err)
+	}
+	router, err := gorillamux.NewRouter(doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to route OpenAPI specification: %w", err)
+	}
+
+	return &OpenApiValidator{
+		OpenApiValidatorConfig: config,
+		router:                 router,
+		options: openapi3filter.Options{
+			// requests are authenticated and authorized by the preceding middlewares
+			AuthenticationFunc: openapi3filter.NoopAuthenticationFunc,
+			// clients may send back the resources they received, read only
+			// properties are ignored by the handlers
+			ExcludeReadOnlyValidations: true,
+			// request body is passed to the handlers as it was sent
+			SkipSettingDefaults:   true,
+			IncludeResponseStatus: true,
+			MultiError:            true,
+			SchemaValidationOptions: []openapi3.SchemaValidationOption{
+				openapi3.WithStringFormatValidator("uri", absoluteUrlFormat),
+				openapi3.WithStringFormatValidator("url", absoluteUrlFormat),
+			},
+		},
+		logger: log.With().Str("component", "openapi-validator").Logger(),
+	}, nil
+}
+
+var absoluteUrlFormat = openapi3.NewCallbackValidator(func(value string) error {
+	parsed, err := url.Parse(value)
+	if err != nil {
+		return err
+	}
+	if !parsed.IsAbs() || parsed.Host == "" {
+		return errors.New("expected absolute URL")
+	}
+	return nil
+})
+
+// Middleware rejects the requests violating the specification with the
+// problem details. Requests of the paths not described by the specification,
+// e.g. the nurse console, are passed unchanged.
+func (v *OpenApiValidator) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route, pathParams, err := v.router.FindRoute(c.Request)
+		if err != nil {
+			c.Next()
+			return
+		}
+
+		input := &openapi3filter.RequestValidationInput{
+			Request:    c.Request,
+			PathParams: pathParams,
+			Route:      route,
+			Options:    &v.options,
+		}
+		if err := openapi3filter.ValidateRequest(c.Request.Context(), input); err != nil {
+			v.logger.Debug().
+				Err(err).
+				Str("method", c.Request.Method).
+				Str("path", route.Path).
+				Msg("Request violates the API contract")
+			kind, detail, violation := describeRequestViolation(err)
+			abortWithProblem(c, kind, detail, violation)
+			return
+		}
+
+		if v.Responses == ResponseValidationOff || streamsEvents(route.Operation) {
+			c.Next()
+			return
+		}
+		v.validateResponse(c, input)
+	}
+}
+
+// validateResponse records the response of the handlers and sends it once it
+// is validated
+func (v *OpenApiValidator) validateResponse(c *gin.Context, input *openapi3filter.RequestValidationInput) {
+	writer := c.Writer
+	recorded := &recordedResponse{ResponseWriter: writer, status: http.StatusOK}
+	c.Writer = recorded
+	c.Next()
+	c.Writer = writer
+
+	err := openapi3filter.ValidateResponse(c.Request.Context(), &openapi3filter.ResponseValidationInput{
+		RequestValidationInput: input,
+		Status:                 recorded.status,
+		Header:                 writer.Header(),
+		Body:                   io.NopCloser(bytes.NewReader(recorded.body.Bytes())),
+		Options:                &v.options,
+	})
+	if err != nil {
+		v.logger.Warn().
+			Err(err).
+			Str("method", c.Request.Method).
+			Str("path", input.Route.Path).
+			Int("status", recorded.status).
+			Msg("Response violates the API contract")
+		if v.Responses == ResponseValidationFail {
+			writer.Header().Del("Content-Length")
+			writeProblem(c, problemInternalError, "Response violates the API contract", err)
+			return
+		}
+	}
+
+	writer.WriteHeader(recorded.status)
+	writer.Write(recorded.body.Bytes())
+}
+
+// streamsEvents tells if the operation responds with the stream of the server
+// sent events, the stream cannot be recorded
+func streamsEvents(operation *openapi3.Operation) bool {
+	for _, response := range operation.Responses.Map() {
+		if response.Value != nil && response.Value.Content.Get("text/event-stream") != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// describeRequestViolation maps the validation errors to the problem, the
+// properties of the body violating its schema are reported as the field errors
+func describeRequestViolation(err error) (problemKind, string, error) {
+	kind := problemValidationFailed
+	details := []string{}
+	invalid := fieldErrors{}
+
+	var visit func(err error)
+	visit = func(err error) {
+		multiError, isMultiError := err.(openapi3.MultiError)
+		requestError, isRequestError := err.(*openapi3filter.RequestError)
+		switch {
+		case isMultiError:
+			for _, nested := range multiError {
+				visit(nested)
+			}
+		case !isRequestError || requestError.RequestBody == nil:
+			if isRequestError && requestError.Parameter != nil &&
+				requestError.Parameter.In == openapi3.ParameterInQuery && kind == problemValidationFailed {
+				kind = problemInvalidQuery
+			}
+			details = append(details, err.Error())
+		case requestError.Err == nil && strings.HasPrefix(requestError.Reason, "header Content-Type"):
+			kind = problemUnsupportedMediaType
+			details = append(details, err.Error())
+		case strings.HasPrefix(requestError.Reason, "doesn't match schema"):
+			invalid = append(invalid, schemaFieldErrors(requestError.Err)...)
+		default:
+			if kind != problemUnsupportedMediaType {
+				kind = problemInvalidBody
+			}
+			details = append(details, err.Error())
+		}
+	}
+	visit(err)
+
+	if len(invalid) == 0 {
+		return kind, strings.Join(details, "; "), nil
+	}
+	return kind, strings.Join(details, "; "), invalid
+}
+
+// schemaFieldErrors lists the properties violating the schema by their JSON
+// Pointers, errors not related to a property are reported for the whole body
+func schemaFieldErrors(err error) fieldErrors {
+	if multiError, ok := err.(openapi3.MultiError); ok {
+		invalid := fieldErrors{}
+		for _, nested := range multiError {
+			invalid = append(invalid, schemaFieldErrors(nested)...)
+		}
+		return invalid
+	}
+	var schemaError *openapi3.SchemaError
+	if !errors.As(err, &schemaError) {
+		return fieldErrors{invalidField("", err.Error())}
+	}
+	return fieldErrors{schemaFieldError(schemaError)}
+}
+
+// schemaFieldError reports the property violating the schema by its JSON Pointer
+func schemaFieldError(err *openapi3.SchemaError) ProblemFieldError {
+	field := ""
+	for _, token := range err.JSONPointer() {
+		field += "/" + strings.NewReplacer("~", "~0", "/", "~1").Replace(token)
+	}
+	if err.SchemaField == "required" {
+		return requiredField(field)
+	}
+	return invalidField(field, err.Reason)
+}
+
+// recordedResponse keeps the response of the handlers until it is validated
+type recordedResponse struct {
+	gin.ResponseWriter
+	status  int
+	written bool
+	body    bytes.Buffer
+}
+
+func (r *recordedResponse) WriteHeader(status int) {
+	if status > 0 && !r.written {
+		r.status = status
+	}
+}
+
+func (r *recordedResponse) WriteHeaderNow() {
+	r.written = true
+}
+
+func (r *recordedResponse) Write(data []byte) (int, error) {
+	r.written = true
+	return r.body.Write(data)
+}
+
+func (r *recordedResponse) WriteString(data string) (int, error) {
+	r.written = true
+	return r.body.WriteString(data)
+}
+
+func (r *recordedResponse) Status() int {
+	return r.status
+}
+
+func (r *recordedResponse) Size() int {
+	if !r.written {
+		return -1
+	}
+	return r.body.Len()
+}
+
+func (r *recordedResponse) Written() bool {
+	return r.written
+}
+
+// Flush is ignored, the response is sent at once after the validation
+func (r *recordedResponse) Flush() {
+}
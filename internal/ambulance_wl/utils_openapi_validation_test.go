@@ -0,0 +1,168 @@
+package ambulance_wl
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/suite"
+	"github.com/wac-fiit/cv2-ambulance-webapi/api"
+)
+
+type OpenApiValidationSuite struct {
+	suite.Suite
+	handled bool
+}
+
+func TestOpenApiValidationSuite(t *testing.T) {
+	suite.Run(t, new(OpenApiValidationSuite))
+}
+
+func (suite *OpenApiValidationSuite) SetupTest() {
+	gin.SetMode(gin.TestMode)
+	suite.handled = false
+}
+
+// router serves the operations of the specification by the stub handlers,
+// the ambulance is served with the body violating its schema
+func (suite *OpenApiValidationSuite) router(responses ResponseValidation) *gin.Engine {
+	validator, err := NewOpenApiValidator(api.Spec(), OpenApiValidatorConfig{Responses: responses})
+	suite.Require().NoError(err)
+
+	engine := gin.New()
+	engine.Use(validator.Middleware())
+	handler := func(c *gin.Context) {
+		suite.handled = true
+		c.Status(http.StatusCreated)
+	}
+	engine.POST("/api/ambulance", handler)
+	engine.POST("/api/waiting-list/:ambulanceId/entries", handler)
+	engine.GET("/api/audit", handler)
+	engine.GET("/api/ambulance/:ambulanceId", func(c *gin.Context) {
+		suite.handled = true
+		c.JSON(http.StatusOK, gin.H{"id": c.Param("ambulanceId"), "name": 42})
+	})
+	engine.GET("/api/unspecified", handler)
+	return engine
+}
+
+func (suite *OpenApiValidationSuite) request(router *gin.Engine, method string, path string, contentType string, body string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(method, path, strings.NewReader(body))
+	if contentType != "" {
+		request.Header.Set("Content-Type", contentType)
+	}
+	router.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func (suite *OpenApiValidationSuite) problem(response *httptest.ResponseRecorder) Problem {
+	suite.Equal(problemContentType, response.Header().Get("Content-Type"))
+	var problem Problem
+	suite.Require().NoError(json.Unmarshal(response.Body.Bytes(), &problem))
+	return problem
+}
+
+func (suite *OpenApiValidationSuite) Test_Middleware_PassesValidRequest() {
+	// ARRANGE
+	router := suite.router(ResponseValidationOff)
+
+	// ACT
+	response := suite.request(router, http.MethodPost, "/api/waiting-list/test-ambulance/entries", "application/json",
+		`{ "patientId": "test-patient", "waitingSince": "2038-12-24T10:05:00Z", "priority": 2 }`)
+
+	// ASSERT
+	suite.Equal(http.StatusCreated, response.Code)
+	suite.True(suite.handled)
+}
+
+func (suite *OpenApiValidationSuite) Test_Middleware_ReportsInvalidProperties() {
+	// ARRANGE
+	router := suite.router(ResponseValidationOff)
+
+	// ACT
+	response := suite.request(router, http.MethodPost, "/api/waiting-list/test-ambulance/entries", "application/json",
+		`{ "waitingSince": "yesterday", "priority": 7, "condition": { "value": "Teploty", "reference": "zdravoteka" } }`)
+
+	// ASSERT
+	suite.Equal(http.StatusBadRequest, response.Code)
+	suite.False(suite.handled)
+	problem := suite.problem(response)
+	suite.Equal(VALIDATION_FAILED, problem.Code)
+	fields := map[string]string{}
+	for _, fieldError := range problem.Errors {
+		fields[fieldError.Field] = fieldError.Code
+	}
+	suite.Equal(map[string]string{
+		"/patientId":           "required",
+		"/waitingSince":        "invalid",
+		"/priority":            "invalid",
+		"/condition/reference": "invalid",
+	}, fields)
+}
+
+func (suite *OpenApiValidationSuite) Test_Middleware_RejectsMalformedRequests() {
+	// ARRANGE
+	router := suite.router(ResponseValidationOff)
+
+	// ACT
+	mediaType := suite.request(router, http.MethodPost, "/api/ambulance", "text/plain", "ambulance")
+	malformed := suite.request(router, http.MethodPost, "/api/ambulance", "application/json", `{ "id": `)
+	query := suite.request(router, http.MethodGet, "/api/audit?from=yesterday", "", "")
+
+	// ASSERT
+	suite.Equal(http.StatusUnsupportedMediaType, mediaType.Code)
+	suite.Equal(UNSUPPORTED_MEDIA_TYPE, suite.problem(mediaType).Code)
+	suite.Equal(http.StatusBadRequest, malformed.Code)
+	suite.Equal(INVALID_BODY, suite.problem(malformed).Code)
+	suite.Equal(http.StatusBadRequest, query.Code)
+	suite.Equal(INVALID_QUERY, suite.problem(query).Code)
+	suite.False(suite.handled)
+}
+
+func (suite *OpenApiValidationSuite) Test_Middleware_PassesUnspecifiedPaths() {
+	// ARRANGE
+	router := suite.router(ResponseValidationFail)
+
+	// ACT
+	response := suite.request(router, http.MethodGet, "/api/unspecified", "", "")
+
+	// ASSERT
+	suite.Equal(http.StatusCreated, response.Code)
+	suite.True(suite.handled)
+}
+
+func (suite *OpenApiValidationSuite) Test_Middleware_ValidatesResponses() {
+	// ARRANGE
+	logged := suite.router(ResponseValidationLog)
+	failed := suite.router(ResponseValidationFail)
+	unchecked := suite.router(ResponseValidationOff)
+
+	// ACT
+	loggedResponse := suite.request(logged, http.MethodGet, "/api/ambulance/test-ambulance", "", "")
+	failedResponse := suite.request(failed, http.MethodGet, "/api/ambulance/test-ambulance", "", "")
+	uncheckedResponse := suite.request(unchecked, http.MethodGet, "/api/ambulance/test-ambulance", "", "")
+
+	// ASSERT
+	suite.Equal(http.StatusOK, loggedResponse.Code)
+	suite.JSONEq(`{ "id": "test-ambulance", "name": 42 }`, loggedResponse.Body.String())
+	suite.Equal(http.StatusOK, uncheckedResponse.Code)
+	suite.Equal(http.StatusInternalServerError, failedResponse.Code)
+	problem := suite.problem(failedResponse)
+	suite.Equal(INTERNAL_ERROR, problem.Code)
+	suite.Contains(problem.Detail, "Response violates the API contract")
+}
+
+func (suite *OpenApiValidationSuite) Test_NewOpenApiValidator_RejectsUnknownResponseValidation() {
+	// ARRANGE
+	suite.T().Setenv("AMBULANCE_API_RESPONSE_VALIDATION", "sometimes")
+
+	// ACT
+	_, err := NewOpenApiValidator(api.Spec(), OpenApiValidatorConfig{})
+
+	// ASSERT
+	suite.ErrorContains(err, "unknown response validation")
+}
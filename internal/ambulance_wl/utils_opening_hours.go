@@ -0,0 +1,227 @@
+package ambulance_wl
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+// how far ahead the scheduler looks for the next opening of the ambulance
+const maxScheduleLookaheadDays = 366
+
+var weekdays = map[DayOfWeek]time.Weekday{
+	MONDAY:    time.Monday,
+	TUESDAY:   time.Tuesday,
+	WEDNESDAY: time.Wednesday,
+	THURSDAY:  time.Thursday,
+	FRIDAY:    time.Friday,
+	SATURDAY:  time.Saturday,
+	SUNDAY:    time.Sunday,
+}
+
+// clockInterval is interval within a day in minutes since midnight, the end is exclusive
+type clockInterval struct {
+	from int
+	to   int
+}
+
+// openingSchedule answers when the ambulance is open, nil schedule means always open
+type openingSchedule struct {
+	location *time.Location
+	// opening intervals without breaks ordered by their start, empty if opened all day
+	weekly   map[time.Weekday][]clockInterval
+	closures []timeRange
+}
+
+// timeRange is interval of time with exclusive end, e.g. closure spans from
+// the midnight of its first day to the midnight after its last day
+type timeRange struct {
+	from time.Time
+	to   time.Time
+}
+
+func (r timeRange) overlaps(other timeRange) bool {
+	return r.from.Before(other.to) && other.from.Before(r.to)
+}
+
+func (r timeRange) overlapsAny(others []timeRange) bool {
+	return slices.ContainsFunc(others, r.overlaps)
+}
+
+// openingSchedule builds the schedule from the ambulance settings, it returns nil
+// if the ambulance has neither opening hours nor closures
+func (a *Ambulance) openingSchedule() (*openingSchedule, error) {
+	if len(a.OpeningHours) == 0 && len(a.Closures) == 0 {
+		return nil, nil
+	}
+
+	location := time.UTC
+	if a.TimeZone != "" {
+		var err error
+		if location, err = time.LoadLocation(a.TimeZone); err != nil {
+			return nil, fmt.Errorf("invalid time zone %q: %w", a.TimeZone, err)
+		}
+	}
+
+	schedule := &openingSchedule{
+		location: location,
+		weekly:   map[time.Weekday][]clockInterval{},
+	}
+
+	for _, hours := range a.OpeningHours {
+		weekday, ok := weekdays[hours.DayOfWeek]
+		if !ok {
+			return nil, fmt.Errorf("invalid day of week %q", hours.DayOfWeek)
+		}
+		opening, err := parseClockInterval(hours.From, hours.To)
+		if err != nil {
+			return nil, err
+		}
+
+		intervals := []clockInterval{opening}
+		for _, pause := range hours.Breaks {
+			breakInterval, err := parseClockInterval(pause.From, pause.To)
+			if err != nil {
+				return nil, err
+			}
+			intervals = breakInterval.subtractFrom(intervals)
+		}
+		schedule.weekly[weekday] = append(schedule.weekly[weekday], intervals...)
+	}
+	for weekday := range schedule.weekly {
+		slices.SortFunc(schedule.weekly[weekday], func(left, right clockInterval) int {
+			return left.from - right.from
+		})
+	}
+
+	for _, closure := range a.Closures {
+		from, err := time.ParseInLocation(time.DateOnly, closure.From, location)
+		if err != nil {
+			return nil, fmt.Errorf("invalid closure start %q: %w", closure.From, err)
+		}
+		to, err := time.ParseInLocation(time.DateOnly, closure.To, location)
+		if err != nil {
+			return nil, fmt.Errorf("invalid closure end %q: %w", closure.To, err)
+		}
+		if to.Before(from) {
+			return nil, fmt.Errorf("closure %s - %s ends before it starts", closure.From, closure.To)
+		}
+		schedule.closures = append(schedule.closures, timeRange{from: from, to: to.AddDate(0, 0, 1)})
+	}
+	return schedule, nil
+}
+
+// nextStart returns the earliest time not before the earliest when the visit of
+// the given duration can start. The visit is postponed to the next opening if it
+// does not fit before the closing or break, unless it does not fit into the
+// whole opening interval either.
+func (s *openingSchedule) nextStart(earliest time.Time, duration time.Duration) time.Time {
+	if s == nil {
+		return earliest
+	}
+
+	local := earliest.In(s.location)
+	day := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, s.location)
+	for i := 0; i < maxScheduleLookaheadDays; i++ {
+		for _, interval := range s.openingsOn(day.AddDate(0, 0, i)) {
+			if !interval.to.After(earliest) {
+				continue
+			}
+			start := interval.from
+			if start.Before(earliest) {
+				start = earliest
+			}
+			if !start.Add(duration).After(interval.to) || start.Equal(interval.from) {
+				return start
+			}
+		}
+	}
+	// no opening found, keep the estimate continuous
+	return earliest
+}
+
+// nextFreeStart returns the earliest start of the visit within the opening hours
+// which does not overlap with any of the reserved intervals
+func (s *openingSchedule) nextFreeStart(reserved []timeRange, earliest time.Time, duration time.Duration) time.Time {
+	start := earliest
+	// every iteration moves the start past one of the reservations
+	for attempt := 0; attempt <= len(reserved); attempt++ {
+		start = s.nextStart(start, duration)
+		visit := timeRange{from: start, to: start.Add(duration)}
+		reservationIndx := slices.IndexFunc(reserved, visit.overlaps)
+		if reservationIndx < 0 {
+			return start
+		}
+		start = reserved[reservationIndx].to
+	}
+	return s.nextStart(start, duration)
+}
+
+// openingsOn returns the opening intervals on the day starting at the given midnight
+func (s *openingSchedule) openingsOn(day time.Time) []timeRange {
+	for _, closure := range s.closures {
+		if !day.Before(closure.from) && day.Before(closure.to) {
+			return nil
+		}
+	}
+
+	intervals := []clockInterval{{from: 0, to: 24 * 60}}
+	if len(s.weekly) > 0 {
+		intervals = s.weekly[day.Weekday()]
+	}
+
+	openings := make([]timeRange, 0, len(intervals))
+	for _, interval := range intervals {
+		// time.Date normalizes minutes overflowing the hour, which also respects DST changes
+		openings = append(openings, timeRange{
+			from: time.Date(day.Year(), day.Month(), day.Day(), 0, interval.from, 0, 0, s.location),
+			to:   time.Date(day.Year(), day.Month(), day.Day(), 0, interval.to, 0, 0, s.location),
+		})
+	}
+	return openings
+}
+
+func parseClockInterval(from string, to string) (clockInterval, error) {
+	start, err := parseClock(from)
+	if err != nil {
+		return clockInterval{}, err
+	}
+	end, err := parseClock(to)
+	if err != nil {
+		return clockInterval{}, err
+	}
+	if end <= start {
+		return clockInterval{}, fmt.Errorf("interval %s - %s ends before it starts", from, to)
+	}
+	return clockInterval{from: start, to: end}, nil
+}
+
+// parseClock returns minutes since midnight of the time in the HH:MM format
+func parseClock(value string) (int, error) {
+	if value == "24:00" {
+		return 24 * 60, nil
+	}
+	clock, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", value)
+	}
+	return clock.Hour()*60 + clock.Minute(), nil
+}
+
+// subtractFrom removes the interval from each of the intervals
+func (c clockInterval) subtractFrom(intervals []clockInterval) []clockInterval {
+	result := []clockInterval{}
+	for _, interval := range intervals {
+		if c.to <= interval.from || c.from >= interval.to {
+			result = append(result, interval)
+			continue
+		}
+		if c.from > interval.from {
+			result = append(result, clockInterval{from: interval.from, to: c.from})
+		}
+		if c.to < interval.to {
+			result = append(result, clockInterval{from: c.to, to: interval.to})
+		}
+	}
+	return result
+}
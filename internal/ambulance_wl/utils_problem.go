@@ -0,0 +1,122 @@
+package ambulance_wl
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const problemContentType = "application/problem+json"
+
+// prefix of the problem type URIs, the type is identified by the problem code
+const problemTypePrefix = "urn:ambulance-wl:problem:"
+
+// problemKind describes the problem type, all problems of the type share the
+// status and title
+type problemKind struct {
+	code   ProblemCode
+	status int
+	title  string
+}
+
+var (
+	problemInternalError           = problemKind{INTERNAL_ERROR, http.StatusInternalServerError, "Internal server error"}
+	problemInvalidBody             = problemKind{INVALID_BODY, http.StatusBadRequest, "Invalid request body"}
+	problemUnsupportedMediaType    = problemKind{UNSUPPORTED_MEDIA_TYPE, http.StatusUnsupportedMediaType, "Unsupported media type of the request body"}
+	problemValidationFailed        = problemKind{VALIDATION_FAILED, http.StatusBadRequest, "Request violates constraints of the API"}
+	problemInvalidQuery            = problemKind{INVALID_QUERY, http.StatusBadRequest, "Invalid query parameters"}
+	problemAmbulanceNotFound       = problemKind{AMBULANCE_NOT_FOUND, http.StatusNotFound, "Ambulance not found"}
+	problemEntryNotFound           = problemKind{ENTRY_NOT_FOUND, http.StatusNotFound, "Waiting list entry not found"}
+	problemWebhookNotFound         = problemKind{WEBHOOK_NOT_FOUND, http.StatusNotFound, "Webhook not found"}
+	problemDeadLetterNotFound      = problemKind{DEAD_LETTER_NOT_FOUND, http.StatusNotFound, "Dead letter not found"}
+	problemApiKeyNotFound          = problemKind{API_KEY_NOT_FOUND, http.StatusNotFound, "API key not found"}
+	problemAmbulanceExists         = problemKind{AMBULANCE_EXISTS, http.StatusConflict, "Ambulance already exists"}
+	problemEntryExists             = problemKind{ENTRY_EXISTS, http.StatusConflict, "Waiting list entry already exists"}
+	problemWebhookExists           = problemKind{WEBHOOK_EXISTS, http.StatusConflict, "Webhook already exists"}
+	problemConcurrentModification  = problemKind{CONCURRENT_MODIFICATION, http.StatusConflict, "Resource was modified concurrently"}
+	problemInvalidStateTransition  = problemKind{INVALID_STATE_TRANSITION, http.StatusConflict, "Invalid state transition of the entry"}
+	problemAppointmentUnavailable  = problemKind{APPOINTMENT_UNAVAILABLE, http.StatusConflict, "Appointment slot is not available"}
+	problemApiKeyRevoked           = problemKind{API_KEY_REVOKED, http.StatusConflict, "API key is revoked"}
+	problemPreconditionFailed      = problemKind{PRECONDITION_FAILED, http.StatusPreconditionFailed, "Resource was modified since it was retrieved"}
+	problemPreconditionRequired    = problemKind{PRECONDITION_REQUIRED, http.StatusPreconditionRequired, "If-Match header is required to modify the resource"}
+	problemIdempotencyKeyReused    = problemKind{IDEMPOTENCY_KEY_REUSED, http.StatusUnprocessableEntity, "Idempotency key was used for a different request"}
+	problemIdempotencyKeyInUse     = problemKind{IDEMPOTENCY_KEY_IN_USE, http.StatusConflict, "Request with the same idempotency key is being processed"}
+	problemIdempotencyKeyAnonymous = problemKind{IDEMPOTENCY_KEY_ANONYMOUS, http.StatusBadRequest, "Idempotency key requires an identified client"}
+	problemDatabaseError           = problemKind{DATABASE_ERROR, http.StatusBadGateway, "Database operation failed"}
+	problemInvalidCredentials      = problemKind{INVALID_CREDENTIALS, http.StatusUnauthorized, "Invalid credentials"}
+	problemAmbulanceNotAssigned    = problemKind{AMBULANCE_NOT_ASSIGNED, http.StatusForbidden, "User is not assigned to the ambulance"}
+	problemForbidden               = problemKind{FORBIDDEN, http.StatusForbidden, "Roles of the user do not permit the operation"}
+)
+
+// fieldErrors lists the invalid properties of the request body, they are
+// reported in the errors of the problem
+type fieldErrors []ProblemFieldError
+
+func (e fieldErrors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, fieldError := range e {
+		messages = append(messages, fieldError.Field+": "+fieldError.Message)
+	}
+	return strings.Join(messages, "; ")
+}
+
+func requiredField(field string) ProblemFieldError {
+	return ProblemFieldError{Field: field, Code: "required", Message: "missing required property"}
+}
+
+func invalidField(field string, message string) ProblemFieldError {
+	return ProblemFieldError{Field: field, Code: "invalid", Message: message}
+}
+
+// newProblem describes the failed request, the detail explains this
+// occurrence of the problem and is completed by the error if any. Invalid
+// properties are taken from the fieldErrors of the error.
+func newProblem(request *http.Request, kind problemKind, detail string, err error) *Problem {
+	problem := &Problem{
+		Type:     problemTypePrefix + string(kind.code),
+		Title:    kind.title,
+		Status:   int32(kind.status),
+		Detail:   detail,
+		Instance: request.URL.Path,
+		Code:     kind.code,
+	}
+	if err != nil {
+		if problem.Detail != "" {
+			problem.Detail += ": "
+		}
+		problem.Detail += err.Error()
+	}
+	var invalid fieldErrors
+	if errors.As(err, &invalid) {
+		problem.Errors = invalid
+	}
+	if spanContext := trace.SpanContextFromContext(request.Context()); spanContext.HasTraceID() {
+		problem.TraceId = spanContext.TraceID().String()
+	}
+	return problem
+}
+
+// writeProblem responds with the problem details of the failed request
+func writeProblem(c *gin.Context, kind problemKind, detail string, err error) {
+	renderProblem(c, newProblem(c.Request, kind, detail, err))
+}
+
+// abortWithProblem responds with the problem details and stops the
+// processing of the request by the following handlers
+func abortWithProblem(c *gin.Context, kind problemKind, detail string, err error) {
+	c.Abort()
+	writeProblem(c, kind, detail, err)
+}
+
+func renderProblem(c *gin.Context, problem *Problem) {
+	c.Header("Content-Type", problemContentType)
+	c.JSON(int(problem.Status), problem)
+}
+
+// rejectUpdate is returned by the ambulance updaters which refuse the update
+func rejectUpdate(c *gin.Context, kind problemKind, detail string, err error) (*Ambulance, interface{}, int) {
+	return nil, newProblem(c.Request, kind, detail, err), kind.status
+}
@@ -0,0 +1,114 @@
+package ambulance_wl
+
+import (
+	"slices"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wac-fiit/cv2-ambulance-webapi/internal/auth"
+)
+
+const (
+	permissionAmbulancesRead   = "ambulances:read"
+	permissionAmbulancesWrite  = "ambulances:write"
+	permissionAmbulancesDelete = "ambulances:delete"
+	// users without this permission may access only the ambulances listed in
+	// the ambulances claim of their token
+	permissionAmbulancesAll   = "ambulances:all"
+	permissionConditionsRead  = "conditions:read"
+	permissionWaitingListRead = "waiting-list:read"
+	permissionWaitingList     = "waiting-list:write"
+	permissionFinishVisit     = "waiting-list:finish"
+	permissionAuditRead       = "audit:read"
+	permissionWebhooks        = "webhooks:manage"
+	permissionApiKeys         = "api-keys:manage"
+)
+
+// claim of the token listing the ambulances the user is assigned to
+const assignedAmbulancesClaim = "ambulances"
+
+// permissions required by the operations of the API, keyed by the route name
+var routePermissions = map[string]string{
+	"GetConditions":              permissionConditionsRead,
+	"BookAppointment":            permissionWaitingList,
+	"CallNextWaitingListEntry":   permissionWaitingList,
+	"CallWaitingListEntry":       permissionWaitingList,
+	"CancelAppointment":          permissionWaitingList,
+	"CreateWaitingListEntry":     permissionWaitingList,
+	"DeleteWaitingListEntry":     permissionWaitingList,
+	"FinishWaitingListEntry":     permissionFinishVisit,
+	"GetWaitingListEntries":      permissionWaitingListRead,
+	"GetWaitingListEntry":        permissionWaitingListRead,
+	"GetWaitingListEvents":       permissionWaitingListRead,
+	"MarkWaitingListEntryNoShow": permissionWaitingList,
+	"StartWaitingListEntry":      permissionWaitingList,
+	"UpdateWaitingListEntry":     permissionWaitingList,
+	"CreateAmbulance":            permissionAmbulancesWrite,
+	"DeleteAmbulance":            permissionAmbulancesDelete,
+	"GetAmbulance":               permissionAmbulancesRead,
+	"GetAmbulances":              permissionAmbulancesRead,
+	"PatchAmbulance":             permissionAmbulancesWrite,
+	"UpdateAmbulance":            permissionAmbulancesWrite,
+	"CreateApiKey":               permissionApiKeys,
+	"GetApiKey":                  permissionApiKeys,
+	"GetApiKeys":                 permissionApiKeys,
+	"RevokeApiKey":               permissionApiKeys,
+	"RotateApiKey":               permissionApiKeys,
+	"GetAuditRecords":            permissionAuditRead,
+	"CreateWebhook":              permissionWebhooks,
+	"DeleteWebhook":              permissionWebhooks,
+	"DeleteWebhookDeadLetter":    permissionWebhooks,
+	"GetWebhook":                 permissionWebhooks,
+	"GetWebhookDeadLetters":      permissionWebhooks,
+	"GetWebhooks":                permissionWebhooks,
+	"ReplayWebhookDeadLetter":    permissionWebhooks,
+	"UpdateWebhook":              permissionWebhooks,
+}
+
+// NewAuthorizedRouterWithGinEngine adds routes to the existing gin engine like
+// NewRouterWithGinEngine, each route is accessible only to the users whose
+// roles grant the permission of the operation and who are assigned to the
+// ambulance of the route. Requests must be authenticated before.
+func NewAuthorizedRouterWithGinEngine(router *gin.Engine, handleFunctions ApiHandleFunctions, policy *auth.Policy) *gin.Engine {
+	for _, route := range getRoutes(handleFunctions) {
+		permission, ok := routePermissions[route.Name]
+		if !ok {
+			// the operation would be accessible to everybody
+			panic("permission of the operation " + route.Name + " is not defined")
+		}
+		if route.HandlerFunc == nil {
+			route.HandlerFunc = DefaultHandleFunc
+		}
+		router.Handle(route.Method, route.Pattern, policy.Require(permission), authorizeAmbulance(policy), route.HandlerFunc)
+	}
+
+	return router
+}
+
+// authorizeAmbulance rejects requests for the ambulances the user is not
+// assigned to and keeps the assigned ambulances for the handlers of the lists
+func authorizeAmbulance(policy *auth.Policy) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// handlers check that the users do not grant more than they are granted
+		c.Set("authorization_policy", policy)
+		principal, ok := auth.PrincipalFromContext(c)
+		if !ok || policy.Allows(principal, permissionAmbulancesAll) {
+			c.Next()
+			return
+		}
+
+		assigned := principal.StringsClaim(assignedAmbulancesClaim)
+		c.Set("assigned_ambulances", assigned)
+		if ambulanceId := c.Param("ambulanceId"); ambulanceId != "" && !slices.Contains(assigned, ambulanceId) {
+			abortWithProblem(c, problemAmbulanceNotAssigned, "ambulance "+ambulanceId+" is not assigned to "+principal.Username, nil)
+			return
+		}
+		c.Next()
+	}
+}
+
+// assignedAmbulances returns the ambulances the user is restricted to, ok is
+// false if the user may access all ambulances
+func assignedAmbulances(c *gin.Context) (ambulanceIds []string, ok bool) {
+	ambulanceIds, ok = c.Value("assigned_ambulances").([]string)
+	return ambulanceIds, ok
+}
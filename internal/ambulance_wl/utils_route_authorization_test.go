@@ -0,0 +1,276 @@
+package ambulance_wl
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/suite"
+	"github.com/wac-fiit/cv2-ambulance-webapi/internal/auth"
+	"github.com/wac-fiit/cv2-ambulance-webapi/internal/db_service"
+)
+
+type RouteAuthorizationSuite struct {
+	suite.Suite
+	router *gin.Engine
+	policy *auth.Policy
+}
+
+func TestRouteAuthorizationSuite(t *testing.T) {
+	suite.Run(t, new(RouteAuthorizationSuite))
+}
+
+func (suite *RouteAuthorizationSuite) SetupTest() {
+	gin.SetMode(gin.TestMode)
+	dbService := db_service.NewMemoryService[Ambulance]()
+	waitingListDbService := db_service.NewMemoryChildService[WaitingListEntry]()
+	apiKeysDbService := db_service.NewMemoryService[StoredApiKey]()
+	policy, err := auth.LoadPolicy("")
+	suite.Require().NoError(err)
+	// manages the keys of the systems of its ambulances only
+	policy.Roles["integrator"] = []string{"api-keys:manage", "waiting-list:read"}
+	suite.policy = policy
+
+	engine := gin.New()
+	engine.Use(func(ctx *gin.Context) {
+		ctx.Set("db_service", dbService)
+		ctx.Set("db_service_waiting_list", waitingListDbService)
+		ctx.Set("db_service_api_keys", apiKeysDbService)
+		ctx.Next()
+	})
+	engine.Use(NewApiKeyMiddleware())
+	// the principal is described by the test headers instead of the bearer token
+	engine.Use(func(ctx *gin.Context) {
+		if _, ok := auth.PrincipalFromContext(ctx); ok {
+			ctx.Next()
+			return
+		}
+		if role := ctx.GetHeader("X-Test-Role"); role != "" {
+			ambulances := []interface{}{}
+			for _, ambulanceId := range strings.Fields(ctx.GetHeader("X-Test-Ambulances")) {
+				ambulances = append(ambulances, ambulanceId)
+			}
+			auth.SetPrincipal(ctx, &auth.Principal{
+				Username: role,
+				Roles:    []string{role},
+				Claims:   jwt.MapClaims{"ambulances": ambulances},
+			})
+		}
+		ctx.Next()
+	})
+	suite.router = NewAuthorizedRouterWithGinEngine(engine, ApiHandleFunctions{
+		AmbulanceConditionsAPI:  NewAmbulanceConditionsApi(),
+		AmbulanceWaitingListAPI: NewAmbulanceWaitingListApi(),
+		AmbulancesAPI:           NewAmbulancesApi(),
+		ApiKeysAPI:              NewApiKeysApi(),
+		AuditAPI:                NewAuditApi(),
+		WebhooksAPI:             NewWebhooksApi(),
+	}, policy)
+
+	for _, ambulanceId := range []string{"test-ambulance", "other-ambulance"} {
+		suite.Require().Equal(http.StatusCreated, suite.request("admin", "", http.MethodPost, "/api/ambulance",
+			`{ "id": "`+ambulanceId+`", "name": "Test Ambulance", "roomNumber": "101" }`).Code)
+	}
+}
+
+func (suite *RouteAuthorizationSuite) request(role string, ambulances string, method string, path string, body string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(method, path, strings.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	if role != "" {
+		request.Header.Set("X-Test-Role", role)
+		request.Header.Set("X-Test-Ambulances", ambulances)
+	}
+	suite.router.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func (suite *RouteAuthorizationSuite) requestWithKey(key string, method string, path string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(method, path, nil)
+	request.Header.Set("X-API-Key", key)
+	suite.router.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func (suite *RouteAuthorizationSuite) issueApiKey(body string) ApiKey {
+	response := suite.request("admin", "", http.MethodPost, "/api/api-keys", body)
+	suite.Require().Equal(http.StatusCreated, response.Code, response.Body.String())
+	apiKey := ApiKey{}
+	suite.Require().NoError(json.Unmarshal(response.Body.Bytes(), &apiKey))
+	return apiKey
+}
+
+func (suite *RouteAuthorizationSuite) Test_OnlyDoctorFinishesVisit() {
+	// ARRANGE
+	entries := "/api/waiting-list/test-ambulance/entries"
+	suite.Require().Equal(http.StatusOK, suite.request("nurse", "test-ambulance", http.MethodPost, entries,
+		`{ "id": "test-entry", "patientId": "test-patient", "waitingSince": "2038-12-24T10:05:00Z", "estimatedDurationMinutes": 15 }`).Code)
+	suite.Require().Equal(http.StatusOK, suite.request("nurse", "test-ambulance", http.MethodPost, entries+"/test-entry/call", "").Code)
+	suite.Require().Equal(http.StatusOK, suite.request("nurse", "test-ambulance", http.MethodPost, entries+"/test-entry/start", "").Code)
+
+	// ACT
+	byNurse := suite.request("nurse", "test-ambulance", http.MethodPost, entries+"/test-entry/finish", "")
+	byDoctor := suite.request("doctor", "test-ambulance", http.MethodPost, entries+"/test-entry/finish", "")
+
+	// ASSERT
+	suite.Equal(http.StatusForbidden, byNurse.Code)
+	suite.Contains(byNurse.Body.String(), "waiting-list:finish")
+	suite.Equal(http.StatusOK, byDoctor.Code)
+}
+
+func (suite *RouteAuthorizationSuite) Test_OnlyAdminDeletesAmbulance() {
+	// ACT
+	unauthenticated := suite.request("", "", http.MethodDelete, "/api/ambulance/test-ambulance", "")
+	byDoctor := suite.request("doctor", "test-ambulance", http.MethodDelete, "/api/ambulance/test-ambulance", "")
+	byAdmin := suite.request("admin", "", http.MethodDelete, "/api/ambulance/test-ambulance", "")
+
+	// ASSERT
+	suite.Equal(http.StatusUnauthorized, unauthenticated.Code)
+	suite.Equal(http.StatusForbidden, byDoctor.Code)
+	suite.Equal(http.StatusNoContent, byAdmin.Code)
+}
+
+func (suite *RouteAuthorizationSuite) Test_StaffSeesOnlyAssignedAmbulances() {
+	// ACT
+	list := suite.request("nurse", "test-ambulance", http.MethodGet, "/api/ambulance", "")
+	assigned := suite.request("nurse", "test-ambulance", http.MethodGet, "/api/waiting-list/test-ambulance/entries", "")
+	other := suite.request("nurse", "test-ambulance", http.MethodGet, "/api/waiting-list/other-ambulance/entries", "")
+	all := suite.request("admin", "", http.MethodGet, "/api/ambulance", "")
+
+	// ASSERT
+	suite.Equal(http.StatusOK, list.Code)
+	var ambulances AmbulanceList
+	suite.Require().NoError(json.Unmarshal(list.Body.Bytes(), &ambulances))
+	suite.Equal([]AmbulanceSummary{{Id: "test-ambulance", Name: "Test Ambulance", RoomNumber: "101"}}, ambulances.Items)
+	suite.Equal(http.StatusOK, assigned.Code)
+	suite.Equal(http.StatusForbidden, other.Code)
+	suite.Require().NoError(json.Unmarshal(all.Body.Bytes(), &ambulances))
+	suite.Len(ambulances.Items, 2)
+}
+
+func (suite *RouteAuthorizationSuite) Test_AllRoutesRequirePermission() {
+	// ASSERT
+	for _, route := range getRoutes(ApiHandleFunctions{
+		AmbulanceConditionsAPI:  NewAmbulanceConditionsApi(),
+		AmbulanceWaitingListAPI: NewAmbulanceWaitingListApi(),
+		AmbulancesAPI:           NewAmbulancesApi(),
+		ApiKeysAPI:              NewApiKeysApi(),
+		AuditAPI:                NewAuditApi(),
+		WebhooksAPI:             NewWebhooksApi(),
+	}) {
+		suite.Contains(routePermissions, route.Name)
+	}
+}
+
+func (suite *RouteAuthorizationSuite) Test_ApiKey_GrantsScopesForAssignedAmbulances() {
+	// ARRANGE
+	apiKey := suite.issueApiKey(`{ "name": "Waiting room kiosk", "scopes": ["waiting-list:read", "ambulances:all"], "ambulanceIds": ["test-ambulance"] }`)
+
+	// ACT
+	assigned := suite.requestWithKey(apiKey.Key, http.MethodGet, "/api/waiting-list/test-ambulance/entries")
+	other := suite.requestWithKey(apiKey.Key, http.MethodGet, "/api/waiting-list/other-ambulance/entries")
+	outOfScope := suite.requestWithKey(apiKey.Key, http.MethodDelete, "/api/ambulance/test-ambulance")
+	unknown := suite.requestWithKey(apiKey.Id+".unknown", http.MethodGet, "/api/waiting-list/test-ambulance/entries")
+	stored := suite.request("admin", "", http.MethodGet, "/api/api-keys/"+apiKey.Id, "")
+
+	// ASSERT
+	suite.NotEmpty(apiKey.Id)
+	suite.True(strings.HasPrefix(apiKey.Key, apiKey.Id+"."))
+	suite.Equal(http.StatusOK, assigned.Code)
+	suite.Equal(http.StatusForbidden, other.Code)
+	suite.Equal(http.StatusForbidden, outOfScope.Code)
+	suite.Equal(http.StatusUnauthorized, unknown.Code)
+	suite.Equal(http.StatusOK, stored.Code)
+	suite.NotContains(stored.Body.String(), `"key"`)
+	suite.NotContains(stored.Body.String(), apiKey.Key)
+	storedKey := ApiKey{}
+	suite.Require().NoError(json.Unmarshal(stored.Body.Bytes(), &storedKey))
+	suite.False(storedKey.LastUsedAt.IsZero())
+}
+
+func (suite *RouteAuthorizationSuite) Test_ApiKey_RotatedAndRevoked() {
+	// ARRANGE
+	apiKey := suite.issueApiKey(`{ "name": "Laboratory system", "scopes": ["waiting-list:read"] }`)
+
+	issued := ApiKey{}
+	suite.Require().NoError(json.Unmarshal(suite.request("admin", "", http.MethodGet, "/api/api-keys/"+apiKey.Id, "").Body.Bytes(), &issued))
+
+	// ACT
+	rotated := suite.request("admin", "", http.MethodPost, "/api/api-keys/"+apiKey.Id+"/rotate", "")
+	rotatedKey := ApiKey{}
+	suite.Require().NoError(json.Unmarshal(rotated.Body.Bytes(), &rotatedKey))
+	previous := suite.requestWithKey(apiKey.Key, http.MethodGet, "/api/waiting-list/other-ambulance/entries")
+	current := suite.requestWithKey(rotatedKey.Key, http.MethodGet, "/api/waiting-list/other-ambulance/entries")
+	revoked := suite.request("admin", "", http.MethodPost, "/api/api-keys/"+apiKey.Id+"/revoke", "")
+	afterRevoke := suite.requestWithKey(rotatedKey.Key, http.MethodGet, "/api/waiting-list/other-ambulance/entries")
+	byNurse := suite.request("nurse", "test-ambulance", http.MethodGet, "/api/api-keys", "")
+
+	// ASSERT
+	suite.Equal(http.StatusOK, rotated.Code)
+	suite.NotEqual(apiKey.Key, rotatedKey.Key)
+	suite.True(issued.CreatedAt.Equal(rotatedKey.CreatedAt))
+	suite.Equal(http.StatusUnauthorized, previous.Code)
+	suite.Equal(http.StatusOK, current.Code)
+	suite.Equal(http.StatusOK, revoked.Code)
+	suite.Contains(revoked.Body.String(), "revokedAt")
+	suite.Equal(http.StatusUnauthorized, afterRevoke.Code)
+	suite.Contains(afterRevoke.Body.String(), "revoked")
+	suite.Equal(http.StatusForbidden, byNurse.Code)
+}
+
+func (suite *RouteAuthorizationSuite) Test_ApiKey_InvalidRequestRejected() {
+	// ACT
+	unknownScope := suite.request("admin", "", http.MethodPost, "/api/api-keys", `{ "name": "kiosk", "scopes": ["everything"] }`)
+	expired := suite.request("admin", "", http.MethodPost, "/api/api-keys", `{ "name": "kiosk", "scopes": ["waiting-list:read"], "expiresAt": "2000-01-01T00:00:00Z" }`)
+
+	// ASSERT
+	suite.Equal(http.StatusBadRequest, unknownScope.Code)
+	suite.Equal(http.StatusBadRequest, expired.Code)
+}
+
+func (suite *RouteAuthorizationSuite) Test_ApiKey_GrantsNoMoreThanIssuer() {
+	// ACT
+	issue := func(body string) *httptest.ResponseRecorder {
+		return suite.request("integrator", "test-ambulance", http.MethodPost, "/api/api-keys", body)
+	}
+	otherScope := issue(`{ "name": "kiosk", "scopes": ["waiting-list:write"], "ambulanceIds": ["test-ambulance"] }`)
+	allAmbulances := issue(`{ "name": "kiosk", "scopes": ["waiting-list:read", "ambulances:all"], "ambulanceIds": ["test-ambulance"] }`)
+	otherAmbulance := issue(`{ "name": "kiosk", "scopes": ["waiting-list:read"], "ambulanceIds": ["other-ambulance"] }`)
+	unrestricted := issue(`{ "name": "kiosk", "scopes": ["waiting-list:read"] }`)
+	assigned := issue(`{ "name": "kiosk", "scopes": ["waiting-list:read"], "ambulanceIds": ["test-ambulance"] }`)
+
+	// ASSERT
+	suite.Equal(http.StatusForbidden, otherScope.Code)
+	suite.Contains(otherScope.Body.String(), "waiting-list:write")
+	suite.Equal(http.StatusForbidden, allAmbulances.Code)
+	suite.Equal(http.StatusForbidden, otherAmbulance.Code)
+	suite.Contains(otherAmbulance.Body.String(), "ambulance-not-assigned")
+	suite.Equal(http.StatusForbidden, unrestricted.Code)
+	suite.Equal(http.StatusCreated, assigned.Code)
+	apiKey := ApiKey{}
+	suite.Require().NoError(json.Unmarshal(assigned.Body.Bytes(), &apiKey))
+	suite.Equal(http.StatusForbidden, suite.requestWithKey(apiKey.Key, http.MethodGet, "/api/waiting-list/other-ambulance/entries").Code)
+}
+
+func (suite *RouteAuthorizationSuite) Test_ApiKey_RestrictedWhenAnonymousRequestsAllowed() {
+	// ARRANGE
+	suite.policy.AllowAnonymous = true
+	apiKey := suite.issueApiKey(`{ "name": "Laboratory system", "scopes": ["waiting-list:read"], "ambulanceIds": ["test-ambulance"] }`)
+
+	// ACT
+	anonymous := suite.request("", "", http.MethodGet, "/api/waiting-list/other-ambulance/entries", "")
+	withKey := suite.requestWithKey(apiKey.Key, http.MethodGet, "/api/waiting-list/other-ambulance/entries")
+	outOfScope := suite.requestWithKey(apiKey.Key, http.MethodDelete, "/api/ambulance/test-ambulance")
+	invalidKey := suite.requestWithKey(apiKey.Id+".unknown", http.MethodGet, "/api/waiting-list/other-ambulance/entries")
+
+	// ASSERT
+	suite.Equal(http.StatusOK, anonymous.Code)
+	suite.Equal(http.StatusForbidden, withKey.Code)
+	suite.Equal(http.StatusForbidden, outOfScope.Code)
+	suite.Equal(http.StatusUnauthorized, invalidKey.Code)
+}
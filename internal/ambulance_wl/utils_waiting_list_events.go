@@ -0,0 +1,163 @@
+package ambulance_wl
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// number of recent events of each ambulance kept for resuming the streams
+	waitingListEventsHistorySize = 256
+	// events buffered for each subscriber, slow subscribers are disconnected
+	waitingListSubscriberBufferSize = 64
+	// keeps the event stream alive through proxies closing idle connections
+	waitingListEventsHeartbeatInterval = 15 * time.Second
+)
+
+// WaitingListEventBroker fans out changes of the waiting lists to the
+// subscribers in this process. Events get increasing ids when published and
+// the most recent ones are kept so that subscribers can resume after reconnect.
+type WaitingListEventBroker struct {
+	lock sync.Mutex
+	// distinguishes ids issued by other instances of the broker, e.g. before restart
+	epoch       string
+	sequence    uint64
+	history     map[string][]sequencedEvent
+	trimmed     map[string]uint64
+	subscribers map[string]map[chan WaitingListEvent]struct{}
+}
+
+type sequencedEvent struct {
+	sequence uint64
+	event    WaitingListEvent
+}
+
+func NewWaitingListEventBroker() *WaitingListEventBroker {
+	return &WaitingListEventBroker{
+		epoch:       uuid.NewString()[:8],
+		history:     map[string][]sequencedEvent{},
+		trimmed:     map[string]uint64{},
+		subscribers: map[string]map[chan WaitingListEvent]struct{}{},
+	}
+}
+
+// Publish assigns ids to the events and delivers them to the subscribers of their ambulance
+func (b *WaitingListEventBroker) Publish(events ...WaitingListEvent) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for _, event := range events {
+		b.sequence++
+		event.Id = fmt.Sprintf("%s-%d", b.epoch, b.sequence)
+
+		history := append(b.history[event.AmbulanceId], sequencedEvent{sequence: b.sequence, event: event})
+		if len(history) > waitingListEventsHistorySize {
+			b.trimmed[event.AmbulanceId] = history[0].sequence
+			history = history[1:]
+		}
+		b.history[event.AmbulanceId] = history
+
+		for subscriber := range b.subscribers[event.AmbulanceId] {
+			select {
+			case subscriber <- event:
+			default:
+				// subscriber does not keep up, it resumes from the history after reconnect
+				b.unsubscribeLocked(event.AmbulanceId, subscriber)
+			}
+		}
+	}
+}
+
+// Subscribe registers for the events of the ambulance. If lastEventId is
+// provided then the events published after it are returned for replay, or a
+// single reset event if they are no longer available. The events channel is
+// closed when the subscription is cancelled or the subscriber is too slow.
+func (b *WaitingListEventBroker) Subscribe(ambulanceId string, lastEventId string) (
+	replay []WaitingListEvent, events <-chan WaitingListEvent, cancel func(),
+) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if lastEventId != "" {
+		replay = b.replayLocked(ambulanceId, lastEventId)
+	}
+
+	subscriber := make(chan WaitingListEvent, waitingListSubscriberBufferSize)
+	if b.subscribers[ambulanceId] == nil {
+		b.subscribers[ambulanceId] = map[chan WaitingListEvent]struct{}{}
+	}
+	b.subscribers[ambulanceId][subscriber] = struct{}{}
+
+	cancel = func() {
+		b.lock.Lock()
+		defer b.lock.Unlock()
+		b.unsubscribeLocked(ambulanceId, subscriber)
+	}
+	return replay, subscriber, cancel
+}
+
+// replayLocked returns events of the ambulance published after the lastEventId
+func (b *WaitingListEventBroker) replayLocked(ambulanceId string, lastEventId string) []WaitingListEvent {
+	epoch, sequenceText, _ := strings.Cut(lastEventId, "-")
+	lastSequence, err := strconv.ParseUint(sequenceText, 10, 64)
+	if err != nil || epoch != b.epoch || lastSequence > b.sequence || lastSequence < b.trimmed[ambulanceId] {
+		return []WaitingListEvent{{
+			Id:          fmt.Sprintf("%s-%d", b.epoch, b.sequence),
+			Type:        RESET,
+			AmbulanceId: ambulanceId,
+		}}
+	}
+
+	replay := []WaitingListEvent{}
+	for _, published := range b.history[ambulanceId] {
+		if published.sequence > lastSequence {
+			replay = append(replay, published.event)
+		}
+	}
+	return replay
+}
+
+// ambulanceIds returns ambulances which have subscribers or recent events
+func (b *WaitingListEventBroker) ambulanceIds() []string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	ids := slices.Collect(maps.Keys(b.history))
+	for ambulanceId := range b.subscribers {
+		if _, exists := b.history[ambulanceId]; !exists {
+			ids = append(ids, ambulanceId)
+		}
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+func (b *WaitingListEventBroker) unsubscribeLocked(ambulanceId string, subscriber chan WaitingListEvent) {
+	if _, exists := b.subscribers[ambulanceId][subscriber]; !exists {
+		return
+	}
+	delete(b.subscribers[ambulanceId], subscriber)
+	if len(b.subscribers[ambulanceId]) == 0 {
+		delete(b.subscribers, ambulanceId)
+	}
+	close(subscriber)
+}
+
+// writeServerSentEvent writes the event in the text/event-stream format
+func writeServerSentEvent(writer io.Writer, event WaitingListEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(writer, "id: %s\nevent: %s\ndata: %s\n\n", event.Id, event.Type, data)
+	return err
+}
@@ -0,0 +1,95 @@
+package ambulance_wl
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type WaitingListEventBrokerSuite struct {
+	suite.Suite
+	sut *WaitingListEventBroker
+}
+
+func TestWaitingListEventBrokerSuite(t *testing.T) {
+	suite.Run(t, new(WaitingListEventBrokerSuite))
+}
+
+func (suite *WaitingListEventBrokerSuite) SetupTest() {
+	suite.sut = NewWaitingListEventBroker()
+}
+
+func (suite *WaitingListEventBrokerSuite) Test_Publish_DeliversToSubscribersOfAmbulance() {
+	// ARRANGE
+	_, events, cancel := suite.sut.Subscribe("test-ambulance", "")
+	defer cancel()
+
+	// ACT
+	suite.sut.Publish(
+		WaitingListEvent{Type: CREATED, AmbulanceId: "other-ambulance", EntryId: "other-entry"},
+		WaitingListEvent{Type: CREATED, AmbulanceId: "test-ambulance", EntryId: "test-entry"},
+	)
+
+	// ASSERT
+	suite.Require().Len(events, 1)
+	event := <-events
+	suite.Equal("test-entry", event.EntryId)
+	suite.NotEmpty(event.Id)
+}
+
+func (suite *WaitingListEventBrokerSuite) Test_Subscribe_ReplaysEventsAfterLastEventId() {
+	// ARRANGE
+	_, events, cancel := suite.sut.Subscribe("test-ambulance", "")
+	suite.sut.Publish(
+		WaitingListEvent{Type: CREATED, AmbulanceId: "test-ambulance", EntryId: "first"},
+		WaitingListEvent{Type: CREATED, AmbulanceId: "test-ambulance", EntryId: "second"},
+	)
+	first := <-events
+	cancel()
+
+	// ACT
+	replay, _, cancel := suite.sut.Subscribe("test-ambulance", first.Id)
+	defer cancel()
+
+	// ASSERT
+	suite.Require().Len(replay, 1)
+	suite.Equal("second", replay[0].EntryId)
+}
+
+func (suite *WaitingListEventBrokerSuite) Test_Subscribe_ResetWhenHistoryIsLost() {
+	// ARRANGE
+	// the event following the first one is dropped from the history
+	for i := 0; i < waitingListEventsHistorySize+2; i++ {
+		suite.sut.Publish(WaitingListEvent{Type: UPDATED, AmbulanceId: "test-ambulance"})
+	}
+
+	// ACT
+	trimmed, _, cancelTrimmed := suite.sut.Subscribe("test-ambulance", suite.sut.epoch+"-1")
+	defer cancelTrimmed()
+	restarted, _, cancelRestarted := suite.sut.Subscribe("test-ambulance", "previous-1")
+	defer cancelRestarted()
+
+	// ASSERT
+	suite.Require().Len(trimmed, 1)
+	suite.Equal(RESET, trimmed[0].Type)
+	suite.Require().Len(restarted, 1)
+	suite.Equal(RESET, restarted[0].Type)
+}
+
+func (suite *WaitingListEventBrokerSuite) Test_Publish_DisconnectsSlowSubscriber() {
+	// ARRANGE
+	_, events, cancel := suite.sut.Subscribe("test-ambulance", "")
+	defer cancel()
+
+	// ACT
+	for i := 0; i <= waitingListSubscriberBufferSize; i++ {
+		suite.sut.Publish(WaitingListEvent{Type: UPDATED, AmbulanceId: "test-ambulance"})
+	}
+
+	// ASSERT
+	received := 0
+	for range events {
+		received++
+	}
+	suite.Equal(waitingListSubscriberBufferSize, received)
+}
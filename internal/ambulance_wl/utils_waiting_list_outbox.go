@@ -0,0 +1,63 @@
+package ambulance_wl
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+
+	"github.com/wac-fiit/cv2-ambulance-webapi/internal/outbox"
+)
+
+// prefix of the CloudEvents type of the waiting list events, the type is
+// completed by the WebhookEventType of the event
+const waitingListCloudEventTypePrefix = "ambulance-wl."
+
+// waitingListEventData is the data of the waiting list CloudEvents
+type waitingListEventData struct {
+	AmbulanceId string           `json:"ambulanceId"`
+	Entry       WaitingListEntry `json:"entry"`
+}
+
+func newWaitingListCloudEvent(eventType WebhookEventType, ambulanceId string, entry WaitingListEntry) (outbox.CloudEvent, error) {
+	return outbox.NewCloudEvent(
+		"/api/waiting-list/"+ambulanceId,
+		waitingListCloudEventTypePrefix+string(eventType),
+		entry.Id,
+		waitingListEventData{AmbulanceId: ambulanceId, Entry: entry},
+	)
+}
+
+// entryChangeEvent derives the event of the entry changed by the update, previous
+// is nil for the created entry and current is nil for the removed one. Ok is false
+// if the change is not published.
+func entryChangeEvent(previous *WaitingListEntry, current *WaitingListEntry) (eventType WebhookEventType, ok bool) {
+	switch {
+	case previous == nil:
+		return ENTRY_ENQUEUED, true
+	case current == nil:
+		return ENTRY_REMOVED, true
+	case previous.currentState() != CALLED && current.currentState() == CALLED:
+		return ENTRY_CALLED, true
+	}
+	return "", false
+}
+
+// webhookEvent converts the relayed CloudEvent back to the webhook payload, ok
+// is false for the events which are not delivered to the webhooks
+func webhookEvent(event outbox.CloudEvent) (result WebhookEvent, ok bool, err error) {
+	eventType := WebhookEventType(strings.TrimPrefix(event.Type, waitingListCloudEventTypePrefix))
+	if !strings.HasPrefix(event.Type, waitingListCloudEventTypePrefix) || !slices.Contains(webhookEventTypes, eventType) {
+		return WebhookEvent{}, false, nil
+	}
+	var data waitingListEventData
+	if err := json.Unmarshal(event.Data, &data); err != nil {
+		return WebhookEvent{}, false, err
+	}
+	return WebhookEvent{
+		Id:          event.Id,
+		Type:        eventType,
+		OccurredAt:  event.Time,
+		AmbulanceId: data.AmbulanceId,
+		Entry:       data.Entry,
+	}, true, nil
+}
@@ -0,0 +1,188 @@
+package ambulance_wl
+
+import (
+	"cmp"
+	"context"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/wac-fiit/cv2-ambulance-webapi/internal/db_service"
+)
+
+const (
+	// changes waiting for the relay, the feed blocks when it is full
+	waitingListRelayBufferSize = 256
+	// delays before watching the failed change feed again, doubled after each failure
+	waitingListRelayMinRetryDelay = time.Second
+	waitingListRelayMaxRetryDelay = 30 * time.Second
+)
+
+// waitingListRelay converts changes of the stored entries to the waiting list events
+type waitingListRelay struct {
+	entriesDb db_service.DbChildService[WaitingListEntry]
+	broker    *WaitingListEventBroker
+	logger    zerolog.Logger
+	// order of the active entries last published for each ambulance
+	orders map[string][]string
+}
+
+// RelayWaitingListChanges publishes changes of the waiting list entries made by
+// any replica of the service to the subscribers connected to this replica. It
+// blocks until the context is cancelled, failed feed is watched again.
+//
+// Changes of the ambulance documents are not relayed, their revision is bumped
+// by every change of the waiting list, which is already published as the entry
+// event, and the removed ambulance is observed as the removal of its entries.
+func RelayWaitingListChanges(
+	ctx context.Context,
+	feed db_service.ChangeFeed[WaitingListEntry],
+	entriesDb db_service.DbChildService[WaitingListEntry],
+	broker *WaitingListEventBroker,
+) {
+	relay := &waitingListRelay{
+		entriesDb: entriesDb,
+		broker:    broker,
+		logger:    log.With().Str("component", "waiting-list-relay").Logger(),
+		orders:    map[string][]string{},
+	}
+
+	changes := make(chan db_service.Change[WaitingListEntry], waitingListRelayBufferSize)
+	failures := make(chan error, 1)
+	watch := func(resumeAfter string) {
+		failures <- feed.Watch(ctx, resumeAfter, func(change db_service.Change[WaitingListEntry]) {
+			select {
+			case changes <- change:
+			case <-ctx.Done():
+			}
+		})
+	}
+
+	resumeAfter := ""
+	retryDelay := waitingListRelayMinRetryDelay
+	go watch(resumeAfter)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case change := <-changes:
+			resumeAfter = relay.forward(ctx, change, changes)
+			retryDelay = waitingListRelayMinRetryDelay
+		case err := <-failures:
+			if ctx.Err() != nil {
+				return
+			}
+			// changes delivered before the failure are relayed before watching again
+			if len(changes) > 0 {
+				resumeAfter = relay.forward(ctx, <-changes, changes)
+			}
+			if err == db_service.ErrChangeHistoryLost {
+				relay.logger.Warn().Err(err).Msg("Waiting list changes were lost, subscribers are reset")
+				relay.reset()
+				resumeAfter = ""
+			} else {
+				relay.logger.Error().Err(err).Dur("retryDelay", retryDelay).Msg("Failed to watch waiting list changes")
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(retryDelay):
+			}
+			retryDelay = min(2*retryDelay, waitingListRelayMaxRetryDelay)
+			go watch(resumeAfter)
+		}
+	}
+}
+
+// forward publishes the change and the changes pending after it. New order of
+// the affected waiting lists is published once the pending changes are drained,
+// so that the order is not published for each entry modified by one update.
+// It returns the resume token of the last published change.
+func (r *waitingListRelay) forward(
+	ctx context.Context,
+	change db_service.Change[WaitingListEntry],
+	pending <-chan db_service.Change[WaitingListEntry],
+) string {
+	affected := map[string]struct{}{}
+	for {
+		r.broker.Publish(changeEvent(change))
+		affected[change.ParentId] = struct{}{}
+
+		if len(pending) == 0 {
+			break
+		}
+		change = <-pending
+	}
+
+	for _, ambulanceId := range slices.Sorted(maps.Keys(affected)) {
+		entries, err := r.entriesDb.FindChildDocuments(ctx, ambulanceId, activeEntries)
+		if err != nil {
+			r.logger.Error().Err(err).Str("ambulanceId", ambulanceId).Msg("Failed to load waiting list order")
+			continue
+		}
+		order := waitingListOrder(entries)
+		if previous, known := r.orders[ambulanceId]; known && slices.Equal(previous, order) {
+			continue
+		}
+		r.broker.Publish(WaitingListEvent{Type: REORDERED, AmbulanceId: ambulanceId, Order: order})
+		if len(order) == 0 {
+			delete(r.orders, ambulanceId)
+		} else {
+			r.orders[ambulanceId] = order
+		}
+	}
+	return change.ResumeToken
+}
+
+// reset tells the subscribers to reload the waiting lists
+func (r *waitingListRelay) reset() {
+	for _, ambulanceId := range r.broker.ambulanceIds() {
+		r.broker.Publish(WaitingListEvent{Type: RESET, AmbulanceId: ambulanceId})
+	}
+	r.orders = map[string][]string{}
+}
+
+func changeEvent(change db_service.Change[WaitingListEntry]) WaitingListEvent {
+	event := WaitingListEvent{AmbulanceId: change.ParentId, EntryId: change.Id}
+	switch change.Operation {
+	case db_service.ChangeCreated:
+		event.Type = CREATED
+	case db_service.ChangeUpdated:
+		event.Type = UPDATED
+	default:
+		event.Type = DELETED
+	}
+	if change.Document != nil {
+		event.Entry = *change.Document
+	}
+	return event
+}
+
+// waitingListOrder returns ids of the active entries in the order of the waiting
+// list, stored estimates reflect the order established by the last update
+func waitingListOrder(entries []*WaitingListEntry) []string {
+	ordered := slices.Clone(entries)
+	slices.SortStableFunc(ordered, func(left, right *WaitingListEntry) int {
+		if order := cmp.Compare(left.stateOrder(), right.stateOrder()); order != 0 {
+			return order
+		}
+		if order := left.EstimatedStart.Compare(right.EstimatedStart); order != 0 {
+			return order
+		}
+		if order := left.WaitingSince.Compare(right.WaitingSince); order != 0 {
+			return order
+		}
+		return cmp.Compare(left.Id, right.Id)
+	})
+
+	ids := []string{}
+	for _, entry := range ordered {
+		if entry.isActive() {
+			ids = append(ids, entry.Id)
+		}
+	}
+	return ids
+}
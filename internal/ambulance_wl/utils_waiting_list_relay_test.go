@@ -0,0 +1,98 @@
+package ambulance_wl
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/wac-fiit/cv2-ambulance-webapi/internal/db_service"
+)
+
+type WaitingListRelaySuite struct {
+	suite.Suite
+	entriesDb db_service.DbChildService[WaitingListEntry]
+	broker    *WaitingListEventBroker
+	ctx       context.Context
+}
+
+func TestWaitingListRelaySuite(t *testing.T) {
+	suite.Run(t, new(WaitingListRelaySuite))
+}
+
+func (suite *WaitingListRelaySuite) SetupTest() {
+	suite.entriesDb = db_service.NewMemoryChildService[WaitingListEntry]()
+	suite.broker = NewWaitingListEventBroker()
+	var cancel context.CancelFunc
+	suite.ctx, cancel = context.WithCancel(context.Background())
+	suite.T().Cleanup(cancel)
+}
+
+// lostHistoryFeed fails as if the changes were lost and then waits for the cancellation
+type lostHistoryFeed struct{}
+
+func (lostHistoryFeed) Watch(ctx context.Context, resumeAfter string, handle func(change db_service.Change[WaitingListEntry])) error {
+	if resumeAfter == "" {
+		return db_service.ErrChangeHistoryLost
+	}
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (suite *WaitingListRelaySuite) next(events <-chan WaitingListEvent) WaitingListEvent {
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(5 * time.Second):
+		suite.FailNow("waiting list event not relayed")
+		return WaitingListEvent{}
+	}
+}
+
+func (suite *WaitingListRelaySuite) Test_Relay_PublishesOrderOnlyWhenChanged() {
+	// ARRANGE
+	_, events, cancel := suite.broker.Subscribe("test-ambulance", "")
+	defer cancel()
+	go RelayWaitingListChanges(suite.ctx, suite.entriesDb.(db_service.ChangeFeed[WaitingListEntry]), suite.entriesDb, suite.broker)
+	time.Sleep(10 * time.Millisecond) // let the relay start watching
+	since := time.Date(2038, 12, 24, 10, 0, 0, 0, time.UTC)
+	first := &WaitingListEntry{Id: "first", PatientId: "first-patient", WaitingSince: since}
+
+	// ACT
+	suite.Require().NoError(suite.entriesDb.CreateChildDocument(suite.ctx, "test-ambulance", "first", first))
+	created := suite.next(events)
+	reordered := suite.next(events)
+	first.PatientId = "renamed-patient"
+	suite.Require().NoError(suite.entriesDb.UpdateChildDocument(suite.ctx, "test-ambulance", "first", first))
+	updated := suite.next(events)
+	suite.Require().NoError(suite.entriesDb.DeleteChildDocument(suite.ctx, "test-ambulance", "first"))
+	deleted := suite.next(events)
+	emptied := suite.next(events)
+
+	// ASSERT
+	suite.Equal(CREATED, created.Type)
+	suite.Equal("first-patient", created.Entry.PatientId)
+	suite.Equal(REORDERED, reordered.Type)
+	suite.Equal([]string{"first"}, reordered.Order)
+	suite.Equal(UPDATED, updated.Type)
+	suite.Equal("renamed-patient", updated.Entry.PatientId)
+	suite.Equal(int64(2), updated.Entry.Version)
+	suite.Equal(DELETED, deleted.Type)
+	suite.Equal("first", deleted.EntryId)
+	suite.Equal(REORDERED, emptied.Type)
+	suite.Empty(emptied.Order)
+}
+
+func (suite *WaitingListRelaySuite) Test_Relay_ResetsSubscribersWhenChangesLost() {
+	// ARRANGE
+	_, events, cancel := suite.broker.Subscribe("test-ambulance", "")
+	defer cancel()
+
+	// ACT
+	go RelayWaitingListChanges(suite.ctx, lostHistoryFeed{}, suite.entriesDb, suite.broker)
+
+	// ASSERT
+	event := suite.next(events)
+	suite.Equal(RESET, event.Type)
+	suite.Equal("test-ambulance", event.AmbulanceId)
+}
@@ -0,0 +1,376 @@
+package ambulance_wl
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/wac-fiit/cv2-ambulance-webapi/internal/db_service"
+	"github.com/wac-fiit/cv2-ambulance-webapi/internal/outbox"
+)
+
+const (
+	// deliveries in progress at once
+	webhookWorkers = 8
+	// delivery attempts of one event before it is moved to the dead letters
+	webhookMaxAttempts = 6
+	// delay before the first retry, doubled after each failed attempt
+	webhookRetryDelay = time.Second
+	// time given to the receiver to accept the event
+	webhookRequestTimeout = 10 * time.Second
+	// how often the stored deliveries are checked for those due
+	webhookPollInterval = time.Second
+	// due deliveries loaded at once
+	webhookBatchSize = 100
+	// claimed delivery is not attempted by the other workers before this
+	// duration passes, it is attempted again if the worker does not finish it
+	webhookClaimDuration = time.Minute
+)
+
+// WebhookDelivery is the event waiting for the delivery to one webhook. The
+// deliveries are stored before the event is acknowledged to the outbox relay,
+// so that the slow or failing webhooks do not hold up the other events.
+type WebhookDelivery struct {
+	// derived from the event and the webhook, sent in the X-Webhook-Delivery header
+	Id         string
+	WebhookId  string
+	Event      WebhookEvent
+	Attempts   int32
+	LastStatus int32
+	LastError  string
+	// delivery is not attempted before this time
+	AvailableAt time.Time
+	// revision of the stored delivery, only one of the workers may claim it
+	Version int64
+}
+
+// GetVersion implements db_service.Versioned
+func (d *WebhookDelivery) GetVersion() int64 {
+	return d.Version
+}
+
+// SetVersion implements db_service.Versioned
+func (d *WebhookDelivery) SetVersion(version int64) {
+	d.Version = version
+}
+
+// WebhookDispatcher delivers waiting list events to the subscribed webhooks.
+// Events are stored as the deliveries and delivered by the pool of workers,
+// failed deliveries are retried with exponential backoff and stored as dead
+// letters when the retries are exhausted.
+type WebhookDispatcher struct {
+	webhooksDb    db_service.DbService[Webhook]
+	deadLettersDb db_service.DbService[WebhookDeadLetter]
+	deliveriesDb  db_service.DbService[WebhookDelivery]
+	client        *http.Client
+	logger        zerolog.Logger
+	// claimed deliveries handed over to the workers
+	queue chan *WebhookDelivery
+	// signals the deliveries stored by this replica
+	wake          chan struct{}
+	pollInterval  time.Duration
+	claimDuration time.Duration
+	retryDelay    time.Duration
+	maxAttempts   int
+}
+
+func NewWebhookDispatcher(
+	webhooksDb db_service.DbService[Webhook],
+	deadLettersDb db_service.DbService[WebhookDeadLetter],
+	deliveriesDb db_service.DbService[WebhookDelivery],
+) *WebhookDispatcher {
+	return &WebhookDispatcher{
+		webhooksDb:    webhooksDb,
+		deadLettersDb: deadLettersDb,
+		deliveriesDb:  deliveriesDb,
+		client:        newWebhookClient(),
+		logger:        log.With().Str("component", "webhook-dispatcher").Logger(),
+		queue:         make(chan *WebhookDelivery),
+		wake:          make(chan struct{}, 1),
+		pollInterval:  webhookPollInterval,
+		claimDuration: webhookClaimDuration,
+		retryDelay:    webhookRetryDelay,
+		maxAttempts:   webhookMaxAttempts,
+	}
+}
+
+// webhookDeliveryId derives the id of the delivery, the event dispatched again
+// by the outbox relay is not delivered to the same webhook twice
+func webhookDeliveryId(eventId string, webhookId string) string {
+	return uuid.NewSHA1(uuid.NameSpaceOID, []byte(eventId+"/"+webhookId)).String()
+}
+
+// Dispatch stores the deliveries of the event to the matching webhooks. It
+// returns once the deliveries are stored, they are delivered by the workers
+// of the Run method.
+func (d *WebhookDispatcher) Dispatch(ctx context.Context, event WebhookEvent) error {
+	page, err := d.webhooksDb.FindDocuments(ctx, db_service.Query{})
+	if err != nil {
+		return err
+	}
+	for _, webhook := range page.Items {
+		if !webhook.matches(event) {
+			continue
+		}
+		delivery := WebhookDelivery{
+			Id:          webhookDeliveryId(event.Id, webhook.Id),
+			WebhookId:   webhook.Id,
+			Event:       event,
+			AvailableAt: time.Now(),
+		}
+		switch err := d.deliveriesDb.CreateDocument(ctx, delivery.Id, &delivery); err {
+		case nil, db_service.ErrConflict:
+			// delivery stored before the event was dispatched again
+		default:
+			return err
+		}
+	}
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Publish stores the deliveries of the waiting list events relayed from the
+// outbox, so that the dispatcher can be used as the outbox sink
+func (d *WebhookDispatcher) Publish(ctx context.Context, event outbox.CloudEvent) error {
+	webhookEvent, ok, err := webhookEvent(event)
+	if err != nil || !ok {
+		return err
+	}
+	return d.Dispatch(ctx, webhookEvent)
+}
+
+// Run delivers the stored deliveries by the fixed number of workers until the
+// context is cancelled, it returns once the deliveries in progress are
+// finished. The deliveries stored by the other replicas are delivered as well.
+func (d *WebhookDispatcher) Run(ctx context.Context) {
+	var workers sync.WaitGroup
+	for i := 0; i < webhookWorkers; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case delivery := <-d.queue:
+					d.deliver(ctx, delivery)
+				}
+			}
+		}()
+	}
+
+	ticker := time.NewTicker(d.pollInterval)
+	defer ticker.Stop()
+	for {
+		if err := d.claimDue(ctx); err != nil && ctx.Err() == nil {
+			d.logger.Error().Err(err).Msg("Failed to load webhook deliveries")
+		}
+		select {
+		case <-ctx.Done():
+			workers.Wait()
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+	}
+}
+
+// claimDue hands the due deliveries over to the workers. Each delivery is
+// claimed only once a worker is ready to take it, so that the claims do not
+// expire while the deliveries wait for the workers.
+func (d *WebhookDispatcher) claimDue(ctx context.Context) error {
+	page, err := d.deliveriesDb.FindDocuments(ctx, db_service.Query{
+		Filters: []db_service.Filter{
+			{Field: "availableat", Operator: db_service.FilterLessOrEqual, Value: time.Now()},
+		},
+		SortBy: "availableat",
+		Limit:  webhookBatchSize,
+	})
+	if err != nil {
+		return err
+	}
+	for _, delivery := range page.Items {
+		// claim the delivery, concurrent replica fails to update it and skips it
+		delivery.AvailableAt = time.Now().Add(d.claimDuration)
+		switch err := d.deliveriesDb.UpdateDocument(ctx, delivery.Id, delivery); err {
+		case nil:
+		case db_service.ErrVersionConflict, db_service.ErrNotFound:
+			continue
+		default:
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case d.queue <- delivery:
+		}
+	}
+	return nil
+}
+
+// deliver attempts to post the event to the webhook. The webhook is loaded
+// before each attempt, so that retries use its current URL and secret and stop
+// when the webhook is deleted. Failed delivery is scheduled for the retry or
+// moved to the dead letters once the attempts are exhausted, the delivery
+// interrupted by the shutdown is attempted again once its claim expires.
+func (d *WebhookDispatcher) deliver(ctx context.Context, delivery *WebhookDelivery) {
+	logger := d.logger.With().
+		Str("webhookId", delivery.WebhookId).
+		Str("deliveryId", delivery.Id).
+		Str("eventId", delivery.Event.Id).
+		Logger()
+
+	webhook, err := d.webhooksDb.FindDocument(ctx, delivery.WebhookId)
+	if err == db_service.ErrNotFound {
+		logger.Debug().Msg("Webhook was deleted, delivery abandoned")
+		d.remove(ctx, logger, delivery)
+		return
+	}
+	status := 0
+	if err == nil {
+		status, err = d.attempt(ctx, webhook, delivery.Event, delivery.Id)
+	}
+	if err == nil {
+		logger.Debug().Int32("attempts", delivery.Attempts+1).Msg("Webhook event delivered")
+		d.remove(ctx, logger, delivery)
+		return
+	}
+	if ctx.Err() != nil {
+		return
+	}
+
+	delivery.Attempts++
+	delivery.LastStatus = int32(status)
+	delivery.LastError = err.Error()
+	if int(delivery.Attempts) < d.maxAttempts {
+		delay := d.retryDelay << (delivery.Attempts - 1)
+		delivery.AvailableAt = time.Now().Add(delay)
+		if err := d.deliveriesDb.UpdateDocument(ctx, delivery.Id, delivery); err != nil {
+			logger.Error().Err(err).Msg("Failed to schedule webhook delivery retry")
+			return
+		}
+		logger.Warn().Str("lastError", delivery.LastError).Int32("attempts", delivery.Attempts).Dur("retryDelay", delay).Msg("Webhook delivery failed")
+		return
+	}
+
+	deadLetter := WebhookDeadLetter{
+		Id:         delivery.Id,
+		WebhookId:  delivery.WebhookId,
+		Event:      delivery.Event,
+		Attempts:   delivery.Attempts,
+		LastStatus: delivery.LastStatus,
+		LastError:  delivery.LastError,
+		FailedAt:   time.Now(),
+	}
+	switch err := d.deadLettersDb.CreateDocument(ctx, deadLetter.Id, &deadLetter); err {
+	case nil, db_service.ErrConflict:
+	default:
+		// delivery is attempted again once its claim expires
+		logger.Error().Err(err).Msg("Failed to store webhook dead letter")
+		return
+	}
+	logger.Error().Str("lastError", deadLetter.LastError).Int32("attempts", deadLetter.Attempts).Msg("Webhook delivery failed, event moved to dead letters")
+	d.remove(ctx, logger, delivery)
+}
+
+// remove deletes the finished delivery, the delivery which fails to be deleted
+// is attempted again once its claim expires
+func (d *WebhookDispatcher) remove(ctx context.Context, logger zerolog.Logger, delivery *WebhookDelivery) {
+	if err := d.deliveriesDb.DeleteDocument(ctx, delivery.Id); err != nil && err != db_service.ErrNotFound {
+		logger.Error().Err(err).Msg("Failed to remove webhook delivery")
+	}
+}
+
+// replay attempts to deliver the dead letter once and returns the HTTP status
+// of the response, or 0 if no response was received
+func (d *WebhookDispatcher) replay(ctx context.Context, webhook *Webhook, deadLetter *WebhookDeadLetter) (int, error) {
+	return d.attempt(ctx, webhook, deadLetter.Event, deadLetter.Id)
+}
+
+func (d *WebhookDispatcher) attempt(ctx context.Context, webhook *Webhook, event WebhookEvent, deliveryId string) (int, error) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return 0, err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.Url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("X-Webhook-Event", string(event.Type))
+	request.Header.Set("X-Webhook-Delivery", deliveryId)
+	request.Header.Set("X-Webhook-Timestamp", timestamp)
+	request.Header.Set("X-Webhook-Signature", signWebhookPayload(webhook.Secret, timestamp, body))
+
+	response, err := d.client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return response.StatusCode, fmt.Errorf("unexpected response status %d", response.StatusCode)
+	}
+	return response.StatusCode, nil
+}
+
+// newWebhookClient creates the client which connects only to the public
+// addresses, the addresses are checked after the host is resolved, so that the
+// host cannot be pointed to the internal services once the webhook is validated
+func newWebhookClient() *http.Client {
+	dialer := &net.Dialer{Timeout: webhookRequestTimeout}
+	guardedDialer := &net.Dialer{
+		Timeout: webhookRequestTimeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil {
+				return fmt.Errorf("%w, unresolved address %s", errWebhookTargetNotAllowed, address)
+			}
+			return checkWebhookAddress(ip)
+		},
+	}
+	return &http.Client{
+		Timeout: webhookRequestTimeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+				host, _, err := net.SplitHostPort(address)
+				if err == nil && webhookHostAllowed(host) {
+					return dialer.DialContext(ctx, network, address)
+				}
+				return guardedDialer.DialContext(ctx, network, address)
+			},
+			TLSHandshakeTimeout: webhookRequestTimeout,
+			MaxIdleConnsPerHost: webhookWorkers,
+		},
+	}
+}
+
+// signWebhookPayload computes the X-Webhook-Signature header, the timestamp is
+// signed with the body so that the captured requests cannot be replayed later
+func signWebhookPayload(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,248 @@
+package ambulance_wl
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/suite"
+	"github.com/wac-fiit/cv2-ambulance-webapi/internal/db_service"
+)
+
+type WebhookDispatcherSuite struct {
+	suite.Suite
+	webhooksDb    db_service.DbService[Webhook]
+	deadLettersDb db_service.DbService[WebhookDeadLetter]
+	deliveriesDb  db_service.DbService[WebhookDelivery]
+	dispatcher    *WebhookDispatcher
+	ctx           context.Context
+	// receiver fails while the number of the received requests is below failures
+	received   atomic.Int32
+	failures   atomic.Int32
+	deliveries chan string
+	receiver   *httptest.Server
+}
+
+func TestWebhookDispatcherSuite(t *testing.T) {
+	suite.Run(t, new(WebhookDispatcherSuite))
+}
+
+func (suite *WebhookDispatcherSuite) SetupTest() {
+	// receivers of the tests listen on the loopback
+	suite.T().Setenv("AMBULANCE_API_WEBHOOK_ALLOWED_HOSTS", "127.0.0.1")
+	suite.webhooksDb = db_service.NewMemoryService[Webhook]()
+	suite.deadLettersDb = db_service.NewMemoryService[WebhookDeadLetter]()
+	suite.deliveriesDb = db_service.NewMemoryService[WebhookDelivery]()
+	suite.dispatcher = NewWebhookDispatcher(suite.webhooksDb, suite.deadLettersDb, suite.deliveriesDb)
+	suite.dispatcher.pollInterval = time.Millisecond
+	suite.dispatcher.retryDelay = time.Millisecond
+	suite.dispatcher.maxAttempts = 3
+
+	var cancel context.CancelFunc
+	suite.ctx, cancel = context.WithCancel(context.Background())
+	suite.T().Cleanup(cancel)
+	go suite.dispatcher.Run(suite.ctx)
+
+	suite.received.Store(0)
+	suite.failures.Store(0)
+	suite.deliveries = make(chan string, 10)
+	suite.receiver = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if suite.received.Add(1) <= suite.failures.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		suite.deliveries <- r.Header.Get("X-Webhook-Delivery")
+	}))
+	suite.T().Cleanup(suite.receiver.Close)
+
+	suite.Require().NoError(suite.webhooksDb.CreateDocument(suite.ctx, "test-webhook", &Webhook{
+		Id:           "test-webhook",
+		Url:          suite.receiver.URL,
+		Secret:       "test-secret",
+		AmbulanceIds: []string{"test-ambulance"},
+	}))
+}
+
+func (suite *WebhookDispatcherSuite) event(ambulanceId string) WebhookEvent {
+	return WebhookEvent{
+		Id:          "test-event",
+		Type:        ENTRY_ENQUEUED,
+		OccurredAt:  time.Date(2038, 12, 24, 10, 0, 0, 0, time.UTC),
+		AmbulanceId: ambulanceId,
+		Entry:       WaitingListEntry{Id: "test-entry", PatientId: "test-patient"},
+	}
+}
+
+func (suite *WebhookDispatcherSuite) deadLetters() []*WebhookDeadLetter {
+	page, err := suite.deadLettersDb.FindDocuments(suite.ctx, db_service.Query{})
+	suite.Require().NoError(err)
+	return page.Items
+}
+
+// waitForDeadLetters waits until the number of the dead letters is count
+func (suite *WebhookDispatcherSuite) waitForDeadLetters(count int) []*WebhookDeadLetter {
+	suite.Require().Eventually(func() bool {
+		return len(suite.deadLetters()) == count
+	}, 5*time.Second, time.Millisecond)
+	return suite.deadLetters()
+}
+
+func (suite *WebhookDispatcherSuite) Test_Dispatch_RetriesFailedDelivery() {
+	// ARRANGE
+	suite.failures.Store(2)
+
+	// ACT
+	suite.Require().NoError(suite.dispatcher.Dispatch(suite.ctx, suite.event("other-ambulance")))
+	suite.Require().NoError(suite.dispatcher.Dispatch(suite.ctx, suite.event("test-ambulance")))
+
+	// ASSERT
+	select {
+	case deliveryId := <-suite.deliveries:
+		suite.NotEmpty(deliveryId)
+	case <-time.After(5 * time.Second):
+		suite.FailNow("webhook event not delivered")
+	}
+	// event of the other ambulance is not delivered at all
+	suite.Equal(int32(3), suite.received.Load())
+	suite.Empty(suite.deadLetters())
+	suite.Eventually(func() bool {
+		page, err := suite.deliveriesDb.FindDocuments(suite.ctx, db_service.Query{})
+		return err == nil && len(page.Items) == 0
+	}, 5*time.Second, time.Millisecond)
+}
+
+func (suite *WebhookDispatcherSuite) Test_Dispatch_StoresDeadLetterWhenRetriesExhausted() {
+	// ARRANGE
+	suite.failures.Store(100)
+
+	// ACT
+	err := suite.dispatcher.Dispatch(suite.ctx, suite.event("test-ambulance"))
+
+	// ASSERT
+	suite.NoError(err)
+	deadLetter := suite.waitForDeadLetters(1)[0]
+	suite.Equal(webhookDeliveryId("test-event", "test-webhook"), deadLetter.Id)
+	suite.Equal("test-webhook", deadLetter.WebhookId)
+	suite.Equal("test-event", deadLetter.Event.Id)
+	suite.Equal(int32(3), deadLetter.Attempts)
+	suite.Equal(int32(http.StatusServiceUnavailable), deadLetter.LastStatus)
+	suite.Equal("unexpected response status 503", deadLetter.LastError)
+}
+
+func (suite *WebhookDispatcherSuite) Test_Dispatch_DoesNotWaitForFailingWebhook() {
+	// ARRANGE
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	suite.Require().NoError(suite.webhooksDb.CreateDocument(suite.ctx, "failing-webhook", &Webhook{
+		Id:     "failing-webhook",
+		Url:    failing.URL,
+		Secret: "test-secret",
+	}))
+	suite.dispatcher.retryDelay = time.Hour
+
+	// ACT
+	err := suite.dispatcher.Dispatch(suite.ctx, suite.event("test-ambulance"))
+
+	// ASSERT
+	suite.NoError(err)
+	select {
+	case deliveryId := <-suite.deliveries:
+		suite.Equal(webhookDeliveryId("test-event", "test-webhook"), deliveryId)
+	case <-time.After(5 * time.Second):
+		suite.FailNow("webhook event not delivered")
+	}
+	var pending *WebhookDelivery
+	suite.Require().Eventually(func() bool {
+		delivery, err := suite.deliveriesDb.FindDocument(suite.ctx, webhookDeliveryId("test-event", "failing-webhook"))
+		pending = delivery
+		return err == nil && delivery.Attempts == 1 && delivery.AvailableAt.After(time.Now().Add(time.Minute))
+	}, 5*time.Second, time.Millisecond)
+	suite.Equal(int32(http.StatusServiceUnavailable), pending.LastStatus)
+	suite.Empty(suite.deadLetters())
+	suite.Equal(int32(1), suite.received.Load())
+}
+
+func (suite *WebhookDispatcherSuite) Test_ReplayWebhookDeadLetter_DeliversAndRemovesDeadLetter() {
+	// ARRANGE
+	suite.Require().NoError(suite.deadLettersDb.CreateDocument(suite.ctx, "test-delivery", &WebhookDeadLetter{
+		Id:        "test-delivery",
+		WebhookId: "test-webhook",
+		Event:     suite.event("test-ambulance"),
+		Attempts:  3,
+	}))
+	suite.failures.Store(1)
+	replay := func() *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		ctx, _ := gin.CreateTestContext(recorder)
+		ctx.Set("db_service_webhooks", suite.webhooksDb)
+		ctx.Set("db_service_webhook_dead_letters", suite.deadLettersDb)
+		ctx.Set("webhook_dispatcher", suite.dispatcher)
+		ctx.Params = []gin.Param{
+			{Key: "webhookId", Value: "test-webhook"},
+			{Key: "deliveryId", Value: "test-delivery"},
+		}
+		ctx.Request = httptest.NewRequest("POST", "/api/webhooks/test-webhook/dead-letters/test-delivery/replay", strings.NewReader(""))
+		NewWebhooksApi().ReplayWebhookDeadLetter(ctx)
+		ctx.Writer.WriteHeaderNow()
+		return recorder
+	}
+
+	// ACT
+	failed := replay()
+	delivered := replay()
+
+	// ASSERT
+	suite.Equal(http.StatusBadGateway, failed.Code)
+	suite.Contains(failed.Body.String(), `"attempts":4`)
+	suite.Equal(http.StatusNoContent, delivered.Code)
+	suite.Equal("test-delivery", <-suite.deliveries)
+	suite.Empty(suite.deadLetters())
+}
+
+func (suite *WebhookDispatcherSuite) Test_Dispatch_RefusesInternalAddress() {
+	// ARRANGE
+	suite.T().Setenv("AMBULANCE_API_WEBHOOK_ALLOWED_HOSTS", "")
+
+	// ACT
+	err := suite.dispatcher.Dispatch(suite.ctx, suite.event("test-ambulance"))
+
+	// ASSERT
+	suite.NoError(err)
+	suite.Contains(suite.waitForDeadLetters(1)[0].LastError, "webhook target is not allowed")
+	suite.Equal(int32(0), suite.received.Load())
+}
+
+func (suite *WebhookDispatcherSuite) Test_CheckWebhookHost_RefusesInternalHosts() {
+	// ARRANGE
+	hosts := map[string]bool{
+		"hooks.example.com":                  true,
+		"203.0.113.10":                       true,
+		"127.0.0.1":                          true,
+		"localhost":                          false,
+		"10.0.0.12":                          false,
+		"169.254.169.254":                    false,
+		"::1":                                false,
+		"mongodb":                            false,
+		"mongodb.wac-hospital.svc":           false,
+		"mongodb.wac-hospital.cluster.local": false,
+	}
+
+	for host, allowed := range hosts {
+		// ACT
+		err := checkWebhookHost(host)
+
+		// ASSERT
+		if allowed {
+			suite.NoError(err, host)
+		} else {
+			suite.ErrorIs(err, errWebhookTargetNotAllowed, host)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ErrNotConfigured is returned by NewAuthenticator when neither the issuer
+// nor the key set file is configured and the anonymous access is allowed
+var ErrNotConfigured = errors.New("authentication is not configured")
+
+// ErrAnonymousNotAllowed is returned by NewAuthenticator when neither the
+// issuer nor the key set file is configured and the anonymous access is not
+// allowed, so that the mistyped configuration does not open the API
+var ErrAnonymousNotAllowed = errors.New(
+	"authentication is not configured, set AMBULANCE_API_AUTH_ISSUER or AMBULANCE_API_AUTH_JWKS_FILE, " +
+		"or AMBULANCE_API_ALLOW_ANONYMOUS=true to allow anonymous access")
+
+const (
+	defaultRealm = "ambulance-wl"
+	// tolerated clock difference between the issuer and the service
+	tokenLeeway = 30 * time.Second
+)
+
+type Config struct {
+	// URL of the OpenID Connect issuer, tokens must be issued by it. Signing
+	// keys are discovered from its metadata unless JwksFile is set.
+	Issuer string
+	// tokens must be issued for the audience if it is set
+	Audience string
+	// JSON Web Key Set with the signing keys, for offline environments
+	// without access to the issuer
+	JwksFile string
+	// protection space reported in the WWW-Authenticate challenges
+	Realm string
+	// API is accessible without the tokens if neither Issuer nor JwksFile is
+	// set, otherwise such configuration is refused
+	AllowAnonymous bool
+}
+
+// Authenticator validates the RS256 and ES256 signed JWT bearer tokens of the requests
+type Authenticator struct {
+	Config
+	keys   *keySet
+	parser *jwt.Parser
+}
+
+// NewAuthenticator completes the configuration from the environment variables
+// and loads the key set file. Keys of the issuer are loaded on the first request.
+// ErrNotConfigured is returned only when the anonymous access is allowed.
+func NewAuthenticator(config Config) (*Authenticator, error) {
+	if config.Issuer == "" {
+		config.Issuer = os.Getenv("AMBULANCE_API_AUTH_ISSUER")
+	}
+	if config.Audience == "" {
+		config.Audience = os.Getenv("AMBULANCE_API_AUTH_AUDIENCE")
+	}
+	if config.JwksFile == "" {
+		config.JwksFile = os.Getenv("AMBULANCE_API_AUTH_JWKS_FILE")
+	}
+	if config.Realm == "" {
+		config.Realm = defaultRealm
+	}
+	if !config.AllowAnonymous {
+		config.AllowAnonymous = strings.EqualFold(os.Getenv("AMBULANCE_API_ALLOW_ANONYMOUS"), "true")
+	}
+	if config.Issuer == "" && config.JwksFile == "" {
+		if config.AllowAnonymous {
+			return nil, ErrNotConfigured
+		}
+		return nil, ErrAnonymousNotAllowed
+	}
+
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodES256.Alg()}),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(tokenLeeway),
+	}
+	if config.Issuer != "" {
+		options = append(options, jwt.WithIssuer(config.Issuer))
+	}
+	if config.Audience != "" {
+		options = append(options, jwt.WithAudience(config.Audience))
+	}
+
+	authenticator := &Authenticator{
+		Config: config,
+		keys: &keySet{
+			issuer:   config.Issuer,
+			jwksFile: config.JwksFile,
+			client:   &http.Client{Timeout: keySetRequestTimeout},
+		},
+		parser: jwt.NewParser(options...),
+	}
+	if config.JwksFile != "" {
+		if err := authenticator.keys.load(context.Background()); err != nil {
+			return nil, err
+		}
+	}
+	return authenticator, nil
+}
+
+// Middleware rejects the requests without valid bearer token and exposes the
+// authenticated principal on the context. Requests of the public paths, the
+// CORS preflight requests, and the requests authenticated by the preceding
+// middlewares are passed without the token.
+func (a *Authenticator) Middleware(publicPaths ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method == http.MethodOptions || slices.Contains(publicPaths, c.Request.URL.Path) {
+			c.Next()
+			return
+		}
+		if _, ok := PrincipalFromContext(c); ok {
+			// authenticated by other means, e.g. by the API key
+			c.Next()
+			return
+		}
+
+		raw, err := bearerToken(c.Request)
+		switch {
+		case err != nil:
+			a.challenge(c, http.StatusBadRequest, "invalid_request", err.Error())
+			return
+		case raw == "":
+			a.challenge(c, http.StatusUnauthorized, "", "")
+			return
+		}
+
+		claims := jwt.MapClaims{}
+		_, err = a.parser.ParseWithClaims(raw, claims, func(token *jwt.Token) (interface{}, error) {
+			kid, _ := token.Header["kid"].(string)
+			return a.keys.verificationKey(c.Request.Context(), kid, token.Method)
+		})
+		if errors.Is(err, errKeysUnavailable) {
+			abortWithProblem(
+				c,
+				http.StatusServiceUnavailable,
+				problemCredentialsUnavailable,
+				"Signing keys of the token issuer are not available",
+				err.Error())
+			return
+		}
+		if err != nil {
+			a.challenge(c, http.StatusUnauthorized, "invalid_token", err.Error())
+			return
+		}
+
+		SetPrincipal(c, newPrincipal(claims))
+		c.Next()
+	}
+}
+
+// bearerToken extracts the token from the Authorization header or from the
+// access_token query parameter, RFC 6750 section 2 allows only one of them
+func bearerToken(request *http.Request) (string, error) {
+	header := request.Header.Get("Authorization")
+	query := request.URL.Query().Get("access_token")
+	if header == "" {
+		return query, nil
+	}
+	scheme, token, _ := strings.Cut(header, " ")
+	if !strings.EqualFold(scheme, "Bearer") {
+		// credentials of other schemes are not accepted, client is challenged
+		return "", nil
+	}
+	token = strings.TrimSpace(token)
+	switch {
+	case token == "":
+		return "", errors.New("empty bearer token")
+	case query != "":
+		return "", errors.New("bearer token provided by more than one method")
+	}
+	return token, nil
+}
+
+// challenge responds with the WWW-Authenticate header of RFC 6750 section 3,
+// error code is empty when the request does not provide any token
+func (a *Authenticator) challenge(c *gin.Context, status int, code string, description string) {
+	value := `Bearer realm="` + a.Realm + `"`
+	problem, title, detail := problemUnauthenticated, "Bearer token is required", "missing bearer token"
+	if code != "" {
+		// quotes and backslashes are not allowed in the error description
+		description = strings.NewReplacer(`"`, "'", `\`, "/").Replace(description)
+		value += `, error="` + code + `", error_description="` + description + `"`
+		problem, title, detail = problemInvalidCredentials, "Bearer token is not valid", code+": "+description
+		if code == "invalid_request" {
+			problem, title = problemMalformedCredentials, "Malformed authorization of the request"
+		}
+	}
+	c.Header("WWW-Authenticate", value)
+	abortWithProblem(c, status, problem, title, detail)
+}
@@ -0,0 +1,331 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/suite"
+)
+
+const (
+	testIssuer   = "https://issuer.example.org/realms/wac"
+	testAudience = "ambulance-wl"
+)
+
+type AuthenticatorSuite struct {
+	suite.Suite
+	rsaKey *rsa.PrivateKey
+	ecKey  *ecdsa.PrivateKey
+	router *gin.Engine
+}
+
+func TestAuthenticatorSuite(t *testing.T) {
+	suite.Run(t, new(AuthenticatorSuite))
+}
+
+func (suite *AuthenticatorSuite) SetupSuite() {
+	gin.SetMode(gin.TestMode)
+	var err error
+	suite.rsaKey, err = rsa.GenerateKey(rand.Reader, 2048)
+	suite.Require().NoError(err)
+	suite.ecKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	suite.Require().NoError(err)
+}
+
+func (suite *AuthenticatorSuite) SetupTest() {
+	jwksFile := filepath.Join(suite.T().TempDir(), "jwks.json")
+	suite.Require().NoError(os.WriteFile(jwksFile, suite.jwks(map[string]interface{}{
+		"rsa-key": &suite.rsaKey.PublicKey,
+		"":        &suite.ecKey.PublicKey,
+	}), 0o600))
+
+	authenticator, err := NewAuthenticator(Config{Issuer: testIssuer, Audience: testAudience, JwksFile: jwksFile})
+	suite.Require().NoError(err)
+	suite.router = suite.newRouter(authenticator)
+}
+
+func (suite *AuthenticatorSuite) newRouter(authenticator *Authenticator) *gin.Engine {
+	router := gin.New()
+	router.Use(authenticator.Middleware("/openapi"))
+	router.GET("/api/test", func(c *gin.Context) {
+		principal, ok := PrincipalFromContext(c)
+		suite.Require().True(ok)
+		c.String(http.StatusOK, principal.Username)
+	})
+	router.GET("/openapi", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	return router
+}
+
+// jwks encodes the public keys as JSON Web Key Set
+func (suite *AuthenticatorSuite) jwks(keys map[string]interface{}) []byte {
+	encode := func(value *big.Int) string {
+		return base64.RawURLEncoding.EncodeToString(value.Bytes())
+	}
+	set := map[string][]map[string]string{"keys": {}}
+	for kid, key := range keys {
+		var jwk map[string]string
+		switch key := key.(type) {
+		case *rsa.PublicKey:
+			jwk = map[string]string{"kty": "RSA", "n": encode(key.N), "e": encode(big.NewInt(int64(key.E)))}
+		case *ecdsa.PublicKey:
+			jwk = map[string]string{"kty": "EC", "crv": "P-256", "x": encode(key.X), "y": encode(key.Y)}
+		}
+		if kid != "" {
+			jwk["kid"] = kid
+		}
+		jwk["use"] = "sig"
+		set["keys"] = append(set["keys"], jwk)
+	}
+	content, err := json.Marshal(set)
+	suite.Require().NoError(err)
+	return content
+}
+
+func (suite *AuthenticatorSuite) claims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":                testIssuer,
+		"aud":                testAudience,
+		"sub":                "f1c0a6d2",
+		"preferred_username": "nurse.novakova",
+		"exp":                time.Now().Add(time.Minute).Unix(),
+	}
+}
+
+func (suite *AuthenticatorSuite) token(method jwt.SigningMethod, key interface{}, kid string, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	suite.Require().NoError(err)
+	return signed
+}
+
+func (suite *AuthenticatorSuite) request(path string, authorization string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodGet, path, nil)
+	if authorization != "" {
+		request.Header.Set("Authorization", authorization)
+	}
+	suite.router.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func (suite *AuthenticatorSuite) Test_Middleware_AcceptsValidTokens() {
+	// ARRANGE
+	rsaToken := suite.token(jwt.SigningMethodRS256, suite.rsaKey, "rsa-key", suite.claims())
+	// token without kid is verified by the keys of its algorithm
+	ecClaims := suite.claims()
+	delete(ecClaims, "preferred_username")
+	ecToken := suite.token(jwt.SigningMethodES256, suite.ecKey, "", ecClaims)
+
+	// ACT
+	rsa := suite.request("/api/test", "Bearer "+rsaToken)
+	ec := suite.request("/api/test", "bearer "+ecToken)
+	query := suite.request("/api/test?access_token="+rsaToken, "")
+
+	// ASSERT
+	suite.Equal(http.StatusOK, rsa.Code)
+	suite.Equal("nurse.novakova", rsa.Body.String())
+	suite.Equal(http.StatusOK, ec.Code)
+	suite.Equal("f1c0a6d2", ec.Body.String())
+	suite.Equal(http.StatusOK, query.Code)
+}
+
+func (suite *AuthenticatorSuite) Test_Middleware_ChallengesRequestWithoutToken() {
+	// ACT
+	missing := suite.request("/api/test", "")
+	basic := suite.request("/api/test", "Basic bnVyc2U6c2VjcmV0")
+	public := suite.request("/openapi", "")
+
+	// ASSERT
+	suite.Equal(http.StatusUnauthorized, missing.Code)
+	suite.Equal(`Bearer realm="ambulance-wl"`, missing.Header().Get("WWW-Authenticate"))
+	suite.Equal(http.StatusUnauthorized, basic.Code)
+	suite.Equal(`Bearer realm="ambulance-wl"`, basic.Header().Get("WWW-Authenticate"))
+	suite.Equal(http.StatusOK, public.Code)
+}
+
+func (suite *AuthenticatorSuite) Test_Middleware_RejectsInvalidTokens() {
+	// ARRANGE
+	expired := suite.claims()
+	expired["exp"] = time.Now().Add(-time.Hour).Unix()
+	otherAudience := suite.claims()
+	otherAudience["aud"] = "other-service"
+	otherIssuer := suite.claims()
+	otherIssuer["iss"] = "https://attacker.example.org"
+	withoutExpiration := suite.claims()
+	delete(withoutExpiration, "exp")
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	suite.Require().NoError(err)
+
+	tokens := map[string]string{
+		"expired":            suite.token(jwt.SigningMethodRS256, suite.rsaKey, "rsa-key", expired),
+		"other audience":     suite.token(jwt.SigningMethodRS256, suite.rsaKey, "rsa-key", otherAudience),
+		"other issuer":       suite.token(jwt.SigningMethodRS256, suite.rsaKey, "rsa-key", otherIssuer),
+		"without expiration": suite.token(jwt.SigningMethodRS256, suite.rsaKey, "rsa-key", withoutExpiration),
+		"unknown key":        suite.token(jwt.SigningMethodRS256, otherKey, "other-key", suite.claims()),
+		"forged signature":   suite.token(jwt.SigningMethodRS256, otherKey, "rsa-key", suite.claims()),
+		"symmetric":          suite.token(jwt.SigningMethodHS256, []byte("secret"), "rsa-key", suite.claims()),
+		"unsigned":           suite.token(jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, "", suite.claims()),
+		"malformed":          "not-a-token",
+	}
+
+	for name, token := range tokens {
+		// ACT
+		response := suite.request("/api/test", "Bearer "+token)
+
+		// ASSERT
+		suite.Equal(http.StatusUnauthorized, response.Code, name)
+		suite.Contains(response.Header().Get("WWW-Authenticate"), `error="invalid_token"`, name)
+		suite.Equal("application/problem+json", response.Header().Get("Content-Type"), name)
+		suite.Contains(response.Body.String(), `"code":"invalid-credentials"`, name)
+	}
+}
+
+func (suite *AuthenticatorSuite) Test_Middleware_RejectsMalformedAuthorization() {
+	// ARRANGE
+	token := suite.token(jwt.SigningMethodRS256, suite.rsaKey, "rsa-key", suite.claims())
+
+	// ACT
+	empty := suite.request("/api/test", "Bearer ")
+	twice := suite.request("/api/test?access_token="+token, "Bearer "+token)
+
+	// ASSERT
+	suite.Equal(http.StatusBadRequest, empty.Code)
+	suite.Contains(empty.Header().Get("WWW-Authenticate"), `error="invalid_request"`)
+	suite.Equal(http.StatusBadRequest, twice.Code)
+	suite.Contains(twice.Header().Get("WWW-Authenticate"), `error="invalid_request"`)
+}
+
+func (suite *AuthenticatorSuite) Test_Middleware_DiscoversRotatedKeysOfIssuer() {
+	// ARRANGE
+	var jwks atomic.Value
+	jwks.Store(suite.jwks(map[string]interface{}{"rsa-key": &suite.rsaKey.PublicKey}))
+	var issuer *httptest.Server
+	issuer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			json.NewEncoder(w).Encode(map[string]string{"issuer": issuer.URL, "jwks_uri": issuer.URL + "/certs"})
+		case "/certs":
+			w.Write(jwks.Load().([]byte))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	suite.T().Cleanup(issuer.Close)
+	authenticator, err := NewAuthenticator(Config{Issuer: issuer.URL, Audience: testAudience})
+	suite.Require().NoError(err)
+	suite.router = suite.newRouter(authenticator)
+	claims := suite.claims()
+	claims["iss"] = issuer.URL
+
+	// ACT
+	before := suite.request("/api/test", "Bearer "+suite.token(jwt.SigningMethodRS256, suite.rsaKey, "rsa-key", claims))
+	jwks.Store(suite.jwks(map[string]interface{}{"ec-key": &suite.ecKey.PublicKey}))
+	rotated := suite.token(jwt.SigningMethodES256, suite.ecKey, "ec-key", claims)
+	throttled := suite.request("/api/test", "Bearer "+rotated)
+	authenticator.keys.attemptedAt = time.Time{}
+	after := suite.request("/api/test", "Bearer "+rotated)
+
+	// ASSERT
+	suite.Equal(http.StatusOK, before.Code)
+	suite.Equal(http.StatusUnauthorized, throttled.Code)
+	suite.Equal(http.StatusOK, after.Code)
+}
+
+func (suite *AuthenticatorSuite) Test_Middleware_IssuerUnavailable() {
+	// ARRANGE
+	issuer := httptest.NewServer(http.NotFoundHandler())
+	suite.T().Cleanup(issuer.Close)
+	authenticator, err := NewAuthenticator(Config{Issuer: issuer.URL})
+	suite.Require().NoError(err)
+	suite.router = suite.newRouter(authenticator)
+	claims := suite.claims()
+	claims["iss"] = issuer.URL
+
+	// ACT
+	response := suite.request("/api/test", "Bearer "+suite.token(jwt.SigningMethodRS256, suite.rsaKey, "rsa-key", claims))
+
+	// ASSERT
+	suite.Equal(http.StatusServiceUnavailable, response.Code)
+}
+
+func (suite *AuthenticatorSuite) Test_Middleware_VerifiesKnownKeysWhileKeysAreLoaded() {
+	// ARRANGE
+	var slow atomic.Bool
+	fetching := make(chan struct{}, 1)
+	release := make(chan struct{})
+	var issuer *httptest.Server
+	issuer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			json.NewEncoder(w).Encode(map[string]string{"issuer": issuer.URL, "jwks_uri": issuer.URL + "/certs"})
+		case "/certs":
+			if slow.Load() {
+				fetching <- struct{}{}
+				<-release
+			}
+			w.Write(suite.jwks(map[string]interface{}{"rsa-key": &suite.rsaKey.PublicKey}))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	suite.T().Cleanup(issuer.Close)
+	authenticator, err := NewAuthenticator(Config{Issuer: issuer.URL, Audience: testAudience})
+	suite.Require().NoError(err)
+	suite.router = suite.newRouter(authenticator)
+	claims := suite.claims()
+	claims["iss"] = issuer.URL
+	known := "Bearer " + suite.token(jwt.SigningMethodRS256, suite.rsaKey, "rsa-key", claims)
+	suite.Require().Equal(http.StatusOK, suite.request("/api/test", known).Code)
+	slow.Store(true)
+	authenticator.keys.attemptedAt = time.Time{}
+	unknown := make(chan *httptest.ResponseRecorder)
+	go func() {
+		unknown <- suite.request("/api/test", "Bearer "+suite.token(jwt.SigningMethodRS256, suite.rsaKey, "other-key", claims))
+	}()
+	<-fetching
+
+	// ACT
+	response := suite.request("/api/test", known)
+
+	// ASSERT
+	suite.Equal(http.StatusOK, response.Code)
+	close(release)
+	suite.Equal(http.StatusUnauthorized, (<-unknown).Code)
+}
+
+func (suite *AuthenticatorSuite) Test_NewAuthenticator_RefusesMissingConfigurationUnlessAnonymousAllowed() {
+	// ARRANGE
+	suite.T().Setenv("AMBULANCE_API_AUTH_ISSUER", "")
+	suite.T().Setenv("AMBULANCE_API_AUTH_JWKS_FILE", "")
+	suite.T().Setenv("AMBULANCE_API_ALLOW_ANONYMOUS", "")
+
+	// ACT
+	_, refused := NewAuthenticator(Config{})
+	_, allowed := NewAuthenticator(Config{AllowAnonymous: true})
+	suite.T().Setenv("AMBULANCE_API_ALLOW_ANONYMOUS", "true")
+	_, allowedByEnv := NewAuthenticator(Config{})
+
+	// ASSERT
+	suite.ErrorIs(refused, ErrAnonymousNotAllowed)
+	suite.ErrorIs(allowed, ErrNotConfigured)
+	suite.ErrorIs(allowedByEnv, ErrNotConfigured)
+}
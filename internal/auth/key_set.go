@@ -0,0 +1,271 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/sync/singleflight"
+)
+
+var (
+	errKeysUnavailable = errors.New("signing keys are not available")
+	errUnknownKey      = errors.New("token is signed by unknown key")
+)
+
+const (
+	// keys are reloaded after the interval to pick up the rotated keys
+	keySetRefreshInterval = time.Hour
+	// keys are loaded at most once per interval, also when the token is
+	// signed by unknown key or the loading fails
+	keySetMinRefreshInterval = 10 * time.Second
+	// time given to the issuer to provide its metadata and keys
+	keySetRequestTimeout = 10 * time.Second
+)
+
+// keySet keeps the public keys of the token issuer loaded from the JSON Web
+// Key Set. Keys are loaded from the file or from the URL published in the
+// OpenID Connect metadata of the issuer.
+type keySet struct {
+	issuer   string
+	jwksFile string
+	client   *http.Client
+
+	// concurrent requests wait for the same load, which is done without the lock
+	loads singleflight.Group
+	// used only by the load in progress
+	jwksUri string
+
+	lock     sync.RWMutex
+	keys     map[string]interface{}
+	loadedAt time.Time
+	// time and error of the last load attempt
+	attemptedAt time.Time
+	loadErr     error
+}
+
+// jsonWebKey is the public key of RFC 7517, only RSA and P-256 keys are supported
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// verificationKey provides the key of the token, all keys of the algorithm
+// are tried when the token does not identify its key
+func (s *keySet) verificationKey(ctx context.Context, kid string, method jwt.SigningMethod) (interface{}, error) {
+	keys, loadErr, refresh := s.current(kid)
+	if refresh {
+		// the load is shared by the waiting requests, it is not cancelled with the first of them
+		s.loads.Do("keys", func() (interface{}, error) {
+			return nil, s.load(context.WithoutCancel(ctx))
+		})
+		keys, loadErr, _ = s.current(kid)
+	}
+	if keys == nil {
+		return nil, fmt.Errorf("%w: %v", errKeysUnavailable, loadErr)
+	}
+
+	if kid != "" {
+		if key, ok := keys[kid]; ok && keyMatches(key, method) {
+			return key, nil
+		}
+		return nil, errUnknownKey
+	}
+	candidates := jwt.VerificationKeySet{}
+	for _, key := range keys {
+		if keyMatches(key, method) {
+			candidates.Keys = append(candidates.Keys, key)
+		}
+	}
+	if len(candidates.Keys) == 0 {
+		return nil, errUnknownKey
+	}
+	return candidates, nil
+}
+
+// current provides the loaded keys and tells if they shall be loaded again
+func (s *keySet) current(kid string) (map[string]interface{}, error, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	_, known := s.keys[kid]
+	refresh := s.keys == nil || time.Since(s.loadedAt) > keySetRefreshInterval || (kid != "" && !known)
+	return s.keys, s.loadErr, refresh && time.Since(s.attemptedAt) > keySetMinRefreshInterval
+}
+
+func keyMatches(key interface{}, method jwt.SigningMethod) bool {
+	switch key.(type) {
+	case *rsa.PublicKey:
+		return method == jwt.SigningMethodRS256
+	case *ecdsa.PublicKey:
+		return method == jwt.SigningMethodES256
+	}
+	return false
+}
+
+// load replaces the keys by the current content of the key set, the keys
+// are kept if the loading fails. The lock is held only to replace the keys.
+func (s *keySet) load(ctx context.Context) error {
+	s.lock.Lock()
+	// the keys were loaded while the request waited for the previous load
+	if time.Since(s.attemptedAt) <= keySetMinRefreshInterval {
+		defer s.lock.Unlock()
+		return s.loadErr
+	}
+	attemptedAt := time.Now()
+	s.attemptedAt = attemptedAt
+	s.lock.Unlock()
+
+	var content []byte
+	var err error
+	if s.jwksFile != "" {
+		content, err = os.ReadFile(s.jwksFile)
+	} else {
+		content, err = s.fetchJwks(ctx)
+	}
+	var keys map[string]interface{}
+	if err == nil {
+		keys, err = parseJwks(content)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.loadErr = err
+	if err == nil {
+		s.keys = keys
+		s.loadedAt = attemptedAt
+	}
+	return err
+}
+
+func (s *keySet) fetchJwks(ctx context.Context) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, keySetRequestTimeout)
+	defer cancel()
+
+	if s.jwksUri == "" {
+		var metadata struct {
+			Issuer  string `json:"issuer"`
+			JwksUri string `json:"jwks_uri"`
+		}
+		content, err := s.get(ctx, strings.TrimSuffix(s.issuer, "/")+"/.well-known/openid-configuration")
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(content, &metadata); err != nil {
+			return nil, fmt.Errorf("invalid OpenID Connect metadata: %w", err)
+		}
+		// metadata must belong to the configured issuer, see OpenID Connect Discovery 4.3
+		if metadata.Issuer != s.issuer || metadata.JwksUri == "" {
+			return nil, fmt.Errorf("OpenID Connect metadata of issuer %q does not match the configured issuer", metadata.Issuer)
+		}
+		s.jwksUri = metadata.JwksUri
+	}
+	return s.get(ctx, s.jwksUri)
+}
+
+func (s *keySet) get(ctx context.Context, url string) ([]byte, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Accept", "application/json")
+	response, err := s.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected response status %d from %s", response.StatusCode, url)
+	}
+	return io.ReadAll(response.Body)
+}
+
+// parseJwks decodes the signing keys of the key set, unsupported keys are skipped
+func parseJwks(content []byte) (map[string]interface{}, error) {
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(content, &jwks); err != nil {
+		return nil, fmt.Errorf("invalid JSON Web Key Set: %w", err)
+	}
+
+	keys := map[string]interface{}{}
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		var key interface{}
+		var err error
+		switch {
+		case jwk.Kty == "RSA":
+			key, err = jwk.rsaPublicKey()
+		case jwk.Kty == "EC" && jwk.Crv == "P-256":
+			key, err = jwk.ecdsaPublicKey()
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %q: %w", jwk.Kid, err)
+		}
+		keys[jwk.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("JSON Web Key Set contains no RSA or P-256 signing key")
+	}
+	return keys, nil
+}
+
+func (jwk jsonWebKey) rsaPublicKey() (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+	if err != nil {
+		return nil, err
+	}
+	e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+	if err != nil {
+		return nil, err
+	}
+	exponent := new(big.Int).SetBytes(e)
+	if len(n) == 0 || !exponent.IsInt64() || exponent.Int64() < 3 || exponent.Int64() > 1<<31-1 {
+		return nil, errors.New("invalid RSA modulus or exponent")
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+}
+
+func (jwk jsonWebKey) ecdsaPublicKey() (*ecdsa.PublicKey, error) {
+	x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+	if err != nil {
+		return nil, err
+	}
+	y, err := base64.RawURLEncoding.DecodeString(jwk.Y)
+	if err != nil {
+		return nil, err
+	}
+	if len(x) != 32 || len(y) != 32 {
+		return nil, errors.New("invalid P-256 coordinates")
+	}
+	// the point must lie on the curve, otherwise the signatures cannot be trusted
+	point := append(append([]byte{4}, x...), y...)
+	if _, err := ecdh.P256().NewPublicKey(point); err != nil {
+		return nil, err
+	}
+	return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+}
@@ -0,0 +1,96 @@
+package auth
+
+import (
+	_ "embed"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed default_policy.yaml
+var defaultPolicy []byte
+
+// Policy grants permissions to the roles of the authenticated principals
+type Policy struct {
+	Roles map[string][]string `yaml:"roles"`
+	// unauthenticated requests are permitted, the service relies on the
+	// authentication in front of it, only the authenticated principals are
+	// then restricted to their permissions
+	AllowAnonymous bool `yaml:"-"`
+}
+
+// LoadPolicy reads the policy from the file, the default policy is used if
+// the path is empty
+func LoadPolicy(path string) (*Policy, error) {
+	content := defaultPolicy
+	if path != "" {
+		var err error
+		if content, err = os.ReadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	policy := &Policy{}
+	if err := yaml.Unmarshal(content, policy); err != nil {
+		return nil, fmt.Errorf("invalid authorization policy: %w", err)
+	}
+	if len(policy.Roles) == 0 {
+		return nil, fmt.Errorf("authorization policy grants no permissions")
+	}
+	return policy, nil
+}
+
+// LoadPolicyFromEnv reads the policy from the file referenced by the
+// AMBULANCE_API_AUTH_POLICY_FILE environment variable
+func LoadPolicyFromEnv() (*Policy, error) {
+	return LoadPolicy(os.Getenv("AMBULANCE_API_AUTH_POLICY_FILE"))
+}
+
+// Allows checks whether the principal was granted the permission directly or
+// by any of its roles
+func (p *Policy) Allows(principal *Principal, permission string) bool {
+	if slices.Contains(principal.Permissions, permission) {
+		return true
+	}
+	for _, role := range principal.Roles {
+		if slices.Contains(p.Roles[role], permission) {
+			return true
+		}
+	}
+	return false
+}
+
+// Require rejects the requests of the principals without the permission. The
+// request must be authenticated before, unauthenticated requests are rejected
+// unless the policy allows anonymous requests.
+func (p *Policy) Require(permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, ok := PrincipalFromContext(c)
+		if !ok && p.AllowAnonymous {
+			c.Next()
+			return
+		}
+		if !ok {
+			abortWithProblem(
+				c,
+				http.StatusUnauthorized,
+				problemUnauthenticated,
+				"Request is not authenticated",
+				"missing principal of the request")
+			return
+		}
+		if !p.Allows(principal, permission) {
+			abortWithProblem(
+				c,
+				http.StatusForbidden,
+				problemForbidden,
+				"Roles of the user do not permit the operation",
+				"missing permission "+permission)
+			return
+		}
+		c.Next()
+	}
+}
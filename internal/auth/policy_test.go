@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/suite"
+)
+
+type PolicySuite struct {
+	suite.Suite
+}
+
+func TestPolicySuite(t *testing.T) {
+	suite.Run(t, new(PolicySuite))
+}
+
+func (suite *PolicySuite) Test_LoadPolicy_DefaultPolicy() {
+	// ARRANGE
+	nurse := newPrincipal(jwt.MapClaims{"sub": "nurse", "roles": []interface{}{"nurse"}})
+	doctor := newPrincipal(jwt.MapClaims{"sub": "doctor", "realm_access": map[string]interface{}{"roles": []interface{}{"doctor", "offline_access"}}})
+
+	// ACT
+	policy, err := LoadPolicy("")
+
+	// ASSERT
+	suite.Require().NoError(err)
+	suite.True(policy.Allows(nurse, "waiting-list:write"))
+	suite.False(policy.Allows(nurse, "waiting-list:finish"))
+	suite.True(policy.Allows(doctor, "waiting-list:finish"))
+	suite.False(policy.Allows(doctor, "ambulances:delete"))
+}
+
+func (suite *PolicySuite) Test_LoadPolicy_ReplacesDefaultPolicyByFile() {
+	// ARRANGE
+	path := filepath.Join(suite.T().TempDir(), "policy.yaml")
+	suite.Require().NoError(os.WriteFile(path, []byte("roles:\n  nurse: [waiting-list:finish]\n"), 0o600))
+	nurse := newPrincipal(jwt.MapClaims{"sub": "nurse", "roles": []interface{}{"nurse"}})
+
+	// ACT
+	policy, err := LoadPolicy(path)
+	_, missing := LoadPolicy(filepath.Join(suite.T().TempDir(), "missing.yaml"))
+
+	// ASSERT
+	suite.Require().NoError(err)
+	suite.True(policy.Allows(nurse, "waiting-list:finish"))
+	suite.False(policy.Allows(nurse, "waiting-list:write"))
+	suite.Error(missing)
+}
@@ -0,0 +1,76 @@
+package auth
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// key of the authenticated principal in the gin context
+const principalKey = "auth_principal"
+
+// Principal is the user authenticated by the bearer token of the request
+type Principal struct {
+	Subject string
+	Issuer  string
+	// human readable identity of the user, the preferred_username or email
+	// claim of the token, or the subject if the token has neither
+	Username string
+	// roles granted by the roles claim of the token, or by the realm_access
+	// claim of the Keycloak tokens
+	Roles []string
+	// permissions granted directly, regardless of the policy, e.g. the scopes
+	// of the API key
+	Permissions []string
+	// all claims of the token
+	Claims jwt.MapClaims
+}
+
+func newPrincipal(claims jwt.MapClaims) *Principal {
+	principal := &Principal{Claims: claims}
+	principal.Subject, _ = claims.GetSubject()
+	principal.Issuer, _ = claims.GetIssuer()
+	for _, claim := range []string{"preferred_username", "email"} {
+		if value, ok := claims[claim].(string); ok && value != "" {
+			principal.Username = value
+			break
+		}
+	}
+	if principal.Username == "" {
+		principal.Username = principal.Subject
+	}
+	principal.Roles = append(stringsClaim(claims["roles"]), realmRoles(claims)...)
+	return principal
+}
+
+func realmRoles(claims jwt.MapClaims) []string {
+	realmAccess, _ := claims["realm_access"].(map[string]interface{})
+	return stringsClaim(realmAccess["roles"])
+}
+
+// StringsClaim returns the strings of the array claim, other values are skipped
+func (p *Principal) StringsClaim(name string) []string {
+	return stringsClaim(p.Claims[name])
+}
+
+func stringsClaim(claim interface{}) []string {
+	values, _ := claim.([]interface{})
+	result := []string{}
+	for _, value := range values {
+		if value, ok := value.(string); ok {
+			result = append(result, value)
+		}
+	}
+	return result
+}
+
+// SetPrincipal exposes the principal authenticated by the request on the context
+func SetPrincipal(c *gin.Context, principal *Principal) {
+	c.Set(principalKey, principal)
+}
+
+// PrincipalFromContext returns the principal authenticated by the middleware,
+// ok is false if the request was not authenticated
+func PrincipalFromContext(c *gin.Context) (principal *Principal, ok bool) {
+	principal, ok = c.Value(principalKey).(*Principal)
+	return principal, ok
+}
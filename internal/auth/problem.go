@@ -0,0 +1,34 @@
+package auth
+
+import (
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// problem types reported by the authentication and authorization, the codes
+// are shared with the problems of the API handlers
+const (
+	problemUnauthenticated        = "unauthenticated"
+	problemInvalidCredentials     = "invalid-credentials"
+	problemMalformedCredentials   = "malformed-credentials"
+	problemCredentialsUnavailable = "credentials-unavailable"
+	problemForbidden              = "forbidden"
+)
+
+// abortWithProblem rejects the request with the RFC 7807 problem details of
+// the same shape as the problems reported by the API handlers
+func abortWithProblem(c *gin.Context, status int, code string, title string, detail string) {
+	problem := gin.H{
+		"type":     "urn:ambulance-wl:problem:" + code,
+		"title":    title,
+		"status":   status,
+		"detail":   detail,
+		"instance": c.Request.URL.Path,
+		"code":     code,
+	}
+	if spanContext := trace.SpanContextFromContext(c.Request.Context()); spanContext.HasTraceID() {
+		problem["traceId"] = spanContext.TraceID().String()
+	}
+	c.Header("Content-Type", "application/problem+json")
+	c.AbortWithStatusJSON(status, problem)
+}
@@ -0,0 +1,177 @@
+package db_service
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// ErrChangeHistoryLost is returned by ChangeFeed.Watch when the changes following
+// the resume token are no longer available, the watcher has to start over
+var ErrChangeHistoryLost = fmt.Errorf("change history lost")
+
+type ChangeOperation string
+
+const (
+	ChangeCreated ChangeOperation = "created"
+	ChangeUpdated ChangeOperation = "updated"
+	ChangeDeleted ChangeOperation = "deleted"
+)
+
+// Change is modification of the stored document made by any instance of the service
+type Change[DocType interface{}] struct {
+	Operation ChangeOperation
+	// parent of the child document, empty for the plain documents
+	ParentId string
+	// id of the document, it may be empty for the deleted plain documents
+	// if the database does not record their content before the change
+	Id string
+	// stored document, nil if it was deleted
+	Document *DocType
+	// watching resumed after this token continues with the next change
+	ResumeToken string
+}
+
+// ChangeFeed is implemented by the services which notify about changes of the
+// stored documents, including changes made by other replicas of the service.
+type ChangeFeed[DocType interface{}] interface {
+	// Watch calls the handle for each change made after the change identified by
+	// the resumeAfter token, or after the call if the token is empty. It blocks
+	// until the context is cancelled or the feed fails.
+	Watch(ctx context.Context, resumeAfter string, handle func(change Change[DocType])) error
+}
+
+// number of recent changes the in-memory services keep for resuming the watchers
+const memoryChangeHistorySize = 1024
+
+// changes buffered for each in-memory watcher, slow watchers have to resume
+const memoryWatcherBufferSize = 256
+
+// memoryChange is a change recorded by the in-memory services, document is kept
+// in its BSON representation so that every watcher decodes its own copy
+type memoryChange struct {
+	sequence  uint64
+	operation ChangeOperation
+	parentId  string
+	id        string
+	document  bson.Raw
+}
+
+// memoryChangeFeed is ChangeFeed of the in-memory services, it observes only
+// changes made by the service instance itself
+type memoryChangeFeed[DocType interface{}] struct {
+	lock     sync.Mutex
+	sequence uint64
+	history  []memoryChange
+	watchers map[chan memoryChange]struct{}
+}
+
+// notify records the change, services call it while holding their lock so that
+// the changes are delivered in the order in which they were made
+func (f *memoryChangeFeed[DocType]) notify(operation ChangeOperation, parentId string, id string, document bson.Raw) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.sequence++
+	change := memoryChange{sequence: f.sequence, operation: operation, parentId: parentId, id: id, document: document}
+	f.history = append(f.history, change)
+	if len(f.history) > memoryChangeHistorySize {
+		f.history = f.history[1:]
+	}
+
+	for watcher := range f.watchers {
+		select {
+		case watcher <- change:
+		default:
+			// watcher does not keep up, it resumes from the history
+			delete(f.watchers, watcher)
+			close(watcher)
+		}
+	}
+}
+
+func (f *memoryChangeFeed[DocType]) Watch(ctx context.Context, resumeAfter string, handle func(change Change[DocType])) error {
+	replay, changes, err := f.subscribe(resumeAfter)
+	if err != nil {
+		return err
+	}
+	defer f.unsubscribe(changes)
+
+	for _, change := range replay {
+		if err := f.deliver(change, handle); err != nil {
+			return err
+		}
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case change, ok := <-changes:
+			if !ok {
+				return fmt.Errorf("change feed watcher does not keep up with the changes")
+			}
+			if err := f.deliver(change, handle); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// subscribe registers the watcher and returns changes after the resume token
+func (f *memoryChangeFeed[DocType]) subscribe(resumeAfter string) ([]memoryChange, chan memoryChange, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	replay := []memoryChange{}
+	if resumeAfter != "" {
+		lastSequence, err := strconv.ParseUint(resumeAfter, 10, 64)
+		oldest := f.sequence + 1
+		if len(f.history) > 0 {
+			oldest = f.history[0].sequence
+		}
+		if err != nil || lastSequence > f.sequence || lastSequence+1 < oldest {
+			return nil, nil, ErrChangeHistoryLost
+		}
+		for _, change := range f.history {
+			if change.sequence > lastSequence {
+				replay = append(replay, change)
+			}
+		}
+	}
+
+	changes := make(chan memoryChange, memoryWatcherBufferSize)
+	if f.watchers == nil {
+		f.watchers = map[chan memoryChange]struct{}{}
+	}
+	f.watchers[changes] = struct{}{}
+	return replay, changes, nil
+}
+
+func (f *memoryChangeFeed[DocType]) unsubscribe(changes chan memoryChange) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if _, exists := f.watchers[changes]; exists {
+		delete(f.watchers, changes)
+		close(changes)
+	}
+}
+
+func (f *memoryChangeFeed[DocType]) deliver(change memoryChange, handle func(change Change[DocType])) error {
+	delivered := Change[DocType]{
+		Operation:   change.operation,
+		ParentId:    change.parentId,
+		Id:          change.id,
+		ResumeToken: strconv.FormatUint(change.sequence, 10),
+	}
+	if change.document != nil {
+		if err := bson.Unmarshal(change.document, &delivered.Document); err != nil {
+			return err
+		}
+	}
+	handle(delivered)
+	return nil
+}
@@ -0,0 +1,186 @@
+package db_service
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// memoryChildSvc is in-memory counterpart of mongoChildSvc, documents are kept
+// in their BSON representation grouped by the parent id
+type memoryChildSvc[DocType interface{}] struct {
+	lock      sync.RWMutex
+	documents map[string]map[string]bson.Raw
+	changes   memoryChangeFeed[DocType]
+}
+
+func NewMemoryChildService[DocType interface{}]() DbChildService[DocType] {
+	return &memoryChildSvc[DocType]{
+		documents: map[string]map[string]bson.Raw{},
+	}
+}
+
+func (m *memoryChildSvc[DocType]) Disconnect(ctx context.Context) error {
+	return nil
+}
+
+func (m *memoryChildSvc[DocType]) Watch(ctx context.Context, resumeAfter string, handle func(change Change[DocType])) error {
+	return m.changes.Watch(ctx, resumeAfter, handle)
+}
+
+func (m *memoryChildSvc[DocType]) CreateChildDocument(ctx context.Context, parentId string, id string, document *DocType) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	children, exists := m.documents[parentId]
+	if !exists {
+		children = map[string]bson.Raw{}
+		m.documents[parentId] = children
+	}
+	if _, exists := children[id]; exists {
+		return ErrConflict
+	}
+
+	if versioned, ok := any(document).(Versioned); ok {
+		versioned.SetVersion(1)
+	}
+
+	raw, err := bson.Marshal(document)
+	if err != nil {
+		return err
+	}
+	children[id] = raw
+	m.changes.notify(ChangeCreated, parentId, id, raw)
+	return nil
+}
+
+func (m *memoryChildSvc[DocType]) FindChildDocument(ctx context.Context, parentId string, id string) (*DocType, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	raw, exists := m.documents[parentId][id]
+	if !exists {
+		return nil, ErrNotFound
+	}
+
+	var document *DocType
+	if err := bson.Unmarshal(raw, &document); err != nil {
+		return nil, err
+	}
+	return document, nil
+}
+
+func (m *memoryChildSvc[DocType]) FindChildDocuments(ctx context.Context, parentId string, query Query) ([]*DocType, error) {
+	if query.Cursor != "" || len(query.Projection) > 0 {
+		return nil, fmt.Errorf("%w: cursor and projection of child documents are not supported", ErrInvalidQuery)
+	}
+	if err := query.checkOperators(); err != nil {
+		return nil, err
+	}
+
+	type child struct {
+		id       string
+		raw      bson.Raw
+		document bson.M
+	}
+	m.lock.RLock()
+	matching := []child{}
+	for id, raw := range m.documents[parentId] {
+		document := bson.M{}
+		if err := bson.Unmarshal(raw, &document); err != nil {
+			m.lock.RUnlock()
+			return nil, err
+		}
+		if query.matches(document, nil) {
+			matching = append(matching, child{id: id, raw: raw, document: document})
+		}
+	}
+	m.lock.RUnlock()
+
+	// children are ordered by their id unless the query orders them otherwise
+	sortField, direction := query.sortField()
+	slices.SortFunc(matching, func(left, right child) int {
+		order := 0
+		if sortField != "id" {
+			order = compareValues(lookupField(left.document, sortField), lookupField(right.document, sortField))
+		}
+		if order == 0 {
+			order = strings.Compare(left.id, right.id)
+		}
+		return order * direction
+	})
+	if query.Limit > 0 && len(matching) > query.Limit {
+		matching = matching[:query.Limit]
+	}
+
+	documents := make([]*DocType, 0, len(matching))
+	for _, child := range matching {
+		var document *DocType
+		if err := bson.Unmarshal(child.raw, &document); err != nil {
+			return nil, err
+		}
+		documents = append(documents, document)
+	}
+	return documents, nil
+}
+
+func (m *memoryChildSvc[DocType]) UpdateChildDocument(ctx context.Context, parentId string, id string, document *DocType) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	stored, exists := m.documents[parentId][id]
+	if !exists {
+		return ErrNotFound
+	}
+
+	if versioned, ok := any(document).(Versioned); ok {
+		var storedVersion int64
+		if value, err := stored.LookupErr("version"); err == nil {
+			storedVersion, _ = value.AsInt64OK()
+		}
+		if storedVersion != versioned.GetVersion() {
+			return ErrVersionConflict
+		}
+		versioned.SetVersion(storedVersion + 1)
+	}
+
+	raw, err := bson.Marshal(document)
+	if err != nil {
+		return err
+	}
+	m.documents[parentId][id] = raw
+	m.changes.notify(ChangeUpdated, parentId, id, raw)
+	return nil
+}
+
+func (m *memoryChildSvc[DocType]) DeleteChildDocument(ctx context.Context, parentId string, id string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, exists := m.documents[parentId][id]; !exists {
+		return ErrNotFound
+	}
+	delete(m.documents[parentId], id)
+	if len(m.documents[parentId]) == 0 {
+		delete(m.documents, parentId)
+	}
+	m.changes.notify(ChangeDeleted, parentId, id, nil)
+	return nil
+}
+
+func (m *memoryChildSvc[DocType]) DeleteChildDocuments(ctx context.Context, parentId string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	ids := slices.Sorted(maps.Keys(m.documents[parentId]))
+	delete(m.documents, parentId)
+	for _, id := range ids {
+		m.changes.notify(ChangeDeleted, parentId, id, nil)
+	}
+	return nil
+}
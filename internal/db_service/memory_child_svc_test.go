@@ -0,0 +1,164 @@
+package db_service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type MemoryChildServiceSuite struct {
+	suite.Suite
+	sut DbChildService[testDocument]
+	ctx context.Context
+}
+
+func TestMemoryChildServiceSuite(t *testing.T) {
+	suite.Run(t, new(MemoryChildServiceSuite))
+}
+
+func (suite *MemoryChildServiceSuite) SetupTest() {
+	suite.sut = NewMemoryChildService[testDocument]()
+	suite.ctx = context.Background()
+	for _, key := range []childKey{{"p1", "b"}, {"p1", "a"}, {"p2", "a"}} {
+		document := &testDocument{Id: key.Id, Name: key.ParentId + "/" + key.Id}
+		suite.Require().NoError(suite.sut.CreateChildDocument(suite.ctx, key.ParentId, key.Id, document))
+	}
+}
+
+func (suite *MemoryChildServiceSuite) Test_CreateChildDocument_ConflictWithinParentOnly() {
+	// ACT
+	conflictErr := suite.sut.CreateChildDocument(suite.ctx, "p2", "a", &testDocument{Id: "a"})
+	otherParentErr := suite.sut.CreateChildDocument(suite.ctx, "p3", "a", &testDocument{Id: "a"})
+
+	// ASSERT
+	suite.ErrorIs(conflictErr, ErrConflict)
+	suite.NoError(otherParentErr)
+}
+
+func (suite *MemoryChildServiceSuite) Test_FindChildDocuments_ReturnsChildrenOfParent() {
+	// ACT
+	documents, err := suite.sut.FindChildDocuments(suite.ctx, "p1", Query{})
+	missing, missingErr := suite.sut.FindChildDocuments(suite.ctx, "missing", Query{})
+
+	// ASSERT
+	suite.Require().NoError(err)
+	suite.Require().Len(documents, 2)
+	suite.Equal("p1/a", documents[0].Name)
+	suite.Equal("p1/b", documents[1].Name)
+	suite.NoError(missingErr)
+	suite.Empty(missing)
+}
+
+func (suite *MemoryChildServiceSuite) Test_FindChildDocuments_FiltersSortsAndLimits() {
+	// ARRANGE
+	for _, id := range []string{"c", "d"} {
+		suite.Require().NoError(suite.sut.CreateChildDocument(suite.ctx, "p1", id, &testDocument{Id: id, Name: "p1/" + id}))
+	}
+
+	// ACT
+	filtered, err := suite.sut.FindChildDocuments(suite.ctx, "p1", Query{
+		Filters: []Filter{{Field: "name", Operator: FilterIn, Value: []string{"p1/a", "p1/c", "p1/d", "p2/a"}}},
+		SortBy:  "-name",
+		Limit:   2,
+	})
+	_, invalidErr := suite.sut.FindChildDocuments(suite.ctx, "p1", Query{Cursor: "next-page"})
+
+	// ASSERT
+	suite.Require().NoError(err)
+	suite.Require().Len(filtered, 2)
+	suite.Equal("p1/d", filtered[0].Name)
+	suite.Equal("p1/c", filtered[1].Name)
+	suite.ErrorIs(invalidErr, ErrInvalidQuery)
+}
+
+func (suite *MemoryChildServiceSuite) Test_UpdateChildDocument_VersionConflict() {
+	// ARRANGE
+	first, _ := suite.sut.FindChildDocument(suite.ctx, "p1", "a")
+	second, _ := suite.sut.FindChildDocument(suite.ctx, "p1", "a")
+
+	// ACT
+	firstErr := suite.sut.UpdateChildDocument(suite.ctx, "p1", "a", first)
+	secondErr := suite.sut.UpdateChildDocument(suite.ctx, "p1", "a", second)
+	missingErr := suite.sut.UpdateChildDocument(suite.ctx, "p1", "missing", &testDocument{})
+
+	// ASSERT
+	suite.NoError(firstErr)
+	suite.ErrorIs(secondErr, ErrVersionConflict)
+	suite.ErrorIs(missingErr, ErrNotFound)
+}
+
+func (suite *MemoryChildServiceSuite) Test_DeleteChildDocuments() {
+	// ACT
+	deleteErr := suite.sut.DeleteChildDocument(suite.ctx, "p1", "a")
+	secondDeleteErr := suite.sut.DeleteChildDocument(suite.ctx, "p1", "a")
+	deleteAllErr := suite.sut.DeleteChildDocuments(suite.ctx, "p1")
+
+	// ASSERT
+	suite.NoError(deleteErr)
+	suite.ErrorIs(secondDeleteErr, ErrNotFound)
+	suite.NoError(deleteAllErr)
+	documents, _ := suite.sut.FindChildDocuments(suite.ctx, "p1", Query{})
+	suite.Empty(documents)
+	_, err := suite.sut.FindChildDocument(suite.ctx, "p2", "a")
+	suite.NoError(err)
+}
+
+// watch collects the changes delivered to the watcher until the test ends
+func (suite *MemoryChildServiceSuite) watch(resumeAfter string) (<-chan Change[testDocument], <-chan error) {
+	ctx, cancel := context.WithCancel(suite.ctx)
+	suite.T().Cleanup(cancel)
+	changes := make(chan Change[testDocument], 16)
+	result := make(chan error, 1)
+	go func() {
+		result <- suite.sut.(ChangeFeed[testDocument]).Watch(ctx, resumeAfter, func(change Change[testDocument]) {
+			changes <- change
+		})
+	}()
+	return changes, result
+}
+
+func (suite *MemoryChildServiceSuite) Test_Watch_DeliversChanges() {
+	// ARRANGE
+	changes, _ := suite.watch("0")
+	for range 3 {
+		<-changes // documents created by the setup
+	}
+
+	// ACT
+	document, _ := suite.sut.FindChildDocument(suite.ctx, "p1", "a")
+	document.Name = "renamed"
+	suite.Require().NoError(suite.sut.UpdateChildDocument(suite.ctx, "p1", "a", document))
+	suite.Require().NoError(suite.sut.DeleteChildDocuments(suite.ctx, "p1"))
+
+	// ASSERT
+	updated := <-changes
+	suite.Equal(ChangeUpdated, updated.Operation)
+	suite.Equal("p1", updated.ParentId)
+	suite.Equal("a", updated.Id)
+	suite.Equal("renamed", updated.Document.Name)
+	suite.Equal(int64(2), updated.Document.Version)
+	for _, id := range []string{"a", "b"} {
+		deleted := <-changes
+		suite.Equal(ChangeDeleted, deleted.Operation)
+		suite.Equal(id, deleted.Id)
+		suite.Nil(deleted.Document)
+	}
+}
+
+func (suite *MemoryChildServiceSuite) Test_Watch_ResumesAfterToken() {
+	// ARRANGE
+	changes, _ := suite.watch("0")
+	first := <-changes
+
+	// ACT
+	resumed, _ := suite.watch(first.ResumeToken)
+	_, lostErr := suite.watch("42")
+
+	// ASSERT
+	second := <-resumed
+	suite.Equal(ChangeCreated, second.Operation)
+	suite.Equal("p1", second.ParentId)
+	suite.Equal("a", second.Id)
+	suite.ErrorIs(<-lostErr, ErrChangeHistoryLost)
+}
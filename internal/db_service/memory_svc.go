@@ -0,0 +1,354 @@
+package db_service
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// memorySvc keeps documents in process memory. Documents are stored in their
+// BSON representation, so every read and write works with a deep copy and the
+// stored fields are the same as with the MongoDB service.
+type memorySvc[DocType interface{}] struct {
+	lock      sync.RWMutex
+	documents map[string]bson.Raw
+	changes   memoryChangeFeed[DocType]
+}
+
+func NewMemoryService[DocType interface{}]() DbService[DocType] {
+	return &memorySvc[DocType]{
+		documents: map[string]bson.Raw{},
+	}
+}
+
+func (m *memorySvc[DocType]) Disconnect(ctx context.Context) error {
+	return nil
+}
+
+func (m *memorySvc[DocType]) Watch(ctx context.Context, resumeAfter string, handle func(change Change[DocType])) error {
+	return m.changes.Watch(ctx, resumeAfter, handle)
+}
+
+func (m *memorySvc[DocType]) CreateDocument(ctx context.Context, id string, document *DocType) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, exists := m.documents[id]; exists {
+		return ErrConflict
+	}
+
+	if versioned, ok := any(document).(Versioned); ok {
+		versioned.SetVersion(1)
+	}
+
+	raw, err := bson.Marshal(document)
+	if err != nil {
+		return err
+	}
+	m.documents[id] = raw
+	m.changes.notify(ChangeCreated, "", id, raw)
+	return nil
+}
+
+func (m *memorySvc[DocType]) FindDocument(ctx context.Context, id string) (*DocType, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	raw, exists := m.documents[id]
+	if !exists {
+		return nil, ErrNotFound
+	}
+
+	var document *DocType
+	if err := bson.Unmarshal(raw, &document); err != nil {
+		return nil, err
+	}
+	return document, nil
+}
+
+func (m *memorySvc[DocType]) FindDocuments(ctx context.Context, query Query) (*Page[DocType], error) {
+	var cursor *pageCursor
+	if query.Cursor != "" {
+		var err error
+		if cursor, err = decodeCursor(query.Cursor); err != nil {
+			return nil, err
+		}
+	}
+	if err := query.checkOperators(); err != nil {
+		return nil, err
+	}
+
+	sortField, direction := query.sortField()
+
+	m.lock.RLock()
+	matching := []bson.M{}
+	raws := map[string]bson.Raw{}
+	for id, raw := range m.documents {
+		document := bson.M{}
+		if err := bson.Unmarshal(raw, &document); err != nil {
+			m.lock.RUnlock()
+			return nil, err
+		}
+		if query.matches(document, cursor) {
+			matching = append(matching, document)
+			raws[id] = raw
+		}
+	}
+	m.lock.RUnlock()
+
+	slices.SortFunc(matching, func(left, right bson.M) int {
+		order := compareValues(lookupField(left, sortField), lookupField(right, sortField))
+		if order == 0 {
+			order = compareValues(left["id"], right["id"])
+		}
+		return order * direction
+	})
+
+	page := &Page[DocType]{Items: []*DocType{}}
+	for index, document := range matching {
+		id, _ := document["id"].(string)
+		if query.Limit > 0 && len(page.Items) == query.Limit {
+			lastRaw, err := bson.Marshal(matching[index-1])
+			if err != nil {
+				return nil, err
+			}
+			if page.NextCursor, err = encodeCursor(lastRaw, sortField); err != nil {
+				return nil, err
+			}
+			break
+		}
+
+		raw := raws[id]
+		if len(query.Projection) > 0 {
+			projected := bson.M{}
+			for _, element := range query.mongoProjection() {
+				if value, ok := document[element.Key]; ok {
+					projected[element.Key] = value
+				}
+			}
+			var err error
+			if raw, err = bson.Marshal(projected); err != nil {
+				return nil, err
+			}
+		}
+
+		var item *DocType
+		if err := bson.Unmarshal(raw, &item); err != nil {
+			return nil, err
+		}
+		page.Items = append(page.Items, item)
+	}
+	return page, nil
+}
+
+func (m *memorySvc[DocType]) UpdateDocument(ctx context.Context, id string, document *DocType) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	stored, exists := m.documents[id]
+	if !exists {
+		return ErrNotFound
+	}
+
+	if versioned, ok := any(document).(Versioned); ok {
+		var storedVersion int64
+		if value, err := stored.LookupErr("version"); err == nil {
+			storedVersion, _ = value.AsInt64OK()
+		}
+		if storedVersion != versioned.GetVersion() {
+			return ErrVersionConflict
+		}
+		versioned.SetVersion(storedVersion + 1)
+	}
+
+	raw, err := bson.Marshal(document)
+	if err != nil {
+		return err
+	}
+	m.documents[id] = raw
+	m.changes.notify(ChangeUpdated, "", id, raw)
+	return nil
+}
+
+func (m *memorySvc[DocType]) DeleteDocument(ctx context.Context, id string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, exists := m.documents[id]; !exists {
+		return ErrNotFound
+	}
+	delete(m.documents, id)
+	m.changes.notify(ChangeDeleted, "", id, nil)
+	return nil
+}
+
+// checkOperators rejects the filters which cannot be evaluated
+func (q Query) checkOperators() error {
+	for _, filter := range q.Filters {
+		switch filter.Operator {
+		case FilterEqual, FilterIn, FilterGreaterOrEqual, FilterLessOrEqual, "":
+		default:
+			return fmt.Errorf("%w: unsupported operator %v", ErrInvalidQuery, filter.Operator)
+		}
+	}
+	return nil
+}
+
+// matches evaluates the query filters, search text, and cursor position against the document
+func (q Query) matches(document bson.M, cursor *pageCursor) bool {
+	for _, filter := range q.Filters {
+		value := lookupField(document, filter.Field)
+		switch filter.Operator {
+		case FilterEqual, "":
+			if compareValues(value, filter.Value) != 0 {
+				return false
+			}
+		case FilterIn:
+			candidates := reflect.ValueOf(filter.Value)
+			if candidates.Kind() != reflect.Slice && candidates.Kind() != reflect.Array {
+				return false
+			}
+			found := false
+			for i := 0; i < candidates.Len() && !found; i++ {
+				found = compareValues(value, candidates.Index(i).Interface()) == 0
+			}
+			if !found {
+				return false
+			}
+		case FilterGreaterOrEqual:
+			if value == nil || compareValues(value, filter.Value) < 0 {
+				return false
+			}
+		case FilterLessOrEqual:
+			if value == nil || compareValues(value, filter.Value) > 0 {
+				return false
+			}
+		}
+	}
+
+	if q.SearchText != "" && len(q.SearchFields) > 0 {
+		found := false
+		for _, field := range q.SearchFields {
+			if text, ok := lookupField(document, field).(string); ok &&
+				strings.Contains(strings.ToLower(text), strings.ToLower(q.SearchText)) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if cursor != nil {
+		field, direction := q.sortField()
+		order := 0
+		if field != "id" {
+			order = compareValues(lookupField(document, field), cursor.Value)
+		}
+		if order == 0 {
+			order = compareValues(document["id"], cursor.Id)
+		}
+		if order*direction <= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// lookupField returns value of the field, nested fields are separated by dot
+func lookupField(document bson.M, field string) interface{} {
+	var value interface{} = document
+	for _, name := range strings.Split(field, ".") {
+		object, ok := value.(bson.M)
+		if !ok {
+			return nil
+		}
+		value = object[name]
+	}
+	return value
+}
+
+// compareValues orders values similarly to MongoDB, nil is lower than any other value
+func compareValues(left, right interface{}) int {
+	left, right = normalizeValue(left), normalizeValue(right)
+	switch {
+	case left == nil && right == nil:
+		return 0
+	case left == nil:
+		return -1
+	case right == nil:
+		return 1
+	}
+
+	switch l := left.(type) {
+	case float64:
+		if r, ok := right.(float64); ok {
+			return compareOrdered(l, r)
+		}
+	case string:
+		if r, ok := right.(string); ok {
+			return strings.Compare(l, r)
+		}
+	case time.Time:
+		if r, ok := right.(time.Time); ok {
+			return l.Compare(r)
+		}
+	case bool:
+		if r, ok := right.(bool); ok {
+			return compareOrdered(boolToInt(l), boolToInt(r))
+		}
+	}
+	// different types are ordered by their type name to keep the ordering stable
+	return strings.Compare(fmt.Sprintf("%T", left), fmt.Sprintf("%T", right))
+}
+
+func normalizeValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case int:
+		return float64(v)
+	case int32:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case float32:
+		return float64(v)
+	case primitive.DateTime:
+		return v.Time()
+	case time.Time:
+		// stored timestamps have millisecond precision
+		return v.Truncate(time.Millisecond)
+	case bson.RawValue:
+		var decoded interface{}
+		if err := v.Unmarshal(&decoded); err == nil {
+			return normalizeValue(decoded)
+		}
+		return nil
+	}
+	return value
+}
+
+func compareOrdered[T int | float64](left, right T) int {
+	switch {
+	case left < right:
+		return -1
+	case left > right:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func boolToInt(value bool) int {
+	if value {
+		return 1
+	}
+	return 0
+}
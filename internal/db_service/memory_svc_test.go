@@ -0,0 +1,164 @@
+package db_service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type testDocument struct {
+	Id       string
+	Name     string
+	Capacity int
+	Since    time.Time
+	Tags     []string
+	Version  int64
+}
+
+func (d *testDocument) GetVersion() int64        { return d.Version }
+func (d *testDocument) SetVersion(version int64) { d.Version = version }
+
+type MemoryServiceSuite struct {
+	suite.Suite
+	sut DbService[testDocument]
+	ctx context.Context
+}
+
+func TestMemoryServiceSuite(t *testing.T) {
+	suite.Run(t, new(MemoryServiceSuite))
+}
+
+func (suite *MemoryServiceSuite) SetupTest() {
+	suite.sut = NewMemoryService[testDocument]()
+	suite.ctx = context.Background()
+	base := time.Date(2038, 12, 24, 10, 0, 0, 0, time.UTC)
+	for i, name := range []string{"Delta", "alpha", "Charlie", "bravo", "Echo"} {
+		document := &testDocument{
+			Id:       string(rune('a' + i)),
+			Name:     name,
+			Capacity: i % 2,
+			Since:    base.Add(time.Duration(i) * time.Hour),
+		}
+		suite.Require().NoError(suite.sut.CreateDocument(suite.ctx, document.Id, document))
+	}
+}
+
+func (suite *MemoryServiceSuite) Test_CreateDocument_Conflict() {
+	// ACT
+	err := suite.sut.CreateDocument(suite.ctx, "a", &testDocument{Id: "a"})
+
+	// ASSERT
+	suite.ErrorIs(err, ErrConflict)
+}
+
+func (suite *MemoryServiceSuite) Test_FindDocument_ReturnsCopy() {
+	// ARRANGE
+	document, err := suite.sut.FindDocument(suite.ctx, "a")
+	suite.Require().NoError(err)
+
+	// ACT
+	document.Name = "changed"
+	document.Tags = append(document.Tags, "changed")
+
+	// ASSERT
+	stored, err := suite.sut.FindDocument(suite.ctx, "a")
+	suite.Require().NoError(err)
+	suite.Equal("Delta", stored.Name)
+	suite.Empty(stored.Tags)
+	suite.Equal(int64(1), stored.Version)
+}
+
+func (suite *MemoryServiceSuite) Test_FindDocument_NotFound() {
+	// ACT
+	_, err := suite.sut.FindDocument(suite.ctx, "missing")
+
+	// ASSERT
+	suite.ErrorIs(err, ErrNotFound)
+}
+
+func (suite *MemoryServiceSuite) Test_UpdateDocument_VersionConflict() {
+	// ARRANGE
+	first, _ := suite.sut.FindDocument(suite.ctx, "a")
+	second, _ := suite.sut.FindDocument(suite.ctx, "a")
+
+	// ACT
+	firstErr := suite.sut.UpdateDocument(suite.ctx, "a", first)
+	secondErr := suite.sut.UpdateDocument(suite.ctx, "a", second)
+
+	// ASSERT
+	suite.NoError(firstErr)
+	suite.Equal(int64(2), first.Version)
+	suite.ErrorIs(secondErr, ErrVersionConflict)
+}
+
+func (suite *MemoryServiceSuite) Test_UpdateDocument_NotFound() {
+	// ACT
+	err := suite.sut.UpdateDocument(suite.ctx, "missing", &testDocument{Id: "missing"})
+
+	// ASSERT
+	suite.ErrorIs(err, ErrNotFound)
+}
+
+func (suite *MemoryServiceSuite) Test_DeleteDocument() {
+	// ACT
+	err := suite.sut.DeleteDocument(suite.ctx, "a")
+	secondErr := suite.sut.DeleteDocument(suite.ctx, "a")
+
+	// ASSERT
+	suite.NoError(err)
+	suite.ErrorIs(secondErr, ErrNotFound)
+}
+
+func (suite *MemoryServiceSuite) Test_FindDocuments_PaginatesSortedResults() {
+	// ARRANGE
+	query := Query{SortBy: "-name", Limit: 2}
+	names := []string{}
+
+	// ACT
+	for {
+		page, err := suite.sut.FindDocuments(suite.ctx, query)
+		suite.Require().NoError(err)
+		for _, item := range page.Items {
+			names = append(names, item.Name)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		query.Cursor = page.NextCursor
+	}
+
+	// ASSERT
+	suite.Equal([]string{"bravo", "alpha", "Echo", "Delta", "Charlie"}, names)
+}
+
+func (suite *MemoryServiceSuite) Test_FindDocuments_FiltersAndSearch() {
+	// ACT
+	page, err := suite.sut.FindDocuments(suite.ctx, Query{
+		Filters: []Filter{
+			{Field: "capacity", Operator: FilterEqual, Value: 0},
+			{Field: "since", Operator: FilterGreaterOrEqual, Value: time.Date(2038, 12, 24, 11, 0, 0, 0, time.UTC)},
+			{Field: "id", Operator: FilterIn, Value: []string{"c", "e", "b"}},
+		},
+		SearchText:   "CHAR",
+		SearchFields: []string{"name"},
+		Projection:   []string{"name"},
+	})
+
+	// ASSERT
+	suite.Require().NoError(err)
+	suite.Require().Len(page.Items, 1)
+	suite.Equal("c", page.Items[0].Id)
+	suite.Equal("Charlie", page.Items[0].Name)
+	suite.True(page.Items[0].Since.IsZero())
+	suite.Empty(page.NextCursor)
+}
+
+func (suite *MemoryServiceSuite) Test_FindDocuments_InvalidCursor() {
+	// ACT
+	_, err := suite.sut.FindDocuments(suite.ctx, Query{Cursor: "not a cursor"})
+
+	// ASSERT
+	suite.ErrorIs(err, ErrInvalidQuery)
+}
@@ -0,0 +1,148 @@
+package db_service
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// error codes of the change streams which cannot be resumed
+const (
+	mongoChangeStreamFatalError  = 280
+	mongoChangeStreamHistoryLost = 286
+)
+
+// mongoChangeEvent is the change stream event, documents are decoded by the services
+type mongoChangeEvent struct {
+	OperationType            string   `bson:"operationType"`
+	DocumentKey              bson.Raw `bson:"documentKey"`
+	FullDocument             bson.Raw `bson:"fullDocument"`
+	FullDocumentBeforeChange bson.Raw `bson:"fullDocumentBeforeChange"`
+}
+
+// Watch observes the collection by the change stream, it requires MongoDB
+// running as replica set. Ids of the deleted documents are known only if the
+// collection records pre-images of the changes.
+func (m *mongoSvc[DocType]) Watch(ctx context.Context, resumeAfter string, handle func(change Change[DocType])) error {
+	client, err := m.connect(ctx)
+	if err != nil {
+		return err
+	}
+	collection := client.Database(m.DbName).Collection(m.Collection)
+
+	return watchCollection(ctx, collection, resumeAfter, func(event mongoChangeEvent, change Change[DocType]) error {
+		stored := event.FullDocument
+		if stored == nil {
+			stored = event.FullDocumentBeforeChange
+		}
+		if stored != nil {
+			if value, err := stored.LookupErr("id"); err == nil {
+				change.Id, _ = value.StringValueOK()
+			}
+		}
+		if event.FullDocument != nil {
+			if err := bson.Unmarshal(event.FullDocument, &change.Document); err != nil {
+				return err
+			}
+		}
+		handle(change)
+		return nil
+	})
+}
+
+// Watch observes the collection by the change stream, it requires MongoDB running as replica set
+func (m *mongoChildSvc[DocType]) Watch(ctx context.Context, resumeAfter string, handle func(change Change[DocType])) error {
+	collection, err := m.collection(ctx)
+	if err != nil {
+		return err
+	}
+
+	return watchCollection(ctx, collection, resumeAfter, func(event mongoChangeEvent, change Change[DocType]) error {
+		var documentKey struct {
+			Key childKey `bson:"_id"`
+		}
+		if err := bson.Unmarshal(event.DocumentKey, &documentKey); err != nil {
+			return err
+		}
+		change.ParentId = documentKey.Key.ParentId
+		change.Id = documentKey.Key.Id
+		if event.FullDocument != nil {
+			var record childRecord[DocType]
+			if err := bson.Unmarshal(event.FullDocument, &record); err != nil {
+				return err
+			}
+			change.Document = &record.Document
+		}
+		handle(change)
+		return nil
+	})
+}
+
+// watchCollection reads the change stream of the collection and passes the
+// changes of the documents to the handle until the stream fails
+func watchCollection[DocType interface{}](
+	ctx context.Context,
+	collection *mongo.Collection,
+	resumeAfter string,
+	handle func(event mongoChangeEvent, change Change[DocType]) error,
+) error {
+	opts := options.ChangeStream().
+		SetFullDocument(options.UpdateLookup).
+		SetFullDocumentBeforeChange(options.WhenAvailable)
+	if resumeAfter != "" {
+		token, err := base64.RawURLEncoding.DecodeString(resumeAfter)
+		if err != nil {
+			return ErrChangeHistoryLost
+		}
+		opts.SetStartAfter(bson.Raw(token))
+	}
+
+	stream, err := collection.Watch(ctx, mongo.Pipeline{}, opts)
+	if err != nil {
+		return changeStreamError(err)
+	}
+	defer stream.Close(context.Background())
+
+	for stream.Next(ctx) {
+		var event mongoChangeEvent
+		if err := stream.Decode(&event); err != nil {
+			return err
+		}
+
+		change := Change[DocType]{ResumeToken: base64.RawURLEncoding.EncodeToString(stream.ResumeToken())}
+		switch event.OperationType {
+		case "insert":
+			change.Operation = ChangeCreated
+		case "update", "replace":
+			change.Operation = ChangeUpdated
+		case "delete":
+			change.Operation = ChangeDeleted
+		case "drop", "dropDatabase", "rename", "invalidate":
+			// stream is closed by the server, changes of the collection cannot be followed anymore
+			return ErrChangeHistoryLost
+		default:
+			continue
+		}
+		if err := handle(event, change); err != nil {
+			return err
+		}
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return changeStreamError(stream.Err())
+}
+
+// changeStreamError maps errors of change streams which cannot be resumed to ErrChangeHistoryLost
+func changeStreamError(err error) error {
+	var serverErr mongo.ServerError
+	if errors.As(err, &serverErr) &&
+		(serverErr.HasErrorCode(mongoChangeStreamHistoryLost) || serverErr.HasErrorCode(mongoChangeStreamFatalError)) {
+		return ErrChangeHistoryLost
+	}
+	return err
+}
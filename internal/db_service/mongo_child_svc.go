@@ -0,0 +1,304 @@
+package db_service
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// DbChildService stores documents owned by a parent document, e.g. waiting list
+// entries of an ambulance. Each child document is stored separately and is
+// inserted, updated, or deleted atomically on its own.
+type DbChildService[DocType interface{}] interface {
+	CreateChildDocument(ctx context.Context, parentId string, id string, document *DocType) error
+	FindChildDocument(ctx context.Context, parentId string, id string) (*DocType, error)
+	// FindChildDocuments returns children of the parent matching the filters
+	// and the search text of the query, ordered and limited by the query. The
+	// cursor and the projection of the query are not supported.
+	FindChildDocuments(ctx context.Context, parentId string, query Query) ([]*DocType, error)
+	UpdateChildDocument(ctx context.Context, parentId string, id string, document *DocType) error
+	DeleteChildDocument(ctx context.Context, parentId string, id string) error
+	DeleteChildDocuments(ctx context.Context, parentId string) error
+	Disconnect(ctx context.Context) error
+}
+
+// childKey is the _id of the stored child document
+type childKey struct {
+	ParentId string `bson:"parentId"`
+	Id       string `bson:"id"`
+}
+
+type childRecord[DocType interface{}] struct {
+	Key      childKey `bson:"_id"`
+	Document DocType  `bson:",inline"`
+}
+
+type mongoChildSvc[DocType interface{}] struct {
+	// connection and configuration is shared with the plain documents service
+	base           *mongoSvc[DocType]
+	indexesCreated atomic.Bool
+}
+
+func NewMongoChildService[DocType interface{}](config MongoServiceConfig) DbChildService[DocType] {
+	return &mongoChildSvc[DocType]{
+		base: newMongoSvc[DocType](config, "AMBULANCE_API_MONGODB_ENTRIES_COLLECTION", "waiting_list_entry"),
+	}
+}
+
+func (m *mongoChildSvc[DocType]) Disconnect(ctx context.Context) error {
+	return m.base.Disconnect(ctx)
+}
+
+func (m *mongoChildSvc[DocType]) collection(ctx context.Context) (*mongo.Collection, error) {
+	client, err := m.base.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	collection := client.Database(m.base.DbName).Collection(m.base.Collection)
+
+	if !m.indexesCreated.Load() {
+		// indexes cannot be created in the transaction the context may belong to
+		indexCtx, cancel := context.WithTimeout(context.Background(), m.base.Timeout)
+		defer cancel()
+		// children are always listed by their parent
+		_, err := collection.Indexes().CreateOne(indexCtx, mongo.IndexModel{
+			Keys: bson.D{{Key: "_id.parentId", Value: 1}},
+		})
+		if err != nil {
+			return nil, err
+		}
+		m.indexesCreated.Store(true)
+	}
+	return collection, nil
+}
+
+func (m *mongoChildSvc[DocType]) startSpan(ctx context.Context, name string, parentId string, id string) (context.Context, trace.Span) {
+	return m.base.tracer.Start(
+		ctx,
+		name,
+		trace.WithAttributes(
+			attribute.String("mongodb.collection", m.base.Collection),
+			attribute.String("parent.id", parentId),
+			attribute.String("entry.id", id),
+		),
+	)
+}
+
+func (m *mongoChildSvc[DocType]) CreateChildDocument(ctx context.Context, parentId string, id string, document *DocType) error {
+	ctx, span := m.startSpan(ctx, "CreateChildDocument", parentId, id)
+	defer span.End()
+
+	ctx, contextCancel := context.WithTimeout(ctx, m.base.Timeout)
+	defer contextCancel()
+	collection, err := m.collection(ctx)
+	if err != nil {
+		return err
+	}
+
+	if versioned, ok := any(document).(Versioned); ok {
+		versioned.SetVersion(1)
+	}
+
+	_, err = collection.InsertOne(ctx, childRecord[DocType]{
+		Key:      childKey{ParentId: parentId, Id: id},
+		Document: *document,
+	})
+	switch {
+	case err == nil:
+		span.SetStatus(codes.Ok, "Document inserted")
+		return nil
+	case mongo.IsDuplicateKeyError(err):
+		span.SetStatus(codes.Error, "Document already exists")
+		return ErrConflict
+	default:
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+}
+
+func (m *mongoChildSvc[DocType]) FindChildDocument(ctx context.Context, parentId string, id string) (*DocType, error) {
+	ctx, span := m.startSpan(ctx, "FindChildDocument", parentId, id)
+	defer span.End()
+
+	ctx, contextCancel := context.WithTimeout(ctx, m.base.Timeout)
+	defer contextCancel()
+	collection, err := m.collection(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := collection.FindOne(ctx, bson.D{{Key: "_id", Value: childKey{ParentId: parentId, Id: id}}})
+	switch result.Err() {
+	case nil:
+	case mongo.ErrNoDocuments:
+		span.SetStatus(codes.Error, "Document not found")
+		return nil, ErrNotFound
+	default: // other errors - return them
+		span.SetStatus(codes.Error, result.Err().Error())
+		return nil, result.Err()
+	}
+	var record childRecord[DocType]
+	if err := result.Decode(&record); err != nil {
+		span.SetStatus(codes.Error, "Document decode error")
+		return nil, err
+	}
+	span.SetStatus(codes.Ok, "Document found")
+	return &record.Document, nil
+}
+
+func (m *mongoChildSvc[DocType]) FindChildDocuments(ctx context.Context, parentId string, query Query) ([]*DocType, error) {
+	ctx, span := m.startSpan(ctx, "FindChildDocuments", parentId, "")
+	defer span.End()
+
+	if query.Cursor != "" || len(query.Projection) > 0 {
+		span.SetStatus(codes.Error, "Unsupported query")
+		return nil, fmt.Errorf("%w: cursor and projection of child documents are not supported", ErrInvalidQuery)
+	}
+	filter, err := query.mongoFilter()
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	ctx, contextCancel := context.WithTimeout(ctx, m.base.Timeout)
+	defer contextCancel()
+	collection, err := m.collection(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "_id.id", Value: 1}})
+	if query.SortBy != "" {
+		opts.SetSort(query.mongoSort())
+	}
+	if query.Limit > 0 {
+		opts.SetLimit(int64(query.Limit))
+	}
+	cursor, err := collection.Find(
+		ctx,
+		bson.D{{Key: "$and", Value: bson.A{bson.D{{Key: "_id.parentId", Value: parentId}}, filter}}},
+		opts,
+	)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	documents := []*DocType{}
+	for cursor.Next(ctx) {
+		var record childRecord[DocType]
+		if err := cursor.Decode(&record); err != nil {
+			span.SetStatus(codes.Error, "Document decode error")
+			return nil, err
+		}
+		documents = append(documents, &record.Document)
+	}
+	if err := cursor.Err(); err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	span.SetStatus(codes.Ok, "Documents found")
+	return documents, nil
+}
+
+func (m *mongoChildSvc[DocType]) UpdateChildDocument(ctx context.Context, parentId string, id string, document *DocType) error {
+	ctx, span := m.startSpan(ctx, "UpdateChildDocument", parentId, id)
+	defer span.End()
+
+	ctx, contextCancel := context.WithTimeout(ctx, m.base.Timeout)
+	defer contextCancel()
+	collection, err := m.collection(ctx)
+	if err != nil {
+		return err
+	}
+
+	key := childKey{ParentId: parentId, Id: id}
+	filter := bson.D{{Key: "_id", Value: key}}
+	versioned, isVersioned := any(document).(Versioned)
+	if isVersioned {
+		expectedVersion := versioned.GetVersion()
+		if expectedVersion == 0 {
+			filter = append(filter, bson.E{Key: "version", Value: bson.D{{Key: "$in", Value: bson.A{0, nil}}}})
+		} else {
+			filter = append(filter, bson.E{Key: "version", Value: expectedVersion})
+		}
+		versioned.SetVersion(expectedVersion + 1)
+		span.SetAttributes(attribute.Int64("document.version", expectedVersion))
+	}
+
+	result, err := collection.ReplaceOne(ctx, filter, childRecord[DocType]{Key: key, Document: *document})
+	if err == nil && result.MatchedCount == 0 {
+		// distinguish between deleted and concurrently modified document
+		err = ErrNotFound
+		if isVersioned {
+			count, countErr := collection.CountDocuments(ctx, bson.D{{Key: "_id", Value: key}})
+			switch {
+			case countErr != nil:
+				err = countErr
+			case count > 0:
+				err = ErrVersionConflict
+			}
+		}
+	}
+	if err != nil {
+		if isVersioned {
+			versioned.SetVersion(versioned.GetVersion() - 1)
+		}
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	span.SetStatus(codes.Ok, "Document updated")
+	return nil
+}
+
+func (m *mongoChildSvc[DocType]) DeleteChildDocument(ctx context.Context, parentId string, id string) error {
+	ctx, span := m.startSpan(ctx, "DeleteChildDocument", parentId, id)
+	defer span.End()
+
+	ctx, contextCancel := context.WithTimeout(ctx, m.base.Timeout)
+	defer contextCancel()
+	collection, err := m.collection(ctx)
+	if err != nil {
+		return err
+	}
+
+	result, err := collection.DeleteOne(ctx, bson.D{{Key: "_id", Value: childKey{ParentId: parentId, Id: id}}})
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	if result.DeletedCount == 0 {
+		span.SetStatus(codes.Error, "Document not found")
+		return ErrNotFound
+	}
+	span.SetStatus(codes.Ok, "Document deleted")
+	return nil
+}
+
+func (m *mongoChildSvc[DocType]) DeleteChildDocuments(ctx context.Context, parentId string) error {
+	ctx, span := m.startSpan(ctx, "DeleteChildDocuments", parentId, "")
+	defer span.End()
+
+	ctx, contextCancel := context.WithTimeout(ctx, m.base.Timeout)
+	defer contextCancel()
+	collection, err := m.collection(ctx)
+	if err != nil {
+		return err
+	}
+
+	if _, err := collection.DeleteMany(ctx, bson.D{{Key: "_id.parentId", Value: parentId}}); err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	span.SetStatus(codes.Ok, "Documents deleted")
+	return nil
+}
@@ -2,6 +2,7 @@ package db_service
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -24,6 +25,7 @@ import (
 type DbService[DocType interface{}] interface {
 	CreateDocument(ctx context.Context, id string, document *DocType) error
 	FindDocument(ctx context.Context, id string) (*DocType, error)
+	FindDocuments(ctx context.Context, query Query) (*Page[DocType], error)
 	UpdateDocument(ctx context.Context, id string, document *DocType) error
 	DeleteDocument(ctx context.Context, id string) error
 	Disconnect(ctx context.Context) error
@@ -31,6 +33,15 @@ type DbService[DocType interface{}] interface {
 
 var ErrNotFound = fmt.Errorf("document not found")
 var ErrConflict = fmt.Errorf("conflict: document already exists")
+var ErrVersionConflict = fmt.Errorf("conflict: document was modified concurrently")
+
+// Versioned is implemented by documents which carry their own revision number.
+// Such documents are updated only if the stored revision matches the revision
+// of the updated document, otherwise ErrVersionConflict is returned.
+type Versioned interface {
+	GetVersion() int64
+	SetVersion(version int64)
+}
 
 type MongoServiceConfig struct {
 	ServerHost string
@@ -47,9 +58,46 @@ type mongoSvc[DocType interface{}] struct {
 	client     atomic.Pointer[mongo.Client]
 	clientLock sync.Mutex
 	tracer     trace.Tracer
+	// key of the shared client in mongoClients
+	uri string
+}
+
+// Transactional is implemented by services which can write documents of several
+// services atomically. Services take part in the transaction when they are called
+// with the context passed to fn, fn may be applied without the transaction if the
+// storage does not support transactions.
+type Transactional interface {
+	RunInTransaction(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+// how many times the commit is retried when its result is unknown
+const maxCommitAttempts = 3
+
+type sharedMongoClient struct {
+	client *mongo.Client
+	users  int
 }
 
+// mongoClients are shared by the services connected to the same server, so
+// that documents of the services can be written in one transaction
+var mongoClients = struct {
+	sync.Mutex
+	byUri map[string]*sharedMongoClient
+}{byUri: map[string]*sharedMongoClient{}}
+
 func NewMongoService[DocType interface{}](config MongoServiceConfig) DbService[DocType] {
+	return newMongoSvc[DocType](config, "AMBULANCE_API_MONGODB_COLLECTION", "ambulance")
+}
+
+// NewMongoCollectionService stores documents in other collection than ambulances,
+// collectionEnv names the variable with the collection name
+func NewMongoCollectionService[DocType interface{}](config MongoServiceConfig, collectionEnv string, defaultCollection string) DbService[DocType] {
+	return newMongoSvc[DocType](config, collectionEnv, defaultCollection)
+}
+
+// newMongoSvc completes the configuration from the environment variables,
+// collectionEnv names the variable with the collection name
+func newMongoSvc[DocType interface{}](config MongoServiceConfig, collectionEnv string, defaultCollection string) *mongoSvc[DocType] {
 	enviro := func(name string, defaultValue string) string {
 		if value, ok := os.LookupEnv(name); ok {
 			return value
@@ -88,7 +136,7 @@ func NewMongoService[DocType interface{}](config MongoServiceConfig) DbService[D
 	}
 
 	if svc.Collection == "" {
-		svc.Collection = enviro("AMBULANCE_API_MONGODB_COLLECTION", "ambulance")
+		svc.Collection = enviro(collectionEnv, defaultCollection)
 	}
 
 	if svc.Timeout == 0 {
@@ -139,6 +187,15 @@ func (m *mongoSvc[DocType]) connect(ctx context.Context) (*mongo.Client, error)
 		uri = fmt.Sprintf("mongodb://%v:%v@%v:%v", m.UserName, m.Password, m.ServerHost, m.ServerPort)
 	}
 
+	mongoClients.Lock()
+	defer mongoClients.Unlock()
+	if shared, exists := mongoClients.byUri[uri]; exists {
+		shared.users++
+		m.uri = uri
+		m.client.Store(shared.client)
+		return shared.client, nil
+	}
+
 	opts := options.Client()
 	opts.Monitor = otelmongo.NewMonitor()
 	opts.ApplyURI(uri).SetConnectTimeout(10 * time.Second)
@@ -146,6 +203,8 @@ func (m *mongoSvc[DocType]) connect(ctx context.Context) (*mongo.Client, error)
 		span.SetStatus(codes.Error, "MongoDB connection error")
 		return nil, err
 	} else {
+		mongoClients.byUri[uri] = &sharedMongoClient{client: client, users: 1}
+		m.uri = uri
 		m.client.Store(client)
 		return client, nil
 	}
@@ -161,6 +220,15 @@ func (m *mongoSvc[DocType]) Disconnect(ctx context.Context) error {
 		client = m.client.Load()
 		defer m.client.Store(nil)
 		if client != nil {
+			mongoClients.Lock()
+			defer mongoClients.Unlock()
+			// client is disconnected when the last of the services sharing it is disconnected
+			if shared := mongoClients.byUri[m.uri]; shared != nil && shared.client == client {
+				if shared.users--; shared.users > 0 {
+					return nil
+				}
+				delete(mongoClients.byUri, m.uri)
+			}
 			if err := client.Disconnect(ctx); err != nil {
 				return err
 			}
@@ -168,6 +236,58 @@ func (m *mongoSvc[DocType]) Disconnect(ctx context.Context) error {
 	}
 	return nil
 }
+
+// RunInTransaction runs fn in the transaction of the client shared by all the
+// services connected to the same server. Services called with the context passed
+// to fn take part in the transaction, which is committed when fn succeeds.
+// Concurrent writes of the same documents fail with ErrVersionConflict.
+func (m *mongoSvc[DocType]) RunInTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	ctx, span := m.tracer.Start(ctx, "RunInTransaction")
+	defer span.End()
+
+	client, err := m.connect(ctx)
+	if err != nil {
+		return err
+	}
+	session, err := client.StartSession()
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	defer session.EndSession(context.WithoutCancel(ctx))
+
+	err = mongo.WithSession(ctx, session, func(sessionCtx mongo.SessionContext) error {
+		if err := session.StartTransaction(); err != nil {
+			return err
+		}
+		if err := fn(sessionCtx); err != nil {
+			// the transaction is aborted even if the request was cancelled meanwhile
+			_ = session.AbortTransaction(context.WithoutCancel(sessionCtx))
+			return err
+		}
+		err := session.CommitTransaction(sessionCtx)
+		for attempt := 1; hasErrorLabel(err, "UnknownTransactionCommitResult") && attempt < maxCommitAttempts; attempt++ {
+			err = session.CommitTransaction(sessionCtx)
+		}
+		return err
+	})
+
+	if hasErrorLabel(err, "TransientTransactionError") {
+		// write conflict with other transaction, caller applies its changes again
+		err = ErrVersionConflict
+	}
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	span.SetStatus(codes.Ok, "Transaction committed")
+	return nil
+}
+
+func hasErrorLabel(err error, label string) bool {
+	var labeled mongo.LabeledError
+	return errors.As(err, &labeled) && labeled.HasErrorLabel(label)
+}
 func (m *mongoSvc[DocType]) CreateDocument(ctx context.Context, id string, document *DocType) error {
 	ctx, span := m.tracer.Start(
 		ctx,
@@ -198,6 +318,10 @@ func (m *mongoSvc[DocType]) CreateDocument(ctx context.Context, id string, docum
 		return result.Err()
 	}
 
+	if versioned, ok := any(document).(Versioned); ok {
+		versioned.SetVersion(1)
+	}
+
 	span.SetStatus(codes.Ok, "Document inserted")
 	_, err = collection.InsertOne(ctx, document)
 	return err
@@ -240,6 +364,77 @@ func (m *mongoSvc[DocType]) FindDocument(ctx context.Context, id string) (*DocTy
 	return document, nil
 }
 
+func (m *mongoSvc[DocType]) FindDocuments(ctx context.Context, query Query) (*Page[DocType], error) {
+	ctx, span := m.tracer.Start(
+		ctx,
+		"FindDocuments",
+		trace.WithAttributes(
+			attribute.String("mongodb.collection", m.Collection),
+			attribute.String("query.sort", query.SortBy),
+			attribute.Int("query.limit", query.Limit),
+		),
+	)
+	defer span.End()
+
+	filter, err := query.mongoFilter()
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	ctx, contextCancel := context.WithTimeout(ctx, m.Timeout)
+	defer contextCancel()
+	client, err := m.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	db := client.Database(m.DbName)
+	collection := db.Collection(m.Collection)
+
+	opts := options.Find().SetSort(query.mongoSort())
+	if projection := query.mongoProjection(); projection != nil {
+		opts.SetProjection(projection)
+	}
+	if query.Limit > 0 {
+		// load one more document to find out if there is a next page
+		opts.SetLimit(int64(query.Limit) + 1)
+	}
+
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	page := &Page[DocType]{Items: []*DocType{}}
+	var lastDocument bson.Raw
+	for cursor.Next(ctx) {
+		if query.Limit > 0 && len(page.Items) == query.Limit {
+			sortField, _ := query.sortField()
+			if page.NextCursor, err = encodeCursor(lastDocument, sortField); err != nil {
+				span.SetStatus(codes.Error, "Cursor encode error")
+				return nil, err
+			}
+			break
+		}
+		var document *DocType
+		if err := cursor.Decode(&document); err != nil {
+			span.SetStatus(codes.Error, "Document decode error")
+			return nil, err
+		}
+		page.Items = append(page.Items, document)
+		lastDocument = cursor.Current
+	}
+	if err := cursor.Err(); err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	span.SetStatus(codes.Ok, "Documents found")
+	return page, nil
+}
+
 func (m *mongoSvc[DocType]) UpdateDocument(ctx context.Context, id string, document *DocType) error {
 	ctx, span := m.tracer.Start(
 		ctx,
@@ -270,8 +465,35 @@ func (m *mongoSvc[DocType]) UpdateDocument(ctx context.Context, id string, docum
 		span.SetStatus(codes.Error, result.Err().Error())
 		return result.Err()
 	}
-	_, err = collection.ReplaceOne(ctx, bson.D{{Key: "id", Value: id}}, document)
-	return err
+
+	filter := bson.D{{Key: "id", Value: id}}
+	versioned, isVersioned := any(document).(Versioned)
+	if isVersioned {
+		// replace the document only if nobody else updated it in the meantime
+		expectedVersion := versioned.GetVersion()
+		if expectedVersion == 0 {
+			// documents stored before versioning was introduced
+			filter = append(filter, bson.E{Key: "version", Value: bson.D{{Key: "$in", Value: bson.A{0, nil}}}})
+		} else {
+			filter = append(filter, bson.E{Key: "version", Value: expectedVersion})
+		}
+		versioned.SetVersion(expectedVersion + 1)
+		span.SetAttributes(attribute.Int64("document.version", expectedVersion))
+	}
+
+	replaceResult, err := collection.ReplaceOne(ctx, filter, document)
+	if err == nil && replaceResult.MatchedCount == 0 {
+		err = ErrVersionConflict
+	}
+	if err != nil {
+		if isVersioned {
+			versioned.SetVersion(versioned.GetVersion() - 1)
+		}
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	span.SetStatus(codes.Ok, "Document updated")
+	return nil
 }
 
 func (m *mongoSvc[DocType]) DeleteDocument(ctx context.Context, id string) error {
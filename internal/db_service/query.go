@@ -0,0 +1,180 @@
+package db_service
+
+import (
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+var ErrInvalidQuery = fmt.Errorf("invalid query")
+
+type FilterOperator string
+
+const (
+	FilterEqual          FilterOperator = "eq"
+	FilterIn             FilterOperator = "in"
+	FilterGreaterOrEqual FilterOperator = "gte"
+	FilterLessOrEqual    FilterOperator = "lte"
+)
+
+// Filter restricts the documents returned by FindDocuments.
+// Field names refer to the stored representation of the document.
+type Filter struct {
+	Field    string
+	Operator FilterOperator
+	Value    interface{}
+}
+
+type Query struct {
+	// all filters must be satisfied by the returned documents
+	Filters []Filter
+	// if not empty then at least one of the SearchFields must contain
+	// the SearchText (case insensitive)
+	SearchText   string
+	SearchFields []string
+	// field to order documents by, prefixed with "-" for descending order.
+	// Documents are always additionally ordered by "id" to keep the order stable
+	SortBy string
+	// maximal number of documents in one page, 0 means no limit
+	Limit int
+	// cursor of the page, taken from the Page.NextCursor of the previous page
+	Cursor string
+	// fields to be loaded, all fields are loaded if empty
+	Projection []string
+}
+
+type Page[DocType interface{}] struct {
+	Items []*DocType
+	// cursor of the following page, empty if this is the last page
+	NextCursor string
+}
+
+// sortField returns the field name and direction (1 or -1) of the query ordering
+func (q Query) sortField() (string, int) {
+	switch {
+	case q.SortBy == "":
+		return "id", 1
+	case strings.HasPrefix(q.SortBy, "-"):
+		return strings.TrimPrefix(q.SortBy, "-"), -1
+	default:
+		return strings.TrimPrefix(q.SortBy, "+"), 1
+	}
+}
+
+// pageCursor identifies the last document of the page, next page starts after it
+type pageCursor struct {
+	Value interface{} `bson:"v"`
+	Id    string      `bson:"id"`
+}
+
+func encodeCursor(lastDocument bson.Raw, sortField string) (string, error) {
+	cursor := pageCursor{}
+	if value, err := lastDocument.LookupErr(sortField); err == nil {
+		cursor.Value = value
+	}
+	if value, err := lastDocument.LookupErr("id"); err == nil {
+		cursor.Id, _ = value.StringValueOK()
+	}
+	data, err := bson.MarshalExtJSON(cursor, true, false)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func decodeCursor(cursor string) (*pageCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidQuery)
+	}
+	result := &pageCursor{}
+	if err := bson.UnmarshalExtJSON(data, true, result); err != nil {
+		return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidQuery)
+	}
+	return result, nil
+}
+
+func (q Query) mongoFilter() (bson.D, error) {
+	conditions := bson.A{}
+	for _, filter := range q.Filters {
+		switch filter.Operator {
+		case FilterEqual, "":
+			conditions = append(conditions, bson.D{{Key: filter.Field, Value: filter.Value}})
+		case FilterIn, FilterGreaterOrEqual, FilterLessOrEqual:
+			conditions = append(conditions, bson.D{{
+				Key:   filter.Field,
+				Value: bson.D{{Key: "$" + string(filter.Operator), Value: filter.Value}},
+			}})
+		default:
+			return nil, fmt.Errorf("%w: unsupported operator %v", ErrInvalidQuery, filter.Operator)
+		}
+	}
+
+	if q.SearchText != "" && len(q.SearchFields) > 0 {
+		pattern := bson.D{
+			{Key: "$regex", Value: regexp.QuoteMeta(q.SearchText)},
+			{Key: "$options", Value: "i"},
+		}
+		alternatives := bson.A{}
+		for _, field := range q.SearchFields {
+			alternatives = append(alternatives, bson.D{{Key: field, Value: pattern}})
+		}
+		conditions = append(conditions, bson.D{{Key: "$or", Value: alternatives}})
+	}
+
+	if q.Cursor != "" {
+		cursor, err := decodeCursor(q.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		field, direction := q.sortField()
+		comparison := "$gt"
+		if direction < 0 {
+			comparison = "$lt"
+		}
+		if field == "id" {
+			conditions = append(conditions, bson.D{{Key: "id", Value: bson.D{{Key: comparison, Value: cursor.Id}}}})
+		} else {
+			conditions = append(conditions, bson.D{{Key: "$or", Value: bson.A{
+				bson.D{{Key: field, Value: bson.D{{Key: comparison, Value: cursor.Value}}}},
+				bson.D{
+					{Key: field, Value: cursor.Value},
+					{Key: "id", Value: bson.D{{Key: comparison, Value: cursor.Id}}},
+				},
+			}}})
+		}
+	}
+
+	if len(conditions) == 0 {
+		return bson.D{}, nil
+	}
+	return bson.D{{Key: "$and", Value: conditions}}, nil
+}
+
+func (q Query) mongoSort() bson.D {
+	field, direction := q.sortField()
+	if field == "id" {
+		return bson.D{{Key: "id", Value: direction}}
+	}
+	return bson.D{{Key: field, Value: direction}, {Key: "id", Value: direction}}
+}
+
+func (q Query) mongoProjection() bson.D {
+	if len(q.Projection) == 0 {
+		return nil
+	}
+	// id and sort field are needed to build the page cursor
+	field, _ := q.sortField()
+	projection := bson.D{}
+	included := map[string]bool{}
+	for _, name := range append([]string{"id", field}, q.Projection...) {
+		if !included[name] {
+			included[name] = true
+			projection = append(projection, bson.E{Key: name, Value: 1})
+		}
+	}
+	return projection
+}
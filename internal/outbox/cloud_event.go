@@ -0,0 +1,54 @@
+package outbox
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const cloudEventsSpecVersion = "1.0"
+
+// CloudEventContentType is the media type of the event in the structured
+// content mode of the CloudEvents HTTP and NATS bindings
+const CloudEventContentType = "application/cloudevents+json"
+
+// CloudEvent is the envelope of the published events, it follows the JSON
+// format of the CloudEvents 1.0 specification
+type CloudEvent struct {
+	SpecVersion string `json:"specversion"`
+	// unique within the source, consumers use it to detect duplicate deliveries
+	Id string `json:"id"`
+	// URI reference of the resource the event originates from
+	Source string `json:"source"`
+	Type   string `json:"type"`
+	// identifies the subject of the event within the source
+	Subject         string          `json:"subject,omitempty"`
+	Time            time.Time       `json:"time"`
+	DataContentType string          `json:"datacontenttype,omitempty"`
+	Data            json.RawMessage `json:"data,omitempty"`
+}
+
+// NewCloudEvent creates the event with unique id and JSON encoded data
+func NewCloudEvent(source string, eventType string, subject string, data interface{}) (CloudEvent, error) {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return CloudEvent{}, err
+	}
+	// ids are ordered by time, so the outbox keeps the order of the events
+	// stored within the same millisecond
+	id, err := uuid.NewV7()
+	if err != nil {
+		return CloudEvent{}, err
+	}
+	return CloudEvent{
+		SpecVersion:     cloudEventsSpecVersion,
+		Id:              id.String(),
+		Source:          source,
+		Type:            eventType,
+		Subject:         subject,
+		Time:            time.Now().UTC(),
+		DataContentType: "application/json",
+		Data:            encoded,
+	}, nil
+}
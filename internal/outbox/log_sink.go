@@ -0,0 +1,53 @@
+package outbox
+
+import (
+	"context"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// LogSink writes the events to the service log, it is useful when no broker
+// is available and for troubleshooting
+type LogSink struct {
+	logger zerolog.Logger
+}
+
+func NewLogSink() *LogSink {
+	return &LogSink{logger: log.With().Str("component", "outbox-log-sink").Logger()}
+}
+
+func (s *LogSink) Publish(ctx context.Context, event CloudEvent) error {
+	s.logger.Info().
+		Str("id", event.Id).
+		Str("source", event.Source).
+		Str("type", event.Type).
+		Str("subject", event.Subject).
+		Time("time", event.Time).
+		RawJSON("data", event.Data).
+		Msg("Event published")
+	return nil
+}
+
+// MultiSink publishes the events to all the sinks, the event is published
+// again to all of them if any of the sinks fails. The Relay publishes the
+// event again only to the sinks which did not accept it.
+type MultiSink []Sink
+
+func (s MultiSink) Publish(ctx context.Context, event CloudEvent) error {
+	for _, sink := range s {
+		if err := sink.Publish(ctx, event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close releases the connections of the sinks which keep them
+func (s MultiSink) Close() {
+	for _, sink := range s {
+		if closer, ok := sink.(interface{ Close() }); ok {
+			closer.Close()
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+)
+
+// NatsSink publishes the events to the NATS compatible broker in the structured
+// content mode of the CloudEvents NATS binding. The subject of the message is
+// the type of the event prefixed with the subject prefix.
+type NatsSink struct {
+	url           string
+	subjectPrefix string
+	lock          sync.Mutex
+	conn          *nats.Conn
+}
+
+func NewNatsSink(url string, subjectPrefix string) *NatsSink {
+	return &NatsSink{url: url, subjectPrefix: subjectPrefix}
+}
+
+// connect connects to the broker on the first use, the client reconnects
+// automatically afterwards
+func (s *NatsSink) connect() (*nats.Conn, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.conn != nil {
+		return s.conn, nil
+	}
+	conn, err := nats.Connect(s.url, nats.Name("ambulance-webapi-outbox"))
+	if err != nil {
+		return nil, err
+	}
+	s.conn = conn
+	return conn, nil
+}
+
+func (s *NatsSink) Publish(ctx context.Context, event CloudEvent) error {
+	conn, err := s.connect()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	subject := event.Type
+	if s.subjectPrefix != "" {
+		subject = s.subjectPrefix + "." + subject
+	}
+	message := nats.NewMsg(subject)
+	message.Header.Set("Content-Type", CloudEventContentType)
+	message.Data = data
+	if err := conn.PublishMsg(message); err != nil {
+		return err
+	}
+	// round trip to the server confirms the message was received
+	return conn.FlushWithContext(ctx)
+}
+
+func (s *NatsSink) Close() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+}
@@ -0,0 +1,233 @@
+package outbox
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/wac-fiit/cv2-ambulance-webapi/internal/db_service"
+)
+
+const (
+	// how often the outbox is checked for pending messages
+	relayPollInterval = time.Second
+	// messages published in one pass of the relay
+	relayBatchSize = 100
+	// how long the message claimed by one relay is not published by the others,
+	// it outlasts the retries of the sinks which wait for the delivery
+	relayClaimDuration = 5 * time.Minute
+	// delays before the failed message is published again, doubled after each failure
+	relayMinRetryDelay = time.Second
+	relayMaxRetryDelay = 5 * time.Minute
+	// how long the delivered messages are kept in the outbox
+	relayRetention = 24 * time.Hour
+)
+
+// Message is the event stored in the outbox together with the change it
+// describes, so that the event is published if and only if the change is stored
+type Message struct {
+	Id    string     `json:"id"`
+	Event CloudEvent `json:"event"`
+	// order in which the messages are published
+	CreatedAt time.Time `json:"createdAt"`
+	// message is not published before this time, claimed and failed messages
+	// are postponed
+	AvailableAt time.Time `json:"availableAt"`
+	Delivered   bool      `json:"delivered"`
+	DeliveredAt time.Time `json:"deliveredAt"`
+	// positions of the sinks which accepted the message, it is not published
+	// to them again when other sink fails
+	PublishedTo []int `json:"publishedTo,omitempty"`
+	// number of the failed attempts to publish the message
+	Attempts  int32  `json:"attempts,omitempty"`
+	LastError string `json:"lastError,omitempty"`
+	Version   int64  `json:"version,omitempty"`
+}
+
+func (m *Message) GetVersion() int64 {
+	return m.Version
+}
+
+func (m *Message) SetVersion(version int64) {
+	m.Version = version
+}
+
+// NewMessage wraps the event into the message to be stored in the outbox
+func NewMessage(event CloudEvent) *Message {
+	now := time.Now()
+	return &Message{
+		Id:          event.Id,
+		Event:       event,
+		CreatedAt:   now,
+		AvailableAt: now,
+	}
+}
+
+// Sink publishes the events relayed from the outbox. Events are delivered at
+// least once, the sink may receive the event again if marking it delivered fails.
+type Sink interface {
+	Publish(ctx context.Context, event CloudEvent) error
+}
+
+// Relay publishes the pending outbox messages to the sinks and marks them
+// delivered once all the sinks accept them. Relays of several replicas may
+// share one outbox, each message is claimed by one of them before it is
+// published.
+type Relay struct {
+	db            db_service.DbService[Message]
+	sinks         MultiSink
+	logger        zerolog.Logger
+	pollInterval  time.Duration
+	claimDuration time.Duration
+	retryDelay    time.Duration
+	retention     time.Duration
+}
+
+// NewRelay creates the relay checking the outbox each pollInterval, the default
+// interval is used if pollInterval is zero. The sinks of the MultiSink are
+// tracked separately, the message accepted by some of them is published only
+// to the others when it is published again.
+func NewRelay(db db_service.DbService[Message], sink Sink, pollInterval time.Duration) *Relay {
+	if pollInterval <= 0 {
+		pollInterval = relayPollInterval
+	}
+	sinks, ok := sink.(MultiSink)
+	if !ok {
+		sinks = MultiSink{sink}
+	}
+	return &Relay{
+		db:            db,
+		sinks:         sinks,
+		logger:        log.With().Str("component", "outbox-relay").Logger(),
+		pollInterval:  pollInterval,
+		claimDuration: relayClaimDuration,
+		retryDelay:    relayMinRetryDelay,
+		retention:     relayRetention,
+	}
+}
+
+// Run publishes the pending messages until the context is cancelled
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.pollInterval)
+	defer ticker.Stop()
+	for {
+		if err := r.publishPending(ctx); err != nil && ctx.Err() == nil {
+			r.logger.Error().Err(err).Msg("Failed to publish outbox messages")
+		}
+		r.purgeDelivered(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// publishPending publishes the available messages in the order they were
+// stored. The pass stops at the first failed message, so that the following
+// messages are not published before it.
+func (r *Relay) publishPending(ctx context.Context) error {
+	for {
+		page, err := r.db.FindDocuments(ctx, db_service.Query{
+			Filters: []db_service.Filter{
+				{Field: "delivered", Value: false},
+				{Field: "availableat", Operator: db_service.FilterLessOrEqual, Value: time.Now()},
+			},
+			SortBy: "createdat",
+			Limit:  relayBatchSize,
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, message := range page.Items {
+			// claim the message, concurrent relay fails to update it and skips it
+			message.AvailableAt = time.Now().Add(r.claimDuration)
+			switch err := r.db.UpdateDocument(ctx, message.Id, message); err {
+			case nil:
+			case db_service.ErrVersionConflict, db_service.ErrNotFound:
+				continue
+			default:
+				return err
+			}
+
+			publishErr := r.publish(ctx, message)
+			if publishErr == nil {
+				message.Delivered = true
+				message.DeliveredAt = time.Now()
+			} else {
+				message.Attempts++
+				message.LastError = publishErr.Error()
+				message.AvailableAt = time.Now().Add(r.backoff(message.Attempts))
+			}
+			if err := r.db.UpdateDocument(ctx, message.Id, message); err != nil {
+				// message is published again once the claim expires
+				r.logger.Error().Err(err).Str("messageId", message.Id).Msg("Failed to update outbox message")
+			}
+			if publishErr != nil {
+				r.logger.Warn().Err(publishErr).
+					Str("messageId", message.Id).
+					Int32("attempts", message.Attempts).
+					Time("availableAt", message.AvailableAt).
+					Msg("Failed to publish outbox message")
+				return nil
+			}
+		}
+
+		if page.NextCursor == "" {
+			return nil
+		}
+	}
+}
+
+// publish publishes the message to the sinks which did not accept it yet and
+// records those which accept it. The sinks are identified by their position,
+// the messages pending when the sinks are reconfigured may be published to
+// some sinks again or not at all.
+func (r *Relay) publish(ctx context.Context, message *Message) error {
+	for i, sink := range r.sinks {
+		if slices.Contains(message.PublishedTo, i) {
+			continue
+		}
+		if err := sink.Publish(ctx, message.Event); err != nil {
+			return err
+		}
+		message.PublishedTo = append(message.PublishedTo, i)
+	}
+	return nil
+}
+
+func (r *Relay) backoff(attempts int32) time.Duration {
+	delay := r.retryDelay
+	for i := int32(1); i < attempts && delay < relayMaxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, relayMaxRetryDelay)
+}
+
+// purgeDelivered removes the messages delivered before the retention period
+func (r *Relay) purgeDelivered(ctx context.Context) {
+	page, err := r.db.FindDocuments(ctx, db_service.Query{
+		Filters: []db_service.Filter{
+			{Field: "delivered", Value: true},
+			{Field: "deliveredat", Operator: db_service.FilterLessOrEqual, Value: time.Now().Add(-r.retention)},
+		},
+		Limit:      relayBatchSize,
+		Projection: []string{"id"},
+	})
+	if err != nil {
+		if ctx.Err() == nil {
+			r.logger.Error().Err(err).Msg("Failed to find delivered outbox messages")
+		}
+		return
+	}
+	for _, message := range page.Items {
+		if err := r.db.DeleteDocument(ctx, message.Id); err != nil && err != db_service.ErrNotFound {
+			r.logger.Error().Err(err).Str("messageId", message.Id).Msg("Failed to remove delivered outbox message")
+			return
+		}
+	}
+}
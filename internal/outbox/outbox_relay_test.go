@@ -0,0 +1,161 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/wac-fiit/cv2-ambulance-webapi/internal/db_service"
+)
+
+// sinkMock records the published events and fails while failures are set
+type sinkMock struct {
+	lock      sync.Mutex
+	published []string
+	failures  int
+}
+
+func (s *sinkMock) Publish(ctx context.Context, event CloudEvent) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("broker unavailable")
+	}
+	s.published = append(s.published, event.Id)
+	return nil
+}
+
+type RelaySuite struct {
+	suite.Suite
+	db    db_service.DbService[Message]
+	sink  *sinkMock
+	relay *Relay
+	ctx   context.Context
+}
+
+func TestRelaySuite(t *testing.T) {
+	suite.Run(t, new(RelaySuite))
+}
+
+func (suite *RelaySuite) SetupTest() {
+	suite.db = db_service.NewMemoryService[Message]()
+	suite.sink = &sinkMock{}
+	suite.relay = NewRelay(suite.db, suite.sink, 0)
+	suite.relay.retryDelay = time.Minute
+	suite.ctx = context.Background()
+}
+
+func (suite *RelaySuite) store(id string, createdAt time.Time) {
+	event, err := NewCloudEvent("/api/waiting-list/test-ambulance", "ambulance-wl.entry.enqueued", "test-entry", map[string]string{"id": id})
+	suite.Require().NoError(err)
+	event.Id = id
+	message := NewMessage(event)
+	message.CreatedAt = createdAt
+	message.AvailableAt = createdAt
+	suite.Require().NoError(suite.db.CreateDocument(suite.ctx, id, message))
+}
+
+func (suite *RelaySuite) message(id string) *Message {
+	message, err := suite.db.FindDocument(suite.ctx, id)
+	suite.Require().NoError(err)
+	return message
+}
+
+func (suite *RelaySuite) Test_PublishPending_PublishesInOrderAndMarksDelivered() {
+	// ARRANGE
+	now := time.Now()
+	suite.store("second", now.Add(-time.Second))
+	suite.store("first", now.Add(-time.Minute))
+
+	// ACT
+	err := suite.relay.publishPending(suite.ctx)
+	again := suite.relay.publishPending(suite.ctx)
+
+	// ASSERT
+	suite.NoError(err)
+	suite.NoError(again)
+	suite.Equal([]string{"first", "second"}, suite.sink.published)
+	suite.True(suite.message("first").Delivered)
+	suite.False(suite.message("first").DeliveredAt.IsZero())
+	suite.True(suite.message("second").Delivered)
+}
+
+func (suite *RelaySuite) Test_PublishPending_PostponesFailedMessage() {
+	// ARRANGE
+	now := time.Now()
+	suite.store("first", now.Add(-time.Minute))
+	suite.store("second", now.Add(-time.Second))
+	suite.sink.failures = 1
+
+	// ACT
+	err := suite.relay.publishPending(suite.ctx)
+
+	// ASSERT
+	suite.NoError(err)
+	// the following message waits for the failed one
+	suite.Empty(suite.sink.published)
+	failed := suite.message("first")
+	suite.False(failed.Delivered)
+	suite.Equal(int32(1), failed.Attempts)
+	suite.Equal("broker unavailable", failed.LastError)
+	suite.True(failed.AvailableAt.After(now.Add(59 * time.Second)))
+	suite.False(suite.message("second").Delivered)
+}
+
+func (suite *RelaySuite) Test_PublishPending_RepublishesOnlyToFailedSink() {
+	// ARRANGE
+	failing := &sinkMock{failures: 1}
+	suite.relay = NewRelay(suite.db, MultiSink{suite.sink, failing}, 0)
+	suite.store("first", time.Now().Add(-time.Minute))
+
+	// ACT
+	err := suite.relay.publishPending(suite.ctx)
+	failed := suite.message("first")
+	failed.AvailableAt = time.Now()
+	suite.Require().NoError(suite.db.UpdateDocument(suite.ctx, failed.Id, failed))
+	retryErr := suite.relay.publishPending(suite.ctx)
+
+	// ASSERT
+	suite.NoError(err)
+	suite.NoError(retryErr)
+	suite.Equal([]string{"first"}, suite.sink.published)
+	suite.Equal([]string{"first"}, failing.published)
+	suite.True(suite.message("first").Delivered)
+}
+
+func (suite *RelaySuite) Test_PublishPending_SkipsMessageClaimedByOtherRelay() {
+	// ARRANGE
+	suite.store("first", time.Now().Add(-time.Minute))
+	claimed := suite.message("first")
+	claimed.AvailableAt = time.Now().Add(time.Minute)
+	suite.Require().NoError(suite.db.UpdateDocument(suite.ctx, "first", claimed))
+
+	// ACT
+	err := suite.relay.publishPending(suite.ctx)
+
+	// ASSERT
+	suite.NoError(err)
+	suite.Empty(suite.sink.published)
+}
+
+func (suite *RelaySuite) Test_PurgeDelivered_RemovesExpiredMessages() {
+	// ARRANGE
+	suite.store("expired", time.Now().Add(-48*time.Hour))
+	suite.store("recent", time.Now().Add(-time.Minute))
+	suite.Require().NoError(suite.relay.publishPending(suite.ctx))
+	expired := suite.message("expired")
+	expired.DeliveredAt = time.Now().Add(-25 * time.Hour)
+	suite.Require().NoError(suite.db.UpdateDocument(suite.ctx, "expired", expired))
+
+	// ACT
+	suite.relay.purgeDelivered(suite.ctx)
+
+	// ASSERT
+	_, err := suite.db.FindDocument(suite.ctx, "expired")
+	suite.Equal(db_service.ErrNotFound, err)
+	suite.True(suite.message("recent").Delivered)
+}
@@ -10,6 +10,8 @@ $ProjectRoot = "${PSScriptRoot}/.."
 
 $env:AMBULANCE_API_ENVIRONMENT="Development"
 $env:AMBULANCE_API_PORT="8080"
+$env:AMBULANCE_API_RESPONSE_VALIDATION="log"
+$env:AMBULANCE_API_ALLOW_ANONYMOUS="true"
 $env:AMBULANCE_API_MONGODB_USERNAME="root"
 $env:AMBULANCE_API_MONGODB_PASSWORD="neUhaDnes"
 
@@ -27,6 +29,10 @@ switch ($command) {
             mongo down
         }
     }
+    "start-memory" {
+        $env:AMBULANCE_API_STORAGE="memory"
+        go run ${ProjectRoot}/cmd/ambulance-api-service
+    }
     "test" {
         go test -v ./...
     }
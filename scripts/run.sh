@@ -10,6 +10,8 @@ ProjectRoot="$(cd "$(dirname "${BASH_SOURCE[0]}")/.." && pwd)"
 
 export AMBULANCE_API_ENVIRONMENT="Development"
 export AMBULANCE_API_PORT="8080"
+export AMBULANCE_API_RESPONSE_VALIDATION="log"
+export AMBULANCE_API_ALLOW_ANONYMOUS="true"
 export AMBULANCE_API_MONGODB_USERNAME="root"
 export AMBULANCE_API_MONGODB_PASSWORD="neUhaDnes"
 
@@ -23,6 +25,9 @@ case "$command" in
     mongo up --detach
     go run "${ProjectRoot}/cmd/ambulance-api-service"
     ;;
+  start-memory)
+    AMBULANCE_API_STORAGE="memory" go run "${ProjectRoot}/cmd/ambulance-api-service"
+    ;;
   test)   
      go test -v ./...
     ;;